@@ -37,6 +37,7 @@ const (
 	DataChannelRetryMaxIntervalMillis  = 5000
 	RetryAttempt                       = 5
 	PingTimeInterval                   = 5 * time.Minute
+	HandshakeTimeout                   = 30 * time.Second
 
 	// Plugin names
 	ShellPluginName                  = "Standard_Stream"
@@ -48,4 +49,33 @@ const (
 	TerminateSessionFlagSupportedAfterThisAgentVersion            = "2.3.722.0"
 	TCPMultiplexingSupportedAfterThisAgentVersion                 = "3.0.196.0"
 	TCPMultiplexingWithSmuxKeepAliveDisabledAfterThisAgentVersion = "3.1.1511.0"
+
+	// Environment variables read by the plugin when started by AWS CLI
+	AuditLogEnvironmentVariable             = "AWS_SSM_PLUGIN_AUDIT_LOG"
+	AuditKeyFileEnvironmentVariable         = "AWS_SSM_PLUGIN_AUDIT_KEY_FILE"
+	GuardrailsEnvironmentVariable           = "AWS_SSM_PLUGIN_GUARDRAILS"
+	ConfirmPasteEnvironmentVariable         = "AWS_SSM_PLUGIN_CONFIRM_PASTE"
+	RemoteEncodingEnvironmentVariable       = "AWS_SSM_PLUGIN_REMOTE_ENCODING"
+	WatchSocketEnvironmentVariable          = "AWS_SSM_PLUGIN_WATCH_SOCKET"
+	WatchSocketModeEnvironmentVariable      = "AWS_SSM_PLUGIN_WATCH_SOCKET_MODE"
+	StatusLineEnvironmentVariable           = "AWS_SSM_PLUGIN_STATUS_LINE"
+	PredictiveEchoEnvironmentVariable       = "AWS_SSM_PLUGIN_PREDICTIVE_ECHO"
+	ZmodemDirEnvironmentVariable            = "AWS_SSM_PLUGIN_ZMODEM_DIR"
+	SignalMapEnvironmentVariable            = "AWS_SSM_PLUGIN_SIGNAL_MAP"
+	MaxRestartsEnvironmentVariable          = "AWS_SSM_PLUGIN_MAX_RESTARTS"
+	IdleTimeoutEnvironmentVariable          = "AWS_SSM_PLUGIN_IDLE_TIMEOUT"
+	MaxDurationEnvironmentVariable          = "AWS_SSM_PLUGIN_MAX_DURATION"
+	LimitWarningEnvironmentVariable         = "AWS_SSM_PLUGIN_LIMIT_WARNING"
+	KeepAliveIntervalEnvironmentVariable    = "AWS_SSM_PLUGIN_KEEP_ALIVE_INTERVAL"
+	SessionPerConnectionEnvironmentVariable = "AWS_SSM_PLUGIN_SESSION_PER_CONNECTION"
+	LocalHostEnvironmentVariable            = "AWS_SSM_PLUGIN_LOCAL_HOST"
+	AllowNonLoopbackEnvironmentVariable     = "AWS_SSM_PLUGIN_ALLOW_NON_LOOPBACK"
+	ReadyEventsEnvironmentVariable          = "AWS_SSM_PLUGIN_READY_EVENTS"
+	AllowClientsEnvironmentVariable         = "AWS_SSM_PLUGIN_ALLOW_CLIENTS"
+	AllowPeerUIDsEnvironmentVariable        = "AWS_SSM_PLUGIN_ALLOW_PEER_UIDS"
+	AllowPeerGIDsEnvironmentVariable        = "AWS_SSM_PLUGIN_ALLOW_PEER_GIDS"
+	SocketModeEnvironmentVariable           = "AWS_SSM_PLUGIN_SOCKET_MODE"
+	SocketOwnerEnvironmentVariable          = "AWS_SSM_PLUGIN_SOCKET_OWNER"
+	MaxConnectionsEnvironmentVariable       = "AWS_SSM_PLUGIN_MAX_CONNECTIONS"
+	ConnectionLogEnvironmentVariable        = "AWS_SSM_PLUGIN_CONNECTION_LOG"
 )
@@ -5,6 +5,7 @@ package mocks
 
 import (
 	list "container/list"
+	time "time"
 
 	communicator "github.com/aws/session-manager-plugin/src/communicator"
 	datachannel "github.com/aws/session-manager-plugin/src/datachannel"
@@ -80,6 +81,48 @@ func (_m *IDataChannel) GetAgentVersion() string {
 	return r0
 }
 
+// GetLastActivityTime provides a mock function with given fields:
+func (_m *IDataChannel) GetLastActivityTime() time.Time {
+	ret := _m.Called()
+
+	var r0 time.Time
+	if rf, ok := ret.Get(0).(func() time.Time); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Time)
+	}
+
+	return r0
+}
+
+// GetRetransmissionTimeout provides a mock function with given fields:
+func (_m *IDataChannel) GetRetransmissionTimeout() time.Duration {
+	ret := _m.Called()
+
+	var r0 time.Duration
+	if rf, ok := ret.Get(0).(func() time.Duration); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	return r0
+}
+
+// GetRoundTripTime provides a mock function with given fields:
+func (_m *IDataChannel) GetRoundTripTime() time.Duration {
+	ret := _m.Called()
+
+	var r0 time.Duration
+	if rf, ok := ret.Get(0).(func() time.Duration); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	return r0
+}
+
 // GetSessionProperties provides a mock function with given fields:
 func (_m *IDataChannel) GetSessionProperties() interface{} {
 	ret := _m.Called()
@@ -124,6 +167,41 @@ func (_m *IDataChannel) GetStreamDataSequenceNumber() int64 {
 	return r0
 }
 
+// GetStreamDataByteCount provides a mock function with given fields:
+func (_m *IDataChannel) GetStreamDataByteCount() (int64, int64) {
+	ret := _m.Called()
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func() int64); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 int64
+	if rf, ok := ret.Get(1).(func() int64); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Get(1).(int64)
+	}
+
+	return r0, r1
+}
+
+// GetUnacknowledgedMessageCount provides a mock function with given fields:
+func (_m *IDataChannel) GetUnacknowledgedMessageCount() int {
+	ret := _m.Called()
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func() int); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	return r0
+}
+
 // GetWsChannel provides a mock function with given fields:
 func (_m *IDataChannel) GetWsChannel() communicator.IWebSocketChannel {
 	ret := _m.Called()
@@ -145,6 +223,20 @@ func (_m *IDataChannel) Initialize(_a0 log.T, clientId string, sessionId string,
 	_m.Called(_a0, clientId, sessionId, targetId, isAwsCliUpgradeNeeded)
 }
 
+// IsReconnecting provides a mock function with given fields:
+func (_m *IDataChannel) IsReconnecting() bool {
+	ret := _m.Called()
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func() bool); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
 // IsSessionTypeSet provides a mock function with given fields:
 func (_m *IDataChannel) IsSessionTypeSet() chan bool {
 	ret := _m.Called()
@@ -161,6 +253,22 @@ func (_m *IDataChannel) IsSessionTypeSet() chan bool {
 	return r0
 }
 
+// Closed provides a mock function with given fields:
+func (_m *IDataChannel) Closed() <-chan struct{} {
+	ret := _m.Called()
+
+	var r0 <-chan struct{}
+	if rf, ok := ret.Get(0).(func() <-chan struct{}); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(<-chan struct{})
+		}
+	}
+
+	return r0
+}
+
 // IsStreamMessageResendTimeout checks if resending a streaming message reaches timeout
 func (_m *IDataChannel) IsStreamMessageResendTimeout() chan bool {
 	ret := _m.Called()
@@ -233,6 +341,11 @@ func (_m *IDataChannel) Reconnect(_a0 log.T) error {
 	return r0
 }
 
+// RecordStreamActivity provides a mock function with given fields:
+func (_m *IDataChannel) RecordStreamActivity() {
+	_m.Called()
+}
+
 // RegisterOutputStreamHandler provides a mock function with given fields: handler, isSessionSpecificHandler
 func (_m *IDataChannel) RegisterOutputStreamHandler(handler datachannel.OutputStreamDataMessageHandler, isSessionSpecificHandler bool) {
 	_m.Called(handler, isSessionSpecificHandler)
@@ -323,6 +436,11 @@ func (_m *IDataChannel) SetAgentVersion(agentVersion string) {
 	_m.Called(agentVersion)
 }
 
+// SetReconnecting provides a mock function with given fields: reconnecting
+func (_m *IDataChannel) SetReconnecting(reconnecting bool) {
+	_m.Called(reconnecting)
+}
+
 // SetSessionType provides a mock function with given fields: sessionType
 func (_m *IDataChannel) SetSessionType(sessionType string) {
 	_m.Called(sessionType)
@@ -337,3 +455,22 @@ func (_m *IDataChannel) SetWebsocket(_a0 log.T, streamUrl string, tokenValue str
 func (_m *IDataChannel) SetWsChannel(wsChannel communicator.IWebSocketChannel) {
 	_m.Called(wsChannel)
 }
+
+// SwitchSession provides a mock function with given fields: _a0, sessionId, streamUrl, tokenValue
+func (_m *IDataChannel) SwitchSession(_a0 log.T, sessionId string, streamUrl string, tokenValue string) error {
+	ret := _m.Called(_a0, sessionId, streamUrl, tokenValue)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(log.T, string, string, string) error); ok {
+		r0 = rf(_a0, sessionId, streamUrl, tokenValue)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// TrackStreamActivity provides a mock function with given fields:
+func (_m *IDataChannel) TrackStreamActivity() {
+	_m.Called()
+}
@@ -25,6 +25,7 @@ import (
 	"os"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -44,6 +45,7 @@ type IDataChannel interface {
 	Initialize(log log.T, clientId string, sessionId string, targetId string, isAwsCliUpgradeNeeded bool)
 	SetWebsocket(log log.T, streamUrl string, tokenValue string)
 	Reconnect(log log.T) error
+	SwitchSession(log log.T, sessionId string, streamUrl string, tokenValue string) error
 	SendFlag(log log.T, flagType message.PayloadTypeFlag) error
 	Open(log log.T) error
 	Close(log log.T) error
@@ -63,18 +65,37 @@ type IDataChannel interface {
 	DeregisterOutputStreamHandler(handler OutputStreamDataMessageHandler)
 	IsSessionTypeSet() chan bool
 	IsStreamMessageResendTimeout() chan bool
+	Closed() <-chan struct{}
 	GetSessionType() string
 	SetSessionType(sessionType string)
 	GetSessionProperties() interface{}
 	GetWsChannel() communicator.IWebSocketChannel
 	SetWsChannel(wsChannel communicator.IWebSocketChannel)
 	GetStreamDataSequenceNumber() int64
+	GetStreamDataByteCount() (sent int64, received int64)
+	GetLastActivityTime() time.Time
+	TrackStreamActivity()
+	RecordStreamActivity()
+	GetRoundTripTime() time.Duration
+	GetRetransmissionTimeout() time.Duration
+	GetUnacknowledgedMessageCount() int
+	IsReconnecting() bool
+	SetReconnecting(reconnecting bool)
 	GetAgentVersion() string
 	SetAgentVersion(agentVersion string)
 }
 
 // DataChannel used for communication between the mgs and the cli.
 type DataChannel struct {
+	// Fields accessed atomically come first as 64-bit atomic operations require 8-byte alignment on 32-bit
+	// platforms, which Go only guarantees for the first word of an allocated struct.
+
+	// Number of output payload bytes sent to and received from the agent
+	streamDataBytesSent     int64
+	streamDataBytesReceived int64
+	// Time in unix nanoseconds of the last input sent or output received
+	lastActivity int64
+
 	wsChannel             communicator.IWebSocketChannel
 	Role                  string
 	ClientId              string
@@ -85,13 +106,16 @@ type DataChannel struct {
 	ExpectedSequenceNumber int64
 	//records sequence number of last stream data message sent over data channel
 	StreamDataSequenceNumber int64
+	// Serializes senders of stream data messages, so that sequence numbers go out and into the outgoing
+	// buffer in order when the keep-alive or several connections send concurrently
+	sendMutex sync.Mutex
 	//buffer to store outgoing stream messages until acknowledged
 	//using linked list for this buffer as access to oldest message is required and it support faster deletion from any position of list
 	OutgoingMessageBuffer ListMessageBuffer
 	//buffer to store incoming stream messages if received out of sequence
 	//using map for this buffer as incoming messages can be out of order and retrieval would be faster by sequenceId
 	IncomingMessageBuffer MapMessageBuffer
-	//round trip time of latest acknowledged message
+	//round trip time of latest acknowledged message, guarded like the following fields by the mutex of OutgoingMessageBuffer
 	RoundTripTime float64
 	//round trip time variation of latest acknowledged message
 	RoundTripTimeVariation float64
@@ -109,12 +133,22 @@ type DataChannel struct {
 	// Used to detect if resending a streaming message reaches timeout
 	isStreamMessageResendTimeout chan bool
 
+	// Closed once the data channel is closed for good, ending the goroutines of the session
+	closed    chan struct{}
+	closeOnce *sync.Once
+
 	// Handles data on output stream. Output stream is data outputted by the SSM agent and received here.
 	outputStreamHandlers        []OutputStreamDataMessageHandler
 	isSessionSpecificHandlerSet bool
 
 	// AgentVersion received during handshake
 	agentVersion string
+
+	// Set while the session tries to reconnect a lost connection
+	reconnecting int32
+
+	// Set once activity is recorded by the session for the streams it multiplexes instead of for every payload
+	streamActivity int32
 }
 
 type ListMessageBuffer struct {
@@ -188,8 +222,11 @@ func (dataChannel *DataChannel) Initialize(log log.T, clientId string, sessionId
 	dataChannel.encryptionEnabled = false
 	dataChannel.isSessionTypeSet = make(chan bool, 1)
 	dataChannel.isStreamMessageResendTimeout = make(chan bool, 1)
+	dataChannel.closed = make(chan struct{})
+	dataChannel.closeOnce = &sync.Once{}
 	dataChannel.sessionType = ""
 	dataChannel.IsAwsCliUpgradeNeeded = isAwsCliUpgradeNeeded
+	dataChannel.lastActivity = time.Now().UnixNano()
 }
 
 // SetWebsocket function populates websocket channel object
@@ -237,16 +274,29 @@ func (dataChannel *DataChannel) Open(log log.T) (err error) {
 	return
 }
 
-// Close closes datachannel - its web socket connection
+// Close closes datachannel - its web socket connection, and stops resending stream data messages
 func (dataChannel *DataChannel) Close(log log.T) error {
+	if dataChannel.closeOnce != nil {
+		dataChannel.closeOnce.Do(func() { close(dataChannel.closed) })
+	}
+	return dataChannel.closeWebsocket(log)
+}
+
+// closeWebsocket closes the web socket connection, which may be opened again.
+func (dataChannel *DataChannel) closeWebsocket(log log.T) error {
 	log.Infof("Closing datachannel with url %s", dataChannel.wsChannel.GetStreamUrl())
 	return dataChannel.wsChannel.Close(log)
 }
 
+// Closed returns a channel that is closed once the data channel is closed by Close.
+func (dataChannel *DataChannel) Closed() <-chan struct{} {
+	return dataChannel.closed
+}
+
 // Reconnect calls ResumeSession API to reconnect datachannel when connection is lost
 func (dataChannel *DataChannel) Reconnect(log log.T) (err error) {
 
-	if err = dataChannel.Close(log); err != nil {
+	if err = dataChannel.closeWebsocket(log); err != nil {
 		log.Debugf("Closing datachannel failed with error: %v", err)
 	}
 
@@ -258,6 +308,44 @@ func (dataChannel *DataChannel) Reconnect(log log.T) (err error) {
 	return
 }
 
+// SwitchSession connects the data channel to a new session, keeping its handlers. The stream state of the
+// previous session is discarded.
+func (dataChannel *DataChannel) SwitchSession(log log.T, sessionId string, streamUrl string, tokenValue string) (err error) {
+	if err = dataChannel.closeWebsocket(log); err != nil {
+		log.Debugf("Closing datachannel failed with error: %v", err)
+	}
+
+	dataChannel.SessionId = sessionId
+	dataChannel.ExpectedSequenceNumber = 0
+	dataChannel.sendMutex.Lock()
+	dataChannel.StreamDataSequenceNumber = 0
+	dataChannel.sendMutex.Unlock()
+	dataChannel.OutgoingMessageBuffer.Mutex.Lock()
+	dataChannel.OutgoingMessageBuffer.Messages.Init()
+	dataChannel.OutgoingMessageBuffer.Mutex.Unlock()
+	dataChannel.IncomingMessageBuffer.Mutex.Lock()
+	dataChannel.IncomingMessageBuffer.Messages = make(map[int64]StreamingMessage)
+	dataChannel.IncomingMessageBuffer.Mutex.Unlock()
+	dataChannel.OutgoingMessageBuffer.Mutex.Lock()
+	dataChannel.RoundTripTime = float64(config.DefaultRoundTripTime)
+	dataChannel.RoundTripTimeVariation = config.DefaultRoundTripTimeVariation
+	dataChannel.RetransmissionTimeout = config.DefaultTransmissionTimeout
+	dataChannel.OutgoingMessageBuffer.Mutex.Unlock()
+	dataChannel.encryptionEnabled = false
+	// the handshake of the new session reports the session type again
+	select {
+	case <-dataChannel.isSessionTypeSet:
+	default:
+	}
+
+	dataChannel.SetWebsocket(log, streamUrl, tokenValue)
+	if err = dataChannel.Open(log); err != nil {
+		return fmt.Errorf("failed to connect data channel to session %s with error: %v", sessionId, err)
+	}
+	log.Infof("Data channel switched to session %s", sessionId)
+	return
+}
+
 // SendFlag sends a data message with PayloadType as given flag.
 func (dataChannel *DataChannel) SendFlag(
 	log log.T,
@@ -280,6 +368,7 @@ func (dataChannel *DataChannel) SendInputDataMessage(
 	)
 
 	messageId := uuid.NewV4()
+	payloadLength := int64(len(inputData))
 
 	// today 'enter' is taken as 'next line' in winpty shell. so hardcoding 'next line' byte to actual 'enter' byte
 	if bytes.Equal(inputData, []byte{10}) {
@@ -294,6 +383,9 @@ func (dataChannel *DataChannel) SendInputDataMessage(
 		}
 	}
 
+	dataChannel.sendMutex.Lock()
+	defer dataChannel.sendMutex.Unlock()
+
 	clientMessage := message.ClientMessage{
 		MessageType:    message.InputStreamMessage,
 		SchemaVersion:  1,
@@ -324,18 +416,32 @@ func (dataChannel *DataChannel) SendInputDataMessage(
 	}
 	dataChannel.AddDataToOutgoingMessageBuffer(streamingMessage)
 	dataChannel.StreamDataSequenceNumber = dataChannel.StreamDataSequenceNumber + 1
+	if payloadType == message.Output {
+		atomic.AddInt64(&dataChannel.streamDataBytesSent, payloadLength)
+	}
+	// empty messages keep the connection alive without counting as activity
+	if payloadType == message.Output && payloadLength > 0 && atomic.LoadInt32(&dataChannel.streamActivity) == 0 {
+		atomic.StoreInt64(&dataChannel.lastActivity, time.Now().UnixNano())
+	}
 
 	return
 }
 
 // ResendStreamDataMessageScheduler spawns a separate go thread which keeps checking OutgoingMessageBuffer at fixed interval
-// and resends first message if time elapsed since lastSentTime of the message is more than acknowledge wait time
+// and resends first message if time elapsed since lastSentTime of the message is more than acknowledge wait time.
+// It stops once the data channel is closed.
 func (dataChannel *DataChannel) ResendStreamDataMessageScheduler(log log.T) (err error) {
+	closed := dataChannel.closed
 	go func() {
 		for {
-			time.Sleep(config.ResendSleepInterval)
+			select {
+			case <-closed:
+				return
+			case <-time.After(config.ResendSleepInterval):
+			}
 			dataChannel.OutgoingMessageBuffer.Mutex.Lock()
 			streamMessageElement := dataChannel.OutgoingMessageBuffer.Messages.Front()
+			retransmissionTimeout := dataChannel.RetransmissionTimeout
 			dataChannel.OutgoingMessageBuffer.Mutex.Unlock()
 
 			if streamMessageElement == nil {
@@ -343,11 +449,15 @@ func (dataChannel *DataChannel) ResendStreamDataMessageScheduler(log log.T) (err
 			}
 
 			streamMessage := streamMessageElement.Value.(StreamingMessage)
-			if time.Since(streamMessage.LastSentTime) > dataChannel.RetransmissionTimeout {
+			if time.Since(streamMessage.LastSentTime) > retransmissionTimeout {
 				log.Debugf("Resend stream data message %d for the %d attempt.", streamMessage.SequenceNumber, *streamMessage.ResendAttempt)
 				if *streamMessage.ResendAttempt >= config.ResendMaxAttempt {
 					log.Warnf("Message %d was resent over %d times.", streamMessage.SequenceNumber, config.ResendMaxAttempt)
-					dataChannel.isStreamMessageResendTimeout <- true
+					select {
+					case dataChannel.isStreamMessageResendTimeout <- true:
+					case <-closed:
+						return
+					}
 				}
 				*streamMessage.ResendAttempt++
 				if err = SendMessageCall(log, dataChannel, streamMessage.Content, websocket.BinaryMessage); err != nil {
@@ -589,18 +699,24 @@ func (dataChannel *DataChannel) DeregisterOutputStreamHandler(handler OutputStre
 	}
 }
 
-func (dataChannel *DataChannel) processOutputMessageWithHandlers(log log.T, message message.ClientMessage) (isHandlerReady bool, err error) {
+func (dataChannel *DataChannel) processOutputMessageWithHandlers(log log.T, clientMessage message.ClientMessage) (isHandlerReady bool, err error) {
 	// Return false if sessionType is known but session specific handler is not set
 	if dataChannel.sessionType != "" && !dataChannel.isSessionSpecificHandlerSet {
 		return false, nil
 	}
 	for _, handler := range dataChannel.outputStreamHandlers {
-		isHandlerReady, err = handler(log, message)
+		isHandlerReady, err = handler(log, clientMessage)
 		// Break the processing of message and return if session specific handler is not ready
 		if err != nil || !isHandlerReady {
 			break
 		}
 	}
+	if isHandlerReady && err == nil && clientMessage.PayloadType == uint32(message.Output) {
+		atomic.AddInt64(&dataChannel.streamDataBytesReceived, int64(len(clientMessage.Payload)))
+		if atomic.LoadInt32(&dataChannel.streamActivity) == 0 {
+			atomic.StoreInt64(&dataChannel.lastActivity, time.Now().UnixNano())
+		}
+	}
 	return isHandlerReady, err
 }
 
@@ -773,7 +889,7 @@ func (dataChannel *DataChannel) HandleAcknowledgeMessage(
 }
 
 // handleChannelClosedMessage exits the shell
-func (dataChannel DataChannel) HandleChannelClosedMessage(log log.T, stopHandler Stop, sessionId string, outputMessage message.ClientMessage) {
+func (dataChannel *DataChannel) HandleChannelClosedMessage(log log.T, stopHandler Stop, sessionId string, outputMessage message.ClientMessage) {
 	var (
 		channelClosedMessage message.ChannelClosed
 		err                  error
@@ -830,6 +946,9 @@ func (dataChannel *DataChannel) RemoveDataFromIncomingMessageBuffer(sequenceNumb
 func (dataChannel *DataChannel) CalculateRetransmissionTimeout(log log.T, streamingMessage StreamingMessage) {
 	newRoundTripTime := float64(GetRoundTripTime(streamingMessage))
 
+	dataChannel.OutgoingMessageBuffer.Mutex.Lock()
+	defer dataChannel.OutgoingMessageBuffer.Mutex.Unlock()
+
 	dataChannel.RoundTripTimeVariation = ((1 - config.RTTVConstant) * dataChannel.RoundTripTimeVariation) +
 		(config.RTTVConstant * math.Abs(dataChannel.RoundTripTime-newRoundTripTime))
 
@@ -924,9 +1043,68 @@ func (dataChannel *DataChannel) SetWsChannel(wsChannel communicator.IWebSocketCh
 
 // GetStreamDataSequenceNumber returns StreamDataSequenceNumber of the dataChannel
 func (dataChannel *DataChannel) GetStreamDataSequenceNumber() int64 {
+	dataChannel.sendMutex.Lock()
+	defer dataChannel.sendMutex.Unlock()
 	return dataChannel.StreamDataSequenceNumber
 }
 
+// GetStreamDataByteCount returns the number of output payload bytes sent and received on the dataChannel
+func (dataChannel *DataChannel) GetStreamDataByteCount() (sent int64, received int64) {
+	return atomic.LoadInt64(&dataChannel.streamDataBytesSent), atomic.LoadInt64(&dataChannel.streamDataBytesReceived)
+}
+
+// GetLastActivityTime returns when input was last sent or output last received on the dataChannel
+func (dataChannel *DataChannel) GetLastActivityTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&dataChannel.lastActivity))
+}
+
+// TrackStreamActivity stops recording activity for every payload, the session records it with RecordStreamActivity
+// when data of the streams it multiplexes is sent or received. Frames of the multiplexer such as keep-alives are
+// payload too, and must not keep an idle session open.
+func (dataChannel *DataChannel) TrackStreamActivity() {
+	atomic.StoreInt32(&dataChannel.streamActivity, 1)
+}
+
+// RecordStreamActivity records that data of a stream multiplexed in the dataChannel was sent or received
+func (dataChannel *DataChannel) RecordStreamActivity() {
+	atomic.StoreInt64(&dataChannel.lastActivity, time.Now().UnixNano())
+}
+
+// GetRoundTripTime returns the smoothed round trip time observed for acknowledged messages
+func (dataChannel *DataChannel) GetRoundTripTime() time.Duration {
+	dataChannel.OutgoingMessageBuffer.Mutex.Lock()
+	defer dataChannel.OutgoingMessageBuffer.Mutex.Unlock()
+	return time.Duration(dataChannel.RoundTripTime)
+}
+
+// GetRetransmissionTimeout returns the timeout after which unacknowledged messages are resent
+func (dataChannel *DataChannel) GetRetransmissionTimeout() time.Duration {
+	dataChannel.OutgoingMessageBuffer.Mutex.Lock()
+	defer dataChannel.OutgoingMessageBuffer.Mutex.Unlock()
+	return dataChannel.RetransmissionTimeout
+}
+
+// GetUnacknowledgedMessageCount returns the number of sent messages waiting for an acknowledgement
+func (dataChannel *DataChannel) GetUnacknowledgedMessageCount() int {
+	dataChannel.OutgoingMessageBuffer.Mutex.Lock()
+	defer dataChannel.OutgoingMessageBuffer.Mutex.Unlock()
+	return dataChannel.OutgoingMessageBuffer.Messages.Len()
+}
+
+// IsReconnecting returns whether the session is trying to reconnect a lost connection
+func (dataChannel *DataChannel) IsReconnecting() bool {
+	return atomic.LoadInt32(&dataChannel.reconnecting) == 1
+}
+
+// SetReconnecting records whether the session is trying to reconnect a lost connection
+func (dataChannel *DataChannel) SetReconnecting(reconnecting bool) {
+	var value int32
+	if reconnecting {
+		value = 1
+	}
+	atomic.StoreInt32(&dataChannel.reconnecting, value)
+}
+
 // GetAgentVersion returns agent version of the target instance
 func (dataChannel *DataChannel) GetAgentVersion() string {
 	return dataChannel.agentVersion
@@ -105,6 +105,33 @@ func TestReconnect(t *testing.T) {
 	mockWsChannel.AssertExpectations(t)
 }
 
+func TestSwitchSession(t *testing.T) {
+	datachannel := getDataChannel()
+	wsChannel := &communicatorMocks.IWebSocketChannel{}
+	datachannel.wsChannel = wsChannel
+	datachannel.ExpectedSequenceNumber = 5
+	datachannel.StreamDataSequenceNumber = 7
+	datachannel.AddDataToOutgoingMessageBuffer(StreamingMessage{Content: []byte("data"), SequenceNumber: 6})
+	datachannel.isSessionTypeSet <- true
+
+	wsChannel.On("GetStreamUrl").Return("wss://old-stream")
+	wsChannel.On("Close", mock.Anything).Return(nil)
+	wsChannel.On("Initialize", mock.Anything, "wss://new-stream", "new-token").Return(nil)
+	wsChannel.On("Open", mock.Anything).Return(nil)
+	wsChannel.On("GetChannelToken").Return("new-token")
+	wsChannel.On("SendMessage", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	err := datachannel.SwitchSession(mockLogger, "new-session", "wss://new-stream", "new-token")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "new-session", datachannel.SessionId)
+	assert.Equal(t, int64(0), datachannel.ExpectedSequenceNumber)
+	assert.Equal(t, int64(0), datachannel.StreamDataSequenceNumber)
+	assert.Equal(t, 0, datachannel.GetUnacknowledgedMessageCount())
+	assert.Equal(t, 0, len(datachannel.isSessionTypeSet))
+	wsChannel.AssertExpectations(t)
+}
+
 func TestOpen(t *testing.T) {
 	datachannel := getDataChannel()
 
@@ -126,6 +153,30 @@ func TestClose(t *testing.T) {
 
 	assert.Nil(t, err)
 	mockWsChannel.AssertExpectations(t)
+	select {
+	case <-datachannel.Closed():
+	default:
+		t.Error("Closed channel not closed")
+	}
+	assert.Nil(t, datachannel.Close(mockLogger))
+}
+
+func TestReconnectDoesNotCloseForGood(t *testing.T) {
+	datachannel := getDataChannel()
+	wsChannel := &communicatorMocks.IWebSocketChannel{}
+	datachannel.wsChannel = wsChannel
+	wsChannel.On("Close", mock.Anything).Return(nil)
+	wsChannel.On("Open", mock.Anything).Return(nil)
+	wsChannel.On("GetStreamUrl").Return(streamUrl)
+	wsChannel.On("GetChannelToken").Return(channelToken)
+	wsChannel.On("SendMessage", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	assert.Nil(t, datachannel.Reconnect(mockLogger))
+	select {
+	case <-datachannel.Closed():
+		t.Error("Closed channel closed by Reconnect")
+	default:
+	}
 }
 
 func TestFinalizeDataChannelHandshake(t *testing.T) {
@@ -159,9 +210,79 @@ func TestSendInputDataMessage(t *testing.T) {
 
 	assert.Equal(t, streamDataSequenceNumber+1, dataChannel.StreamDataSequenceNumber)
 	assert.Equal(t, 1, dataChannel.OutgoingMessageBuffer.Messages.Len())
+	sent, received := dataChannel.GetStreamDataByteCount()
+	assert.Equal(t, int64(len(payload)), sent)
+	assert.Equal(t, int64(0), received)
 	mockWsChannel.AssertExpectations(t)
 }
 
+func TestStreamActivity(t *testing.T) {
+	dataChannel := getDataChannel()
+	defaultSendMessageCall := SendMessageCall
+	defer func() { SendMessageCall = defaultSendMessageCall }()
+	SendMessageCall = func(log log.T, dataChannel *DataChannel, input []byte, inputType int) error {
+		return nil
+	}
+
+	// once tracked by the session, payload sent is no activity of its own
+	dataChannel.TrackStreamActivity()
+	lastActivity := dataChannel.GetLastActivityTime()
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, dataChannel.SendInputDataMessage(mockLogger, message.Output, payload))
+	assert.Equal(t, lastActivity, dataChannel.GetLastActivityTime())
+
+	dataChannel.RecordStreamActivity()
+	assert.True(t, dataChannel.GetLastActivityTime().After(lastActivity))
+}
+
+func TestRoundTripTimeConcurrently(t *testing.T) {
+	dataChannel := getDataChannel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			dataChannel.CalculateRetransmissionTimeout(mockLogger, streamingMessages[0])
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		assert.True(t, dataChannel.GetRoundTripTime() >= 0)
+		assert.True(t, dataChannel.GetRetransmissionTimeout() > 0)
+	}
+	<-done
+}
+
+func TestSendInputDataMessageConcurrently(t *testing.T) {
+	dataChannel := getDataChannel()
+	var sentSequenceNumbers []int64
+	defaultSendMessageCall := SendMessageCall
+	defer func() { SendMessageCall = defaultSendMessageCall }()
+	SendMessageCall = func(log log.T, dataChannel *DataChannel, input []byte, inputType int) error {
+		clientMessage := &message.ClientMessage{}
+		clientMessage.DeserializeClientMessage(log, input)
+		sentSequenceNumbers = append(sentSequenceNumbers, clientMessage.SequenceNumber)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dataChannel.SendInputDataMessage(mockLogger, message.Output, payload)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(10), dataChannel.GetStreamDataSequenceNumber())
+	assert.Equal(t, 10, dataChannel.OutgoingMessageBuffer.Messages.Len())
+	element := dataChannel.OutgoingMessageBuffer.Messages.Front()
+	for i, sequenceNumber := range sentSequenceNumbers {
+		assert.Equal(t, int64(i), sequenceNumber)
+		assert.Equal(t, int64(i), element.Value.(StreamingMessage).SequenceNumber)
+		element = element.Next()
+	}
+}
+
 func TestProcessAcknowledgedMessage(t *testing.T) {
 	dataChannel := getDataChannel()
 	dataChannel.AddDataToOutgoingMessageBuffer(streamingMessages[0])
@@ -187,6 +308,20 @@ func TestCalculateRetransmissionTimeout(t *testing.T) {
 	assert.Equal(t, int64(145), int64(dataChannel.RetransmissionTimeout/time.Millisecond))
 }
 
+func TestSessionHealth(t *testing.T) {
+	dataChannel := getDataChannel()
+	dataChannel.AddDataToOutgoingMessageBuffer(streamingMessages[0])
+	dataChannel.AddDataToOutgoingMessageBuffer(streamingMessages[1])
+
+	assert.Equal(t, 2, dataChannel.GetUnacknowledgedMessageCount())
+	assert.Equal(t, config.DefaultTransmissionTimeout, dataChannel.GetRetransmissionTimeout())
+	assert.False(t, dataChannel.IsReconnecting())
+	dataChannel.SetReconnecting(true)
+	assert.True(t, dataChannel.IsReconnecting())
+	dataChannel.SetReconnecting(false)
+	assert.False(t, dataChannel.IsReconnecting())
+}
+
 func TestAddDataToOutgoingMessageBuffer(t *testing.T) {
 	dataChannel := getDataChannel()
 	dataChannel.OutgoingMessageBuffer.Capacity = 2
@@ -16,6 +16,7 @@ package sdkutil
 
 import (
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -28,15 +29,27 @@ var defaultProfile string
 
 // GetNewSessionWithEndpoint creates aws sdk session with given profile, region and endpoint
 func GetNewSessionWithEndpoint(endpoint string) (sess *session.Session, err error) {
+	return GetNewSession(endpoint, "", "")
+}
+
+// GetNewSession creates aws sdk session with given endpoint, region and profile, the default region and
+// profile are used if empty
+func GetNewSession(endpoint string, region string, profile string) (sess *session.Session, err error) {
+	if region == "" {
+		region = defaultRegion
+	}
+	if profile == "" {
+		profile = defaultProfile
+	}
 	if sess, err = session.NewSessionWithOptions(session.Options{
 		Config: aws.Config{
 			Retryer:    newRetryer(),
 			SleepDelay: sleepDelay,
-			Region:     aws.String(defaultRegion),
+			Region:     aws.String(region),
 			Endpoint:   aws.String(endpoint),
 		},
 		SharedConfigState: session.SharedConfigEnable,
-		Profile:           defaultProfile,
+		Profile:           profile,
 	}); err != nil {
 		return nil, fmt.Errorf("Error creating new aws sdk session %s", err)
 	}
@@ -54,6 +67,17 @@ func SetRegionAndProfile(region string, profile string) {
 	defaultProfile = profile
 }
 
+// GetProfile returns the profile used for default aws sessions, as resolved by the sdk
+func GetProfile() string {
+	if defaultProfile != "" {
+		return defaultProfile
+	}
+	if profile := os.Getenv("AWS_PROFILE"); profile != "" {
+		return profile
+	}
+	return "default"
+}
+
 var newRetryer = func() aws.RequestRetryer {
 	r := retryer.SsmCliRetryer{}
 	r.NumMaxRetries = 3
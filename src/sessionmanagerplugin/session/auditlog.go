@@ -0,0 +1,388 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package session starts the session.
+package session
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/sessionutil"
+)
+
+const (
+	AuditEventSessionStart     = "SessionStart"
+	AuditEventSessionReconnect = "SessionReconnect"
+	AuditEventSessionEnd       = "SessionEnd"
+
+	// auditHeadSuffix is appended to the audit log path to name the file holding the latest record hash,
+	// which allows truncation of the log to be detected.
+	auditHeadSuffix = ".head"
+)
+
+// AuditRecord is a single line of the audit log.
+// Hash covers every other field of the record, including the hash of the previous record.
+type AuditRecord struct {
+	Sequence      int64               `json:"sequence"`
+	Timestamp     string              `json:"timestamp"`
+	Event         string              `json:"event"`
+	SessionId     string              `json:"sessionId"`
+	TargetId      string              `json:"targetId,omitempty"`
+	ClientId      string              `json:"clientId,omitempty"`
+	DocumentName  string              `json:"documentName,omitempty"`
+	Parameters    map[string][]string `json:"parameters,omitempty"`
+	Reason        string              `json:"reason,omitempty"`
+	BytesSent     int64               `json:"bytesSent"`
+	BytesReceived int64               `json:"bytesReceived"`
+	PreviousHash  string              `json:"previousHash"`
+	Hash          string              `json:"hash,omitempty"`
+}
+
+// auditHead records the sequence number and hash of the last record written to the audit log.
+type auditHead struct {
+	Sequence int64  `json:"sequence"`
+	Hash     string `json:"hash"`
+}
+
+// AuditLog appends hash chained audit records to a local file.
+type AuditLog struct {
+	path  string
+	key   []byte
+	mutex sync.Mutex
+	// head is the last record of the log, valid as long as the log has the size it had once head was written
+	head *auditHead
+	size int64
+	// ended holds the sessions whose SessionEnd record was appended
+	ended      map[string]bool
+	timeNowUTC func() time.Time
+}
+
+// NewAuditLog returns an audit log writing to the given path.
+// When key is not empty records are chained with HMAC-SHA256 instead of SHA256.
+func NewAuditLog(path string, key []byte) *AuditLog {
+	return &AuditLog{
+		path:  path,
+		key:   key,
+		ended: make(map[string]bool),
+		timeNowUTC: func() time.Time {
+			return time.Now().UTC()
+		},
+	}
+}
+
+// ReadAuditKeyFile reads the HMAC key used for the audit log from the given file.
+func ReadAuditKeyFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read audit key file: %v", err)
+	}
+	return []byte(strings.TrimSpace(string(key))), nil
+}
+
+// Append chains the record to the last record of the audit log and writes it.
+func (a *AuditLog) Append(record AuditRecord) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.append(record)
+}
+
+// append writes the record as Append does, the caller holds the mutex.
+func (a *AuditLog) append(record AuditRecord) (err error) {
+	var file *os.File
+	if file, err = os.OpenFile(a.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+		return fmt.Errorf("unable to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	// other plugin processes may be writing to the same audit log
+	if err = sessionutil.LockFile(file); err != nil {
+		return fmt.Errorf("unable to lock audit log: %v", err)
+	}
+	defer sessionutil.UnlockFile(file)
+
+	var info os.FileInfo
+	if info, err = file.Stat(); err != nil {
+		return fmt.Errorf("unable to read audit log: %v", err)
+	}
+	// the last record is read once the log is opened, and again only if another process appended to it since
+	if a.head == nil || info.Size() != a.size {
+		if a.head, err = readLastAuditHead(file, info.Size(), a.path+auditHeadSuffix); err != nil {
+			return err
+		}
+	}
+
+	record.Timestamp = a.timeNowUTC().Format(time.RFC3339Nano)
+	record.Sequence = a.head.Sequence + 1
+	record.PreviousHash = a.head.Hash
+	if record.Hash, err = computeAuditRecordHash(record, a.key); err != nil {
+		return err
+	}
+
+	var line []byte
+	if line, err = json.Marshal(record); err != nil {
+		return fmt.Errorf("unable to serialize audit record: %v", err)
+	}
+	// the log is read again by the next record unless it is written completely
+	a.head = nil
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("unable to write audit record: %v", err)
+	}
+	head := auditHead{record.Sequence, record.Hash}
+	if err = writeAuditHead(a.path+auditHeadSuffix, head); err != nil {
+		return err
+	}
+	a.head, a.size = &head, info.Size()+int64(len(line))+1
+	return nil
+}
+
+// AppendSessionEnd appends the SessionEnd record of the session of record once, the first reason given is kept.
+func (a *AuditLog) AppendSessionEnd(record AuditRecord) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.ended[record.SessionId] {
+		return nil
+	}
+	a.ended[record.SessionId] = true
+	record.Event = AuditEventSessionEnd
+	return a.append(record)
+}
+
+// VerifyAuditLog checks the hash chain of the audit log at path and returns the number of verified records.
+// Verification fails if a record was modified, removed, reordered or if records were truncated from the end of the log.
+func VerifyAuditLog(path string, key []byte) (count int64, err error) {
+	var file *os.File
+	if file, err = os.Open(path); err != nil {
+		return 0, fmt.Errorf("unable to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	var (
+		previous *AuditRecord
+		line     int
+	)
+	reader := bufio.NewReader(file)
+	for {
+		var data []byte
+		data, err = reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return count, fmt.Errorf("unable to read audit log: %v", err)
+		}
+		endOfFile := err == io.EOF
+		err = nil
+		if len(strings.TrimSpace(string(data))) > 0 {
+			line++
+			if endOfFile {
+				return count, fmt.Errorf("line %d: incomplete audit record", line)
+			}
+			record := AuditRecord{}
+			if err = json.Unmarshal(data, &record); err != nil {
+				return count, fmt.Errorf("line %d: invalid audit record: %v", line, err)
+			}
+			if err = verifyAuditRecord(record, previous, key); err != nil {
+				return count, fmt.Errorf("line %d: %v", line, err)
+			}
+			previous = &record
+			count++
+		}
+		if endOfFile {
+			break
+		}
+	}
+
+	var head *auditHead
+	if head, err = readAuditHead(path + auditHeadSuffix); err != nil {
+		return count, err
+	}
+	switch {
+	case head == nil && previous != nil:
+		return count, fmt.Errorf("audit head file %s is missing", path+auditHeadSuffix)
+	case head != nil && previous == nil:
+		return count, fmt.Errorf("audit log is empty but head file records sequence %d", head.Sequence)
+	case head != nil && (head.Sequence != previous.Sequence || head.Hash != previous.Hash):
+		return count, fmt.Errorf("audit log ends at sequence %d but head file records sequence %d, log was truncated or modified",
+			previous.Sequence, head.Sequence)
+	}
+	return count, nil
+}
+
+// verifyAuditRecord checks the record hash and its link to the previous record.
+func verifyAuditRecord(record AuditRecord, previous *AuditRecord, key []byte) error {
+	expectedSequence, expectedPreviousHash := int64(1), ""
+	if previous != nil {
+		expectedSequence, expectedPreviousHash = previous.Sequence+1, previous.Hash
+	}
+	if record.Sequence != expectedSequence {
+		return fmt.Errorf("expected sequence %d but found %d", expectedSequence, record.Sequence)
+	}
+	if record.PreviousHash != expectedPreviousHash {
+		return fmt.Errorf("record %d is not chained to the previous record", record.Sequence)
+	}
+	hashValue, err := computeAuditRecordHash(record, key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(hashValue), []byte(record.Hash)) {
+		return fmt.Errorf("hash mismatch for record %d", record.Sequence)
+	}
+	return nil
+}
+
+// computeAuditRecordHash returns the hex encoded hash of the record with its hash field cleared.
+func computeAuditRecordHash(record AuditRecord, key []byte) (string, error) {
+	record.Hash = ""
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("unable to serialize audit record: %v", err)
+	}
+
+	var hasher hash.Hash
+	if len(key) > 0 {
+		hasher = hmac.New(sha256.New, key)
+	} else {
+		hasher = sha256.New()
+	}
+	hasher.Write(data)
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// readLastAuditHead returns the sequence number and hash of the last record of the audit log of the given size,
+// recorded by the head file. The log itself is read only if it was written before head files existed.
+func readLastAuditHead(file *os.File, size int64, headPath string) (*auditHead, error) {
+	if size == 0 {
+		return &auditHead{}, nil
+	}
+	head, err := readAuditHead(headPath)
+	if err != nil || head != nil {
+		return head, err
+	}
+	var last *AuditRecord
+	if last, err = readLastAuditRecord(file); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return &auditHead{}, nil
+	}
+	return &auditHead{last.Sequence, last.Hash}, nil
+}
+
+// readLastAuditRecord returns the last record of the audit log, or nil if the log is empty.
+func readLastAuditRecord(file *os.File) (*AuditRecord, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("unable to read audit log: %v", err)
+	}
+	var last string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if text := strings.TrimSpace(scanner.Text()); text != "" {
+			last = text
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read audit log: %v", err)
+	}
+	if last == "" {
+		return nil, nil
+	}
+
+	record := &AuditRecord{}
+	if err := json.Unmarshal([]byte(last), record); err != nil {
+		return nil, fmt.Errorf("last audit record is invalid: %v", err)
+	}
+	return record, nil
+}
+
+// writeAuditHead replaces the head file with the given head.
+func writeAuditHead(path string, head auditHead) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return fmt.Errorf("unable to serialize audit head: %v", err)
+	}
+	tempPath := path + ".tmp"
+	if err = ioutil.WriteFile(tempPath, data, 0600); err != nil {
+		return fmt.Errorf("unable to write audit head: %v", err)
+	}
+	if err = os.Rename(tempPath, path); err != nil {
+		return fmt.Errorf("unable to write audit head: %v", err)
+	}
+	return nil
+}
+
+// readAuditHead reads the head file, returning nil if it does not exist.
+func readAuditHead(path string) (*auditHead, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read audit head: %v", err)
+	}
+	head := &auditHead{}
+	if err = json.Unmarshal(data, head); err != nil {
+		return nil, fmt.Errorf("invalid audit head: %v", err)
+	}
+	return head, nil
+}
+
+// auditRecord builds a record for the session, it does not modify the session.
+func (s *Session) auditRecord(event string, reason string) AuditRecord {
+	record := AuditRecord{
+		Event:        event,
+		SessionId:    s.SessionId,
+		TargetId:     s.TargetId,
+		ClientId:     s.ClientId,
+		DocumentName: s.DocumentName,
+		Parameters:   s.Parameters,
+		Reason:       reason,
+	}
+	if s.DataChannel != nil {
+		record.BytesSent, record.BytesReceived = s.DataChannel.GetStreamDataByteCount()
+	}
+	return record
+}
+
+// AuditEvent appends an event for the session to the audit log, if one is configured.
+func (s *Session) AuditEvent(log log.T, event string, reason string) {
+	if s.AuditLog == nil {
+		return
+	}
+	if err := s.AuditLog.Append(s.auditRecord(event, reason)); err != nil {
+		log.Errorf("Failed to write %s audit record: %v", event, err)
+	}
+}
+
+// AuditSessionEnd appends the SessionEnd event to the audit log, if one is configured.
+// Only the first call for a session is recorded so the earliest known reason is kept.
+func (s *Session) AuditSessionEnd(log log.T, reason string) {
+	if s.AuditLog == nil {
+		return
+	}
+	if err := s.AuditLog.AppendSessionEnd(s.auditRecord(AuditEventSessionEnd, reason)); err != nil {
+		log.Errorf("Failed to write %s audit record: %v", AuditEventSessionEnd, err)
+	}
+}
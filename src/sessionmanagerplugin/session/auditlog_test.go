@@ -0,0 +1,188 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package session starts the session.
+package session
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/session-manager-plugin/src/datachannel"
+	"github.com/stretchr/testify/assert"
+)
+
+func getAuditLogPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "auditlog")
+	assert.Nil(t, err)
+	return filepath.Join(dir, "audit.log")
+}
+
+func writeAuditRecords(t *testing.T, auditLog *AuditLog) {
+	session := Session{
+		SessionId:    sessionId,
+		TargetId:     instanceId,
+		DocumentName: "AWS-StartPortForwardingSession",
+		Parameters:   map[string][]string{"portNumber": {"80"}},
+		AuditLog:     auditLog,
+	}
+	session.AuditEvent(logger, AuditEventSessionStart, "")
+	session.AuditEvent(logger, AuditEventSessionReconnect, "")
+	session.AuditSessionEnd(logger, "session timed out")
+	session.AuditSessionEnd(logger, "session closed")
+}
+
+func TestAuditLogVerify(t *testing.T) {
+	path := getAuditLogPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	writeAuditRecords(t, NewAuditLog(path, nil))
+
+	count, err := VerifyAuditLog(path, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), count)
+
+	data, _ := ioutil.ReadFile(path)
+	assert.Contains(t, string(data), "session timed out")
+	assert.NotContains(t, string(data), "session closed")
+}
+
+func TestAuditLogChainsAcrossWriters(t *testing.T) {
+	path := getAuditLogPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	writeAuditRecords(t, NewAuditLog(path, nil))
+	writeAuditRecords(t, NewAuditLog(path, nil))
+
+	count, err := VerifyAuditLog(path, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), count)
+}
+
+func TestAuditLogChainsInterleavedWriters(t *testing.T) {
+	path := getAuditLogPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	first, second := NewAuditLog(path, nil), NewAuditLog(path, nil)
+	assert.Nil(t, first.Append(AuditRecord{Event: AuditEventSessionStart, SessionId: sessionId}))
+	assert.Nil(t, second.Append(AuditRecord{Event: AuditEventSessionStart, SessionId: "other-session"}))
+	assert.Nil(t, first.Append(AuditRecord{Event: AuditEventSessionReconnect, SessionId: sessionId}))
+
+	count, err := VerifyAuditLog(path, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestAuditLogReadsHeadOnce(t *testing.T) {
+	path := getAuditLogPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	auditLog := NewAuditLog(path, nil)
+	assert.Nil(t, auditLog.Append(AuditRecord{Event: AuditEventSessionStart, SessionId: sessionId}))
+	// the head written by the logger itself is not read again
+	ioutil.WriteFile(path+auditHeadSuffix, []byte("invalid"), 0600)
+	assert.Nil(t, auditLog.Append(AuditRecord{Event: AuditEventSessionReconnect, SessionId: sessionId}))
+
+	count, err := VerifyAuditLog(path, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestAuditLogSessionEndPerSession(t *testing.T) {
+	path := getAuditLogPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	auditLog := NewAuditLog(path, nil)
+	for _, id := range []string{sessionId, "other-session", sessionId} {
+		session := Session{SessionId: id, AuditLog: auditLog}
+		session.AuditSessionEnd(logger, "session closed")
+	}
+
+	count, err := VerifyAuditLog(path, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestAuditLogDetectsModification(t *testing.T) {
+	path := getAuditLogPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	writeAuditRecords(t, NewAuditLog(path, nil))
+	data, _ := ioutil.ReadFile(path)
+	ioutil.WriteFile(path, []byte(strings.Replace(string(data), instanceId, "i-other", 1)), 0600)
+
+	count, err := VerifyAuditLog(path, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "hash mismatch for record 1")
+	assert.Equal(t, int64(0), count)
+}
+
+func TestAuditLogDetectsTruncation(t *testing.T) {
+	path := getAuditLogPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	writeAuditRecords(t, NewAuditLog(path, nil))
+	data, _ := ioutil.ReadFile(path)
+	lines := strings.SplitAfter(string(data), "\n")
+	ioutil.WriteFile(path, []byte(lines[0]+lines[1]), 0600)
+
+	count, err := VerifyAuditLog(path, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "truncated")
+	assert.Equal(t, int64(2), count)
+}
+
+func TestAuditLogWithKey(t *testing.T) {
+	path := getAuditLogPath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	writeAuditRecords(t, NewAuditLog(path, []byte("secret")))
+
+	_, err := VerifyAuditLog(path, []byte("secret"))
+	assert.Nil(t, err)
+
+	_, err = VerifyAuditLog(path, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "hash mismatch")
+}
+
+func TestAuditRecordByteCount(t *testing.T) {
+	dataChannel := &datachannel.DataChannel{}
+	dataChannel.Initialize(logger, clientId, sessionId, instanceId, false)
+	session := Session{
+		SessionId:   sessionId,
+		DataChannel: dataChannel,
+	}
+
+	record := session.auditRecord(AuditEventSessionEnd, "session closed")
+	assert.Equal(t, AuditEventSessionEnd, record.Event)
+	assert.Equal(t, sessionId, record.SessionId)
+	assert.Equal(t, int64(0), record.BytesSent)
+	assert.Equal(t, int64(0), record.BytesReceived)
+}
+
+func TestGetDocumentAndParameters(t *testing.T) {
+	startSessionRequest := map[string]interface{}{
+		"Target":       "i-0123abc",
+		"DocumentName": "AWS-StartPortForwardingSession",
+		"Parameters": map[string]interface{}{
+			"portNumber": []interface{}{"80"},
+		},
+	}
+	documentName, parameters := getDocumentAndParameters(startSessionRequest)
+	assert.Equal(t, "AWS-StartPortForwardingSession", documentName)
+	assert.Equal(t, []string{"80"}, parameters["portNumber"])
+}
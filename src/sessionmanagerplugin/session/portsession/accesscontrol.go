@@ -0,0 +1,170 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/sessionutil"
+)
+
+// DefaultSocketMode only lets the user of the plugin connect to the unix sockets of port sessions
+const DefaultSocketMode os.FileMode = 0600
+
+// listenUnixSocket listens on the unix socket of a port session with the permissions and owner set in base,
+// replacing a socket left behind by a plugin that did not exit cleanly.
+func listenUnixSocket(base *session.Session, path string) (net.Listener, error) {
+	mode := base.SocketMode
+	if mode == 0 {
+		mode = DefaultSocketMode
+	}
+	uid, gid := -1, -1
+	if base.SocketOwner != "" {
+		var err error
+		if uid, gid, err = sessionutil.ParseSocketOwner(base.SocketOwner); err != nil {
+			return nil, err
+		}
+	}
+	return sessionutil.ListenUnixSocket(path, mode, uid, gid)
+}
+
+// guardListener returns listener accepting only the clients allowed by base, and at most base.MaxConnections
+// connections at once, or listener itself if it accepts any client.
+func guardListener(log log.T, base *session.Session, listener net.Listener) net.Listener {
+	if len(base.AllowClients) == 0 && len(base.AllowPeerUIDs) == 0 && len(base.AllowPeerGIDs) == 0 && base.MaxConnections <= 0 {
+		return listener
+	}
+	return &guardedListener{
+		Listener:       listener,
+		log:            log,
+		clients:        base.AllowClients,
+		uids:           base.AllowPeerUIDs,
+		gids:           base.AllowPeerGIDs,
+		maxConnections: base.MaxConnections,
+	}
+}
+
+// guardedListener refuses clients that are not allowed, and connections beyond the maximum.
+type guardedListener struct {
+	net.Listener
+	log            log.T
+	clients        []*net.IPNet
+	uids           []uint32
+	gids           []uint32
+	maxConnections int
+	mutex          sync.Mutex
+	connections    int
+}
+
+// Accept returns the next connection of an allowed client, refused connections are logged and closed.
+func (l *guardedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		client, refusal := l.check(conn)
+		if refusal == "" {
+			refusal = l.open()
+		}
+		if refusal != "" {
+			l.log.Warnf("Connection from %s to %s refused, %s.", client, l.Addr(), refusal)
+			conn.Close()
+			continue
+		}
+		return &guardedConn{Conn: conn, closed: l.closed}, nil
+	}
+}
+
+// check returns a description of the client of conn and why it is refused, empty if it is allowed.
+func (l *guardedListener) check(conn net.Conn) (client string, refusal string) {
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		return addr.String(), l.checkAddress(addr.IP)
+	case *net.UDPAddr:
+		return addr.String(), l.checkAddress(addr.IP)
+	}
+	if len(l.uids) == 0 && len(l.gids) == 0 {
+		return "unix socket client", ""
+	}
+	uid, gid, err := peerCredentials(conn)
+	if err != nil {
+		return "unix socket client", fmt.Sprintf("peer credentials unavailable: %v", err)
+	}
+	client = fmt.Sprintf("uid %d gid %d", uid, gid)
+	if containsId(l.uids, uid) || containsId(l.gids, gid) {
+		return client, ""
+	}
+	return client, "user and group not allowed"
+}
+
+// checkAddress returns why the client at ip is refused, empty if it is allowed.
+func (l *guardedListener) checkAddress(ip net.IP) string {
+	if len(l.clients) == 0 {
+		return ""
+	}
+	for _, network := range l.clients {
+		if network.Contains(ip) {
+			return ""
+		}
+	}
+	return "client address not allowed"
+}
+
+// open counts a new connection and returns why it is refused if the maximum is reached.
+func (l *guardedListener) open() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.maxConnections > 0 && l.connections >= l.maxConnections {
+		return fmt.Sprintf("%d connections are open, the maximum", l.connections)
+	}
+	l.connections++
+	return ""
+}
+
+// closed counts a closed connection.
+func (l *guardedListener) closed() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.connections--
+}
+
+// guardedConn is an accepted connection counted until it is closed.
+type guardedConn struct {
+	net.Conn
+	closed    func()
+	closeOnce sync.Once
+}
+
+// Close closes the connection.
+func (c *guardedConn) Close() error {
+	c.closeOnce.Do(c.closed)
+	return c.Conn.Close()
+}
+
+// containsId returns whether ids contains id.
+func containsId(ids []uint32, id uint32) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
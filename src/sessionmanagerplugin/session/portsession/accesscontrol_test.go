@@ -0,0 +1,135 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package portsession
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/sessionutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// acceptAsync accepts the next connection of listener in the background.
+func acceptAsync(listener net.Listener) chan net.Conn {
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	return accepted
+}
+
+func TestGuardListenerRefusesClientsNotAllowed(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	networks, _ := sessionutil.ParseClientNetworks("10.0.0.0/8")
+	guarded := guardListener(mockLog, &session.Session{AllowClients: networks}, listener)
+	accepted := acceptAsync(guarded)
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+	// the refused connection is closed
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = client.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+	select {
+	case <-accepted:
+		t.Fatal("connection of a client not allowed was accepted")
+	default:
+	}
+}
+
+func TestGuardListenerLimitsConnections(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	networks, _ := sessionutil.ParseClientNetworks("127.0.0.1")
+	guarded := guardListener(mockLog, &session.Session{AllowClients: networks, MaxConnections: 1}, listener)
+
+	accepted := acceptAsync(guarded)
+	first, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer first.Close()
+	conn := <-accepted
+
+	// a second connection is refused while the first one is open
+	accepted = acceptAsync(guarded)
+	second, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = second.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+
+	conn.Close()
+	third, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer third.Close()
+	select {
+	case conn = <-accepted:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not accepted once the first one closed")
+	}
+}
+
+func TestGuardListenerChecksPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("peer credentials are not supported")
+	}
+	otherUser := []uint32{uint32(os.Getuid()) + 1}
+
+	// the user of the test is not allowed
+	path := filepath.Join(t.TempDir(), "forward.sock")
+	base := &session.Session{AllowPeerUIDs: otherUser}
+	listener, err := listenUnixSocket(base, path)
+	assert.Nil(t, err)
+	defer listener.Close()
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultSocketMode, info.Mode().Perm())
+	acceptAsync(guardListener(mockLog, base, listener))
+	client, err := net.Dial("unix", path)
+	assert.Nil(t, err)
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = client.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+
+	// it is once its group is
+	path = filepath.Join(t.TempDir(), "forward.sock")
+	base = &session.Session{AllowPeerUIDs: otherUser, AllowPeerGIDs: []uint32{uint32(os.Getgid())}}
+	listener, err = listenUnixSocket(base, path)
+	assert.Nil(t, err)
+	defer listener.Close()
+	accepted := acceptAsync(guardListener(mockLog, base, listener))
+	client, err = net.Dial("unix", path)
+	assert.Nil(t, err)
+	defer client.Close()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection of an allowed group not accepted")
+	}
+}
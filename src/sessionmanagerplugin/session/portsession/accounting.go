@@ -0,0 +1,246 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+)
+
+const (
+	// CloseReasonClient is recorded when the client closed the connection
+	CloseReasonClient = "client closed"
+	// CloseReasonRemote is recorded when the plugin closed the connection since the remote end closed
+	CloseReasonRemote = "remote closed"
+	// CloseReasonSessionEnded is recorded for the connections still open when the session ends
+	CloseReasonSessionEnded = "session ended"
+)
+
+// ConnectionRecord is a JSON line describing a connection forwarded by a port session once it closed. BytesIn
+// were received from the client, BytesOut sent to it.
+type ConnectionRecord struct {
+	Id          int64     `json:"id"`
+	SessionId   string    `json:"sessionId"`
+	Client      string    `json:"client"`
+	StreamId    uint32    `json:"streamId,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	BytesIn     int64     `json:"bytesIn"`
+	BytesOut    int64     `json:"bytesOut"`
+	CloseReason string    `json:"closeReason"`
+}
+
+// connectionAccounting records the connections accepted by the local listener of a session, writes a record
+// of each one to destinations once closed and sums them up when the session ends.
+type connectionAccounting struct {
+	log          log.T
+	sessionId    string
+	destinations string
+	mutex        sync.Mutex
+	lastId       int64
+	open         map[*accountedConn]struct{}
+	connections  int
+	clients      map[string]struct{}
+	bytesIn      int64
+	bytesOut     int64
+}
+
+// newConnectionAccounting returns the accounting of the connections of a session, records are written to
+// destinations as described for writeJSONLine.
+func newConnectionAccounting(log log.T, sessionId string, destinations string) *connectionAccounting {
+	return &connectionAccounting{
+		log:          log,
+		sessionId:    sessionId,
+		destinations: destinations,
+		open:         make(map[*accountedConn]struct{}),
+		clients:      make(map[string]struct{}),
+	}
+}
+
+// listener returns listener wrapped to account for the connections it accepts.
+func (a *connectionAccounting) listener(listener net.Listener) net.Listener {
+	return &accountedListener{Listener: listener, accounting: a}
+}
+
+// accept starts the record of conn.
+func (a *connectionAccounting) accept(conn net.Conn) *accountedConn {
+	client := conn.RemoteAddr().String()
+	if client == "" || client == "@" {
+		client = "unix socket client"
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.lastId++
+	accounted := &accountedConn{
+		Conn:       conn,
+		accounting: a,
+		record:     ConnectionRecord{Id: a.lastId, SessionId: a.sessionId, Client: client, Start: time.Now()},
+	}
+	a.open[accounted] = struct{}{}
+	return accounted
+}
+
+// closed completes the record of a closed connection and writes it.
+func (a *connectionAccounting) closed(conn *accountedConn) {
+	record := conn.finish()
+
+	a.mutex.Lock()
+	delete(a.open, conn)
+	a.connections++
+	host, _, err := net.SplitHostPort(record.Client)
+	if err != nil {
+		host = record.Client
+	}
+	a.clients[host] = struct{}{}
+	a.bytesIn += record.BytesIn
+	a.bytesOut += record.BytesOut
+	a.mutex.Unlock()
+
+	a.log.Infof("Connection %d from %s closed after %v, %d bytes in, %d bytes out: %s.", record.Id, record.Client,
+		record.End.Sub(record.Start).Round(time.Millisecond), record.BytesIn, record.BytesOut, record.CloseReason)
+	writeJSONLine(a.log, a.destinations, "connection record", record)
+}
+
+// finish closes the connections still open as ended with the session and shows the sum of all connections.
+func (a *connectionAccounting) finish() {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	open := make([]*accountedConn, 0, len(a.open))
+	for conn := range a.open {
+		open = append(open, conn)
+	}
+	a.mutex.Unlock()
+	for _, conn := range open {
+		conn.setCloseReason(CloseReasonSessionEnded)
+		conn.Close()
+	}
+
+	if summary := a.summary(); summary != "" {
+		a.log.Info(summary)
+		fmt.Println(summary)
+	}
+}
+
+// summary describes the connections forwarded, empty if there were none.
+func (a *connectionAccounting) summary() string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.connections == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Forwarded %d connection(s) from %d client(s) for session %s, %d bytes in, %d bytes out.",
+		a.connections, len(a.clients), a.sessionId, a.bytesIn, a.bytesOut)
+}
+
+// accountedListener starts a record for every connection it accepts.
+type accountedListener struct {
+	net.Listener
+	accounting *connectionAccounting
+}
+
+// Accept returns the next connection, counting the bytes it moves until it is closed.
+func (l *accountedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.accounting.accept(conn), nil
+}
+
+// accountedConn counts the bytes read from and written to a connection and why it closed.
+type accountedConn struct {
+	// bytesIn and bytesOut come first as 64-bit atomic operations require 8-byte alignment on 32-bit platforms
+	bytesIn  int64
+	bytesOut int64
+	net.Conn
+	accounting *connectionAccounting
+	mutex      sync.Mutex
+	record     ConnectionRecord
+	closeOnce  sync.Once
+}
+
+// Read reads from the client, the end of its data closes the connection as closed by the client.
+func (c *accountedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.bytesIn, int64(n))
+	if err == io.EOF {
+		c.setCloseReason(CloseReasonClient)
+	} else if err != nil {
+		c.setCloseReason(fmt.Sprintf("read failed: %v", err))
+	}
+	return n, err
+}
+
+// Write writes to the client.
+func (c *accountedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.bytesOut, int64(n))
+	if err != nil {
+		c.setCloseReason(fmt.Sprintf("write failed: %v", err))
+	}
+	return n, err
+}
+
+// Close closes the connection and writes its record, closed by the plugin as the remote end closed unless
+// another reason was recorded. The reason is recorded first so that reads failing on the closed connection
+// do not replace it.
+func (c *accountedConn) Close() error {
+	c.setCloseReason(CloseReasonRemote)
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { c.accounting.closed(c) })
+	return err
+}
+
+// setStreamId records the multiplexed stream the connection is forwarded over.
+func (c *accountedConn) setStreamId(id uint32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.record.StreamId = id
+}
+
+// setCloseReason records why the connection is closed unless a reason was recorded already.
+func (c *accountedConn) setCloseReason(reason string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.record.CloseReason == "" {
+		c.record.CloseReason = reason
+	}
+}
+
+// finish returns the completed record of the connection.
+func (c *accountedConn) finish() ConnectionRecord {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.record.End = time.Now()
+	c.record.BytesIn = atomic.LoadInt64(&c.bytesIn)
+	c.record.BytesOut = atomic.LoadInt64(&c.bytesOut)
+	return c.record
+}
+
+// closeConnection closes conn recording reason as why it is closed if it is accounted for.
+func closeConnection(conn net.Conn, reason string) error {
+	if accounted, ok := conn.(*accountedConn); ok {
+		accounted.setCloseReason(reason)
+	}
+	return conn.Close()
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package portsession
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readConnectionRecords returns the connection records written to path.
+func readConnectionRecords(t *testing.T, path string) (records []ConnectionRecord) {
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record ConnectionRecord
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestConnectionAccountingRecordsConnections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "connections")
+	accounting := newConnectionAccounting(mockLog, "sessionId", path)
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	listener := accounting.listener(tcpListener)
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	conn, err := listener.Accept()
+	assert.Nil(t, err)
+	conn.(*accountedConn).setStreamId(3)
+
+	_, err = client.Write([]byte("hello"))
+	assert.Nil(t, err)
+	client.(*net.TCPConn).CloseWrite()
+	received, err := ioutil.ReadAll(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(received))
+	_, err = conn.Write([]byte("hi"))
+	assert.Nil(t, err)
+	conn.Close()
+	conn.Close()
+	client.Close()
+
+	records := readConnectionRecords(t, path)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, int64(1), records[0].Id)
+	assert.Equal(t, "sessionId", records[0].SessionId)
+	assert.Equal(t, client.LocalAddr().String(), records[0].Client)
+	assert.Equal(t, uint32(3), records[0].StreamId)
+	assert.Equal(t, int64(5), records[0].BytesIn)
+	assert.Equal(t, int64(2), records[0].BytesOut)
+	assert.Equal(t, CloseReasonClient, records[0].CloseReason)
+	assert.False(t, records[0].End.Before(records[0].Start))
+	assert.Equal(t, "Forwarded 1 connection(s) from 1 client(s) for session sessionId, 5 bytes in, 2 bytes out.", accounting.summary())
+}
+
+func TestConnectionAccountingCloseReasons(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "connections")
+	accounting := newConnectionAccounting(mockLog, "sessionId", path)
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	listener := accounting.listener(tcpListener)
+	defer listener.Close()
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		assert.Nil(t, err)
+		defer client.Close()
+		conn, err := listener.Accept()
+		assert.Nil(t, err)
+		conns = append(conns, conn)
+	}
+	conns[0].Close()
+	closeConnection(conns[1], "queue full")
+	accounting.finish()
+
+	records := readConnectionRecords(t, path)
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, CloseReasonRemote, records[0].CloseReason)
+	assert.Equal(t, "queue full", records[1].CloseReason)
+	assert.Equal(t, CloseReasonSessionEnded, records[2].CloseReason)
+	assert.Equal(t, int64(3), records[2].Id)
+	assert.Equal(t, "Forwarded 3 connection(s) from 1 client(s) for session sessionId, 0 bytes in, 0 bytes out.", accounting.summary())
+}
+
+func TestConnectionAccountingWithoutConnections(t *testing.T) {
+	accounting := newConnectionAccounting(mockLog, "sessionId", "")
+	assert.Equal(t, "", accounting.summary())
+	accounting.finish()
+
+	var none *connectionAccounting
+	none.finish()
+}
+
+func TestConnectionAccountingRemoteCloseDuringRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "connections")
+	accounting := newConnectionAccounting(mockLog, "sessionId", path)
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	listener := accounting.listener(tcpListener)
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+	conn, err := listener.Accept()
+	assert.Nil(t, err)
+
+	_, err = client.Write([]byte("x"))
+	assert.Nil(t, err)
+	buffer := make([]byte, 1)
+	_, err = conn.Read(buffer)
+	assert.Nil(t, err)
+	readDone := make(chan error)
+	go func() {
+		_, err := conn.Read(buffer)
+		readDone <- err
+	}()
+	conn.Close()
+	assert.NotNil(t, <-readDone)
+
+	records := readConnectionRecords(t, path)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, CloseReasonRemote, records[0].CloseReason)
+	assert.Equal(t, int64(1), records[0].BytesIn)
+}
@@ -20,18 +20,23 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/session-manager-plugin/src/config"
 	"github.com/aws/session-manager-plugin/src/log"
 	"github.com/aws/session-manager-plugin/src/message"
 	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
-	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/sessionutil"
 	"github.com/aws/session-manager-plugin/src/version"
 )
 
+const (
+	// BasicPortForwardingQueueSize is how many local connections wait for the connection being forwarded
+	BasicPortForwardingQueueSize = 32
+)
+
 // BasicPortForwarding is type of port session
-// accepts one client connection at a time
+// forwards one client connection at a time, further connections are queued or forwarded in sessions of their own
 type BasicPortForwarding struct {
 	port           IPortSession
 	stream         *net.Conn
@@ -39,6 +44,19 @@ type BasicPortForwarding struct {
 	sessionId      string
 	portParameters PortParameters
 	session        session.Session
+	keepAlive      *keepAlive
+	// queue holds the connections accepted while another connection is forwarded
+	queue     chan queuedConnection
+	acceptErr error
+	// forwarding is set while the session forwards a connection
+	forwarding int32
+	accounting *connectionAccounting
+}
+
+// queuedConnection is a connection waiting to be forwarded
+type queuedConnection struct {
+	conn   net.Conn
+	waited bool
 }
 
 // getNewListener returns a new listener to given address and type like tcp, unix etc.
@@ -58,10 +76,13 @@ func (p *BasicPortForwarding) IsStreamNotSet() (status bool) {
 
 // Stop closes the stream
 func (p *BasicPortForwarding) Stop() {
+	p.session.AuditSessionEnd(log.Logger(false, "session-manager-plugin"), "session closed")
 	if p.stream != nil {
 		(*p.stream).Close()
 	}
-	os.Exit(0)
+	p.accounting.finish()
+	notifySystemd(log.Logger(false, "session-manager-plugin"), "STOPPING=1", fmt.Sprintf("STATUS=Session %s closed", p.sessionId))
+	os.Exit(session.ExitCode())
 }
 
 // InitializeStreams establishes connection and initializes the stream
@@ -81,6 +102,8 @@ func (p *BasicPortForwarding) ReadStream(log log.T) (err error) {
 		if err != nil {
 			log.Debugf("Reading from port %s failed with error: %v. Close this connection, listen and accept new one.",
 				p.portParameters.PortNumber, err)
+			p.keepAlive.connectionClosed(log)
+			atomic.StoreInt32(&p.forwarding, 0)
 
 			// Send DisconnectToPort flag to agent when client tcp connection drops to ensure agent closes tcp connection too with server port
 			if err = p.session.DataChannel.SendFlag(log, message.DisconnectToPort); err != nil {
@@ -125,6 +148,11 @@ func (p *BasicPortForwarding) startLocalConn(log log.T) (err error) {
 		log.Errorf("Unable to open tcp connection to port. %v", err)
 		return err
 	}
+	listener = guardListener(log, &p.session, listener)
+	p.accounting = newConnectionAccounting(log, p.sessionId, p.session.ConnectionLog)
+	listener = p.accounting.listener(listener)
+	listener = readyListener(log, p.session.ReadyEvents, newReadyEvent(p.sessionId, p.session.TargetId, listener), listener)
+	notifySystemd(log, "READY=1", p.waitingStatus())
 
 	var tcpConn net.Conn
 	if tcpConn, err = acceptConnection(log, listener); err != nil {
@@ -133,29 +161,84 @@ func (p *BasicPortForwarding) startLocalConn(log log.T) (err error) {
 	}
 	log.Infof("Connection accepted for session %s.", p.sessionId)
 	fmt.Printf("Connection accepted for session %s.\n", p.sessionId)
+	notifySystemd(log, p.forwardingStatus(tcpConn))
 
 	p.listener = &listener
 	p.stream = &tcpConn
+	p.keepAlive.connectionOpened(log)
+	atomic.StoreInt32(&p.forwarding, 1)
 
+	p.queue = make(chan queuedConnection, BasicPortForwardingQueueSize)
+	go p.acceptConnections(log)
 	return
 }
 
+// waitingStatus returns the sd_notify status of the session while no connection is forwarded.
+func (p *BasicPortForwarding) waitingStatus() string {
+	return fmt.Sprintf("STATUS=Waiting for connections for session %s", p.sessionId)
+}
+
+// forwardingStatus returns the sd_notify status of the session while conn is forwarded.
+func (p *BasicPortForwarding) forwardingStatus(conn net.Conn) string {
+	return fmt.Sprintf("STATUS=Forwarding connection from %s for session %s", conn.RemoteAddr(), p.sessionId)
+}
+
+// acceptConnections accepts connections while one is forwarded, queueing them or forwarding them in sessions of their own
+func (p *BasicPortForwarding) acceptConnections(log log.T) {
+	defer close(p.queue)
+	for {
+		conn, err := acceptConnection(log, *p.listener)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				log.Warnf("Failed to accept connection, retrying: %v", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			log.Errorf("Stopped accepting connections: %v", err)
+			p.acceptErr = err
+			return
+		}
+
+		busy := atomic.LoadInt32(&p.forwarding) == 1
+		if busy && p.session.SessionPerConnection {
+			go p.forwardInNewSession(log, conn)
+			continue
+		}
+		select {
+		case p.queue <- queuedConnection{conn: conn, waited: busy}:
+			if busy {
+				fmt.Printf("Connection from %s queued for session %s, %d connection(s) ahead.\n", conn.RemoteAddr(), p.sessionId, len(p.queue))
+			}
+		default:
+			fmt.Printf("Connection from %s refused for session %s, %d connections are already queued.\n", conn.RemoteAddr(), p.sessionId, BasicPortForwardingQueueSize)
+			closeConnection(conn, "queue full")
+		}
+	}
+}
+
 // startLocalListener starts a local listener to given address
 func (p *BasicPortForwarding) startLocalListener(log log.T, portNumber string) (listener net.Listener, err error) {
 	var displayMessage string
+	if listener = p.portParameters.activatedPortListener(log); listener != nil {
+		displayMessage = fmt.Sprintf("Listener %s passed by systemd opened for sessionId %s.", listener.Addr(), p.sessionId)
+		log.Info(displayMessage)
+		fmt.Println(displayMessage)
+		return
+	}
 	switch p.portParameters.LocalConnectionType {
 	case "unix":
-		if listener, err = getNewListener(p.portParameters.LocalConnectionType, p.portParameters.LocalUnixSocket); err != nil {
+		if listener, err = listenUnixSocket(&p.session, p.portParameters.LocalUnixSocket); err != nil {
 			return
 		}
 		displayMessage = fmt.Sprintf("Unix socket %s opened for sessionId %s.", p.portParameters.LocalUnixSocket, p.sessionId)
 	default:
-		if listener, err = getNewListener("tcp", "localhost:"+portNumber); err != nil {
+		var hosts []string
+		if listener, hosts, err = startLocalTCPListener(log, p.portParameters.LocalHost, portNumber, p.session.AllowNonLoopback); err != nil {
 			return
 		}
 		// get port number the TCP listener opened
 		p.portParameters.LocalPortNumber = strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
-		displayMessage = fmt.Sprintf("Port %s opened for sessionId %s.", p.portParameters.LocalPortNumber, p.sessionId)
+		displayMessage = fmt.Sprintf("Port %s opened%s for sessionId %s.", p.portParameters.LocalPortNumber, listenerHosts(hosts), p.sessionId)
 	}
 
 	log.Info(displayMessage)
@@ -163,13 +246,15 @@ func (p *BasicPortForwarding) startLocalListener(log log.T, portNumber string) (
 	return
 }
 
-// handleControlSignals handles terminate signals
+// handleControlSignals handles terminate signals, signals mapped to control characters terminate port sessions too
 func (p *BasicPortForwarding) handleControlSignals(log log.T) {
+	signalMap := p.session.ControlSignalMap()
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, sessionutil.ControlSignals...)
+	signal.Notify(c, signalMap.Signals()...)
 	go func() {
-		<-c
+		waitForTerminateSignal(log, c, signalMap)
 		fmt.Println("Terminate signal received, exiting.")
+		p.session.AuditSessionEnd(log, "terminate signal received")
 
 		if version.DoesAgentSupportTerminateSessionFlag(log, p.session.DataChannel.GetAgentVersion()) {
 			if err := p.session.DataChannel.SendFlag(log, message.TerminateSession); err != nil {
@@ -187,13 +272,49 @@ func (p *BasicPortForwarding) handleControlSignals(log log.T) {
 func (p *BasicPortForwarding) reconnect(log log.T) (err error) {
 	// close existing connection as it is in a state from which data cannot be read
 	(*p.stream).Close()
+	notifySystemd(log, p.waitingStatus())
 
-	// wait for new connection on listener and accept it
-	var conn net.Conn
-	if conn, err = acceptConnection(log, *p.listener); err != nil {
-		return log.Errorf("Failed to accept connection with error. %v", err)
+	// wait for the next queued connection
+	next, ok := <-p.queue
+	if !ok {
+		return log.Errorf("Failed to accept connection with error. %v", p.acceptErr)
+	}
+	if next.waited {
+		fmt.Printf("Queued connection from %s accepted for session %s.\n", next.conn.RemoteAddr(), p.sessionId)
 	}
-	p.stream = &conn
+	p.stream = &next.conn
+	notifySystemd(log, p.forwardingStatus(next.conn))
+	p.keepAlive.connectionOpened(log)
+	atomic.StoreInt32(&p.forwarding, 1)
 
 	return
 }
+
+// forwardInNewSession forwards a connection that arrived while another one is forwarded in a session of its own,
+// the session is terminated once the connection closes. The session is audited, limited and accounted for as a
+// tunnel with the settings of the session it was started from.
+func (p *BasicPortForwarding) forwardInNewSession(log log.T, conn net.Conn) {
+	// a stop of the new session only ends this connection
+	additional, err := p.session.StartAdditionalSession(log, func() { conn.Close() })
+	if err != nil {
+		log.Errorf("Failed to start a session for connection from %s: %v", conn.RemoteAddr(), err)
+		fmt.Printf("Unable to start a session for connection from %s: %v\n", conn.RemoteAddr(), err)
+		closeConnection(conn, fmt.Sprintf("unable to start session: %v", err))
+		return
+	}
+	tunnel, err := NewTunnel(log, additional, nil)
+	if err != nil {
+		log.Errorf("Failed to forward connection from %s in session %s: %v", conn.RemoteAddr(), additional.SessionId, err)
+		additional.AuditSessionEnd(log, err.Error())
+		additional.EndSession(log)
+		additional.DataChannel.Close(log)
+		closeConnection(conn, err.Error())
+		return
+	}
+	log.Infof("Connection from %s forwarded in session %s.", conn.RemoteAddr(), additional.SessionId)
+	fmt.Printf("Connection from %s forwarded in new session %s.\n", conn.RemoteAddr(), additional.SessionId)
+
+	tunnel.Forward(log, conn)
+	tunnel.Close(log)
+	fmt.Printf("Session %s for connection from %s ended.\n", additional.SessionId, conn.RemoteAddr())
+}
@@ -16,9 +16,12 @@ package portsession
 
 import (
 	"errors"
+	"io"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -61,7 +64,12 @@ func TestSetSessionHandlers(t *testing.T) {
 	}()
 
 	go func() {
+		var accepted int32
 		acceptConnection = func(log log.T, listener net.Listener) (tcpConn net.Conn, err error) {
+			// further connections are accepted while the first one is forwarded
+			if !atomic.CompareAndSwapInt32(&accepted, 0, 1) {
+				return nil, errors.New("listener closed")
+			}
 			return in, nil
 		}
 		signal.Notify(signalCh, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTSTP)
@@ -110,6 +118,7 @@ func TestStartSessionTCPAcceptFailed(t *testing.T) {
 
 func TestStartSessionTCPConnectFailed(t *testing.T) {
 	listenerError := errors.New("TCP connection failed")
+	defer func(listen func(string, string) (net.Listener, error)) { getNewListener = listen }(getNewListener)
 	getNewListener = func(listenerType string, listenerAddress string) (listener net.Listener, err error) {
 		return nil, listenerError
 	}
@@ -123,3 +132,42 @@ func TestStartSessionTCPConnectFailed(t *testing.T) {
 	}
 	assert.Equal(t, portSession.SetSessionHandlers(mockLog), listenerError)
 }
+
+func TestBasicPortForwardingQueuesConnections(t *testing.T) {
+	defer func(listen func(string, string) (net.Listener, error), accept func(log.T, net.Listener) (net.Conn, error)) {
+		getNewListener, acceptConnection = listen, accept
+	}(getNewListener, acceptConnection)
+	getNewListener = net.Listen
+	acceptConnection = func(log log.T, listener net.Listener) (net.Conn, error) {
+		return listener.Accept()
+	}
+
+	free, _ := net.Listen("tcp", "localhost:0")
+	port := strconv.Itoa(free.Addr().(*net.TCPAddr).Port)
+	free.Close()
+
+	p := &BasicPortForwarding{
+		sessionId:      "sessionId",
+		session:        getSessionMock(),
+		portParameters: PortParameters{PortNumber: "22", Type: LocalPortForwardingType, LocalPortNumber: port},
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		net.Dial("tcp", "localhost:"+port)
+	}()
+	assert.Nil(t, p.startLocalConn(mockLog))
+	defer (*p.listener).Close()
+
+	// a second client is queued while the first one is forwarded
+	second, err := net.Dial("tcp", "localhost:"+port)
+	assert.Nil(t, err)
+	defer second.Close()
+	second.Write([]byte("queued"))
+
+	assert.Nil(t, p.reconnect(mockLog))
+	buf := make([]byte, 6)
+	(*p.stream).SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadFull(*p.stream, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "queued", string(buf))
+}
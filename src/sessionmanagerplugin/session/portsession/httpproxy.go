@@ -0,0 +1,243 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+)
+
+const (
+	// httpProxyCertificateValidity is how long the self-signed certificate of the HTTP proxy is valid
+	httpProxyCertificateValidity = 365 * 24 * time.Hour
+)
+
+// httpProxy serves a local listener as an HTTP reverse proxy forwarding requests over the connections of a tunnel.
+// Host headers are rewritten to upstream, and redirects and cookies of upstream to the local address.
+type httpProxy struct {
+	upstream    string
+	certificate *tls.Certificate
+	// dial opens a connection to the destination of the tunnel
+	dial func() (net.Conn, error)
+	// multiplexed returns whether connections are forwarded concurrently, otherwise every request closes its
+	// connection so that the next one can be forwarded
+	multiplexed func() bool
+}
+
+// newHTTPProxy returns the HTTP proxy of mapping with the settings of base.
+func newHTTPProxy(base *session.Session, mapping PortMapping, certificate *tls.Certificate) *httpProxy {
+	upstream := base.HTTPProxyHost
+	if upstream == "" {
+		host := mapping.Host
+		if host == "" {
+			host = "localhost"
+		}
+		upstream = net.JoinHostPort(host, mapping.PortNumber)
+		if mapping.PortNumber == "80" {
+			upstream = host
+		}
+	}
+	return &httpProxy{upstream: upstream, certificate: certificate}
+}
+
+// serve answers the requests of the connections accepted on listener until stop is closed.
+func (p *httpProxy) serve(log log.T, listener net.Listener, stop <-chan struct{}) error {
+	scheme := "http"
+	if p.certificate != nil {
+		scheme = "https"
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{*p.certificate}})
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return p.dial()
+		},
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.Header.Set("X-Forwarded-Host", req.Host)
+			req.Header.Set("X-Forwarded-Proto", scheme)
+			req.URL.Scheme = "http"
+			req.URL.Host = p.upstream
+			req.Host = p.upstream
+			req.Close = req.Close || !p.multiplexed()
+		},
+		Transport:      transport,
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			log.Warnf("HTTP proxy request %s %s to %s failed: %v", req.Method, req.URL.RequestURI(), p.upstream, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			started := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			uri := req.URL.RequestURI()
+			proxy.ServeHTTP(recorder, req)
+			log.Infof("HTTP %s %s %s %d %v", req.RemoteAddr, req.Method, uri, recorder.status, time.Since(started).Round(time.Millisecond))
+		}),
+	}
+
+	go func() {
+		<-stop
+		server.Close()
+		transport.CloseIdleConnections()
+	}()
+	log.Infof("Serving %s requests for %s on %s.", scheme, p.upstream, listener.Addr())
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// modifyResponse rewrites the redirects and cookies of upstream to the local address the request was sent to.
+func (p *httpProxy) modifyResponse(resp *http.Response) error {
+	local := resp.Request.Header.Get("X-Forwarded-Host")
+	scheme := resp.Request.Header.Get("X-Forwarded-Proto")
+
+	if location := resp.Header.Get("Location"); location != "" {
+		if target, err := url.Parse(location); err == nil && target.IsAbs() && p.isUpstream(target.Host) {
+			target.Scheme = scheme
+			target.Host = local
+			resp.Header.Set("Location", target.String())
+		}
+	}
+
+	cookies := resp.Header["Set-Cookie"]
+	for i, cookie := range cookies {
+		cookies[i] = rewriteCookie(cookie, scheme == "https")
+	}
+	return nil
+}
+
+// isUpstream returns whether host, with or without the default port, is the upstream host.
+func (p *httpProxy) isUpstream(host string) bool {
+	return strings.EqualFold(host, p.upstream) || strings.EqualFold(strings.TrimSuffix(host, ":80"), p.upstream) ||
+		strings.EqualFold(host, strings.TrimSuffix(p.upstream, ":80"))
+}
+
+// rewriteCookie removes the Domain attribute of a Set-Cookie header, so that the cookie is sent to the local
+// address, and the Secure attribute unless the local address is served over TLS.
+func rewriteCookie(cookie string, secure bool) string {
+	attributes := strings.Split(cookie, ";")
+	kept := attributes[:1]
+	for _, attribute := range attributes[1:] {
+		name := strings.ToLower(strings.TrimSpace(strings.SplitN(attribute, "=", 2)[0]))
+		if name == "domain" || (name == "secure" && !secure) {
+			continue
+		}
+		kept = append(kept, attribute)
+	}
+	return strings.Join(kept, ";")
+}
+
+// statusRecorder records the status of a response for the request log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status of the response.
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush flushes streamed responses.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack takes over the connection of upgraded requests such as WebSockets.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// httpProxyCertificate generates the self-signed certificate of the HTTP proxies of base for the local addresses,
+// nil unless base.HTTPProxyTLS is set. Its fingerprint is printed for the user to check.
+func httpProxyCertificate(log log.T, base *session.Session) (*tls.Certificate, error) {
+	if !base.HTTPProxy || !base.HTTPProxyTLS {
+		return nil, nil
+	}
+	hosts, err := parseLocalHosts(base.LocalHost)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "session-manager-plugin local proxy"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(httpProxyCertificateValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "localhost" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := sha256.Sum256(der)
+	fmt.Printf("Serving HTTPS with a self-signed certificate, SHA-256 fingerprint %X.\n", fingerprint)
+	log.Infof("Generated self-signed certificate for %s with SHA-256 fingerprint %X", strings.Join(hosts, ", "), fingerprint)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
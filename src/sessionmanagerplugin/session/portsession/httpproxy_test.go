@@ -0,0 +1,80 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package portsession
+
+import (
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPProxyRewritesHostRedirectsAndCookies(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "grafana.internal", req.Host)
+		http.SetCookie(w, &http.Cookie{Name: "grafana_session", Value: "abc", Path: "/", Domain: "grafana.internal", Secure: true, HttpOnly: true})
+		http.Redirect(w, req, "http://grafana.internal/login?next=%2F", http.StatusFound)
+	}))
+	defer upstream.Close()
+
+	proxy := newHTTPProxy(&session.Session{HTTPProxyHost: "grafana.internal"}, PortMapping{PortNumber: "3000"}, nil)
+	proxy.dial = func() (net.Conn, error) { return net.Dial("tcp", upstream.Listener.Addr().String()) }
+	proxy.multiplexed = func() bool { return true }
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	stop := make(chan struct{})
+	defer close(stop)
+	go proxy.serve(mockLog, listener, stop)
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get("http://" + listener.Addr().String() + "/")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "http://"+listener.Addr().String()+"/login?next=%2F", resp.Header.Get("Location"))
+	assert.Equal(t, "grafana_session=abc; Path=/; HttpOnly", resp.Header.Get("Set-Cookie"))
+}
+
+func TestNewHTTPProxyUpstream(t *testing.T) {
+	assert.Equal(t, "localhost:8080", newHTTPProxy(&session.Session{}, PortMapping{PortNumber: "8080"}, nil).upstream)
+	assert.Equal(t, "jenkins.internal", newHTTPProxy(&session.Session{}, PortMapping{Host: "jenkins.internal", PortNumber: "80"}, nil).upstream)
+	assert.Equal(t, "[fd00::1]:5601", newHTTPProxy(&session.Session{}, PortMapping{Host: "fd00::1", PortNumber: "5601"}, nil).upstream)
+}
+
+func TestRewriteCookie(t *testing.T) {
+	assert.Equal(t, "a=b; Path=/", rewriteCookie("a=b; Domain=.example.com; Path=/; Secure", false))
+	assert.Equal(t, "a=b; Path=/; Secure", rewriteCookie("a=b; domain=example.com; Path=/; Secure", true))
+}
+
+func TestHTTPProxyCertificate(t *testing.T) {
+	certificate, err := httpProxyCertificate(mockLog, &session.Session{HTTPProxy: true})
+	assert.Nil(t, err)
+	assert.Nil(t, certificate)
+
+	certificate, err = httpProxyCertificate(mockLog, &session.Session{HTTPProxy: true, HTTPProxyTLS: true, LocalHost: "127.0.0.1,dev.local"})
+	assert.Nil(t, err)
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	assert.Nil(t, err)
+	assert.Nil(t, parsed.VerifyHostname("localhost"))
+	assert.Nil(t, parsed.VerifyHostname("127.0.0.1"))
+	assert.Nil(t, parsed.VerifyHostname("dev.local"))
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+)
+
+// keepAlive sends an empty data message every interval while at least one local connection is open,
+// so that the idle timeout does not end a tunnel whose connections are quiet. No message is sent for an
+// interval in which data went out anyway. Once the last connection closed nothing is sent and an unused
+// tunnel still times out. A nil keepAlive is disabled.
+type keepAlive struct {
+	interval    time.Duration
+	sent        func() int64
+	send        func() error
+	mutex       sync.Mutex
+	connections int
+	stop        chan struct{}
+}
+
+// newKeepAlive returns a keep-alive calling send every interval in which the byte count returned by sent
+// did not change, or nil if interval is not positive.
+func newKeepAlive(interval time.Duration, sent func() int64, send func() error) *keepAlive {
+	if interval <= 0 {
+		return nil
+	}
+	return &keepAlive{interval: interval, sent: sent, send: send}
+}
+
+// connectionOpened starts sending with the first open connection.
+func (k *keepAlive) connectionOpened(log log.T) {
+	if k == nil {
+		return
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.connections++
+	if k.connections == 1 {
+		log.Debugf("Sending keep-alive messages every %v", k.interval)
+		k.stop = make(chan struct{})
+		go k.run(log, k.stop)
+	}
+}
+
+// connectionClosed stops sending once the last open connection closed.
+func (k *keepAlive) connectionClosed(log log.T) {
+	if k == nil {
+		return
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.connections == 0 {
+		return
+	}
+	k.connections--
+	if k.connections == 0 {
+		log.Debugf("No open connections, keep-alive messages stopped")
+		close(k.stop)
+	}
+}
+
+// run sends keep-alive messages until stop is closed.
+func (k *keepAlive) run(log log.T, stop chan struct{}) {
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+	lastSent := k.sent()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if sent := k.sent(); sent != lastSent {
+				lastSent = sent
+				continue
+			}
+			if err := k.send(); err != nil {
+				log.Warnf("Failed to send keep-alive message: %v", err)
+			}
+		}
+	}
+}
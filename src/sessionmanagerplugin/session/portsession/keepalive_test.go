@@ -0,0 +1,71 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepAliveOnlyWhileConnectionsAreOpen(t *testing.T) {
+	var sent int32
+	keepAlive := newKeepAlive(20*time.Millisecond, func() int64 { return 0 }, func() error {
+		atomic.AddInt32(&sent, 1)
+		return nil
+	})
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&sent))
+
+	keepAlive.connectionOpened(mockLog)
+	keepAlive.connectionOpened(mockLog)
+	time.Sleep(70 * time.Millisecond)
+	keepAlive.connectionClosed(mockLog)
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, atomic.LoadInt32(&sent) >= 4)
+
+	// the last connection closed
+	keepAlive.connectionClosed(mockLog)
+	time.Sleep(30 * time.Millisecond)
+	stopped := atomic.LoadInt32(&sent)
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, stopped, atomic.LoadInt32(&sent))
+}
+
+func TestKeepAliveDisabled(t *testing.T) {
+	keepAlive := newKeepAlive(0, func() int64 { return 0 }, func() error { return nil })
+	assert.Nil(t, keepAlive)
+	keepAlive.connectionOpened(mockLog)
+	keepAlive.connectionClosed(mockLog)
+}
+
+func TestKeepAliveSkippedWhileDataIsSent(t *testing.T) {
+	var sent, bytesSent int64
+	keepAlive := newKeepAlive(20*time.Millisecond, func() int64 {
+		// data goes out in every interval
+		return atomic.AddInt64(&bytesSent, 1)
+	}, func() error {
+		atomic.AddInt64(&sent, 1)
+		return nil
+	})
+
+	keepAlive.connectionOpened(mockLog)
+	time.Sleep(90 * time.Millisecond)
+	keepAlive.connectionClosed(mockLog)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&sent))
+}
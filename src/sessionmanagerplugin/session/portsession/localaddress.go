@@ -0,0 +1,232 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	// DefaultLocalHost is the address local listeners bind to unless localHost is set
+	DefaultLocalHost = "localhost"
+)
+
+// parseLocalHosts returns the comma separated addresses to listen on, IPv6 addresses may be in brackets.
+func parseLocalHosts(localHost string) (hosts []string, err error) {
+	if strings.TrimSpace(localHost) == "" {
+		return []string{DefaultLocalHost}, nil
+	}
+	for _, host := range strings.Split(localHost, ",") {
+		host = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(host), "["), "]")
+		if host == "" {
+			return nil, fmt.Errorf("invalid localHost %q, expected comma separated addresses", localHost)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// isLoopbackHost returns whether all addresses of host are loopback addresses.
+func isLoopbackHost(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return false
+		}
+	}
+	return true
+}
+
+// confirmNonLoopbackHosts asks the user whether to listen on addresses reachable from other hosts.
+var confirmNonLoopbackHosts = func(hosts []string) bool {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+	fmt.Printf("Listening on %s makes the port reachable from other hosts. Continue? [y/N] ", strings.Join(hosts, ", "))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// checkLocalHosts refuses to listen on non-loopback addresses unless allowed or confirmed by the user.
+func checkLocalHosts(log log.T, hosts []string, allowNonLoopback bool) error {
+	var exposed []string
+	for _, host := range hosts {
+		if !isLoopbackHost(host) {
+			exposed = append(exposed, host)
+		}
+	}
+	if len(exposed) == 0 || allowNonLoopback {
+		return nil
+	}
+	if !confirmNonLoopbackHosts(exposed) {
+		return fmt.Errorf("listening on non-loopback address %s was not confirmed", strings.Join(exposed, ", "))
+	}
+	log.Infof("Listening on non-loopback address %s confirmed by the user", strings.Join(exposed, ", "))
+	return nil
+}
+
+// startLocalTCPListener listens on portNumber on the comma separated localHost addresses, localhost by default.
+func startLocalTCPListener(log log.T, localHost string, portNumber string, allowNonLoopback bool) (listener net.Listener, hosts []string, err error) {
+	if hosts, err = parseLocalHosts(localHost); err != nil {
+		return
+	}
+	if err = checkLocalHosts(log, hosts, allowNonLoopback); err != nil {
+		return
+	}
+	listener, err = listenTCP(hosts, portNumber)
+	return
+}
+
+// listenTCP listens on port of every host.
+func listenTCP(hosts []string, port string) (net.Listener, error) {
+	return listenHosts(hosts, port, func(address string) (net.Listener, error) {
+		return getNewListener("tcp", address)
+	})
+}
+
+// startLocalUDPListener listens for UDP clients on portNumber like startLocalTCPListener, accepting the flow of
+// every client as a connection.
+func startLocalUDPListener(log log.T, localHost string, portNumber string, allowNonLoopback bool) (listener net.Listener, hosts []string, err error) {
+	if hosts, err = parseLocalHosts(localHost); err != nil {
+		return
+	}
+	if err = checkLocalHosts(log, hosts, allowNonLoopback); err != nil {
+		return
+	}
+	listener, err = listenHosts(hosts, portNumber, func(address string) (net.Listener, error) {
+		return listenUDP(address, UDPFlowIdleTimeout)
+	})
+	return
+}
+
+// listenHosts listens on port of every host, a port chosen for the first host is used for the others.
+func listenHosts(hosts []string, port string, listen func(address string) (net.Listener, error)) (net.Listener, error) {
+	var listeners []net.Listener
+	for _, host := range hosts {
+		listener, err := listen(net.JoinHostPort(host, port))
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, err
+		}
+		if port == "0" {
+			port = listenerPort(listener)
+		}
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 1 {
+		return listeners[0], nil
+	}
+	return newMultiListener(listeners), nil
+}
+
+// listenerPort returns the port a listener listens on.
+func listenerPort(listener net.Listener) string {
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+// multiListener accepts the connections of several listeners.
+type multiListener struct {
+	listeners []net.Listener
+	accepted  chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newMultiListener returns a listener accepting on all listeners.
+func newMultiListener(listeners []net.Listener) *multiListener {
+	m := &multiListener{
+		listeners: listeners,
+		accepted:  make(chan net.Conn),
+		done:      make(chan struct{}),
+	}
+	for _, listener := range listeners {
+		go m.accept(listener)
+	}
+	return m
+}
+
+// accept passes the connections of listener on until it is closed.
+func (m *multiListener) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return
+		}
+		select {
+		case m.accepted <- conn:
+		case <-m.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// Accept returns the next connection accepted on any of the listeners.
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-m.accepted:
+		return conn, nil
+	case <-m.done:
+		return nil, errors.New("listener closed")
+	}
+}
+
+// Close closes all listeners.
+func (m *multiListener) Close() (err error) {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		for _, listener := range m.listeners {
+			if closeErr := listener.Close(); closeErr != nil {
+				err = closeErr
+			}
+		}
+	})
+	return
+}
+
+// Addr returns the address of the first listener.
+func (m *multiListener) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
+
+// listenerHosts describes the addresses listened on for messages to the user.
+func listenerHosts(hosts []string) string {
+	if len(hosts) == 1 && hosts[0] == DefaultLocalHost {
+		return ""
+	}
+	return " on " + strings.Join(hosts, ", ")
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLocalHosts(t *testing.T) {
+	hosts, err := parseLocalHosts("")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"localhost"}, hosts)
+
+	hosts, err = parseLocalHosts("127.0.0.1, [::1],0.0.0.0")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1", "::1", "0.0.0.0"}, hosts)
+
+	_, err = parseLocalHosts("127.0.0.1,")
+	assert.NotNil(t, err)
+}
+
+func TestCheckLocalHosts(t *testing.T) {
+	defer func(confirm func([]string) bool) { confirmNonLoopbackHosts = confirm }(confirmNonLoopbackHosts)
+	var asked []string
+	confirmed := false
+	confirmNonLoopbackHosts = func(hosts []string) bool {
+		asked = hosts
+		return confirmed
+	}
+
+	assert.Nil(t, checkLocalHosts(mockLog, []string{"127.0.0.1", "::1"}, false))
+	assert.Nil(t, asked)
+
+	err := checkLocalHosts(mockLog, []string{"127.0.0.1", "0.0.0.0", "::"}, false)
+	assert.Equal(t, []string{"0.0.0.0", "::"}, asked)
+	assert.Contains(t, err.Error(), "0.0.0.0, :: was not confirmed")
+
+	confirmed = true
+	assert.Nil(t, checkLocalHosts(mockLog, []string{"0.0.0.0"}, false))
+
+	// allowed without asking
+	asked = nil
+	assert.Nil(t, checkLocalHosts(mockLog, []string{"0.0.0.0"}, true))
+	assert.Nil(t, asked)
+}
+
+func TestListenTCPOnSeveralAddresses(t *testing.T) {
+	defer func(listen func(string, string) (net.Listener, error)) { getNewListener = listen }(getNewListener)
+	getNewListener = net.Listen
+
+	listener, err := listenTCP([]string{"127.0.0.1", "127.0.0.2"}, "0")
+	if err != nil {
+		t.Skipf("127.0.0.2 is not available: %v", err)
+	}
+	defer listener.Close()
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	// the port chosen for the first address is used for the others
+	for _, host := range []string{"127.0.0.1", "127.0.0.2"} {
+		client, err := net.Dial("tcp", net.JoinHostPort(host, port))
+		assert.Nil(t, err)
+		conn, err := listener.Accept()
+		assert.Nil(t, err)
+		assert.Equal(t, host, conn.LocalAddr().(*net.TCPAddr).IP.String())
+		conn.Close()
+		client.Close()
+	}
+
+	listener.Close()
+	_, err = listener.Accept()
+	assert.NotNil(t, err)
+}
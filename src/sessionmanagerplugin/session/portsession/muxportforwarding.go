@@ -27,6 +27,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/session-manager-plugin/src/config"
@@ -61,6 +62,9 @@ type MuxPortForwarding struct {
 	session        session.Session
 	muxClient      *MuxClient
 	mgsConn        *MgsConn
+	keepAlive      *keepAlive
+	connections    int32
+	accounting     *connectionAccounting
 }
 
 func (c *MgsConn) close() {
@@ -80,6 +84,7 @@ func (p *MuxPortForwarding) IsStreamNotSet() (status bool) {
 
 // Stop closes all open stream
 func (p *MuxPortForwarding) Stop() {
+	p.session.AuditSessionEnd(log.Logger(false, "session-manager-plugin"), "session closed")
 	if p.mgsConn != nil {
 		p.mgsConn.close()
 	}
@@ -87,13 +92,17 @@ func (p *MuxPortForwarding) Stop() {
 		p.muxClient.close()
 	}
 	p.cleanUp()
-	os.Exit(0)
+	p.accounting.finish()
+	notifySystemd(log.Logger(false, "session-manager-plugin"), "STOPPING=1", fmt.Sprintf("STATUS=Session %s closed", p.sessionId))
+	os.Exit(session.ExitCode())
 }
 
 // InitializeStreams initializes i/o streams
 func (p *MuxPortForwarding) InitializeStreams(log log.T, agentVersion string) (err error) {
 
 	p.handleControlSignals(log)
+	// the smux frames in the data channel include keep-alives of older agents, only stream data is activity
+	p.session.DataChannel.TrackStreamActivity()
 	p.socketFile = getUnixSocketPath(p.sessionId, os.TempDir(), "session_manager_plugin_mux.sock")
 
 	if err = p.initialize(log, agentVersion); err != nil {
@@ -184,13 +193,15 @@ func (p *MuxPortForwarding) initialize(log log.T, agentVersion string) (err erro
 	return g.Wait()
 }
 
-// handleControlSignals handles terminate signals
+// handleControlSignals handles terminate signals, signals mapped to control characters terminate port sessions too
 func (p *MuxPortForwarding) handleControlSignals(log log.T) {
+	signalMap := p.session.ControlSignalMap()
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, sessionutil.ControlSignals...)
+	signal.Notify(c, signalMap.Signals()...)
 	go func() {
-		<-c
+		waitForTerminateSignal(log, c, signalMap)
 		fmt.Println("Terminate signal received, exiting.")
+		p.session.AuditSessionEnd(log, "terminate signal received")
 
 		if err := p.session.DataChannel.SendFlag(log, message.TerminateSession); err != nil {
 			log.Errorf("Failed to send TerminateSession flag: %v", err)
@@ -232,8 +243,10 @@ func (p *MuxPortForwarding) handleClientConnections(log log.T, ctx context.Conte
 		displayMsg string
 	)
 
-	if p.portParameters.LocalConnectionType == "unix" {
-		if listener, err = net.Listen(p.portParameters.LocalConnectionType, p.portParameters.LocalUnixSocket); err != nil {
+	if listener = p.portParameters.activatedPortListener(log); listener != nil {
+		displayMsg = fmt.Sprintf("Listener %s passed by systemd opened for sessionId %s.", listener.Addr(), p.sessionId)
+	} else if p.portParameters.LocalConnectionType == "unix" {
+		if listener, err = listenUnixSocket(&p.session, p.portParameters.LocalUnixSocket); err != nil {
 			return err
 		}
 		displayMsg = fmt.Sprintf("Unix socket %s opened for sessionId %s.", p.portParameters.LocalUnixSocket, p.sessionId)
@@ -242,20 +255,26 @@ func (p *MuxPortForwarding) handleClientConnections(log log.T, ctx context.Conte
 		if p.portParameters.LocalPortNumber == "" {
 			localPortNumber = "0"
 		}
-		if listener, err = net.Listen("tcp", "localhost:"+localPortNumber); err != nil {
+		var hosts []string
+		if listener, hosts, err = startLocalTCPListener(log, p.portParameters.LocalHost, localPortNumber, p.session.AllowNonLoopback); err != nil {
 			return err
 		}
 		p.portParameters.LocalPortNumber = strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
-		displayMsg = fmt.Sprintf("Port %s opened for sessionId %s.", p.portParameters.LocalPortNumber, p.sessionId)
+		displayMsg = fmt.Sprintf("Port %s opened%s for sessionId %s.", p.portParameters.LocalPortNumber, listenerHosts(hosts), p.sessionId)
 	}
 
 	defer listener.Close()
+	listener = guardListener(log, &p.session, listener)
+	p.accounting = newConnectionAccounting(log, p.sessionId, p.session.ConnectionLog)
+	listener = p.accounting.listener(listener)
+	listener = readyListener(log, p.session.ReadyEvents, newReadyEvent(p.sessionId, p.session.TargetId, listener), listener)
 
 	log.Infof(displayMsg)
 	fmt.Printf(displayMsg)
 
 	log.Infof("Waiting for connections...\n")
 	fmt.Printf("\nWaiting for connections...\n")
+	notifySystemd(log, "READY=1", p.connectionsStatus())
 
 	var once sync.Once
 	for {
@@ -274,15 +293,33 @@ func (p *MuxPortForwarding) handleClientConnections(log log.T, ctx context.Conte
 
 				stream, err := p.muxClient.session.OpenStream()
 				if err != nil {
+					log.Errorf("Unable to open stream for connection from %s: %v", conn.RemoteAddr(), err)
+					closeConnection(conn, fmt.Sprintf("unable to open stream: %v", err))
 					continue
 				}
 				log.Debugf("Client stream opened %d\n", stream.ID())
-				go handleDataTransfer(stream, conn)
+				if accounted, ok := conn.(*accountedConn); ok {
+					accounted.setStreamId(stream.ID())
+				}
+				p.keepAlive.connectionOpened(log)
+				atomic.AddInt32(&p.connections, 1)
+				notifySystemd(log, p.connectionsStatus())
+				go func() {
+					handleDataTransfer(newActivityStream(stream, p.session.DataChannel.RecordStreamActivity), conn)
+					p.keepAlive.connectionClosed(log)
+					atomic.AddInt32(&p.connections, -1)
+					notifySystemd(log, p.connectionsStatus())
+				}()
 			}
 		}
 	}
 }
 
+// connectionsStatus returns the sd_notify status of the session, the connections it forwards.
+func (p *MuxPortForwarding) connectionsStatus() string {
+	return fmt.Sprintf("STATUS=Forwarding %d connection(s) for session %s", atomic.LoadInt32(&p.connections), p.sessionId)
+}
+
 // handleDataTransfer launches routines to transfer data between source and destination
 func handleDataTransfer(dst io.ReadWriteCloser, src io.ReadWriteCloser) {
 	var wait sync.WaitGroup
@@ -309,3 +346,30 @@ func getUnixSocketPath(sessionId string, dir string, suffix string) string {
 	hash.Write([]byte(sessionId))
 	return filepath.Join(dir, fmt.Sprintf("%d_%s", hash.Sum32(), suffix))
 }
+
+// activityStream records activity of the session whenever payload of a multiplexed stream is read or written.
+type activityStream struct {
+	io.ReadWriteCloser
+	record func()
+}
+
+// newActivityStream returns stream calling record for every payload it transfers.
+func newActivityStream(stream io.ReadWriteCloser, record func()) *activityStream {
+	return &activityStream{stream, record}
+}
+
+// Read reads from the stream.
+func (s *activityStream) Read(p []byte) (n int, err error) {
+	if n, err = s.ReadWriteCloser.Read(p); n > 0 {
+		s.record()
+	}
+	return n, err
+}
+
+// Write writes to the stream.
+func (s *activityStream) Write(p []byte) (n int, err error) {
+	if n, err = s.ReadWriteCloser.Write(p); n > 0 {
+		s.record()
+	}
+	return n, err
+}
@@ -127,3 +127,26 @@ func TestHandleDataTransferDstToSrc(t *testing.T) {
 	handleDataTransfer(in, out1)
 	assert.EqualValues(t, outputMessage.Payload, msg)
 }
+
+func TestActivityStreamRecordsPayload(t *testing.T) {
+	in, out := net.Pipe()
+	records := 0
+	stream := newActivityStream(in, func() { records++ })
+
+	go out.Write([]byte("data"))
+	buf := make([]byte, 8)
+	n, err := stream.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, 1, records)
+
+	go out.Read(buf)
+	_, err = stream.Write([]byte("reply"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, records)
+
+	stream.Close()
+	_, err = stream.Read(buf)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, records)
+}
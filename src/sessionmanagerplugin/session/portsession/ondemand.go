@@ -0,0 +1,315 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+)
+
+// errSessionLimit is returned for connections needing a session while the most sessions are open.
+var errSessionLimit = errors.New("limit of concurrent sessions reached")
+
+// onDemandTunnel starts the session of a mapping when a connection arrives and ends it once no connection was
+// open for idleTimeout, the next connection starts a new session.
+type onDemandTunnel struct {
+	base        *session.Session
+	mapping     PortMapping
+	target      string
+	local       string
+	listener    net.Listener
+	idleTimeout time.Duration
+	onChange    func()
+	// slots, if set, is shared by tunnels limiting their open sessions to its capacity
+	slots chan struct{}
+	// startMutex is held while a session is started, connections arriving meanwhile wait for it
+	startMutex  sync.Mutex
+	mutex       sync.Mutex
+	tunnel      *Tunnel
+	connections int
+	idleTimer   *time.Timer
+	sessions    int
+	lastError   error
+	closed      bool
+	holdsSlot   bool
+}
+
+// forward forwards conn over the open tunnel, starting one if there is none.
+func (o *onDemandTunnel) forward(log log.T, conn net.Conn) {
+	tunnel, err := o.acquire(log)
+	if err != nil {
+		log.Errorf("Unable to start session for connection from %s to %s: %v", conn.RemoteAddr(), o.local, err)
+		conn.Close()
+		return
+	}
+	tunnel.Forward(log, conn)
+	o.release(log)
+}
+
+// dial returns a connection forwarded over the open tunnel, starting one if there is none.
+func (o *onDemandTunnel) dial(log log.T) (net.Conn, error) {
+	tunnel, err := o.acquire(log)
+	if err != nil {
+		return nil, err
+	}
+	local, forwarded := net.Pipe()
+	go func() {
+		tunnel.Forward(log, forwarded)
+		o.release(log)
+	}()
+	return local, nil
+}
+
+// multiplexed returns whether the open tunnel forwards connections concurrently, assumed until one is open.
+func (o *onDemandTunnel) multiplexed() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.tunnel == nil || o.tunnel.Multiplexed()
+}
+
+// acquire counts a connection and returns the open tunnel, starting one if there is none.
+func (o *onDemandTunnel) acquire(log log.T) (*Tunnel, error) {
+	o.mutex.Lock()
+	if o.closed {
+		o.mutex.Unlock()
+		return nil, fmt.Errorf("tunnel closed")
+	}
+	o.connections++
+	if o.idleTimer != nil {
+		o.idleTimer.Stop()
+		o.idleTimer = nil
+	}
+	o.mutex.Unlock()
+
+	o.startMutex.Lock()
+	defer o.startMutex.Unlock()
+
+	o.mutex.Lock()
+	tunnel := o.tunnel
+	o.mutex.Unlock()
+	if tunnel != nil {
+		select {
+		case <-tunnel.Done():
+		default:
+			return tunnel, nil
+		}
+	}
+
+	// a tunnel closed by the agent keeps its slot for the next one
+	o.mutex.Lock()
+	if o.slots != nil && !o.holdsSlot {
+		select {
+		case o.slots <- struct{}{}:
+			o.holdsSlot = true
+		default:
+			o.mutex.Unlock()
+			o.release(log)
+			return nil, errSessionLimit
+		}
+	}
+	o.mutex.Unlock()
+
+	log.Infof("Starting session for %s on demand.", o.local)
+	tunnel, err := startTunnel(log, o.base, o.target, o.mapping, o.onChange)
+
+	o.mutex.Lock()
+	if err == nil && o.closed {
+		o.mutex.Unlock()
+		tunnel.Close(log)
+		return nil, fmt.Errorf("tunnel closed")
+	}
+	if err == nil {
+		o.tunnel = tunnel
+		o.sessions++
+		log.Infof("Session %s started for %s.", tunnel.SessionId(), o.local)
+	} else {
+		o.tunnel = nil
+		o.freeSlot()
+	}
+	o.lastError = err
+	o.mutex.Unlock()
+	o.onChange()
+
+	if err != nil {
+		o.release(log)
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+// release uncounts a connection, the session ends after idleTimeout without connections.
+func (o *onDemandTunnel) release(log log.T) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.connections--; o.connections > 0 || o.tunnel == nil || o.closed {
+		return
+	}
+	tunnel := o.tunnel
+	o.idleTimer = time.AfterFunc(o.idleTimeout, func() {
+		o.mutex.Lock()
+		idle := o.tunnel == tunnel && o.connections == 0
+		if idle {
+			o.tunnel = nil
+			o.freeSlot()
+		}
+		o.mutex.Unlock()
+		if idle {
+			log.Infof("No connections to %s for %v, ending session %s.", o.local, o.idleTimeout, tunnel.SessionId())
+			tunnel.Close(log)
+			o.onChange()
+		}
+	})
+}
+
+// idle returns whether the tunnel has neither an open session nor connections.
+func (o *onDemandTunnel) idle() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.tunnel == nil && o.connections == 0
+}
+
+// close ends the open session and starts no further ones.
+func (o *onDemandTunnel) close(log log.T) {
+	o.mutex.Lock()
+	o.closed = true
+	if o.idleTimer != nil {
+		o.idleTimer.Stop()
+	}
+	tunnel := o.tunnel
+	o.freeSlot()
+	o.mutex.Unlock()
+	if tunnel != nil {
+		tunnel.Close(log)
+	}
+}
+
+// freeSlot gives the slot of the tunnel back, the mutex is held by the caller.
+func (o *onDemandTunnel) freeSlot() {
+	if o.holdsSlot {
+		<-o.slots
+		o.holdsSlot = false
+	}
+}
+
+// row returns the status table row of the tunnel and its state without connection counts.
+func (o *onDemandTunnel) row() (row []string, state string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	sessionId, status := "-", "idle, no session"
+	if o.lastError != nil {
+		status = fmt.Sprintf("idle, last start failed: %v", o.lastError)
+	}
+	if o.tunnel != nil {
+		sessionId = o.tunnel.SessionId()
+		status = tunnelStatus(o.tunnel)
+	}
+	sessions := strconv.Itoa(o.sessions)
+	state = sessionId + " " + sessions
+	if o.tunnel != nil {
+		state += " " + strings.SplitN(status, ",", 2)[0]
+	}
+	return []string{o.local, o.mapping.remote(o.target), sessionId, sessions, status}, state
+}
+
+// runOnDemandMappings listens for every mapping and starts its session when a connection arrives, ending it
+// after base.OnDemand without connections. All sessions are closed on a terminate signal.
+func runOnDemandMappings(log log.T, base *session.Session, mappings []PortMapping) (err error) {
+	var tunnels []*onDemandTunnel
+	defer func() {
+		for _, o := range tunnels {
+			o.listener.Close()
+			if o.mapping.LocalUnixSocket != "" {
+				removeUnixSocket(o.mapping.LocalUnixSocket)
+			}
+		}
+	}()
+
+	status := newStatusTable(log, []string{"LOCAL", "REMOTE", "SESSION", "SESSIONS", "STATUS"}, func() (rows [][]string, state string) {
+		var states []string
+		for _, o := range tunnels {
+			row, rowState := o.row()
+			rows = append(rows, row)
+			states = append(states, rowState)
+		}
+		return rows, strings.Join(states, "\n")
+	})
+
+	certificate, err := httpProxyCertificate(log, base)
+	if err != nil {
+		return fmt.Errorf("unable to generate certificate of the HTTP proxy: %v", err)
+	}
+	for _, mapping := range mappings {
+		o := &onDemandTunnel{
+			base:        base,
+			mapping:     mapping,
+			target:      mapping.Target,
+			idleTimeout: base.OnDemand,
+			onChange:    status.print,
+		}
+		if o.target == "" {
+			o.target = base.TargetId
+		}
+		if o.listener, o.local, err = mapping.listen(log, base); err != nil {
+			return fmt.Errorf("unable to listen for %s: %v", mapping.remote(o.target), err)
+		}
+		// the session is started by the first connection
+		o.listener = readyListener(log, base.ReadyEvents, newReadyEvent("", o.target, o.listener), o.listener)
+		tunnels = append(tunnels, o)
+	}
+
+	terminate, stopSignals := notifyTerminateSignal(log, base)
+	defer stopSignals()
+
+	stop := make(chan struct{})
+	for _, o := range tunnels {
+		go func(o *onDemandTunnel) {
+			var serveErr error
+			if base.HTTPProxy {
+				proxy := newHTTPProxy(base, o.mapping, certificate)
+				proxy.dial = func() (net.Conn, error) { return o.dial(log) }
+				proxy.multiplexed = o.multiplexed
+				serveErr = proxy.serve(log, o.listener, stop)
+			} else {
+				serveErr = acceptConnections(log, o.listener, stop, func(conn net.Conn) {
+					o.forward(log, conn)
+				})
+			}
+			if serveErr != nil {
+				log.Errorf("Stopped accepting connections on %s: %v", o.local, serveErr)
+			}
+		}(o)
+	}
+	status.print()
+
+	<-terminate
+	fmt.Println("Terminate signal received, closing all port mappings.")
+	close(stop)
+	for _, o := range tunnels {
+		o.listener.Close()
+		o.close(log)
+	}
+	fmt.Println("All port mappings closed.")
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnDemandTunnelStartsAndEndsSessions(t *testing.T) {
+	logger := log.NewMockLog()
+	defer func(original func(log.T, *session.Session, string, PortMapping, func()) (*Tunnel, error)) {
+		startTunnel = original
+	}(startTunnel)
+	var started []*Tunnel
+	startTunnel = func(log log.T, base *session.Session, target string, mapping PortMapping, onChange func()) (*Tunnel, error) {
+		mockSession := getTunnelSessionMock()
+		tunnel := &Tunnel{session: &mockSession, accounting: newConnectionAccounting(log, "", ""), basic: make(chan struct{}, 1), onChange: onChange, done: make(chan struct{})}
+		started = append(started, tunnel)
+		return tunnel, nil
+	}
+
+	changed := make(chan struct{}, 1)
+	o := &onDemandTunnel{
+		base:        &session.Session{},
+		mapping:     PortMapping{LocalPortNumber: "8080", PortNumber: "80"},
+		target:      "i-123456",
+		idleTimeout: 50 * time.Millisecond,
+		onChange: func() {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		},
+	}
+
+	// no session is started before the first connection, the second connection shares it
+	assert.Empty(t, started)
+	first, err := o.acquire(logger)
+	assert.Nil(t, err)
+	<-changed
+	second, err := o.acquire(logger)
+	assert.Nil(t, err)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, len(started))
+
+	// the session ends once no connection was open for the idle timeout
+	o.release(logger)
+	o.mutex.Lock()
+	assert.Nil(t, o.idleTimer)
+	o.mutex.Unlock()
+	row, _ := o.row()
+	assert.Contains(t, row[4], "open")
+	o.release(logger)
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("session not ended after the idle timeout")
+	}
+	select {
+	case <-first.Done():
+	default:
+		assert.Fail(t, "session not ended after the idle timeout")
+	}
+
+	// the next connection starts a new session
+	third, err := o.acquire(logger)
+	assert.Nil(t, err)
+	assert.NotEqual(t, first, third)
+	row, _ = o.row()
+	assert.Equal(t, "2", row[3])
+
+	o.close(logger)
+	<-third.Done()
+	_, err = o.acquire(logger)
+	assert.NotNil(t, err)
+}
+
+func TestOnDemandTunnelsShareSessionLimit(t *testing.T) {
+	defer func(original func(log.T, *session.Session, string, PortMapping, func()) (*Tunnel, error)) {
+		startTunnel = original
+	}(startTunnel)
+	startTunnel = func(log log.T, base *session.Session, target string, mapping PortMapping, onChange func()) (*Tunnel, error) {
+		mockSession := getTunnelSessionMock()
+		return &Tunnel{session: &mockSession, accounting: newConnectionAccounting(log, "", ""), basic: make(chan struct{}, 1), onChange: onChange, done: make(chan struct{})}, nil
+	}
+
+	slots := make(chan struct{}, 1)
+	newTunnel := func(port string) *onDemandTunnel {
+		return &onDemandTunnel{
+			base:        &session.Session{},
+			mapping:     PortMapping{Host: "db.internal", PortNumber: port},
+			target:      "i-123456",
+			idleTimeout: time.Minute,
+			onChange:    func() {},
+			slots:       slots,
+		}
+	}
+	first, second := newTunnel("5432"), newTunnel("6379")
+
+	_, err := first.acquire(mockLog)
+	assert.Nil(t, err)
+	_, err = second.acquire(mockLog)
+	assert.Equal(t, errSessionLimit, err)
+
+	// closing the first session frees its slot
+	first.close(mockLog)
+	_, err = second.acquire(mockLog)
+	assert.Nil(t, err)
+	second.close(mockLog)
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:build darwin
+// +build darwin
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerCredentials returns the user and primary group ids of the process connected to a unix socket.
+func peerCredentials(conn net.Conn) (uid uint32, gid uint32, err error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, 0, errors.New("not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var credentials *unix.Xucred
+	if controlErr := raw.Control(func(fd uintptr) {
+		credentials, err = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	}); controlErr != nil {
+		return 0, 0, controlErr
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if credentials.Ngroups == 0 {
+		return 0, 0, errors.New("peer credentials without group")
+	}
+	return credentials.Uid, credentials.Groups[0], nil
+}
@@ -0,0 +1,28 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentials is not supported, unix socket clients cannot be restricted by user or group.
+func peerCredentials(conn net.Conn) (uid uint32, gid uint32, err error) {
+	return 0, 0, errors.New("peer credentials are not supported on this platform")
+}
@@ -0,0 +1,322 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+)
+
+const (
+	// PortForwardingDocument forwards a local port to a port of the target
+	PortForwardingDocument = "AWS-StartPortForwardingSession"
+	// RemoteHostPortForwardingDocument forwards a local port to a port of a host reachable from the target
+	RemoteHostPortForwardingDocument = "AWS-StartPortForwardingSessionToRemoteHost"
+)
+
+// PortMapping is a local port or unix socket forwarded to a port of a target, or of a host reachable from it.
+type PortMapping struct {
+	LocalPortNumber string
+	LocalUnixSocket string
+	Target          string
+	Host            string
+	PortNumber      string
+	// UDP listens on a local UDP port and forwards the datagrams of every client framed with a length prefix to
+	// a relay listening on the remote port, such as ssmcli udp-relay
+	UDP bool
+	// Document and DocumentParameters replace the port forwarding document chosen by the remote host and
+	// its parameters if set
+	Document           string
+	DocumentParameters map[string][]string
+}
+
+// ParsePortMapping parses LOCAL=[TARGET/][HOST:]PORT. LOCAL is a local port number, 0 for any free port, or
+// the path of a unix socket containing a slash, udp:PORT forwards a local UDP port to a relay. TARGET defaults
+// to the target of the session, HOST forwards to a host reachable from the target, IPv6 addresses are in
+// brackets.
+func ParsePortMapping(spec string) (m PortMapping, err error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return m, fmt.Errorf("invalid port mapping %q, expected LOCAL=[TARGET/][HOST:]PORT", spec)
+	}
+	local, remote := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+	if strings.HasPrefix(strings.ToLower(local), "udp:") {
+		if m.LocalPortNumber, m.UDP = local[len("udp:"):], true; !isPortNumber(m.LocalPortNumber, true) {
+			return m, fmt.Errorf("invalid local UDP port %q in port mapping %q", m.LocalPortNumber, spec)
+		}
+	} else if strings.Contains(local, "/") {
+		m.LocalUnixSocket = local
+	} else if isPortNumber(local, true) {
+		m.LocalPortNumber = local
+	} else {
+		return m, fmt.Errorf("invalid local port %q in port mapping %q, expected a port number or a unix socket path", local, spec)
+	}
+
+	if i := strings.Index(remote, "/"); i >= 0 {
+		if m.Target = remote[:i]; m.Target == "" {
+			return m, fmt.Errorf("invalid target in port mapping %q", spec)
+		}
+		remote = remote[i+1:]
+	}
+	if i := strings.LastIndex(remote, ":"); i >= 0 {
+		if m.Host = strings.TrimSuffix(strings.TrimPrefix(remote[:i], "["), "]"); m.Host == "" {
+			return m, fmt.Errorf("invalid remote host in port mapping %q", spec)
+		}
+		remote = remote[i+1:]
+	}
+	if !isPortNumber(remote, false) {
+		return m, fmt.Errorf("invalid remote port %q in port mapping %q", remote, spec)
+	}
+	m.PortNumber = remote
+	return m, nil
+}
+
+// isPortNumber returns whether port is a TCP port number, 0 only if allowed.
+func isPortNumber(port string, allowZero bool) bool {
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 0 || number > 65535 {
+		return false
+	}
+	return number > 0 || allowZero
+}
+
+// DocumentName returns the document starting the session of the mapping.
+func (m PortMapping) DocumentName() string {
+	if m.Document != "" {
+		return m.Document
+	}
+	if m.Host != "" {
+		return RemoteHostPortForwardingDocument
+	}
+	return PortForwardingDocument
+}
+
+// Parameters returns the document parameters of the session of the mapping.
+func (m PortMapping) Parameters() map[string][]string {
+	if m.DocumentParameters != nil {
+		return m.DocumentParameters
+	}
+	parameters := map[string][]string{"portNumber": {m.PortNumber}}
+	if m.Host != "" {
+		parameters["host"] = []string{m.Host}
+	}
+	return parameters
+}
+
+// remote describes the destination of the mapping on target.
+func (m PortMapping) remote(target string) string {
+	relay := ""
+	if m.UDP {
+		relay = "UDP relay "
+	}
+	if m.Host != "" {
+		return fmt.Sprintf("%s%s via %s", relay, net.JoinHostPort(m.Host, m.PortNumber), target)
+	}
+	return fmt.Sprintf("%s%s port %s", relay, target, m.PortNumber)
+}
+
+// listen opens the local listener of the mapping, or takes the one systemd passed for it, and returns the address
+// it listens on.
+func (m PortMapping) listen(log log.T, base *session.Session) (listener net.Listener, local string, err error) {
+	if !m.UDP {
+		name := m.LocalPortNumber
+		if m.LocalUnixSocket != "" {
+			name = m.LocalUnixSocket
+		}
+		if listener = takeActivatedListener(log, name); listener != nil {
+			return guardListener(log, base, listener), listener.Addr().String(), nil
+		}
+	}
+	if m.LocalUnixSocket != "" {
+		if listener, err = listenUnixSocket(base, m.LocalUnixSocket); err != nil {
+			return nil, "", err
+		}
+		return guardListener(log, base, listener), m.LocalUnixSocket, nil
+	}
+	var hosts []string
+	if m.UDP {
+		listener, hosts, err = startLocalUDPListener(log, base.LocalHost, m.LocalPortNumber, base.AllowNonLoopback)
+	} else {
+		listener, hosts, err = startLocalTCPListener(log, base.LocalHost, m.LocalPortNumber, base.AllowNonLoopback)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	port := listenerPort(listener)
+	for i, host := range hosts {
+		hosts[i] = net.JoinHostPort(host, port)
+	}
+	local = strings.Join(hosts, ", ")
+	if m.UDP {
+		local = "udp " + local
+	}
+	return guardListener(log, base, listener), local, nil
+}
+
+// portMappingTunnel is a mapping forwarded by the plugin.
+type portMappingTunnel struct {
+	mapping  PortMapping
+	local    string
+	target   string
+	listener net.Listener
+	tunnel   *Tunnel
+}
+
+// RunPortMappings forwards every mapping over a session of its own started with the settings of base, shows
+// their state and closes all sessions on a terminate signal. It returns once all tunnels closed. If base.OnDemand
+// is set sessions are started by the first connection instead, and run until the terminate signal. If
+// base.HTTPProxy is set the local listeners are HTTP reverse proxies.
+func RunPortMappings(log log.T, base *session.Session, mappings []PortMapping) (err error) {
+	if base.OnDemand > 0 {
+		return runOnDemandMappings(log, base, mappings)
+	}
+	certificate, err := httpProxyCertificate(log, base)
+	if err != nil {
+		return fmt.Errorf("unable to generate certificate of the HTTP proxy: %v", err)
+	}
+	var tunnels []*portMappingTunnel
+	// the tunnels report changes to the loop below, which prints the status table
+	changes := make(chan struct{}, 1)
+	changed := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+	closeAll := func() {
+		for _, t := range tunnels {
+			t.tunnel.Close(log)
+		}
+	}
+	// abort closes the tunnels started and their listeners when not all mappings could be started
+	abort := func() {
+		closeAll()
+		for _, t := range tunnels {
+			closeMappingListener(t)
+		}
+	}
+
+	terminate, stopSignals := notifyTerminateSignal(log, base)
+	defer stopSignals()
+
+	for _, mapping := range mappings {
+		select {
+		case <-terminate:
+			fmt.Println("Terminate signal received, closing all port mappings.")
+			abort()
+			return nil
+		default:
+		}
+		t := &portMappingTunnel{mapping: mapping, target: mapping.Target}
+		if t.target == "" {
+			t.target = base.TargetId
+		}
+		if t.listener, t.local, err = mapping.listen(log, base); err != nil {
+			abort()
+			return fmt.Errorf("unable to listen for %s: %v", mapping.remote(t.target), err)
+		}
+		if t.tunnel, err = startTunnel(log, base, t.target, mapping, changed); err != nil {
+			closeMappingListener(t)
+			abort()
+			return fmt.Errorf("unable to start session for %s: %v", mapping.remote(t.target), err)
+		}
+		log.Infof("Forwarding %s to %s in session %s.", t.local, mapping.remote(t.target), t.tunnel.SessionId())
+		t.listener = readyListener(log, base.ReadyEvents, newReadyEvent(t.tunnel.SessionId(), t.target, t.listener), t.listener)
+		tunnels = append(tunnels, t)
+	}
+
+	status := newStatusTable(log, []string{"LOCAL", "REMOTE", "SESSION", "STATUS"}, func() (rows [][]string, state string) {
+		closed := 0
+		for _, t := range tunnels {
+			status := tunnelStatus(t.tunnel)
+			if status == "closed" {
+				closed++
+			}
+			rows = append(rows, []string{t.local, t.mapping.remote(t.target), t.tunnel.SessionId(), status})
+		}
+		return rows, strconv.Itoa(closed)
+	})
+	ended := make(chan *portMappingTunnel, len(tunnels))
+	for _, t := range tunnels {
+		go func(t *portMappingTunnel) {
+			var serveErr error
+			if base.HTTPProxy {
+				proxy := newHTTPProxy(base, t.mapping, certificate)
+				proxy.dial = func() (net.Conn, error) { return t.tunnel.Dial(log), nil }
+				proxy.multiplexed = t.tunnel.Multiplexed
+				serveErr = proxy.serve(log, t.listener, t.tunnel.Done())
+			} else {
+				serveErr = t.tunnel.Serve(log, t.listener)
+			}
+			if serveErr != nil {
+				log.Errorf("Stopped accepting connections on %s: %v", t.local, serveErr)
+				t.tunnel.Close(log)
+			}
+			ended <- t
+		}(t)
+	}
+	status.print()
+
+	for open := len(tunnels); open > 0; {
+		select {
+		case t := <-ended:
+			open--
+			closeMappingListener(t)
+			status.print()
+		case <-changes:
+			status.print()
+		case <-terminate:
+			fmt.Println("Terminate signal received, closing all port mappings.")
+			terminate = nil
+			closeAll()
+		}
+	}
+	fmt.Println("All port mappings closed.")
+	return nil
+}
+
+// closeMappingListener closes the listener of a mapping and removes its unix socket.
+func closeMappingListener(t *portMappingTunnel) {
+	t.listener.Close()
+	if t.mapping.LocalUnixSocket != "" {
+		removeUnixSocket(t.mapping.LocalUnixSocket)
+	}
+}
+
+// startTunnel starts and connects the session of a mapping, onChange is passed to NewTunnel.
+var startTunnel = func(log log.T, base *session.Session, target string, mapping PortMapping, onChange func()) (*Tunnel, error) {
+	sess, err := base.StartSessionTo(log, target, mapping.DocumentName(), mapping.Parameters())
+	if err != nil {
+		return nil, err
+	}
+	if err = sess.Connect(log); err != nil {
+		sess.EndSession(log)
+		return nil, err
+	}
+	tunnel, err := NewTunnel(log, sess, onChange)
+	if err != nil {
+		sess.AuditSessionEnd(log, err.Error())
+		sess.EndSession(log)
+		sess.DataChannel.Close(log)
+		return nil, err
+	}
+	return tunnel, nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePortMapping(t *testing.T) {
+	mapping, err := ParsePortMapping("8080=80")
+	assert.Nil(t, err)
+	assert.Equal(t, PortMapping{LocalPortNumber: "8080", PortNumber: "80"}, mapping)
+	assert.Equal(t, PortForwardingDocument, mapping.DocumentName())
+	assert.Equal(t, map[string][]string{"portNumber": {"80"}}, mapping.Parameters())
+
+	mapping, err = ParsePortMapping("0=i-123456/db.internal:5432")
+	assert.Nil(t, err)
+	assert.Equal(t, PortMapping{LocalPortNumber: "0", Target: "i-123456", Host: "db.internal", PortNumber: "5432"}, mapping)
+	assert.Equal(t, RemoteHostPortForwardingDocument, mapping.DocumentName())
+	assert.Equal(t, map[string][]string{"portNumber": {"5432"}, "host": {"db.internal"}}, mapping.Parameters())
+
+	mapping, err = ParsePortMapping("/tmp/redis.sock=[fd00::1]:6379")
+	assert.Nil(t, err)
+	assert.Equal(t, PortMapping{LocalUnixSocket: "/tmp/redis.sock", Host: "fd00::1", PortNumber: "6379"}, mapping)
+
+	mapping, err = ParsePortMapping("udp:5353=9053")
+	assert.Nil(t, err)
+	assert.Equal(t, PortMapping{LocalPortNumber: "5353", PortNumber: "9053", UDP: true}, mapping)
+	assert.Equal(t, "UDP relay i-123456 port 9053", mapping.remote("i-123456"))
+}
+
+func TestParsePortMappingInvalid(t *testing.T) {
+	for _, spec := range []string{"8080", "http=80", "8080=0", "8080=70000", "8080=/80", "8080=i-123456/:80", "65536=80", "udp:/tmp/dns.sock=53"} {
+		_, err := ParsePortMapping(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestStatusTablePrintsOnlyChanges(t *testing.T) {
+	out := &strings.Builder{}
+	tunnel := &Tunnel{session: &session.Session{SessionId: "sessionId"}, done: make(chan struct{})}
+	status := &statusTable{
+		out:    out,
+		header: []string{"LOCAL", "SESSION", "STATUS"},
+		rows: func() ([][]string, string) {
+			state := tunnelStatus(tunnel)
+			return [][]string{{"localhost:8080", tunnel.SessionId(), state}}, strings.Split(state, ",")[0]
+		},
+	}
+
+	status.print()
+	assert.Equal(t, "LOCAL           SESSION    STATUS\nlocalhost:8080  sessionId  open, 0 connection(s)\n", out.String())
+
+	// connections are shown when the table is redrawn in place only
+	out.Reset()
+	tunnel.connections = 1
+	status.print()
+	assert.Empty(t, out.String())
+
+	close(tunnel.done)
+	status.print()
+	assert.Contains(t, out.String(), "localhost:8080  sessionId  closed")
+
+	out.Reset()
+	status.redraw = true
+	tunnel.connections = 0
+	status.print()
+	assert.True(t, strings.HasPrefix(out.String(), "\x1b[2A\x1b[J"))
+}
+
+func TestRunPortMappingsClosesListenersOnError(t *testing.T) {
+	defer func(original func(log.T, *session.Session, string, PortMapping, func()) (*Tunnel, error)) {
+		startTunnel = original
+	}(startTunnel)
+	var started []*Tunnel
+	startTunnel = func(log log.T, base *session.Session, target string, mapping PortMapping, onChange func()) (*Tunnel, error) {
+		if mapping.PortNumber == "6379" {
+			return nil, errors.New("target not connected")
+		}
+		mockSession := getTunnelSessionMock()
+		tunnel := &Tunnel{session: &mockSession, accounting: newConnectionAccounting(log, "", ""), basic: make(chan struct{}, 1), onChange: onChange, done: make(chan struct{})}
+		started = append(started, tunnel)
+		return tunnel, nil
+	}
+
+	socket := filepath.Join(t.TempDir(), "db.sock")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	port := listenerPort(listener)
+	listener.Close()
+
+	err = RunPortMappings(mockLog, &session.Session{TargetId: "i-123456"}, []PortMapping{
+		{LocalUnixSocket: socket, PortNumber: "5432"},
+		{LocalPortNumber: port, PortNumber: "80"},
+		{LocalPortNumber: "0", PortNumber: "6379"},
+	})
+	assert.NotNil(t, err)
+
+	// the sessions started and the listeners opened before the failure are closed
+	assert.Equal(t, 2, len(started))
+	for _, tunnel := range started {
+		<-tunnel.Done()
+	}
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err))
+	listener, err = net.Listen("tcp", "127.0.0.1:"+port)
+	assert.Nil(t, err)
+	listener.Close()
+}
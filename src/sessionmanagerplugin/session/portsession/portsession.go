@@ -15,11 +15,14 @@
 package portsession
 
 import (
+	"os"
+
 	"github.com/aws/session-manager-plugin/src/config"
 	"github.com/aws/session-manager-plugin/src/jsonutil"
 	"github.com/aws/session-manager-plugin/src/log"
 	"github.com/aws/session-manager-plugin/src/message"
 	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/sessionutil"
 	"github.com/aws/session-manager-plugin/src/version"
 )
 
@@ -46,6 +49,7 @@ type PortParameters struct {
 	LocalPortNumber     string `json:"localPortNumber"`
 	LocalUnixSocket     string `json:"localUnixSocket"`
 	LocalConnectionType string `json:"localConnectionType"`
+	LocalHost           string `json:"localHost"`
 	Type                string `json:"type"`
 }
 
@@ -63,19 +67,30 @@ func (s *PortSession) Initialize(log log.T, sessionVar *session.Session) {
 	if err := jsonutil.Remarshal(s.SessionProperties, &s.portParameters); err != nil {
 		log.Errorf("Invalid format: %v", err)
 	}
+	if s.LocalHost != "" {
+		s.portParameters.LocalHost = s.LocalHost
+	}
 
 	if s.portParameters.Type == LocalPortForwardingType {
+		keepAlive := newKeepAlive(s.KeepAliveInterval, func() int64 {
+			sent, _ := s.DataChannel.GetStreamDataByteCount()
+			return sent
+		}, func() error {
+			return s.DataChannel.SendInputDataMessage(log, message.Output, []byte{})
+		})
 		if version.DoesAgentSupportTCPMultiplexing(log, s.DataChannel.GetAgentVersion()) {
 			s.portSessionType = &MuxPortForwarding{
 				sessionId:      s.SessionId,
 				portParameters: s.portParameters,
 				session:        s.Session,
+				keepAlive:      keepAlive,
 			}
 		} else {
 			s.portSessionType = &BasicPortForwarding{
 				sessionId:      s.SessionId,
 				portParameters: s.portParameters,
 				session:        s.Session,
+				keepAlive:      keepAlive,
 			}
 		}
 	} else {
@@ -131,3 +146,19 @@ func (s *PortSession) ProcessStreamMessagePayload(log log.T, outputMessage messa
 	err = s.portSessionType.WriteStream(outputMessage)
 	return true, err
 }
+
+// waitForTerminateSignal suspends the plugin or ignores signals as mapped and returns once a signal terminates the session
+func waitForTerminateSignal(log log.T, signals chan os.Signal, signalMap sessionutil.SignalMap) {
+	for sig := range signals {
+		switch signalMap[sig].Type {
+		case sessionutil.SignalIgnore:
+			log.Debugf("Ignoring signal %v", sig)
+		case sessionutil.SignalSuspend:
+			if err := sessionutil.SuspendProcess(); err != nil {
+				log.Errorf("Failed to suspend: %v", err)
+			}
+		default:
+			return
+		}
+	}
+}
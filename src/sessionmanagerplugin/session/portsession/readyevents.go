@@ -0,0 +1,140 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/session-manager-plugin/src/log"
+)
+
+const (
+	// ReadyEventReady is emitted once a local listener is open
+	ReadyEventReady = "ready"
+	// ReadyEventConnected is emitted once a local listener accepted its first connection
+	ReadyEventConnected = "connected"
+)
+
+// ReadyEvent is a JSON line describing the local end of a port session, written so that scripts learn the port
+// it listens on and when it is ready without parsing the output of the plugin.
+type ReadyEvent struct {
+	Event           string `json:"event"`
+	SessionId       string `json:"sessionId,omitempty"`
+	Target          string `json:"target"`
+	LocalPortNumber string `json:"localPortNumber,omitempty"`
+	LocalUnixSocket string `json:"localUnixSocket,omitempty"`
+}
+
+var (
+	eventLinesLock sync.Mutex
+	// eventLinesFiles keeps the file descriptors events are written to open
+	eventLinesFiles = make(map[uintptr]*os.File)
+)
+
+// newReadyEvent returns the ready event of a local listener.
+func newReadyEvent(sessionId string, target string, listener net.Listener) ReadyEvent {
+	event := ReadyEvent{Event: ReadyEventReady, SessionId: sessionId, Target: target}
+	switch addr := listener.Addr().(type) {
+	case *net.UnixAddr:
+		event.LocalUnixSocket = addr.Name
+	default:
+		event.LocalPortNumber = listenerPort(listener)
+	}
+	return event
+}
+
+// emitReadyEvent writes event as a JSON line to every destination.
+func emitReadyEvent(log log.T, destinations string, event ReadyEvent) {
+	writeJSONLine(log, destinations, event.Event+" event", event)
+}
+
+// writeJSONLine writes value as a JSON line to every destination, a comma separated list of file descriptors,
+// fd:N, or files appended to. Nothing is written if destinations is empty.
+func writeJSONLine(log log.T, destinations string, description string, value interface{}) {
+	if destinations == "" {
+		return
+	}
+	line, err := json.Marshal(value)
+	if err != nil {
+		log.Errorf("Unable to encode %s: %v", description, err)
+		return
+	}
+	line = append(line, '\n')
+	for _, destination := range strings.Split(destinations, ",") {
+		if err = writeEventLine(strings.TrimSpace(destination), line); err != nil {
+			log.Warnf("Unable to write %s to %s: %v", description, destination, err)
+		}
+	}
+}
+
+// writeEventLine writes line to destination, a file descriptor fd:N or a file appended to.
+func writeEventLine(destination string, line []byte) error {
+	eventLinesLock.Lock()
+	defer eventLinesLock.Unlock()
+
+	if strings.HasPrefix(destination, "fd:") {
+		fd, err := strconv.ParseUint(destination[len("fd:"):], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid file descriptor %q", destination)
+		}
+		file, ok := eventLinesFiles[uintptr(fd)]
+		if !ok {
+			file = os.NewFile(uintptr(fd), destination)
+			eventLinesFiles[uintptr(fd)] = file
+		}
+		_, err = file.Write(line)
+		return err
+	}
+	file, err := os.OpenFile(destination, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(line)
+	return err
+}
+
+// readyListener emits the ready event of listener and returns it wrapped to emit the connected event on its
+// first connection, or listener itself if no events are written.
+func readyListener(log log.T, destinations string, event ReadyEvent, listener net.Listener) net.Listener {
+	if destinations == "" {
+		return listener
+	}
+	emitReadyEvent(log, destinations, event)
+	event.Event = ReadyEventConnected
+	return &connectedEventListener{Listener: listener, emit: func() { emitReadyEvent(log, destinations, event) }}
+}
+
+// connectedEventListener emits the connected event when it accepts its first connection.
+type connectedEventListener struct {
+	net.Listener
+	once sync.Once
+	emit func()
+}
+
+// Accept accepts the next connection, emitting the connected event for the first one.
+func (l *connectedEventListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.once.Do(l.emit)
+	}
+	return conn, err
+}
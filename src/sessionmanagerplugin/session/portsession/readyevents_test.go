@@ -0,0 +1,65 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package portsession
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadyListenerEmitsEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	listener = readyListener(mockLog, path, newReadyEvent("sessionId", "i-123456", listener), listener)
+	defer listener.Close()
+	events, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"event":"ready","sessionId":"sessionId","target":"i-123456","localPortNumber":"`+port+`"}`+"\n", string(events))
+
+	for i := 0; i < 2; i++ {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		assert.Nil(t, err)
+		defer client.Close()
+		conn, err := listener.Accept()
+		assert.Nil(t, err)
+		defer conn.Close()
+	}
+	events, err = ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"event":"ready","sessionId":"sessionId","target":"i-123456","localPortNumber":"`+port+`"}`+"\n"+
+		`{"event":"connected","sessionId":"sessionId","target":"i-123456","localPortNumber":"`+port+`"}`+"\n", string(events))
+}
+
+func TestReadyListenerWithoutDestinations(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	assert.Equal(t, listener, readyListener(mockLog, "", newReadyEvent("sessionId", "i-123456", listener), listener))
+}
+
+func TestNewReadyEventOfUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socket")
+	listener, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	defer listener.Close()
+	assert.Equal(t, ReadyEvent{Event: ReadyEventReady, Target: "i-123456", LocalUnixSocket: path}, newReadyEvent("", "i-123456", listener))
+}
@@ -0,0 +1,418 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+)
+
+const (
+	// DefaultSocksPortNumber is the local port of the SOCKS proxy unless set
+	DefaultSocksPortNumber = "1080"
+	// DefaultSocksIdleTimeout ends the session of a destination without connections unless base.OnDemand is set
+	DefaultSocksIdleTimeout = 5 * time.Minute
+	// socksHandshakeTimeout is how long a client may take to send its CONNECT request
+	socksHandshakeTimeout = 30 * time.Second
+
+	socksVersion       = 5
+	socksNoAuth        = 0
+	socksNoAcceptable  = 0xff
+	socksConnect       = 1
+	socksAddressIPv4   = 1
+	socksAddressDomain = 3
+	socksAddressIPv6   = 4
+)
+
+// socksReply is the reply code of a SOCKS5 request.
+type socksReply byte
+
+const (
+	socksSucceeded           socksReply = 0
+	socksGeneralFailure      socksReply = 1
+	socksNotAllowed          socksReply = 2
+	socksCommandNotSupported socksReply = 7
+	socksAddressNotSupported socksReply = 8
+)
+
+// SocksSettings are the settings of a SOCKS proxy.
+type SocksSettings struct {
+	// LocalPortNumber is the port the proxy listens on, DefaultSocksPortNumber if empty
+	LocalPortNumber string
+	// Allowlist restricts the destinations of the proxy, all destinations are allowed if empty
+	Allowlist Allowlist
+	// MaxSessions limits the sessions open at the same time, 0 for no limit
+	MaxSessions int
+}
+
+// Allowlist is a list of destination rules, a destination is allowed if any rule matches it.
+type Allowlist []destinationRule
+
+// destinationRule matches destinations by host name pattern or network, and port range.
+type destinationRule struct {
+	spec     string
+	pattern  string
+	network  *net.IPNet
+	portFrom int
+	portTo   int
+}
+
+// ParseAllowlist parses destination rules HOST[:PORT]. HOST is a host name pattern such as *.internal, an IP
+// address or a CIDR network, IPv6 addresses and networks are in brackets. PORT is a port number, a range such as
+// 8000-8100 or *, the default.
+func ParseAllowlist(specs []string) (allowlist Allowlist, err error) {
+	for _, spec := range specs {
+		rule := destinationRule{spec: spec, portFrom: 1, portTo: 65535}
+		host, ports := strings.TrimSpace(spec), "*"
+		if strings.HasPrefix(host, "[") {
+			end := strings.Index(host, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid destination %q, missing ]", spec)
+			}
+			if rest := host[end+1:]; rest != "" {
+				if !strings.HasPrefix(rest, ":") {
+					return nil, fmt.Errorf("invalid destination %q, expected HOST[:PORT]", spec)
+				}
+				ports = rest[1:]
+			}
+			host = host[1:end]
+		} else if i := strings.LastIndex(host, ":"); i >= 0 {
+			host, ports = host[:i], host[i+1:]
+		}
+
+		if host == "" {
+			return nil, fmt.Errorf("invalid destination %q, missing host", spec)
+		}
+		if strings.Contains(host, "/") {
+			if _, rule.network, err = net.ParseCIDR(host); err != nil {
+				return nil, fmt.Errorf("invalid network in destination %q: %v", spec, err)
+			}
+		} else if ip := net.ParseIP(host); ip != nil {
+			rule.pattern = ip.String()
+		} else if _, err = path.Match(host, ""); err != nil {
+			return nil, fmt.Errorf("invalid host pattern in destination %q: %v", spec, err)
+		} else {
+			rule.pattern = strings.ToLower(host)
+		}
+
+		if ports != "*" {
+			bounds := strings.SplitN(ports, "-", 2)
+			if !isPortNumber(bounds[0], false) || (len(bounds) == 2 && !isPortNumber(bounds[1], false)) {
+				return nil, fmt.Errorf("invalid port %q in destination %q", ports, spec)
+			}
+			rule.portFrom, _ = strconv.Atoi(bounds[0])
+			rule.portTo = rule.portFrom
+			if len(bounds) == 2 {
+				rule.portTo, _ = strconv.Atoi(bounds[1])
+			}
+			if rule.portFrom > rule.portTo {
+				return nil, fmt.Errorf("invalid port range %q in destination %q", ports, spec)
+			}
+		}
+		allowlist = append(allowlist, rule)
+	}
+	return allowlist, nil
+}
+
+// Allows returns whether the allowlist allows host port. Networks only match IP addresses, since host names are
+// resolved by the target.
+func (a Allowlist) Allows(host string, port int) bool {
+	if len(a) == 0 {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil {
+		host = ip.String()
+	} else {
+		host = strings.ToLower(host)
+	}
+	for _, rule := range a {
+		if port < rule.portFrom || port > rule.portTo {
+			continue
+		}
+		if rule.network != nil {
+			if ip != nil && rule.network.Contains(ip) {
+				return true
+			}
+		} else if matched, _ := path.Match(rule.pattern, host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// socksHandshake reads the greeting and request of a SOCKS5 client, accepting clients without authentication, and
+// returns the destination of its CONNECT request. Requests that are not supported are answered.
+func socksHandshake(conn net.Conn) (host string, port int, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return "", 0, err
+	}
+	if header[0] != socksVersion {
+		return "", 0, fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err = io.ReadFull(conn, methods); err != nil {
+		return "", 0, err
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err = conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", 0, err
+	}
+	if method == socksNoAcceptable {
+		return "", 0, errors.New("client requires authentication")
+	}
+
+	request := make([]byte, 4)
+	if _, err = io.ReadFull(conn, request); err != nil {
+		return "", 0, err
+	}
+	if request[0] != socksVersion {
+		return "", 0, fmt.Errorf("unsupported SOCKS version %d", request[0])
+	}
+	switch request[3] {
+	case socksAddressIPv4, socksAddressIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socksAddressIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err = io.ReadFull(conn, ip); err != nil {
+			return "", 0, err
+		}
+		host = ip.String()
+	case socksAddressDomain:
+		length := make([]byte, 1)
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return "", 0, err
+		}
+		domain := make([]byte, length[0])
+		if _, err = io.ReadFull(conn, domain); err != nil {
+			return "", 0, err
+		}
+		host = string(domain)
+	default:
+		writeSocksReply(conn, socksAddressNotSupported)
+		return "", 0, fmt.Errorf("unsupported address type %d", request[3])
+	}
+	portBytes := make([]byte, 2)
+	if _, err = io.ReadFull(conn, portBytes); err != nil {
+		return "", 0, err
+	}
+	port = int(binary.BigEndian.Uint16(portBytes))
+
+	if request[1] != socksConnect {
+		writeSocksReply(conn, socksCommandNotSupported)
+		return "", 0, fmt.Errorf("unsupported command %d for %s", request[1], net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	return host, port, nil
+}
+
+// writeSocksReply answers a request, the bound address is left empty since it is on the target.
+func writeSocksReply(conn net.Conn, reply socksReply) error {
+	_, err := conn.Write([]byte{socksVersion, byte(reply), 0, socksAddressIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// socksProxy forwards the connections of SOCKS clients over remote host port forwarding sessions to their
+// destination, one session per destination started by its first connection and ended once idle.
+type socksProxy struct {
+	base         *session.Session
+	settings     SocksSettings
+	slots        chan struct{}
+	onChange     func()
+	mutex        sync.Mutex
+	destinations map[string]*onDemandTunnel
+	// order lists the destinations in the order they were first requested
+	order []*onDemandTunnel
+	// users counts the connections between requesting a destination and releasing its tunnel
+	users map[*onDemandTunnel]int
+}
+
+// handle answers the handshake of a client and forwards its connection to the requested destination.
+func (p *socksProxy) handle(log log.T, conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	host, port, err := socksHandshake(conn)
+	if err != nil {
+		log.Warnf("SOCKS handshake with %s failed: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	destination := net.JoinHostPort(host, strconv.Itoa(port))
+	if !p.settings.Allowlist.Allows(host, port) {
+		log.Warnf("Refused connection from %s to %s, destination not allowed.", conn.RemoteAddr(), destination)
+		writeSocksReply(conn, socksNotAllowed)
+		conn.Close()
+		return
+	}
+
+	o := p.destination(host, port)
+	defer p.done(o)
+	tunnel, err := o.acquire(log)
+	if err != nil {
+		log.Errorf("Unable to start session for connection from %s to %s: %v", conn.RemoteAddr(), destination, err)
+		writeSocksReply(conn, socksGeneralFailure)
+		conn.Close()
+		return
+	}
+	// the client is answered once the connection is forwarded to the destination
+	tunnel.forward(log, conn, func(forwarded bool) error {
+		if !forwarded {
+			return writeSocksReply(conn, socksGeneralFailure)
+		}
+		return writeSocksReply(conn, socksSucceeded)
+	})
+	o.release(log)
+}
+
+// destination returns the on demand tunnel of host port, adding it on its first request. The caller calls done
+// once it released the tunnel. Destinations without session and connections are evicted when others are added.
+func (p *socksProxy) destination(host string, port int) *onDemandTunnel {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key := net.JoinHostPort(host, strconv.Itoa(port))
+	o, ok := p.destinations[key]
+	if !ok {
+		p.evictIdle()
+		o = &onDemandTunnel{
+			base:        p.base,
+			mapping:     PortMapping{Host: host, PortNumber: strconv.Itoa(port)},
+			target:      p.base.TargetId,
+			local:       key,
+			idleTimeout: p.base.OnDemand,
+			onChange:    p.onChange,
+			slots:       p.slots,
+		}
+		if o.idleTimeout <= 0 {
+			o.idleTimeout = DefaultSocksIdleTimeout
+		}
+		p.destinations[key] = o
+		p.order = append(p.order, o)
+	}
+	if p.users == nil {
+		p.users = make(map[*onDemandTunnel]int)
+	}
+	p.users[o]++
+	return o
+}
+
+// done ends the use of a destination returned by destination.
+func (p *socksProxy) done(o *onDemandTunnel) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.users[o]--; p.users[o] <= 0 {
+		delete(p.users, o)
+	}
+}
+
+// evictIdle removes the destinations not in use without an open session, the mutex is held by the caller.
+func (p *socksProxy) evictIdle() {
+	order := p.order[:0]
+	for _, o := range p.order {
+		if p.users[o] == 0 && o.idle() {
+			delete(p.destinations, o.local)
+			continue
+		}
+		order = append(order, o)
+	}
+	for i := len(order); i < len(p.order); i++ {
+		p.order[i] = nil
+	}
+	p.order = order
+}
+
+// tunnels returns the on demand tunnels of the destinations requested so far.
+func (p *socksProxy) tunnels() []*onDemandTunnel {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]*onDemandTunnel(nil), p.order...)
+}
+
+// RunSocksProxy runs a SOCKS5 proxy forwarding every CONNECT request over a remote host port forwarding session
+// to the requested destination via the target of base. Sessions are shared by the connections to a destination
+// and end after base.OnDemand, or DefaultSocksIdleTimeout, without connections. All sessions are closed on a
+// terminate signal.
+func RunSocksProxy(log log.T, base *session.Session, settings SocksSettings) error {
+	mapping := PortMapping{LocalPortNumber: settings.LocalPortNumber}
+	if mapping.LocalPortNumber == "" {
+		mapping.LocalPortNumber = DefaultSocksPortNumber
+	}
+	listener, local, err := mapping.listen(log, base)
+	if err != nil {
+		return fmt.Errorf("unable to listen for SOCKS clients: %v", err)
+	}
+	defer listener.Close()
+
+	p := &socksProxy{
+		base:         base,
+		settings:     settings,
+		destinations: make(map[string]*onDemandTunnel),
+	}
+	if settings.MaxSessions > 0 {
+		p.slots = make(chan struct{}, settings.MaxSessions)
+	}
+	status := newStatusTable(log, []string{"DESTINATION", "VIA", "SESSION", "SESSIONS", "STATUS"}, func() (rows [][]string, state string) {
+		var states []string
+		for _, o := range p.tunnels() {
+			row, rowState := o.row()
+			rows = append(rows, []string{row[0], base.TargetId, row[2], row[3], row[4]})
+			states = append(states, rowState)
+		}
+		return rows, strings.Join(states, "\n")
+	})
+	p.onChange = status.print
+
+	terminate, stopSignals := notifyTerminateSignal(log, base)
+	defer stopSignals()
+
+	fmt.Printf("SOCKS5 proxy listening on %s, forwarding via %s.\n", local, base.TargetId)
+	stop := make(chan struct{})
+	go func() {
+		if acceptErr := acceptConnections(log, listener, stop, func(conn net.Conn) {
+			p.handle(log, conn)
+		}); acceptErr != nil {
+			log.Errorf("Stopped accepting SOCKS clients on %s: %v", local, acceptErr)
+		}
+	}()
+	status.print()
+
+	<-terminate
+	fmt.Println("Terminate signal received, closing SOCKS proxy.")
+	close(stop)
+	listener.Close()
+	for _, o := range p.tunnels() {
+		o.close(log)
+	}
+	fmt.Println("SOCKS proxy closed.")
+	return nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package portsession
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAllowlist(t *testing.T) {
+	allowlist, err := ParseAllowlist([]string{"*.internal:5432", "10.0.0.0/8:8000-8100", "[fd00::1]", "db.example.com"})
+	assert.Nil(t, err)
+
+	assert.True(t, allowlist.Allows("orders.internal", 5432))
+	assert.True(t, allowlist.Allows("Orders.Internal", 5432))
+	assert.False(t, allowlist.Allows("orders.internal", 5433))
+	assert.True(t, allowlist.Allows("10.1.2.3", 8080))
+	assert.False(t, allowlist.Allows("10.1.2.3", 22))
+	assert.False(t, allowlist.Allows("11.1.2.3", 8080))
+	assert.True(t, allowlist.Allows("fd00:0::1", 443))
+	assert.True(t, allowlist.Allows("db.example.com", 22))
+	assert.False(t, allowlist.Allows("example.com", 22))
+
+	assert.True(t, Allowlist(nil).Allows("anything", 1))
+
+	for _, spec := range []string{":80", "host:0", "host:90-80", "10.0.0.0/33", "[fd00::1", "[[", "host:http"} {
+		_, err = ParseAllowlist([]string{spec})
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestSocksHandshake(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		client.Write([]byte{socksVersion, 2, 2, socksNoAuth})
+		reply := make([]byte, 2)
+		io.ReadFull(client, reply)
+		assert.Equal(t, []byte{socksVersion, socksNoAuth}, reply)
+		client.Write([]byte{socksVersion, socksConnect, 0, socksAddressDomain, 15})
+		client.Write([]byte("orders.internal"))
+		client.Write([]byte{0x15, 0x38})
+	}()
+
+	host, port, err := socksHandshake(server)
+	assert.Nil(t, err)
+	assert.Equal(t, "orders.internal", host)
+	assert.Equal(t, 5432, port)
+}
+
+func TestSocksHandshakeRefusesUnsupportedCommand(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	replies := make(chan []byte, 1)
+	go func() {
+		client.Write([]byte{socksVersion, 1, socksNoAuth})
+		io.ReadFull(client, make([]byte, 2))
+		// BIND to 10.0.0.1:80
+		client.Write([]byte{socksVersion, 2, 0, socksAddressIPv4, 10, 0, 0, 1, 0, 80})
+		reply := make([]byte, 10)
+		io.ReadFull(client, reply)
+		replies <- reply
+	}()
+
+	_, _, err := socksHandshake(server)
+	assert.NotNil(t, err)
+	assert.Equal(t, byte(socksCommandNotSupported), (<-replies)[1])
+}
+
+func TestSocksProxyRefusesDestinations(t *testing.T) {
+	defer func(original func(log.T, *session.Session, string, PortMapping, func()) (*Tunnel, error)) {
+		startTunnel = original
+	}(startTunnel)
+	startTunnel = func(log log.T, base *session.Session, target string, mapping PortMapping, onChange func()) (*Tunnel, error) {
+		assert.Fail(t, "no session expected for refused destinations")
+		return nil, errSessionLimit
+	}
+
+	allowlist, _ := ParseAllowlist([]string{"*.internal"})
+	p := &socksProxy{
+		base:         &session.Session{TargetId: "i-123456"},
+		settings:     SocksSettings{Allowlist: allowlist},
+		onChange:     func() {},
+		destinations: make(map[string]*onDemandTunnel),
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go p.handle(mockLog, server)
+
+	client.Write([]byte{socksVersion, 1, socksNoAuth})
+	io.ReadFull(client, make([]byte, 2))
+	client.Write([]byte{socksVersion, socksConnect, 0, socksAddressIPv4, 192, 168, 0, 1, 0, 22})
+	reply := make([]byte, 10)
+	io.ReadFull(client, reply)
+	assert.Equal(t, byte(socksNotAllowed), reply[1])
+	assert.Empty(t, p.tunnels())
+}
+
+func TestSocksProxyRepliesOnceForwarded(t *testing.T) {
+	logger := log.NewMockLog()
+	mockSession := getTunnelSessionMock()
+	closed := &Tunnel{session: &mockSession, accounting: newConnectionAccounting(logger, "", ""), basic: make(chan struct{}), done: make(chan struct{})}
+	closed.Close(logger)
+
+	defer func(original func(log.T, *session.Session, string, PortMapping, func()) (*Tunnel, error)) {
+		startTunnel = original
+	}(startTunnel)
+	startTunnel = func(log log.T, base *session.Session, target string, mapping PortMapping, onChange func()) (*Tunnel, error) {
+		return closed, nil
+	}
+
+	allowlist, _ := ParseAllowlist([]string{"*"})
+	p := &socksProxy{
+		base:         &session.Session{TargetId: "i-123456"},
+		settings:     SocksSettings{Allowlist: allowlist},
+		onChange:     func() {},
+		destinations: make(map[string]*onDemandTunnel),
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		p.handle(logger, server)
+	}()
+
+	// the session started for the destination cannot forward the connection
+	client.Write([]byte{socksVersion, 1, socksNoAuth})
+	io.ReadFull(client, make([]byte, 2))
+	client.Write([]byte{socksVersion, socksConnect, 0, socksAddressIPv4, 192, 168, 0, 1, 0, 22})
+	reply := make([]byte, 10)
+	io.ReadFull(client, reply)
+	assert.Equal(t, byte(socksGeneralFailure), reply[1])
+	<-handled
+	for _, o := range p.tunnels() {
+		o.close(logger)
+	}
+}
+
+func TestSocksProxyEvictsIdleDestinations(t *testing.T) {
+	p := &socksProxy{
+		base:         &session.Session{TargetId: "i-123456"},
+		onChange:     func() {},
+		destinations: make(map[string]*onDemandTunnel),
+	}
+
+	first := p.destination("10.0.0.1", 22)
+	p.done(first)
+	inUse := p.destination("10.0.0.2", 22)
+	assert.Equal(t, []*onDemandTunnel{inUse}, p.tunnels())
+
+	// destinations in use stay, the same destination is shared
+	assert.Equal(t, inUse, p.destination("10.0.0.2", 22))
+	p.done(inUse)
+	third := p.destination("10.0.0.3", 22)
+	assert.Equal(t, []*onDemandTunnel{inUse, third}, p.tunnels())
+	assert.Equal(t, 2, len(p.destinations))
+}
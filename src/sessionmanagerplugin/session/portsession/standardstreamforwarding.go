@@ -40,9 +40,10 @@ func (p *StandardStreamForwarding) IsStreamNotSet() (status bool) {
 
 // Stop closes the streams
 func (p *StandardStreamForwarding) Stop() {
+	p.session.AuditSessionEnd(log.Logger(false, "session-manager-plugin"), "session closed")
 	p.inputStream.Close()
 	p.outputStream.Close()
-	os.Exit(0)
+	os.Exit(session.ExitCode())
 }
 
 // InitializeStreams initializes the streams with its file descriptors
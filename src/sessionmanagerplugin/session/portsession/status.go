@@ -0,0 +1,135 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// statusTable shows the state of the tunnels of the plugin, redrawn in place on a terminal.
+type statusTable struct {
+	out    io.Writer
+	redraw bool
+	header []string
+	// rows returns the rows of the table and a state that changes when the table is worth printing again
+	// when it cannot be redrawn in place
+	rows     func() (rows [][]string, state string)
+	mutex    sync.Mutex
+	printed  int
+	previous string
+	// notify sends the rows to systemd as the status of the service, READY=1 the first time
+	notify   func(states ...string)
+	notified string
+}
+
+// newStatusTable returns a table written to stdout and sent to systemd if it started the plugin.
+func newStatusTable(log log.T, header []string, rows func() ([][]string, string)) *statusTable {
+	return &statusTable{
+		out:    os.Stdout,
+		redraw: terminal.IsTerminal(int(os.Stdout.Fd())),
+		header: header,
+		rows:   rows,
+		notify: func(states ...string) { notifySystemd(log, states...) },
+	}
+}
+
+// print shows the table if it changed, unless it is redrawn only if its state changed.
+func (s *statusTable) print() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rows, state := s.rows()
+	s.notifyRows(rows)
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(s.header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+
+	if s.redraw {
+		state = table.String()
+	}
+	if state == s.previous {
+		return
+	}
+	s.previous = state
+	if s.redraw && s.printed > 0 {
+		fmt.Fprintf(s.out, "\x1b[%dA\x1b[J", s.printed)
+	}
+	fmt.Fprint(s.out, table.String())
+	s.printed = strings.Count(table.String(), "\n")
+}
+
+// notifyRows sends the rows as a single status line if they changed.
+func (s *statusTable) notifyRows(rows [][]string) {
+	if s.notify == nil {
+		return
+	}
+	status := make([]string, 0, len(rows))
+	for _, row := range rows {
+		status = append(status, strings.Join(row, " "))
+	}
+	line := "STATUS=" + strings.Join(status, "; ")
+	if line == s.notified {
+		return
+	}
+	if s.notified == "" {
+		s.notify("READY=1", line)
+	} else {
+		s.notify(line)
+	}
+	s.notified = line
+}
+
+// tunnelStatus describes the state of a tunnel.
+func tunnelStatus(tunnel *Tunnel) string {
+	select {
+	case <-tunnel.Done():
+		return "closed"
+	default:
+	}
+	status := fmt.Sprintf("open, %d connection(s)", tunnel.Connections())
+	if tunnel.PortFailed() {
+		status += ", connection to destination port failed"
+	}
+	return status
+}
+
+// notifyTerminateSignal returns a channel closed once a signal terminates the sessions of base, and a function
+// to stop listening for signals.
+func notifyTerminateSignal(log log.T, base *session.Session) (terminate chan struct{}, stop func()) {
+	signalMap := base.ControlSignalMap()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, signalMap.Signals()...)
+	terminate = make(chan struct{})
+	go func() {
+		waitForTerminateSignal(log, signals, signalMap)
+		notifySystemd(log, "STOPPING=1")
+		close(terminate)
+	}()
+	return terminate, func() { signal.Stop(signals) }
+}
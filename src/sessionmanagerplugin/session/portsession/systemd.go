@@ -0,0 +1,160 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/session-manager-plugin/src/log"
+)
+
+const (
+	// systemdListenFdsStart is the first file descriptor passed by systemd socket activation
+	systemdListenFdsStart = 3
+)
+
+// activatedListener is a listener passed by systemd socket activation.
+type activatedListener struct {
+	name     string
+	listener net.Listener
+	taken    bool
+}
+
+// newFile returns a file of a descriptor passed by systemd
+var newFile = os.NewFile
+
+var (
+	activatedListenersOnce sync.Once
+	activatedListenersLock sync.Mutex
+	activatedListeners     []*activatedListener
+)
+
+// takeActivatedListener returns a listener passed by systemd socket activation in place of opening one, the one
+// named name with FileDescriptorName if any, otherwise the next one not taken yet, or nil if there is none.
+func takeActivatedListener(log log.T, name string) net.Listener {
+	activatedListenersOnce.Do(func() {
+		activatedListeners = listenersFromEnvironment(log, os.Getenv, systemdListenFdsStart)
+		// like sd_listen_fds, the variables are not passed on to child processes
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	})
+
+	activatedListenersLock.Lock()
+	defer activatedListenersLock.Unlock()
+	var next *activatedListener
+	for _, activated := range activatedListeners {
+		if activated.taken {
+			continue
+		}
+		if name != "" && activated.name == name {
+			next = activated
+			break
+		}
+		if next == nil {
+			next = activated
+		}
+	}
+	if next == nil {
+		return nil
+	}
+	next.taken = true
+	log.Infof("Using listener %s on %s passed by systemd.", next.name, next.listener.Addr())
+	return next.listener
+}
+
+// removeUnixSocket removes the socket file of a unix listener once closed, unless systemd owns it.
+func removeUnixSocket(path string) {
+	activatedListenersLock.Lock()
+	defer activatedListenersLock.Unlock()
+	for _, activated := range activatedListeners {
+		if activated.taken && activated.listener.Addr().String() == path {
+			return
+		}
+	}
+	os.Remove(path)
+}
+
+// activatedPortListener returns the listener passed by systemd for the local end of a port session, the one named
+// after the local port number or unix socket if any, and records the port it listens on.
+func (parameters *PortParameters) activatedPortListener(log log.T) net.Listener {
+	name := parameters.LocalPortNumber
+	if parameters.LocalConnectionType == "unix" {
+		name = parameters.LocalUnixSocket
+	}
+	listener := takeActivatedListener(log, name)
+	if listener == nil {
+		return nil
+	}
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		parameters.LocalPortNumber = strconv.Itoa(addr.Port)
+	}
+	return listener
+}
+
+// listenersFromEnvironment returns the stream listeners passed as LISTEN_FDS file descriptors from first on to
+// the process LISTEN_PID, named by LISTEN_FDNAMES.
+func listenersFromEnvironment(log log.T, getenv func(string) string, first int) (listeners []*activatedListener) {
+	if pid := getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil
+	}
+	names := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("fd%d", first+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := newFile(uintptr(first+i), name)
+		listener, err := net.FileListener(file)
+		// the listener holds a duplicate of the descriptor
+		file.Close()
+		if err != nil {
+			log.Warnf("Ignoring file descriptor %s passed by systemd, not a stream listener: %v", name, err)
+			continue
+		}
+		listeners = append(listeners, &activatedListener{name: name, listener: listener})
+	}
+	return listeners
+}
+
+// notifySystemd sends sd_notify states such as READY=1 to the service manager that started the plugin, if any.
+func notifySystemd(log log.T, states ...string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Warnf("Unable to notify systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		log.Warnf("Unable to notify systemd: %v", err)
+	}
+}
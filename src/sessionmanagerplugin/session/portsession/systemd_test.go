@@ -0,0 +1,113 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package portsession
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenersFromEnvironment(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	file, err := listener.(*net.TCPListener).File()
+	assert.Nil(t, err)
+
+	var descriptors []uintptr
+	defer func() { newFile = os.NewFile }()
+	newFile = func(fd uintptr, name string) *os.File {
+		descriptors = append(descriptors, fd)
+		return file
+	}
+	env := map[string]string{
+		"LISTEN_PID":     strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS":     "1",
+		"LISTEN_FDNAMES": "web",
+	}
+	getenv := func(key string) string { return env[key] }
+
+	listeners := listenersFromEnvironment(mockLog, getenv, systemdListenFdsStart)
+	assert.Equal(t, []uintptr{3}, descriptors)
+	assert.Equal(t, 1, len(listeners))
+	assert.Equal(t, "web", listeners[0].name)
+	assert.Equal(t, listener.Addr().String(), listeners[0].listener.Addr().String())
+	listeners[0].listener.Close()
+
+	// descriptors passed to another process are not used
+	env["LISTEN_PID"] = "1"
+	assert.Empty(t, listenersFromEnvironment(mockLog, getenv, systemdListenFdsStart))
+}
+
+func TestTakeActivatedListener(t *testing.T) {
+	activatedListenersOnce.Do(func() {})
+	first, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer first.Close()
+	second, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer second.Close()
+	defer func() { activatedListeners = nil }()
+	activatedListeners = []*activatedListener{{name: "fd3", listener: first}, {name: "8080", listener: second}}
+
+	// the listener named after the port is taken first, then the others in order
+	parameters := PortParameters{LocalPortNumber: "8080"}
+	assert.Equal(t, second, parameters.activatedPortListener(mockLog))
+	assert.Equal(t, strconv.Itoa(second.Addr().(*net.TCPAddr).Port), parameters.LocalPortNumber)
+	assert.Equal(t, first, takeActivatedListener(mockLog, "8080"))
+	assert.Nil(t, takeActivatedListener(mockLog, ""))
+}
+
+func TestNotifySystemd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	assert.Nil(t, err)
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", path)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	notifySystemd(mockLog, "READY=1", "STATUS=Waiting for connections")
+	buffer := make([]byte, 256)
+	n, err := conn.Read(buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "READY=1\nSTATUS=Waiting for connections", string(buffer[:n]))
+}
+
+func TestStatusTableNotifiesChanges(t *testing.T) {
+	var notified [][]string
+	connections := "0"
+	status := &statusTable{
+		out:    io.Discard,
+		header: []string{"LOCAL", "STATUS"},
+		rows: func() ([][]string, string) {
+			return [][]string{{"localhost:8080", connections + " connection(s)"}}, ""
+		},
+		notify: func(states ...string) { notified = append(notified, states) },
+	}
+
+	status.print()
+	status.print()
+	connections = "1"
+	status.print()
+	assert.Equal(t, [][]string{
+		{"READY=1", "STATUS=localhost:8080 0 connection(s)"},
+		{"STATUS=localhost:8080 1 connection(s)"},
+	}, notified)
+}
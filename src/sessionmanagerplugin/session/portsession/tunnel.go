@@ -0,0 +1,345 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/config"
+	"github.com/aws/session-manager-plugin/src/datachannel"
+	"github.com/aws/session-manager-plugin/src/jsonutil"
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/message"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/aws/session-manager-plugin/src/version"
+	"github.com/xtaci/smux"
+)
+
+// Tunnel forwards local connections over a port forwarding session without taking over the signals, standard
+// streams or exit of the plugin, so that one process runs several of them.
+type Tunnel struct {
+	session    *session.Session
+	keepAlive  *keepAlive
+	accounting *connectionAccounting
+	// mux carries the connections of agents multiplexing them, muxConn is the end of its pipe read by the tunnel
+	mux     *smux.Session
+	muxConn net.Conn
+	// basic is held by the connection forwarded by agents forwarding one connection at a time
+	basic       chan struct{}
+	connMutex   sync.Mutex
+	conn        net.Conn
+	connections int32
+	portFailed  int32
+	// onChange is set before the handlers of the session are registered as they call it
+	onChange  func()
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewTunnel forwards connections over a port forwarding session opened with Connect, closing it at the limits
+// of the session. onChange, if not nil, is called when a connection opens or closes and when the tunnel closes.
+func NewTunnel(log log.T, sess *session.Session, onChange func()) (t *Tunnel, err error) {
+	var portParameters PortParameters
+	if err = jsonutil.Remarshal(sess.SessionProperties, &portParameters); err != nil {
+		return nil, err
+	}
+	if sess.SessionType != config.PortPluginName || portParameters.Type != LocalPortForwardingType {
+		return nil, fmt.Errorf("session %s of type %s does not forward a local port", sess.SessionId, sess.SessionType)
+	}
+
+	t = &Tunnel{
+		session:    sess,
+		accounting: newConnectionAccounting(log, sess.SessionId, sess.ConnectionLog),
+		basic:      make(chan struct{}, 1),
+		onChange:   onChange,
+		done:       make(chan struct{}),
+	}
+	t.keepAlive = newKeepAlive(sess.KeepAliveInterval, func() int64 {
+		sent, _ := sess.DataChannel.GetStreamDataByteCount()
+		return sent
+	}, func() error {
+		return sess.DataChannel.SendInputDataMessage(log, message.Output, []byte{})
+	})
+
+	agentVersion := sess.DataChannel.GetAgentVersion()
+	if version.DoesAgentSupportTCPMultiplexing(log, agentVersion) {
+		muxEnd, tunnelEnd := net.Pipe()
+		smuxConfig := smux.DefaultConfig()
+		if version.DoesAgentSupportDisableSmuxKeepAlive(log, agentVersion) {
+			// Disable smux KeepAlive or else it breaks Session Manager idle timeout.
+			smuxConfig.KeepAliveDisabled = true
+		}
+		if t.mux, err = smux.Client(muxEnd, smuxConfig); err != nil {
+			return nil, err
+		}
+		t.muxConn = tunnelEnd
+		// the smux frames in the data channel include keep-alives of older agents, only stream data is activity
+		sess.DataChannel.TrackStreamActivity()
+		go t.transferMuxData(log)
+	}
+
+	sess.StopHandler = func() { t.stop(log, false) }
+	sess.DataChannel.RegisterOutputStreamHandler(t.processStreamMessage, true)
+	sess.WatchSessionLimits(log, t.done, func(code int) {
+		session.SetExitCode(code)
+		t.Close(log)
+	})
+	return t, nil
+}
+
+// SessionId returns the id of the session of the tunnel.
+func (t *Tunnel) SessionId() string {
+	return t.session.SessionId
+}
+
+// Connections returns the number of connections forwarded or waiting to be forwarded.
+func (t *Tunnel) Connections() int {
+	return int(atomic.LoadInt32(&t.connections))
+}
+
+// PortFailed returns whether the agent failed to connect to the destination port.
+func (t *Tunnel) PortFailed() bool {
+	return atomic.LoadInt32(&t.portFailed) == 1
+}
+
+// Done is closed once the tunnel closed.
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.done
+}
+
+// Multiplexed returns whether the agent forwards the connections of the tunnel concurrently.
+func (t *Tunnel) Multiplexed() bool {
+	return t.mux != nil
+}
+
+// Dial returns a connection forwarded over the tunnel to its destination port.
+func (t *Tunnel) Dial(log log.T) net.Conn {
+	local, forwarded := net.Pipe()
+	go t.Forward(log, forwarded)
+	return local
+}
+
+// Serve forwards the connections accepted on listener until the tunnel closes, the listener is closed with it.
+func (t *Tunnel) Serve(log log.T, listener net.Listener) error {
+	go func() {
+		<-t.done
+		listener.Close()
+	}()
+	return acceptConnections(log, listener, t.done, func(conn net.Conn) {
+		t.Forward(log, conn)
+	})
+}
+
+// acceptConnections handles every connection accepted on listener in a goroutine of its own, retrying temporary
+// errors. It returns nil once stop is closed and the listener with it, or the error accepting failed with.
+func acceptConnections(log log.T, listener net.Listener, stop <-chan struct{}, handle func(conn net.Conn)) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				log.Warnf("Failed to accept connection, retrying: %v", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go handle(conn)
+	}
+}
+
+// Forward forwards conn until either end closes it. Agents forwarding one connection at a time forward the
+// connections of the tunnel in turn.
+func (t *Tunnel) Forward(log log.T, conn net.Conn) {
+	t.forward(log, conn, nil)
+}
+
+// forward forwards conn as Forward does. reply, if set, is called before conn is closed with whether conn is
+// forwarded, once it is and before any data, conn is not forwarded if reply fails.
+func (t *Tunnel) forward(log log.T, conn net.Conn, reply func(forwarded bool) error) {
+	// connections accepted by an accounted listener are recorded by its accounting
+	if _, accounted := conn.(*accountedConn); !accounted {
+		conn = t.accounting.accept(conn)
+	}
+	defer conn.Close()
+	replyTo := func(forwarded bool) error {
+		if reply == nil {
+			return nil
+		}
+		return reply(forwarded)
+	}
+	t.connectionCount(1)
+	defer t.connectionCount(-1)
+
+	log.Infof("Connection accepted from %s for session %s.", conn.RemoteAddr(), t.SessionId())
+	if t.mux != nil {
+		stream, err := t.mux.OpenStream()
+		if err != nil {
+			log.Errorf("Failed to open stream for connection from %s: %v", conn.RemoteAddr(), err)
+			replyTo(false)
+			closeConnection(conn, fmt.Sprintf("unable to open stream: %v", err))
+			return
+		}
+		if accounted, ok := conn.(*accountedConn); ok {
+			accounted.setStreamId(stream.ID())
+		}
+		if err = replyTo(true); err != nil {
+			stream.Close()
+			return
+		}
+		t.keepAlive.connectionOpened(log)
+		handleDataTransfer(newActivityStream(stream, t.session.DataChannel.RecordStreamActivity), conn)
+		t.keepAlive.connectionClosed(log)
+		return
+	}
+
+	select {
+	case t.basic <- struct{}{}:
+	case <-t.done:
+		replyTo(false)
+		return
+	}
+	defer func() { <-t.basic }()
+	if err := replyTo(true); err != nil {
+		return
+	}
+
+	t.setConn(conn)
+	t.keepAlive.connectionOpened(log)
+	err := sendStreamData(log, t.session.DataChannel, conn)
+	log.Debugf("Connection forwarded in session %s closed: %v", t.SessionId(), err)
+	t.keepAlive.connectionClosed(log)
+	t.setConn(nil)
+
+	select {
+	case <-t.done:
+	default:
+		// the agent closes its connection to the destination port too
+		if err := t.session.DataChannel.SendFlag(log, message.DisconnectToPort); err != nil {
+			log.Errorf("Failed to send DisconnectToPort flag: %v", err)
+		}
+	}
+}
+
+// Close ends the session of the tunnel and the connections forwarded over it.
+func (t *Tunnel) Close(log log.T) {
+	t.stop(log, true)
+}
+
+// stop closes the tunnel, ending its session unless the agent closed it.
+func (t *Tunnel) stop(log log.T, endSession bool) {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		t.session.AuditSessionEnd(log, "session closed")
+		t.accounting.finish()
+		if endSession {
+			t.session.EndSession(log)
+		}
+		t.session.DataChannel.Close(log)
+		if t.mux != nil {
+			t.mux.Close()
+			t.muxConn.Close()
+		}
+		t.setConn(nil)
+		log.Infof("Tunnel of session %s closed.", t.SessionId())
+		t.changed()
+	})
+}
+
+// processStreamMessage writes the output of the session to the connection it belongs to.
+func (t *Tunnel) processStreamMessage(log log.T, outputMessage message.ClientMessage) (isHandlerReady bool, err error) {
+	switch message.PayloadType(outputMessage.PayloadType) {
+	case message.Output:
+		if t.mux != nil {
+			if _, err = t.muxConn.Write(outputMessage.Payload); err != nil {
+				log.Debugf("Dropping output of closed tunnel of session %s: %v", t.SessionId(), err)
+			}
+			return true, nil
+		}
+		t.connMutex.Lock()
+		conn := t.conn
+		t.connMutex.Unlock()
+		if conn != nil {
+			conn.Write(outputMessage.Payload)
+		}
+	case message.Flag:
+		var flag message.PayloadTypeFlag
+		binary.Read(bytes.NewBuffer(outputMessage.Payload), binary.BigEndian, &flag)
+		if message.ConnectToPortError == flag {
+			log.Warnf("Connection to destination port failed for session %s, check SSM Agent logs.", t.SessionId())
+			atomic.StoreInt32(&t.portFailed, 1)
+			t.changed()
+		}
+	}
+	return true, nil
+}
+
+// transferMuxData sends the data written by the smux client on the data channel.
+func (t *Tunnel) transferMuxData(log log.T) {
+	err := sendStreamData(log, t.session.DataChannel, t.muxConn)
+	log.Debugf("Reading from mux client of session %s stopped: %v", t.SessionId(), err)
+}
+
+// sendStreamData sends the data read from reader as output on the data channel until reading or sending
+// fails, and returns the error.
+func sendStreamData(log log.T, dataChannel datachannel.IDataChannel, reader io.Reader) error {
+	msg := make([]byte, config.StreamDataPayloadSize)
+	for {
+		numBytes, err := reader.Read(msg)
+		if err != nil {
+			return err
+		}
+		if err = dataChannel.SendInputDataMessage(log, message.Output, msg[:numBytes]); err != nil {
+			log.Errorf("Failed to send packet on data channel: %v", err)
+			return err
+		}
+		// sleep to process more data
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// setConn sets the connection the output of a basic agent is written to, closing the previous one.
+func (t *Tunnel) setConn(conn net.Conn) {
+	t.connMutex.Lock()
+	defer t.connMutex.Unlock()
+	if t.conn != nil && conn == nil {
+		t.conn.Close()
+	}
+	t.conn = conn
+}
+
+// connectionCount adds delta to the number of connections.
+func (t *Tunnel) connectionCount(delta int32) {
+	atomic.AddInt32(&t.connections, delta)
+	t.changed()
+}
+
+// changed calls onChange if set.
+func (t *Tunnel) changed() {
+	if t.onChange != nil {
+		t.onChange()
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/config"
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/message"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTunnelRequiresLocalPortForwarding(t *testing.T) {
+	mockSession := getSessionMock()
+	mockSession.SessionType = config.PortPluginName
+
+	_, err := NewTunnel(mockLog, &mockSession, nil)
+	assert.NotNil(t, err)
+}
+
+func TestTunnelForwardsConnectionsInTurn(t *testing.T) {
+	logger := log.NewMockLog()
+	mockSession := getSessionMockWithParams(map[string]interface{}{"type": LocalPortForwardingType}, agentVersion)
+	mockSession.DataChannel.SetWsChannel(testWebSocketChannel{})
+	mockSession.SessionType = config.PortPluginName
+	tunnel, err := NewTunnel(logger, &mockSession, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, tunnel.mux)
+
+	first, firstRemote := net.Pipe()
+	second, secondRemote := net.Pipe()
+	for _, conn := range []net.Conn{first, second} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	}
+	go tunnel.Forward(logger, firstRemote)
+	go tunnel.Forward(logger, secondRemote)
+	waitUntil(t, func() bool { return tunnel.Connections() == 2 })
+
+	// the output of the session goes to the connection forwarded first
+	var current, waiting, waitingRemote net.Conn = first, second, secondRemote
+	waitUntil(t, func() bool { return forwardedConn(tunnel) != nil })
+	if forwardedConn(tunnel) == secondRemote {
+		current, waiting, waitingRemote = second, first, firstRemote
+	}
+	go tunnel.processStreamMessage(logger, outputMessage)
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(current, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "testing123", string(buf))
+
+	// the waiting connection is forwarded once the first closed
+	current.Close()
+	waitUntil(t, func() bool { return tunnel.Connections() == 1 })
+	waitUntil(t, func() bool { return forwardedConn(tunnel) == waitingRemote })
+	go tunnel.processStreamMessage(logger, outputMessage)
+	_, err = io.ReadFull(waiting, buf)
+	assert.Nil(t, err)
+
+	// the agent closing the session closes the tunnel and its connections
+	mockSession.Stop()
+	<-tunnel.Done()
+	waitUntil(t, func() bool { return tunnel.Connections() == 0 })
+	assert.Contains(t, tunnel.accounting.summary(), "Forwarded 2 connection(s)")
+}
+
+func TestTunnelRecordsPortFailure(t *testing.T) {
+	tunnel := &Tunnel{session: &session.Session{SessionId: "sessionId"}, done: make(chan struct{})}
+	flag := message.ClientMessage{
+		PayloadType: uint32(message.Flag),
+		Payload:     []byte{0, 0, 0, byte(message.ConnectToPortError)},
+	}
+	ready, err := tunnel.processStreamMessage(mockLog, flag)
+	assert.True(t, ready)
+	assert.Nil(t, err)
+	assert.True(t, tunnel.PortFailed())
+}
+
+// testWebSocketChannel accepts every call without recording it. Unlike the shared mock it does not format the
+// log passed to it, which goroutines of the test write to concurrently.
+type testWebSocketChannel struct{}
+
+func (testWebSocketChannel) Initialize(log log.T, channelUrl string, channelToken string) {}
+func (testWebSocketChannel) Open(log log.T) error                                         { return nil }
+func (testWebSocketChannel) Close(log log.T) error                                        { return nil }
+func (testWebSocketChannel) SendMessage(log log.T, input []byte, inputType int) error     { return nil }
+func (testWebSocketChannel) StartPings(log log.T, pingInterval time.Duration)             {}
+func (testWebSocketChannel) GetChannelToken() string                                      { return "" }
+func (testWebSocketChannel) GetStreamUrl() string                                         { return "streamUrl" }
+func (testWebSocketChannel) SetChannelToken(string)                                       {}
+func (testWebSocketChannel) SetOnError(onErrorHandler func(error))                        {}
+func (testWebSocketChannel) SetOnMessage(onMessageHandler func([]byte))                   {}
+
+// getTunnelSessionMock returns a session mock with a web socket channel of its own that accepts the calls of
+// ending and closing the session.
+func getTunnelSessionMock() session.Session {
+	mockSession := getSessionMock()
+	mockSession.DataChannel.SetWsChannel(testWebSocketChannel{})
+	return mockSession
+}
+
+// forwardedConn returns the connection forwarded by a basic tunnel, without its accounting.
+func forwardedConn(tunnel *Tunnel) net.Conn {
+	tunnel.connMutex.Lock()
+	defer tunnel.connMutex.Unlock()
+	if accounted, ok := tunnel.conn.(*accountedConn); ok {
+		return accounted.Conn
+	}
+	return tunnel.conn
+}
+
+// waitUntil fails the test unless condition becomes true within a second.
+func waitUntil(t *testing.T, condition func() bool) {
+	for deadline := time.Now().Add(time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/aws/session-manager-plugin/src/yamlutil"
+)
+
+const (
+	// TunnelRestartDelay is the delay before a closed or failed tunnel is restarted, doubled after every restart
+	TunnelRestartDelay = time.Second
+	// TunnelRestartMaxDelay is the longest delay before a tunnel is restarted
+	TunnelRestartMaxDelay = time.Minute
+	// tunnelStableAfter is how long a tunnel has to stay open for its restart delay to be reset
+	tunnelStableAfter = time.Minute
+)
+
+// TunnelsFile lists the tunnels started together by ssmcli tunnels up.
+type TunnelsFile struct {
+	Tunnels []TunnelDefinition `json:"tunnels"`
+}
+
+// TunnelDefinition is a named tunnel of a tunnels file. The document defaults to the port forwarding
+// document chosen by the remote host, profile and region to the ones of the command.
+type TunnelDefinition struct {
+	Name            string     `json:"name"`
+	Target          string     `json:"target"`
+	Document        string     `json:"document"`
+	RemoteHost      string     `json:"remoteHost"`
+	RemotePort      PortNumber `json:"remotePort"`
+	LocalPort       PortNumber `json:"localPort"`
+	LocalUnixSocket string     `json:"localUnixSocket"`
+	Profile         string     `json:"profile"`
+	Region          string     `json:"region"`
+}
+
+// PortNumber is a port number written as a number or a string.
+type PortNumber string
+
+// UnmarshalJSON accepts port numbers as numbers and strings.
+func (p *PortNumber) UnmarshalJSON(data []byte) error {
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return fmt.Errorf("invalid port number %s", data)
+	}
+	*p = PortNumber(number)
+	return nil
+}
+
+// LoadTunnelDefinitions reads and validates a tunnels file, a JSON document if its name ends with .json or its
+// content starts with {, YAML otherwise.
+func LoadTunnelDefinitions(path string) ([]TunnelDefinition, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var document interface{}
+	if strings.EqualFold(filepath.Ext(path), ".json") || strings.HasPrefix(strings.TrimSpace(string(content)), "{") {
+		err = json.Unmarshal(content, &document)
+	} else {
+		document, err = yamlutil.Parse(string(content))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid tunnels file %s: %v", path, err)
+	}
+
+	// unknown keys are rejected so that misspelled settings are not ignored
+	var file TunnelsFile
+	encoded, _ := json.Marshal(document)
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid tunnels file %s: %v", path, err)
+	}
+	if len(file.Tunnels) == 0 {
+		return nil, fmt.Errorf("tunnels file %s defines no tunnels", path)
+	}
+
+	names := make(map[string]bool)
+	for _, definition := range file.Tunnels {
+		if err = definition.validate(); err != nil {
+			return nil, err
+		}
+		if names[definition.Name] {
+			return nil, fmt.Errorf("tunnel %s is defined more than once", definition.Name)
+		}
+		names[definition.Name] = true
+	}
+	return file.Tunnels, nil
+}
+
+// validate checks the settings of a tunnel.
+func (d TunnelDefinition) validate() error {
+	switch {
+	case d.Name == "":
+		return errors.New("every tunnel needs a name")
+	case d.Target == "":
+		return fmt.Errorf("tunnel %s has no target", d.Name)
+	case !isPortNumber(string(d.RemotePort), false):
+		return fmt.Errorf("tunnel %s has an invalid remotePort %q", d.Name, d.RemotePort)
+	case d.LocalPort != "" && d.LocalUnixSocket != "":
+		return fmt.Errorf("tunnel %s sets both localPort and localUnixSocket", d.Name)
+	case d.LocalPort != "" && !isPortNumber(string(d.LocalPort), true):
+		return fmt.Errorf("tunnel %s has an invalid localPort %q", d.Name, d.LocalPort)
+	}
+	return nil
+}
+
+// mapping returns the port mapping of the tunnel.
+func (d TunnelDefinition) mapping() PortMapping {
+	mapping := PortMapping{
+		LocalPortNumber: string(d.LocalPort),
+		LocalUnixSocket: d.LocalUnixSocket,
+		Target:          d.Target,
+		Document:        d.Document,
+		Host:            d.RemoteHost,
+		PortNumber:      string(d.RemotePort),
+	}
+	if mapping.LocalPortNumber == "" && mapping.LocalUnixSocket == "" {
+		mapping.LocalPortNumber = "0"
+	}
+	return mapping
+}
+
+// supervisedTunnel keeps the tunnel of a definition open, restarting it with backoff once it closed or failed.
+// Connections accepted while the tunnel restarts wait for the next one.
+type supervisedTunnel struct {
+	definition TunnelDefinition
+	base       *session.Session
+	listener   net.Listener
+	local      string
+	onChange   func()
+	mutex      sync.Mutex
+	tunnel     *Tunnel
+	state      string
+	restarts   int
+	// changed is closed and replaced whenever the tunnel or its state changes
+	changed chan struct{}
+}
+
+// setState records the current tunnel, nil while none is open, and the state shown for it.
+func (s *supervisedTunnel) setState(tunnel *Tunnel, state string) {
+	s.mutex.Lock()
+	s.tunnel = tunnel
+	s.state = state
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mutex.Unlock()
+	s.onChange()
+}
+
+// row returns the status table row of the tunnel and its state without connection counts.
+func (s *supervisedTunnel) row() (row []string, state string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	mapping := s.definition.mapping()
+	sessionId, status := "-", s.state
+	if s.tunnel != nil {
+		sessionId = s.tunnel.SessionId()
+		status = tunnelStatus(s.tunnel)
+	}
+	restarts := strconv.Itoa(s.restarts)
+	return []string{s.definition.Name, s.local, mapping.remote(s.definition.Target), sessionId, restarts, status},
+		strings.Join([]string{s.definition.Name, sessionId, restarts, s.state}, " ")
+}
+
+// run starts the tunnel and restarts it until stop is closed.
+func (s *supervisedTunnel) run(log log.T, stop chan struct{}) {
+	delay := TunnelRestartDelay
+	for {
+		s.setState(nil, "starting")
+		tunnel, err := startTunnel(log, s.base, s.definition.Target, s.definition.mapping(), s.onChange)
+		if err == nil {
+			s.setState(tunnel, "open")
+			log.Infof("Tunnel %s forwards %s in session %s.", s.definition.Name, s.local, tunnel.SessionId())
+
+			opened := time.Now()
+			select {
+			case <-tunnel.Done():
+			case <-stop:
+				tunnel.Close(log)
+				return
+			}
+			if time.Since(opened) >= tunnelStableAfter {
+				delay = TunnelRestartDelay
+			}
+			err = fmt.Errorf("session %s closed", tunnel.SessionId())
+		}
+
+		log.Warnf("Tunnel %s: %v, restarting in %v.", s.definition.Name, err, delay)
+		s.setState(nil, fmt.Sprintf("%v, restarting in %v", err, delay))
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return
+		}
+		s.mutex.Lock()
+		s.restarts++
+		s.mutex.Unlock()
+		if delay *= 2; delay > TunnelRestartMaxDelay {
+			delay = TunnelRestartMaxDelay
+		}
+	}
+}
+
+// serve forwards the connections accepted on the listener over the current tunnel until stop is closed.
+func (s *supervisedTunnel) serve(log log.T, stop chan struct{}) {
+	err := acceptConnections(log, s.listener, stop, func(conn net.Conn) {
+		if tunnel := s.waitForTunnel(stop); tunnel != nil {
+			tunnel.Forward(log, conn)
+		} else {
+			conn.Close()
+		}
+	})
+	if err != nil {
+		log.Errorf("Tunnel %s stopped accepting connections on %s: %v", s.definition.Name, s.local, err)
+	}
+}
+
+// waitForTunnel returns the open tunnel, waiting while it restarts, or nil once stop is closed.
+func (s *supervisedTunnel) waitForTunnel(stop chan struct{}) *Tunnel {
+	for {
+		s.mutex.Lock()
+		tunnel, changed := s.tunnel, s.changed
+		s.mutex.Unlock()
+		if tunnel != nil {
+			select {
+			case <-tunnel.Done():
+			default:
+				return tunnel
+			}
+		}
+		select {
+		case <-changed:
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+// RunTunnelDefinitions keeps the tunnels open with the settings of base, restarting them with backoff, shows
+// their state and closes all of them on a terminate signal.
+func RunTunnelDefinitions(log log.T, base *session.Session, definitions []TunnelDefinition) (err error) {
+	var tunnels []*supervisedTunnel
+	defer func() {
+		for _, s := range tunnels {
+			s.listener.Close()
+			if s.definition.LocalUnixSocket != "" {
+				removeUnixSocket(s.definition.LocalUnixSocket)
+			}
+		}
+	}()
+
+	status := newStatusTable(log, []string{"NAME", "LOCAL", "REMOTE", "SESSION", "RESTARTS", "STATUS"}, func() (rows [][]string, state string) {
+		var states []string
+		for _, s := range tunnels {
+			row, rowState := s.row()
+			rows = append(rows, row)
+			states = append(states, rowState)
+		}
+		return rows, strings.Join(states, "\n")
+	})
+
+	// listeners are opened first, so that the local ports stay the same across restarts
+	for _, definition := range definitions {
+		tunnelBase := *base
+		if definition.Profile != "" {
+			tunnelBase.Profile = definition.Profile
+		}
+		if definition.Region != "" {
+			tunnelBase.Region = definition.Region
+		}
+		s := &supervisedTunnel{
+			definition: definition,
+			base:       &tunnelBase,
+			onChange:   status.print,
+			state:      "starting",
+			changed:    make(chan struct{}),
+		}
+		if s.listener, s.local, err = definition.mapping().listen(log, &tunnelBase); err != nil {
+			return fmt.Errorf("unable to listen for tunnel %s: %v", definition.Name, err)
+		}
+		tunnels = append(tunnels, s)
+	}
+
+	terminate, stopSignals := notifyTerminateSignal(log, base)
+	defer stopSignals()
+
+	stop := make(chan struct{})
+	var wait sync.WaitGroup
+	for _, s := range tunnels {
+		wait.Add(1)
+		go func(s *supervisedTunnel) {
+			defer wait.Done()
+			s.run(log, stop)
+		}(s)
+		go s.serve(log, stop)
+	}
+	status.print()
+
+	<-terminate
+	fmt.Println("Terminate signal received, closing all tunnels.")
+	close(stop)
+	for _, s := range tunnels {
+		s.listener.Close()
+	}
+	wait.Wait()
+	fmt.Println("All tunnels closed.")
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTunnelsFile(t *testing.T, name string, content string) string {
+	dir, err := ioutil.TempDir("", "tunnels")
+	assert.Nil(t, err)
+	path := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadTunnelDefinitions(t *testing.T) {
+	path := writeTunnelsFile(t, "tunnels.yaml", `tunnels:
+  - name: orders-db
+    target: i-123456
+    remoteHost: orders.cluster.internal
+    remotePort: 5432
+    localPort: 15432
+    profile: prod
+    region: eu-west-1
+  - name: grafana
+    target: i-789012
+    remotePort: "3000"
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	definitions, err := LoadTunnelDefinitions(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []TunnelDefinition{
+		{Name: "orders-db", Target: "i-123456", RemoteHost: "orders.cluster.internal", RemotePort: "5432", LocalPort: "15432", Profile: "prod", Region: "eu-west-1"},
+		{Name: "grafana", Target: "i-789012", RemotePort: "3000"},
+	}, definitions)
+	assert.Equal(t, RemoteHostPortForwardingDocument, definitions[0].mapping().DocumentName())
+	assert.Equal(t, PortMapping{LocalPortNumber: "0", Target: "i-789012", PortNumber: "3000"}, definitions[1].mapping())
+
+	path = writeTunnelsFile(t, "tunnels.json", `{"tunnels": [{"name": "web", "target": "i-123456", "document": "Custom-PortForwarding", "remotePort": 80}]}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	definitions, err = LoadTunnelDefinitions(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "Custom-PortForwarding", definitions[0].mapping().DocumentName())
+}
+
+func TestLoadTunnelDefinitionsInvalid(t *testing.T) {
+	for content, message := range map[string]string{
+		"tunnels: []": "defines no tunnels",
+		"tunnels:\n  - name: a\n    remotePort: 80":                                                                    "tunnel a has no target",
+		"tunnels:\n  - name: a\n    target: i-1\n    remotePort: 0":                                                    "invalid remotePort",
+		"tunnels:\n  - name: a\n    target: i-1\n    remotePort: 80\n    localAddress: 127.0.0.2":                      "unknown field",
+		"tunnels:\n  - name: a\n    target: i-1\n    remotePort: 80\n  - name: a\n    target: i-2\n    remotePort: 80": "defined more than once",
+	} {
+		path := writeTunnelsFile(t, "tunnels.yml", content)
+		_, err := LoadTunnelDefinitions(path)
+		os.RemoveAll(filepath.Dir(path))
+		if assert.NotNil(t, err, content) {
+			assert.Contains(t, err.Error(), message)
+		}
+	}
+}
+
+func TestSupervisedTunnelRestartsFailedTunnel(t *testing.T) {
+	logger := log.NewMockLog()
+	mockSession := getTunnelSessionMock()
+	mockSession.SessionId = "sessionId"
+	opened := &Tunnel{session: &mockSession, accounting: newConnectionAccounting(logger, "", ""), basic: make(chan struct{}, 1), done: make(chan struct{})}
+
+	defer func(original func(log.T, *session.Session, string, PortMapping, func()) (*Tunnel, error)) {
+		startTunnel = original
+	}(startTunnel)
+	attempts := 0
+	startTunnel = func(log log.T, base *session.Session, target string, mapping PortMapping, onChange func()) (*Tunnel, error) {
+		assert.Equal(t, "i-123456", target)
+		if attempts++; attempts == 1 {
+			return nil, errors.New("target not connected")
+		}
+		return opened, nil
+	}
+
+	s := &supervisedTunnel{
+		definition: TunnelDefinition{Name: "web", Target: "i-123456", RemotePort: "80"},
+		local:      "localhost:8080",
+		onChange:   func() {},
+		changed:    make(chan struct{}),
+	}
+	stop := make(chan struct{})
+	ran := make(chan struct{})
+	go func() {
+		defer close(ran)
+		s.run(logger, stop)
+	}()
+
+	// connections wait for the tunnel while it restarts
+	assert.Equal(t, opened, s.waitForTunnel(stop))
+	row, _ := s.row()
+	assert.Equal(t, []string{"web", "localhost:8080", "i-123456 port 80", "sessionId", "1", "open, 0 connection(s)"}, row)
+
+	close(stop)
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("tunnel not closed on stop")
+	}
+	select {
+	case <-opened.Done():
+	default:
+		assert.Fail(t, "tunnel not closed on stop")
+	}
+	assert.Nil(t, s.waitForTunnel(stop))
+}
@@ -0,0 +1,341 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package portsession starts port session.
+package portsession
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+)
+
+const (
+	// UDPFlowIdleTimeout is how long a UDP client may send and receive nothing before its flow is closed
+	UDPFlowIdleTimeout = time.Minute
+	// udpFrameHeaderSize is the size of the big-endian length prefix of the datagrams of a flow
+	udpFrameHeaderSize = 2
+	// udpMaxDatagramSize is the largest datagram a frame carries
+	udpMaxDatagramSize = 65535
+	// udpFlowQueueSize is how many datagrams of a flow wait to be forwarded before further ones are dropped
+	udpFlowQueueSize = 64
+)
+
+// udpListener accepts the datagrams of every UDP client as a flow, a connection carrying them framed with a
+// length prefix, so that flows are forwarded like TCP connections to a relay on the other end.
+type udpListener struct {
+	conn        net.PacketConn
+	idleTimeout time.Duration
+	accepted    chan *udpFlow
+	mutex       sync.Mutex
+	flows       map[string]*udpFlow
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// listenUDP listens for UDP clients on address.
+func listenUDP(address string, idleTimeout time.Duration) (*udpListener, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	l := &udpListener{
+		conn:        conn,
+		idleTimeout: idleTimeout,
+		accepted:    make(chan *udpFlow),
+		flows:       make(map[string]*udpFlow),
+		done:        make(chan struct{}),
+	}
+	go l.receive()
+	go l.expire()
+	return l, nil
+}
+
+// receive passes the datagrams received on to the flows of their clients, starting a flow for new clients.
+func (l *udpListener) receive() {
+	buffer := make([]byte, udpMaxDatagramSize)
+	for {
+		n, addr, err := l.conn.ReadFrom(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			l.Close()
+			return
+		}
+		datagram := append([]byte(nil), buffer[:n]...)
+
+		l.mutex.Lock()
+		flow, ok := l.flows[addr.String()]
+		if !ok {
+			flow = newUDPFlow(l, addr)
+			l.flows[addr.String()] = flow
+		}
+		l.mutex.Unlock()
+
+		if !ok {
+			select {
+			case l.accepted <- flow:
+			case <-l.done:
+				return
+			}
+		}
+		flow.deliver(datagram)
+	}
+}
+
+// expire closes the flows that were idle for the idle timeout.
+func (l *udpListener) expire() {
+	ticker := time.NewTicker(l.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.done:
+			return
+		}
+		l.mutex.Lock()
+		var idle []*udpFlow
+		for _, flow := range l.flows {
+			if flow.idleSince() >= l.idleTimeout {
+				idle = append(idle, flow)
+			}
+		}
+		l.mutex.Unlock()
+		for _, flow := range idle {
+			flow.Close()
+		}
+	}
+}
+
+// Accept returns the flow of the next new client.
+func (l *udpListener) Accept() (net.Conn, error) {
+	select {
+	case flow := <-l.accepted:
+		return flow, nil
+	case <-l.done:
+		return nil, errors.New("listener closed")
+	}
+}
+
+// Close stops receiving datagrams and closes all flows.
+func (l *udpListener) Close() (err error) {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.conn.Close()
+		l.mutex.Lock()
+		flows := make([]*udpFlow, 0, len(l.flows))
+		for _, flow := range l.flows {
+			flows = append(flows, flow)
+		}
+		l.mutex.Unlock()
+		for _, flow := range flows {
+			flow.Close()
+		}
+	})
+	return
+}
+
+// Addr returns the local address of the listener.
+func (l *udpListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// udpFlow is the connection of a UDP client. Reading returns its datagrams framed with a length prefix,
+// frames written are sent to the client as datagrams.
+type udpFlow struct {
+	listener   *udpListener
+	addr       net.Addr
+	incoming   chan []byte
+	pending    []byte
+	written    []byte
+	mutex      sync.Mutex
+	lastActive time.Time
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// newUDPFlow returns the flow of the client at addr.
+func newUDPFlow(listener *udpListener, addr net.Addr) *udpFlow {
+	return &udpFlow{
+		listener:   listener,
+		addr:       addr,
+		incoming:   make(chan []byte, udpFlowQueueSize),
+		lastActive: time.Now(),
+		done:       make(chan struct{}),
+	}
+}
+
+// deliver queues a datagram of the client, dropping it if the queue is full as the network would.
+func (f *udpFlow) deliver(datagram []byte) {
+	f.touch()
+	select {
+	case f.incoming <- datagram:
+	default:
+	}
+}
+
+// touch records activity of the flow.
+func (f *udpFlow) touch() {
+	f.mutex.Lock()
+	f.lastActive = time.Now()
+	f.mutex.Unlock()
+}
+
+// idleSince returns how long the flow has been idle.
+func (f *udpFlow) idleSince() time.Duration {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return time.Since(f.lastActive)
+}
+
+// Read returns the next datagrams of the client framed with their length, io.EOF once the flow is closed.
+func (f *udpFlow) Read(b []byte) (int, error) {
+	if len(f.pending) == 0 {
+		select {
+		case datagram := <-f.incoming:
+			f.pending = appendUDPFrame(nil, datagram)
+		case <-f.done:
+			return 0, io.EOF
+		}
+	}
+	n := copy(b, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// Write sends the complete frames written so far to the client as datagrams.
+func (f *udpFlow) Write(b []byte) (int, error) {
+	select {
+	case <-f.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	f.touch()
+	f.written = append(f.written, b...)
+	for len(f.written) >= udpFrameHeaderSize {
+		size := udpFrameHeaderSize + int(binary.BigEndian.Uint16(f.written))
+		if len(f.written) < size {
+			break
+		}
+		if _, err := f.listener.conn.WriteTo(f.written[udpFrameHeaderSize:size], f.addr); err != nil {
+			return 0, err
+		}
+		f.written = f.written[size:]
+	}
+	return len(b), nil
+}
+
+// Close ends the flow, the next datagram of the client starts a new one.
+func (f *udpFlow) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.done)
+		f.listener.mutex.Lock()
+		if f.listener.flows[f.addr.String()] == f {
+			delete(f.listener.flows, f.addr.String())
+		}
+		f.listener.mutex.Unlock()
+	})
+	return nil
+}
+
+// LocalAddr returns the address of the listener.
+func (f *udpFlow) LocalAddr() net.Addr { return f.listener.Addr() }
+
+// RemoteAddr returns the address of the client.
+func (f *udpFlow) RemoteAddr() net.Addr { return f.addr }
+
+// SetDeadline is not supported, flows end once idle.
+func (f *udpFlow) SetDeadline(t time.Time) error { return nil }
+
+// SetReadDeadline is not supported, flows end once idle.
+func (f *udpFlow) SetReadDeadline(t time.Time) error { return nil }
+
+// SetWriteDeadline is not supported, flows end once idle.
+func (f *udpFlow) SetWriteDeadline(t time.Time) error { return nil }
+
+// appendUDPFrame appends datagram prefixed with its length to frames.
+func appendUDPFrame(frames []byte, datagram []byte) []byte {
+	header := make([]byte, udpFrameHeaderSize)
+	binary.BigEndian.PutUint16(header, uint16(len(datagram)))
+	return append(append(frames, header...), datagram...)
+}
+
+// ServeUDPRelay is the other end of UDP port mappings. It sends the datagrams framed on every connection accepted
+// on listener to destination from a UDP socket of its own, and frames the datagrams received back on it.
+func ServeUDPRelay(log log.T, listener net.Listener, destination string) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go relayUDP(log, conn, destination)
+	}
+}
+
+// relayUDP relays the datagrams of one flow until either end closes or the flow was idle for UDPFlowIdleTimeout.
+func relayUDP(log log.T, conn net.Conn, destination string) {
+	defer conn.Close()
+	udpConn, err := net.Dial("udp", destination)
+	if err != nil {
+		log.Errorf("Unable to relay datagrams of %s to %s: %v", conn.RemoteAddr(), destination, err)
+		return
+	}
+	defer udpConn.Close()
+	log.Infof("Relaying datagrams of %s to %s.", conn.RemoteAddr(), destination)
+
+	// the flow is idle once no datagram was relayed in either direction
+	lastActive := time.Now().UnixNano()
+	touch := func() { atomic.StoreInt64(&lastActive, time.Now().UnixNano()) }
+	go func() {
+		defer conn.Close()
+		buffer := make([]byte, udpMaxDatagramSize)
+		for {
+			udpConn.SetReadDeadline(time.Unix(0, atomic.LoadInt64(&lastActive)).Add(UDPFlowIdleTimeout))
+			n, err := udpConn.Read(buffer)
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() &&
+				time.Since(time.Unix(0, atomic.LoadInt64(&lastActive))) < UDPFlowIdleTimeout {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			touch()
+			if _, err = conn.Write(appendUDPFrame(nil, buffer[:n])); err != nil {
+				return
+			}
+		}
+	}()
+
+	header := make([]byte, udpFrameHeaderSize)
+	datagram := make([]byte, udpMaxDatagramSize)
+	for {
+		if _, err = io.ReadFull(conn, header); err != nil {
+			return
+		}
+		size := int(binary.BigEndian.Uint16(header))
+		if _, err = io.ReadFull(conn, datagram[:size]); err != nil {
+			return
+		}
+		touch()
+		if _, err = udpConn.Write(datagram[:size]); err != nil {
+			log.Debugf("Unable to relay datagram to %s: %v", destination, err)
+		}
+	}
+}
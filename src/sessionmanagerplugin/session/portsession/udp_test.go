@@ -0,0 +1,126 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package portsession
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUDPFlowsThroughRelay(t *testing.T) {
+	// the relay goroutines log while the other tests run, they get a log of their own
+	logger := log.NewMockLog()
+
+	// echo server standing in for the destination of the relay
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	echoDone := make(chan struct{})
+	go func() {
+		defer close(echoDone)
+		buffer := make([]byte, udpMaxDatagramSize)
+		for {
+			n, addr, err := echo.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(append([]byte("echo "), buffer[:n]...), addr)
+		}
+	}()
+
+	relay, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		ServeUDPRelay(logger, relay, echo.LocalAddr().String())
+	}()
+
+	// every flow is forwarded over a connection of its own, as a tunnel would
+	listener, err := listenUDP("127.0.0.1:0", time.Minute)
+	assert.Nil(t, err)
+	stop := make(chan struct{})
+	acceptDone := make(chan struct{})
+	flowsDone := make(chan struct{}, 2)
+	go func() {
+		defer close(acceptDone)
+		acceptConnections(logger, listener, stop, func(flow net.Conn) {
+			defer func() { flowsDone <- struct{}{} }()
+			conn, err := net.Dial("tcp", relay.Addr().String())
+			if assert.Nil(t, err) {
+				handleDataTransfer(conn, flow)
+			}
+		})
+	}()
+
+	for _, message := range []string{"first client", "second client"} {
+		client, err := net.Dial("udp", listener.Addr().String())
+		assert.Nil(t, err)
+		for i := 0; i < 2; i++ {
+			client.Write([]byte(message))
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			reply := make([]byte, 100)
+			n, err := client.Read(reply)
+			assert.Nil(t, err)
+			assert.Equal(t, "echo "+message, string(reply[:n]))
+		}
+		client.Close()
+	}
+
+	// closing the listener ends the flows, the test returns once they and the servers stopped
+	close(stop)
+	listener.Close()
+	<-acceptDone
+	for i := 0; i < 2; i++ {
+		<-flowsDone
+	}
+	relay.Close()
+	<-relayDone
+	echo.Close()
+	<-echoDone
+}
+
+func TestUDPFlowClosedOnceIdle(t *testing.T) {
+	listener, err := listenUDP("127.0.0.1:0", 50*time.Millisecond)
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	client, err := net.Dial("udp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+	client.Write([]byte("query"))
+
+	flow, err := listener.Accept()
+	assert.Nil(t, err)
+	frame := make([]byte, 7)
+	_, err = io.ReadFull(flow, frame)
+	assert.Nil(t, err)
+	assert.Equal(t, append([]byte{0, 5}, "query"...), frame)
+
+	// frames written are sent to the client, also when split
+	flow.Write([]byte{0, 6, 'a', 'n'})
+	flow.Write([]byte("swer"))
+	reply := make([]byte, 10)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := client.Read(reply)
+	assert.Nil(t, err)
+	assert.Equal(t, "answer", string(reply[:n]))
+
+	_, err = flow.Read(frame)
+	assert.Equal(t, io.EOF, err)
+}
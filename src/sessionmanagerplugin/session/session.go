@@ -19,7 +19,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -63,6 +65,23 @@ type ISession interface {
 	TerminateSession(log.T) error
 }
 
+// IRestartableSessionPlugin is implemented by session plugins that can continue in a new session
+// started in place of their timed out session.
+type IRestartableSessionPlugin interface {
+	// SessionRestarted is called once the data channel is connected to the new session.
+	SessionRestarted(log log.T, sessionId string)
+}
+
+// IShellAutomation drives a shell session programmatically in place of the terminal.
+type IShellAutomation interface {
+	// Run is called once the shell session is ready, input is written with send and the session ends when Run returns.
+	Run(log log.T, send func(input []byte) error) error
+	// ProcessOutput receives the output of the shell.
+	ProcessOutput(payload []byte)
+	// Close is called when the session is closed by the agent.
+	Close()
+}
+
 func init() {
 	SessionRegistry = make(map[string]ISessionPlugin)
 }
@@ -85,6 +104,84 @@ type Session struct {
 	SessionType           string
 	SessionProperties     interface{}
 	DisplayMode           sessionutil.DisplayMode
+	// DocumentName and Parameters started the session, they are recorded in the audit log and start the
+	// sessions replacing it on restart
+	DocumentName string
+	Parameters   map[string][]string
+	// AuditLog receives hash chained records of the session if set
+	AuditLog *AuditLog
+	// ShellAutomation drives a shell session in place of the terminal if set
+	ShellAutomation IShellAutomation
+	// GuardrailsFile is the policy blocking or asking confirmation for commands typed in a shell session
+	GuardrailsFile string
+	// ConfirmPaste asks for confirmation before multi-line pastes are sent to a shell session
+	ConfirmPaste bool
+	// RemoteEncoding is the character set of the remote shell, the output is converted from and the input to
+	// the local terminal encoding if set
+	RemoteEncoding string
+	// WatchSocket is the unix socket read-only viewers of a shell session attach to if set, WatchSocketMode
+	// its octal permissions, 0600 if empty
+	WatchSocket     string
+	WatchSocketMode string
+	// StatusLine keeps the last terminal row of a shell session for its health
+	StatusLine bool
+	// PredictiveEcho shows typed characters before the shell echoes them when the round trip time is high
+	PredictiveEcho bool
+	// ZmodemDir is the directory ZMODEM transfers of a shell session are received into, transfers are off if empty
+	ZmodemDir string
+	// SignalMap is what the signals received by the plugin do
+	SignalMap sessionutil.SignalMap
+	// MaxRestarts is how many times a new session replaces a timed out shell session
+	MaxRestarts int
+	// IdleTimeout ends the session after no input or output for the duration and MaxDuration once it was open
+	// for the duration, each unlimited if zero. LimitWarning is how long before either the user is warned,
+	// DefaultLimitWarning if zero
+	IdleTimeout  time.Duration
+	MaxDuration  time.Duration
+	LimitWarning time.Duration
+	// KeepAliveInterval is how often an empty message is sent while a local connection of a port session is
+	// open and sends nothing, none is sent if zero
+	KeepAliveInterval time.Duration
+	// SessionPerConnection forwards connections made while another one is forwarded in sessions of their own
+	// when the agent forwards one connection at a time, they are queued otherwise
+	SessionPerConnection bool
+	// LocalHost is a comma separated list of the addresses local ports of port sessions listen on, localhost if
+	// empty, listening on non-loopback addresses is confirmed by the user unless AllowNonLoopback is set
+	LocalHost        string
+	AllowNonLoopback bool
+	// ReadyEvents is a comma separated list of file descriptors, fd:N, or files JSON events are written to once
+	// the local listener of a port session is open and once it accepted its first connection
+	ReadyEvents string
+	// AllowClients are the networks of the clients the local TCP ports of port sessions accept, AllowPeerUIDs and
+	// AllowPeerGIDs the user and group ids of the processes their unix sockets accept, any if empty
+	AllowClients  []*net.IPNet
+	AllowPeerUIDs []uint32
+	AllowPeerGIDs []uint32
+	// SocketMode are the permissions of the unix sockets of port sessions, 0600 if zero, SocketOwner their
+	// [USER][:GROUP] owner if set
+	SocketMode  os.FileMode
+	SocketOwner string
+	// MaxConnections is the maximum number of connections a local port forwards at once, unlimited if zero
+	MaxConnections int
+	// ConnectionLog is a comma separated list of file descriptors, fd:N, or files a JSON record of every
+	// connection forwarded by a port session is written to once it closed
+	ConnectionLog string
+	// OnDemand starts port forwarding sessions of ssmcli on the first connection and ends them after no
+	// connection was open for the duration
+	OnDemand time.Duration
+	// HTTPProxy serves the local listeners of port forwarding sessions of ssmcli as HTTP reverse proxies, sending
+	// HTTPProxyHost as Host header, the forwarded address if empty, over TLS with a self-signed certificate if
+	// HTTPProxyTLS is set
+	HTTPProxy     bool
+	HTTPProxyHost string
+	HTTPProxyTLS  bool
+	// Region and Profile of the API calls of the session, the defaults of the plugin if empty
+	Region  string
+	Profile string
+	// StopHandler is called in place of exiting the plugin when the session stops, for sessions run alongside others
+	StopHandler func()
+	restarts    int
+	plugin      ISessionPlugin
 }
 
 // startSession create the datachannel for session
@@ -96,19 +193,27 @@ var startSession = func(session *Session, log log.T) error {
 var setSessionHandlersWithSessionType = func(session *Session, log log.T) error {
 	// SessionType is set inside DataChannel
 	sessionSubType := SessionRegistry[session.SessionType]
+	session.plugin = sessionSubType
 	sessionSubType.Initialize(log, session)
 	return sessionSubType.SetSessionHandlers(log)
 }
 
-// Set up a scheduler to listen on stream data resend timeout event
+// Set up a scheduler to listen on stream data resend timeout event, it ends once the data channel is closed
 var handleStreamMessageResendTimeout = func(session *Session, log log.T) {
 	log.Tracef("Setting up scheduler to listen on IsStreamMessageResendTimeout event.")
+	resendTimeout := session.DataChannel.IsStreamMessageResendTimeout()
+	closed := session.DataChannel.Closed()
 	go func() {
 		for {
-			// Repeat this loop for every 200ms
-			time.Sleep(config.ResendSleepInterval)
-			if <-session.DataChannel.IsStreamMessageResendTimeout() {
+			var timedOut bool
+			select {
+			case timedOut = <-resendTimeout:
+			case <-closed:
+				return
+			}
+			if timedOut {
 				log.Errorf("Terminating session %s as the stream data was not processed before timeout.", session.SessionId)
+				session.AuditSessionEnd(log, "stream data was not processed before timeout")
 				if err := session.TerminateSession(log); err != nil {
 					log.Errorf("Unable to terminate session upon stream data timeout. %v", err)
 				}
@@ -138,6 +243,8 @@ func ValidateInputAndStartSession(args []string, out io.Writer) {
 		profile            string
 		ssmEndpoint        string
 		target             string
+		documentName       string
+		parameters         map[string][]string
 	)
 	log := log.Logger(true, "session-manager-plugin")
 	uuid.SwitchFormat(uuid.CleanHyphen)
@@ -182,6 +289,7 @@ func ValidateInputAndStartSession(args []string, out io.Writer) {
 			startSessionRequest := make(map[string]interface{})
 			json.Unmarshal([]byte(args[5]), &startSessionRequest)
 			target = startSessionRequest["Target"].(string)
+			documentName, parameters = getDocumentAndParameters(startSessionRequest)
 		case 6:
 			ssmEndpoint = args[6]
 		}
@@ -203,8 +311,54 @@ func ValidateInputAndStartSession(args []string, out io.Writer) {
 		session.Endpoint = ssmEndpoint
 		session.ClientId = clientId
 		session.TargetId = target
+		session.DocumentName = documentName
+		session.Parameters = parameters
 		session.DataChannel = &datachannel.DataChannel{}
 
+		session.GuardrailsFile = os.Getenv(config.GuardrailsEnvironmentVariable)
+		session.ConfirmPaste = os.Getenv(config.ConfirmPasteEnvironmentVariable) == "true"
+		session.RemoteEncoding = os.Getenv(config.RemoteEncodingEnvironmentVariable)
+		session.WatchSocket = os.Getenv(config.WatchSocketEnvironmentVariable)
+		session.WatchSocketMode = os.Getenv(config.WatchSocketModeEnvironmentVariable)
+		session.StatusLine = os.Getenv(config.StatusLineEnvironmentVariable) == "true"
+		session.PredictiveEcho = os.Getenv(config.PredictiveEchoEnvironmentVariable) == "true"
+		session.ZmodemDir = os.Getenv(config.ZmodemDirEnvironmentVariable)
+		session.SessionPerConnection = os.Getenv(config.SessionPerConnectionEnvironmentVariable) == "true"
+		session.LocalHost = os.Getenv(config.LocalHostEnvironmentVariable)
+		session.AllowNonLoopback = os.Getenv(config.AllowNonLoopbackEnvironmentVariable) == "true"
+		session.ReadyEvents = os.Getenv(config.ReadyEventsEnvironmentVariable)
+		session.ConnectionLog = os.Getenv(config.ConnectionLogEnvironmentVariable)
+		if session.AuditLog, err = getAuditLogFromEnvironment(); err != nil {
+			log.Errorf("Cannot perform start session: %v", err)
+			fmt.Fprintf(out, "Cannot perform start session: %v\n", err)
+			return
+		}
+		if maxRestarts := os.Getenv(config.MaxRestartsEnvironmentVariable); maxRestarts != "" {
+			if session.MaxRestarts, err = strconv.Atoi(maxRestarts); err != nil || session.MaxRestarts < 0 {
+				err = fmt.Errorf("%s must be a non-negative number of restarts", config.MaxRestartsEnvironmentVariable)
+				log.Errorf("Cannot perform start session: %v", err)
+				fmt.Fprintf(out, "Cannot perform start session: %v\n", err)
+				return
+			}
+		}
+		if err = session.setAccessControlFromEnvironment(); err != nil {
+			log.Errorf("Cannot perform start session: %v", err)
+			fmt.Fprintf(out, "Cannot perform start session: %v\n", err)
+			return
+		}
+		if err = session.setDurationsFromEnvironment(); err != nil {
+			log.Errorf("Cannot perform start session: %v", err)
+			fmt.Fprintf(out, "Cannot perform start session: %v\n", err)
+			return
+		}
+		if signalMap := os.Getenv(config.SignalMapEnvironmentVariable); signalMap != "" {
+			if session.SignalMap, err = sessionutil.ParseSignalMap(signalMap); err != nil {
+				log.Errorf("Cannot perform start session: %v", err)
+				fmt.Fprintf(out, "Cannot perform start session: %v\n", err)
+				return
+			}
+		}
+
 	default:
 		fmt.Fprint(out, "Invalid Operation")
 		return
@@ -231,6 +385,8 @@ func (s *Session) Execute(log log.T) (err error) {
 
 	handleStreamMessageResendTimeout(s, log)
 
+	s.AuditEvent(log, AuditEventSessionStart, "")
+
 	// The session type is set either by handshake or the first packet received.
 	if !<-s.DataChannel.IsSessionTypeSet() {
 		log.Errorf("unable to set SessionType for session %s", s.SessionId)
@@ -238,6 +394,8 @@ func (s *Session) Execute(log log.T) (err error) {
 	} else {
 		s.SessionType = s.DataChannel.GetSessionType()
 		s.SessionProperties = s.DataChannel.GetSessionProperties()
+		// the plugin is passed as setSessionHandlersWithSessionType sets s.plugin while the limits are enforced
+		s.enforceSessionLimits(log, SessionRegistry[s.SessionType])
 		if err = setSessionHandlersWithSessionType(s, log); err != nil {
 			log.Errorf("Session ending with error: %v", err)
 			return
@@ -246,3 +404,98 @@ func (s *Session) Execute(log log.T) (err error) {
 
 	return
 }
+
+// getDocumentAndParameters reads the document name and parameters from the StartSession request sent by AWS CLI.
+func getDocumentAndParameters(startSessionRequest map[string]interface{}) (documentName string, parameters map[string][]string) {
+	documentName, _ = startSessionRequest["DocumentName"].(string)
+	if requestParameters, ok := startSessionRequest["Parameters"].(map[string]interface{}); ok {
+		parameters = make(map[string][]string)
+		for key, values := range requestParameters {
+			if valueList, ok := values.([]interface{}); ok {
+				for _, value := range valueList {
+					parameters[key] = append(parameters[key], fmt.Sprint(value))
+				}
+			}
+		}
+	}
+	return
+}
+
+// ControlSignalMap returns the actions for signals received by the plugin, the default mapping unless configured.
+func (s *Session) ControlSignalMap() sessionutil.SignalMap {
+	if s.SignalMap == nil {
+		return sessionutil.DefaultSignalMap()
+	}
+	return s.SignalMap
+}
+
+// setDurationsFromEnvironment reads the idle timeout, maximum duration, their warning and the keep-alive interval
+// of port sessions from environment variables.
+func (s *Session) setDurationsFromEnvironment() (err error) {
+	durations := []struct {
+		variable string
+		value    *time.Duration
+	}{
+		{config.IdleTimeoutEnvironmentVariable, &s.IdleTimeout},
+		{config.MaxDurationEnvironmentVariable, &s.MaxDuration},
+		{config.LimitWarningEnvironmentVariable, &s.LimitWarning},
+		{config.KeepAliveIntervalEnvironmentVariable, &s.KeepAliveInterval},
+	}
+	for _, duration := range durations {
+		if value := os.Getenv(duration.variable); value != "" {
+			if *duration.value, err = time.ParseDuration(value); err != nil || *duration.value <= 0 {
+				return fmt.Errorf("%s must be a positive duration such as 30m", duration.variable)
+			}
+		}
+	}
+	return nil
+}
+
+// setAccessControlFromEnvironment reads the clients the local listeners of port sessions accept, the permissions
+// and owner of their unix sockets and their maximum number of connections from environment variables.
+func (s *Session) setAccessControlFromEnvironment() (err error) {
+	if value := os.Getenv(config.AllowClientsEnvironmentVariable); value != "" {
+		if s.AllowClients, err = sessionutil.ParseClientNetworks(value); err != nil {
+			return fmt.Errorf("%s: %v", config.AllowClientsEnvironmentVariable, err)
+		}
+	}
+	if value := os.Getenv(config.AllowPeerUIDsEnvironmentVariable); value != "" {
+		if s.AllowPeerUIDs, err = sessionutil.ParseIds(value); err != nil {
+			return fmt.Errorf("%s: %v", config.AllowPeerUIDsEnvironmentVariable, err)
+		}
+	}
+	if value := os.Getenv(config.AllowPeerGIDsEnvironmentVariable); value != "" {
+		if s.AllowPeerGIDs, err = sessionutil.ParseIds(value); err != nil {
+			return fmt.Errorf("%s: %v", config.AllowPeerGIDsEnvironmentVariable, err)
+		}
+	}
+	if value := os.Getenv(config.SocketModeEnvironmentVariable); value != "" {
+		if s.SocketMode, err = sessionutil.ParseSocketMode(value); err != nil {
+			return fmt.Errorf("%s: %v", config.SocketModeEnvironmentVariable, err)
+		}
+	}
+	if s.SocketOwner = os.Getenv(config.SocketOwnerEnvironmentVariable); s.SocketOwner != "" {
+		if _, _, err = sessionutil.ParseSocketOwner(s.SocketOwner); err != nil {
+			return fmt.Errorf("%s: %v", config.SocketOwnerEnvironmentVariable, err)
+		}
+	}
+	if value := os.Getenv(config.MaxConnectionsEnvironmentVariable); value != "" {
+		if s.MaxConnections, err = strconv.Atoi(value); err != nil || s.MaxConnections <= 0 {
+			return fmt.Errorf("%s must be a positive number of connections", config.MaxConnectionsEnvironmentVariable)
+		}
+	}
+	return nil
+}
+
+// getAuditLogFromEnvironment returns the audit log configured through environment variables, or nil if none is set.
+func getAuditLogFromEnvironment() (*AuditLog, error) {
+	auditLogPath := os.Getenv(config.AuditLogEnvironmentVariable)
+	if auditLogPath == "" {
+		return nil, nil
+	}
+	key, err := ReadAuditKeyFile(os.Getenv(config.AuditKeyFileEnvironmentVariable))
+	if err != nil {
+		return nil, err
+	}
+	return NewAuditLog(auditLogPath, key), nil
+}
@@ -170,6 +170,7 @@ func SetupMockActions() {
 	mockDataChannel.On("GetWsChannel").Return(mockWsChannel)
 	mockDataChannel.On("RegisterOutputStreamHandler", mock.Anything, mock.Anything)
 	mockDataChannel.On("ResendStreamDataMessageScheduler", mock.Anything).Return(nil)
+	mockDataChannel.On("Closed").Return(make(<-chan struct{}))
 
 	mockWsChannel.On("SetOnMessage", mock.Anything)
 	mockWsChannel.On("SetOnError", mock.Anything)
@@ -15,17 +15,23 @@
 package session
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	sdkSession "github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/session-manager-plugin/src/config"
+	"github.com/aws/session-manager-plugin/src/datachannel"
 	"github.com/aws/session-manager-plugin/src/log"
 	"github.com/aws/session-manager-plugin/src/message"
 	"github.com/aws/session-manager-plugin/src/retry"
 	"github.com/aws/session-manager-plugin/src/sdkutil"
+	"github.com/aws/session-manager-plugin/src/version"
+	"github.com/twinj/uuid"
 )
 
 // OpenDataChannel initializes datachannel
@@ -56,10 +62,13 @@ func (s *Session) OpenDataChannel(log log.T) (err error) {
 	s.DataChannel.GetWsChannel().SetOnError(
 		func(err error) {
 			log.Errorf("Trying to reconnect the session: %v with seq num: %d", s.StreamUrl, s.DataChannel.GetStreamDataSequenceNumber())
+			s.DataChannel.SetReconnecting(true)
 			s.retryParams.CallableFunc = func() (err error) { return s.ResumeSessionHandler(log) }
 			if err = s.retryParams.Call(); err != nil {
 				log.Error(err)
+				return
 			}
+			s.DataChannel.SetReconnecting(false)
 		})
 
 	// Scheduler for resending of data
@@ -88,7 +97,12 @@ func (s *Session) ProcessFirstMessage(log log.T, outputMessage message.ClientMes
 
 // Stop will end the session
 func (s *Session) Stop() {
-	os.Exit(0)
+	s.AuditSessionEnd(log.Logger(false, "session-manager-plugin"), "session closed")
+	if s.StopHandler != nil {
+		s.StopHandler()
+		return
+	}
+	os.Exit(ExitCode())
 }
 
 // GetResumeSessionParams calls ResumeSession API and gets tokenvalue for reconnecting
@@ -99,7 +113,7 @@ func (s *Session) GetResumeSessionParams(log log.T) (string, error) {
 		sdkSession          *sdkSession.Session
 	)
 
-	if sdkSession, err = sdkutil.GetNewSessionWithEndpoint(s.Endpoint); err != nil {
+	if sdkSession, err = sdkutil.GetNewSession(s.Endpoint, s.Region, s.Profile); err != nil {
 		return "", err
 	}
 	s.sdk = ssm.New(sdkSession)
@@ -130,13 +144,173 @@ func (s *Session) ResumeSessionHandler(log log.T) (err error) {
 	} else if s.TokenValue == "" {
 		log.Debugf("Session: %s timed out", s.SessionId)
 		fmt.Fprintf(os.Stdout, "Session: %s timed out.\n", s.SessionId)
+		s.AuditSessionEnd(log, "session timed out")
+		if s.restartSession(log) {
+			return nil
+		}
+		if s.StopHandler != nil {
+			s.StopHandler()
+			return nil
+		}
 		os.Exit(0)
 	}
 	s.DataChannel.GetWsChannel().SetChannelToken(s.TokenValue)
-	err = s.DataChannel.Reconnect(log)
+	if err = s.DataChannel.Reconnect(log); err != nil {
+		s.AuditEvent(log, AuditEventSessionReconnect, err.Error())
+	} else {
+		s.AuditEvent(log, AuditEventSessionReconnect, "")
+	}
 	return
 }
 
+// startNewSession calls StartSession API with the endpoint of the session
+var startNewSession = func(s *Session, log log.T, targetId string, documentName string, parameters map[string][]string) (*ssm.StartSessionOutput, error) {
+	newSession, err := sdkutil.GetNewSession(s.Endpoint, s.Region, s.Profile)
+	if err != nil {
+		return nil, err
+	}
+	s.sdk = ssm.New(newSession)
+
+	startSessionInput := ssm.StartSessionInput{
+		Target: aws.String(targetId),
+	}
+	if documentName != "" {
+		startSessionInput.DocumentName = aws.String(documentName)
+	}
+	if len(parameters) > 0 {
+		startSessionInput.Parameters = make(map[string][]*string)
+		for key, values := range parameters {
+			startSessionInput.Parameters[key] = aws.StringSlice(values)
+		}
+	}
+
+	log.Debugf("Start Session input parameters: %v", startSessionInput)
+	return s.sdk.StartSession(&startSessionInput)
+}
+
+// restartSession starts a new session to the target in place of the timed out session, as long as
+// restarts are left and the session plugin supports it. It returns whether the session continues.
+func (s *Session) restartSession(log log.T) bool {
+	plugin, ok := s.plugin.(IRestartableSessionPlugin)
+	if !ok || s.restarts >= s.MaxRestarts {
+		return false
+	}
+	s.restarts++
+
+	startSessionOutput, err := startNewSession(s, log, s.TargetId, s.DocumentName, s.Parameters)
+	if err != nil {
+		log.Errorf("Start Session failed: %v", err)
+		fmt.Fprintf(os.Stdout, "Unable to start a new session: %v\n", err)
+		return false
+	}
+	if startSessionOutput.SessionId == nil || startSessionOutput.StreamUrl == nil || startSessionOutput.TokenValue == nil {
+		log.Errorf("Start Session returned an incomplete response: %v", startSessionOutput)
+		return false
+	}
+
+	s.SessionId = *startSessionOutput.SessionId
+	s.StreamUrl = *startSessionOutput.StreamUrl
+	s.TokenValue = *startSessionOutput.TokenValue
+	fmt.Fprintf(os.Stdout, "\r\nStarting new session %d of %d with SessionId: %s\r\n\r\n", s.restarts, s.MaxRestarts, s.SessionId)
+	if err = s.DataChannel.SwitchSession(log, s.SessionId, s.StreamUrl, s.TokenValue); err != nil {
+		log.Errorf("Failed to connect to new session: %v", err)
+		fmt.Fprintf(os.Stdout, "Unable to connect to new session: %v\n", err)
+		return false
+	}
+
+	s.AuditEvent(log, AuditEventSessionStart, "")
+	plugin.SessionRestarted(log, s.SessionId)
+	return true
+}
+
+// StartAdditionalSession starts another session to the target with the document and parameters of the
+// session and waits until its data channel is open. stopHandler is called in place of exiting the plugin
+// when the new session stops. The caller sets the handlers of the new session.
+func (s *Session) StartAdditionalSession(log log.T, stopHandler func()) (additional *Session, err error) {
+	if additional, err = s.StartSessionTo(log, s.TargetId, s.DocumentName, s.Parameters); err != nil {
+		return nil, err
+	}
+	additional.StopHandler = stopHandler
+	if err = additional.Connect(log); err != nil {
+		return nil, err
+	}
+	return additional, nil
+}
+
+// StartSessionTo starts a session to the target with the document and parameters, using the endpoint and
+// client settings of the session. The data channel of the new session is opened by Connect.
+func (s *Session) StartSessionTo(log log.T, targetId string, documentName string, parameters map[string][]string) (started *Session, err error) {
+	var startSessionOutput *ssm.StartSessionOutput
+	if startSessionOutput, err = startNewSession(s, log, targetId, documentName, parameters); err != nil {
+		return nil, err
+	}
+	if startSessionOutput.SessionId == nil || startSessionOutput.StreamUrl == nil || startSessionOutput.TokenValue == nil {
+		return nil, errors.New("StartSession returned an incomplete response")
+	}
+
+	return &Session{
+		DataChannel:           &datachannel.DataChannel{},
+		SessionId:             *startSessionOutput.SessionId,
+		StreamUrl:             *startSessionOutput.StreamUrl,
+		TokenValue:            *startSessionOutput.TokenValue,
+		IsAwsCliUpgradeNeeded: s.IsAwsCliUpgradeNeeded,
+		Endpoint:              s.Endpoint,
+		Region:                s.Region,
+		Profile:               s.Profile,
+		ClientId:              uuid.NewV4().String(),
+		TargetId:              targetId,
+		DisplayMode:           s.DisplayMode,
+		DocumentName:          documentName,
+		Parameters:            parameters,
+		SignalMap:             s.SignalMap,
+		KeepAliveInterval:     s.KeepAliveInterval,
+		AllowNonLoopback:      s.AllowNonLoopback,
+		AuditLog:              s.AuditLog,
+		IdleTimeout:           s.IdleTimeout,
+		MaxDuration:           s.MaxDuration,
+		LimitWarning:          s.LimitWarning,
+		ConnectionLog:         s.ConnectionLog,
+	}, nil
+}
+
+// Connect opens the data channel of a session started by StartSessionTo and waits for the session type. The
+// limits of the session are enforced by its plugin with WatchSessionLimits.
+func (s *Session) Connect(log log.T) (err error) {
+	if err = s.OpenDataChannel(log); err != nil {
+		return err
+	}
+	handleStreamMessageResendTimeout(s, log)
+	s.AuditEvent(log, AuditEventSessionStart, "")
+
+	select {
+	case isSessionTypeSet := <-s.DataChannel.IsSessionTypeSet():
+		if !isSessionTypeSet {
+			err = fmt.Errorf("unable to determine SessionType of session %s", s.SessionId)
+		}
+	case <-time.After(config.HandshakeTimeout):
+		err = fmt.Errorf("session %s did not complete the handshake in %v", s.SessionId, config.HandshakeTimeout)
+	}
+	if err != nil {
+		s.AuditSessionEnd(log, err.Error())
+		s.DataChannel.Close(log)
+		return err
+	}
+	s.SessionType = s.DataChannel.GetSessionType()
+	s.SessionProperties = s.DataChannel.GetSessionProperties()
+	return nil
+}
+
+// EndSession ends the session with the TerminateSession flag, or the TerminateSession API for older agents
+func (s *Session) EndSession(log log.T) {
+	if version.DoesAgentSupportTerminateSessionFlag(log, s.DataChannel.GetAgentVersion()) {
+		if err := s.DataChannel.SendFlag(log, message.TerminateSession); err != nil {
+			log.Errorf("Failed to send TerminateSession flag: %v", err)
+		}
+	} else if err := s.TerminateSession(log); err != nil {
+		log.Errorf("Failed to terminate session: %v", err)
+	}
+}
+
 // TerminateSession calls TerminateSession API
 func (s *Session) TerminateSession(log log.T) error {
 	var (
@@ -144,7 +318,7 @@ func (s *Session) TerminateSession(log log.T) error {
 		newSession *sdkSession.Session
 	)
 
-	if newSession, err = sdkutil.GetNewSessionWithEndpoint(s.Endpoint); err != nil {
+	if newSession, err = sdkutil.GetNewSession(s.Endpoint, s.Region, s.Profile); err != nil {
 		log.Errorf("Terminate Session failed: %v", err)
 		return err
 	}
@@ -159,5 +333,6 @@ func (s *Session) TerminateSession(log log.T) error {
 		log.Errorf("Terminate Session failed: %v", err)
 		return err
 	}
+	s.AuditSessionEnd(log, "session terminated by client")
 	return nil
 }
@@ -17,11 +17,14 @@ package session
 import (
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/service/ssm"
 	wsChannelMock "github.com/aws/session-manager-plugin/src/communicator/mocks"
 	"github.com/aws/session-manager-plugin/src/config"
 	"github.com/aws/session-manager-plugin/src/datachannel"
 	dataChannelMock "github.com/aws/session-manager-plugin/src/datachannel/mocks"
+	"github.com/aws/session-manager-plugin/src/log"
 	"github.com/aws/session-manager-plugin/src/message"
 	"github.com/stretchr/testify/mock"
 
@@ -79,3 +82,80 @@ func TestProcessFirstMessageOutputMessageFirst(t *testing.T) {
 	assert.Equal(t, config.ShellPluginName, session.DataChannel.GetSessionType())
 	assert.True(t, <-session.DataChannel.IsSessionTypeSet())
 }
+
+// restartablePlugin records the sessions it was restarted in.
+type restartablePlugin struct {
+	ISessionPlugin
+	restartedSessions []string
+}
+
+func (p *restartablePlugin) SessionRestarted(log log.T, sessionId string) {
+	p.restartedSessions = append(p.restartedSessions, sessionId)
+}
+
+func TestRestartSession(t *testing.T) {
+	defer func(original func(*Session, log.T, string, string, map[string][]string) (*ssm.StartSessionOutput, error)) {
+		startNewSession = original
+	}(startNewSession)
+	newSessionId, streamUrl, tokenValue := "sessionId_def", "wss://stream", "token"
+	startNewSession = func(s *Session, log log.T, targetId string, documentName string, parameters map[string][]string) (*ssm.StartSessionOutput, error) {
+		assert.Equal(t, instanceId, targetId)
+		assert.Equal(t, "AWS-StartInteractiveCommand", documentName)
+		return &ssm.StartSessionOutput{SessionId: &newSessionId, StreamUrl: &streamUrl, TokenValue: &tokenValue}, nil
+	}
+
+	mockDataChannel := &dataChannelMock.IDataChannel{}
+	mockDataChannel.On("SwitchSession", mock.Anything, newSessionId, streamUrl, tokenValue).Return(nil)
+	plugin := &restartablePlugin{}
+	session := &Session{
+		DataChannel:  mockDataChannel,
+		SessionId:    sessionId,
+		TargetId:     instanceId,
+		DocumentName: "AWS-StartInteractiveCommand",
+		MaxRestarts:  1,
+		plugin:       plugin,
+	}
+
+	assert.True(t, session.restartSession(logger))
+	assert.Equal(t, newSessionId, session.SessionId)
+	assert.Equal(t, tokenValue, session.TokenValue)
+	assert.Equal(t, []string{newSessionId}, plugin.restartedSessions)
+
+	// no restarts left
+	assert.False(t, session.restartSession(logger))
+	mockDataChannel.AssertNumberOfCalls(t, "SwitchSession", 1)
+}
+
+func TestRestartSessionNotSupported(t *testing.T) {
+	session := &Session{MaxRestarts: 1}
+	assert.False(t, session.restartSession(logger))
+	assert.Equal(t, 0, session.restarts)
+}
+
+func TestStartSessionToKeepsSettings(t *testing.T) {
+	defer func(original func(*Session, log.T, string, string, map[string][]string) (*ssm.StartSessionOutput, error)) {
+		startNewSession = original
+	}(startNewSession)
+	newSessionId, streamUrl, tokenValue := "sessionId_def", "wss://stream", "token"
+	startNewSession = func(s *Session, log log.T, targetId string, documentName string, parameters map[string][]string) (*ssm.StartSessionOutput, error) {
+		return &ssm.StartSessionOutput{SessionId: &newSessionId, StreamUrl: &streamUrl, TokenValue: &tokenValue}, nil
+	}
+
+	base := &Session{
+		TargetId:      instanceId,
+		AuditLog:      NewAuditLog("audit.log", nil),
+		IdleTimeout:   10 * time.Minute,
+		MaxDuration:   time.Hour,
+		LimitWarning:  time.Minute,
+		ConnectionLog: "connections.log",
+	}
+	started, err := base.StartSessionTo(logger, "i-other", "AWS-StartPortForwardingSession", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, newSessionId, started.SessionId)
+	assert.Equal(t, "i-other", started.TargetId)
+	assert.Equal(t, base.AuditLog, started.AuditLog)
+	assert.Equal(t, base.IdleTimeout, started.IdleTimeout)
+	assert.Equal(t, base.MaxDuration, started.MaxDuration)
+	assert.Equal(t, base.LimitWarning, started.LimitWarning)
+	assert.Equal(t, base.ConnectionLog, started.ConnectionLog)
+}
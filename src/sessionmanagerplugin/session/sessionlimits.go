@@ -0,0 +1,144 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package session starts the session.
+package session
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+)
+
+const (
+	// ExitCodeIdleTimeout is the exit status of the plugin after it ended an idle session
+	ExitCodeIdleTimeout = 3
+	// ExitCodeMaxDuration is the exit status of the plugin after it ended a session at its maximum duration
+	ExitCodeMaxDuration = 4
+
+	// DefaultLimitWarning is how long before a session limit the user is warned
+	DefaultLimitWarning = time.Minute
+)
+
+// limitCheckInterval is how often the limits of a session are checked
+var limitCheckInterval = time.Second
+
+// exitCode is the exit status of the plugin once the session stops
+var exitCode int32
+
+// SetExitCode sets the exit status of the plugin once the session stops.
+func SetExitCode(code int) {
+	atomic.StoreInt32(&exitCode, int32(code))
+}
+
+// ExitCode returns the exit status of the plugin, 0 unless the plugin ended the session at a limit.
+func ExitCode() int {
+	return int(atomic.LoadInt32(&exitCode))
+}
+
+// sessionLimits decides when a session is warned about and ended at its idle timeout or maximum duration.
+type sessionLimits struct {
+	idleTimeout    time.Duration
+	maxDuration    time.Duration
+	warning        time.Duration
+	start          time.Time
+	idleWarnedFor  time.Time
+	durationWarned bool
+}
+
+// check returns a warning to show, or the reason and exit status once a limit is reached.
+func (l *sessionLimits) check(now time.Time, lastActivity time.Time) (warning string, reason string, code int) {
+	if l.maxDuration > 0 {
+		remaining := l.start.Add(l.maxDuration).Sub(now)
+		if remaining <= 0 {
+			return "", fmt.Sprintf("maximum session duration of %v reached", l.maxDuration), ExitCodeMaxDuration
+		}
+		if remaining <= l.warning && !l.durationWarned {
+			l.durationWarned = true
+			warning = fmt.Sprintf("Session reaches its maximum duration in %v.", remaining.Round(time.Second))
+		}
+	}
+	if l.idleTimeout > 0 {
+		remaining := lastActivity.Add(l.idleTimeout).Sub(now)
+		if remaining <= 0 {
+			return "", fmt.Sprintf("session idle for %v", l.idleTimeout), ExitCodeIdleTimeout
+		}
+		// warned once per period of inactivity
+		if remaining <= l.warning && !l.idleWarnedFor.Equal(lastActivity) && warning == "" {
+			l.idleWarnedFor = lastActivity
+			warning = fmt.Sprintf("Session ends in %v without activity.", remaining.Round(time.Second))
+		}
+	}
+	return
+}
+
+// enforceSessionLimits ends the session once it was idle for IdleTimeout or open for MaxDuration as
+// WatchSessionLimits does, stops plugin if set and exits with the status of the limit.
+func (s *Session) enforceSessionLimits(log log.T, plugin ISessionPlugin) {
+	s.WatchSessionLimits(log, nil, func(code int) {
+		SetExitCode(code)
+		s.EndSession(log)
+		if plugin != nil {
+			plugin.Stop()
+		}
+		os.Exit(code)
+	})
+}
+
+// WatchSessionLimits calls end with the exit status of the limit once the session was idle for IdleTimeout or
+// open for MaxDuration, after appending its SessionEnd audit record, and warns the user LimitWarning before.
+// Limits are no longer checked once stop is closed. Messages go to stderr since stdout may carry the forwarded
+// stream.
+func (s *Session) WatchSessionLimits(log log.T, stop <-chan struct{}, end func(code int)) {
+	if s.IdleTimeout <= 0 && s.MaxDuration <= 0 {
+		return
+	}
+	limits := &sessionLimits{
+		idleTimeout: s.IdleTimeout,
+		maxDuration: s.MaxDuration,
+		warning:     s.LimitWarning,
+		start:       time.Now(),
+	}
+	if limits.warning <= 0 {
+		limits.warning = DefaultLimitWarning
+	}
+	ticker := time.NewTicker(limitCheckInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			var now time.Time
+			select {
+			case <-stop:
+				return
+			case now = <-ticker.C:
+			}
+			warning, reason, code := limits.check(now, s.DataChannel.GetLastActivityTime())
+			if warning != "" {
+				log.Infof("Session %s: %s", s.SessionId, warning)
+				fmt.Fprintf(os.Stderr, "\r\n%s\r\n", warning)
+			}
+			if reason == "" {
+				continue
+			}
+
+			log.Infof("Ending session %s: %s", s.SessionId, reason)
+			fmt.Fprintf(os.Stderr, "\r\nEnding session %s: %s.\r\n", s.SessionId, reason)
+			s.AuditSessionEnd(log, reason)
+			end(code)
+			return
+		}
+	}()
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package session starts the session.
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/datachannel"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionLimitsIdleTimeout(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	limits := &sessionLimits{idleTimeout: 10 * time.Minute, warning: time.Minute, start: start}
+
+	warning, reason, _ := limits.check(start.Add(8*time.Minute), start)
+	assert.Empty(t, warning)
+	assert.Empty(t, reason)
+
+	warning, _, _ = limits.check(start.Add(9*time.Minute+30*time.Second), start)
+	assert.Equal(t, "Session ends in 30s without activity.", warning)
+	warning, _, _ = limits.check(start.Add(9*time.Minute+31*time.Second), start)
+	assert.Empty(t, warning)
+
+	// activity resets the timeout and the warning
+	activity := start.Add(9 * time.Minute)
+	warning, reason, _ = limits.check(start.Add(10*time.Minute), activity)
+	assert.Empty(t, warning)
+	assert.Empty(t, reason)
+	warning, _, _ = limits.check(start.Add(18*time.Minute+30*time.Second), activity)
+	assert.Equal(t, "Session ends in 30s without activity.", warning)
+
+	_, reason, code := limits.check(start.Add(19*time.Minute), activity)
+	assert.Equal(t, "session idle for 10m0s", reason)
+	assert.Equal(t, ExitCodeIdleTimeout, code)
+}
+
+func TestSessionLimitsMaxDuration(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	limits := &sessionLimits{maxDuration: time.Hour, warning: 5 * time.Minute, start: start}
+
+	warning, reason, _ := limits.check(start.Add(56*time.Minute), start.Add(56*time.Minute))
+	assert.Equal(t, "Session reaches its maximum duration in 4m0s.", warning)
+	assert.Empty(t, reason)
+	warning, _, _ = limits.check(start.Add(57*time.Minute), start.Add(57*time.Minute))
+	assert.Empty(t, warning)
+
+	_, reason, code := limits.check(start.Add(time.Hour), start.Add(time.Hour))
+	assert.Equal(t, "maximum session duration of 1h0m0s reached", reason)
+	assert.Equal(t, ExitCodeMaxDuration, code)
+}
+
+func TestWatchSessionLimits(t *testing.T) {
+	defer func(original time.Duration) { limitCheckInterval = original }(limitCheckInterval)
+	limitCheckInterval = 10 * time.Millisecond
+
+	dataChannel := &datachannel.DataChannel{}
+	dataChannel.Initialize(logger, clientId, sessionId, instanceId, false)
+	session := &Session{SessionId: sessionId, DataChannel: dataChannel, MaxDuration: 50 * time.Millisecond}
+
+	ended := make(chan int, 1)
+	session.WatchSessionLimits(logger, nil, func(code int) { ended <- code })
+	select {
+	case code := <-ended:
+		assert.Equal(t, ExitCodeMaxDuration, code)
+	case <-time.After(time.Second):
+		t.Fatal("session not ended at its maximum duration")
+	}
+
+	// limits are no longer checked once stopped
+	stop := make(chan struct{})
+	session.WatchSessionLimits(logger, stop, func(code int) { ended <- code })
+	close(stop)
+	select {
+	case <-ended:
+		t.Fatal("session ended after its limits stopped being checked")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
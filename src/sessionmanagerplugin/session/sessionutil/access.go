@@ -0,0 +1,102 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package sessionutil provides utility for sessions.
+package sessionutil
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// ParseClientNetworks parses a comma separated list of IP addresses and CIDR networks of clients.
+func ParseClientNetworks(value string) (networks []*net.IPNet, err error) {
+	for _, spec := range strings.Split(value, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if strings.Contains(spec, "/") {
+			var network *net.IPNet
+			if _, network, err = net.ParseCIDR(spec); err != nil {
+				return nil, fmt.Errorf("invalid client network %q", spec)
+			}
+			networks = append(networks, network)
+			continue
+		}
+		ip := net.ParseIP(spec)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid client address %q, expected an IP address or a CIDR network", spec)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return networks, nil
+}
+
+// ParseIds parses a comma separated list of numeric user or group ids.
+func ParseIds(value string) (ids []uint32, err error) {
+	for _, spec := range strings.Split(value, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(spec, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q, expected a number", spec)
+		}
+		ids = append(ids, uint32(id))
+	}
+	return ids, nil
+}
+
+// ParseSocketMode parses the octal permissions of a unix socket.
+func ParseSocketMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode&^0777 != 0 {
+		return 0, fmt.Errorf("invalid socket mode %q, expected octal permissions such as 0660", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// ParseSocketOwner parses [USER][:GROUP], names or numeric ids, and returns the user and group ids, -1 for
+// those not given.
+func ParseSocketOwner(value string) (uid int, gid int, err error) {
+	parts := strings.SplitN(value, ":", 2)
+	if parts[0] == "" {
+		uid = -1
+	} else if uid, err = strconv.Atoi(parts[0]); err != nil {
+		account, lookupErr := user.Lookup(parts[0])
+		if lookupErr != nil {
+			return -1, -1, fmt.Errorf("invalid socket owner %q: %v", value, lookupErr)
+		}
+		uid, _ = strconv.Atoi(account.Uid)
+	}
+	gid = -1
+	if len(parts) == 2 && parts[1] != "" {
+		if gid, err = strconv.Atoi(parts[1]); err != nil {
+			group, lookupErr := user.LookupGroup(parts[1])
+			if lookupErr != nil {
+				return -1, -1, fmt.Errorf("invalid socket group %q: %v", value, lookupErr)
+			}
+			gid, _ = strconv.Atoi(group.Gid)
+		}
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package sessionutil contains utility methods required to start session.
+package sessionutil
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClientNetworks(t *testing.T) {
+	networks, err := ParseClientNetworks("127.0.0.1, 10.0.0.0/8,::1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1/32", "10.0.0.0/8", "::1/128"}, []string{networks[0].String(), networks[1].String(), networks[2].String()})
+	assert.True(t, networks[0].Contains(net.ParseIP("127.0.0.1")))
+	assert.False(t, networks[0].Contains(net.ParseIP("127.0.0.2")))
+
+	_, err = ParseClientNetworks("localhost")
+	assert.NotNil(t, err)
+	_, err = ParseClientNetworks("10.0.0.0/33")
+	assert.NotNil(t, err)
+}
+
+func TestParseIds(t *testing.T) {
+	ids, err := ParseIds("1000, 0")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{1000, 0}, ids)
+
+	_, err = ParseIds("root")
+	assert.NotNil(t, err)
+}
+
+func TestParseSocketOwner(t *testing.T) {
+	uid, gid, err := ParseSocketOwner("1000:50")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1000, 50}, []int{uid, gid})
+
+	uid, gid, err = ParseSocketOwner(":50")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{-1, 50}, []int{uid, gid})
+
+	uid, gid, err = ParseSocketOwner(strconv.Itoa(os.Getuid()))
+	assert.Nil(t, err)
+	assert.Equal(t, []int{os.Getuid(), -1}, []int{uid, gid})
+
+	_, _, err = ParseSocketOwner("no-such-user-of-the-plugin")
+	assert.NotNil(t, err)
+}
+
+func TestListenUnixSocketReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "forward.sock")
+
+	// a socket left behind by a plugin that did not exit cleanly
+	stale, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := ListenUnixSocket(path, 0640, -1, -1)
+	assert.Nil(t, err)
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	assert.Equal(t, path, listener.Addr().String())
+
+	// a socket in use is kept
+	_, err = ListenUnixSocket(path, 0600, -1, -1)
+	assert.NotNil(t, err)
+
+	listener.Close()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
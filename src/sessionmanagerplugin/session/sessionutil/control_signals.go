@@ -0,0 +1,127 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package sessionutil contains utility methods required to start session.
+package sessionutil
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SignalActionType is what a session does when the plugin receives a signal.
+type SignalActionType int
+
+const (
+	// SignalSendByte sends a control character to the shell, port sessions are terminated
+	SignalSendByte SignalActionType = iota
+	// SignalTerminate terminates the session
+	SignalTerminate
+	// SignalSuspend stops the plugin until it is continued, the session stays open
+	SignalSuspend
+	// SignalIgnore ignores the signal
+	SignalIgnore
+)
+
+// SignalAction is the action taken for a signal, Byte is the control character of SignalSendByte.
+type SignalAction struct {
+	Type SignalActionType
+	Byte byte
+}
+
+// SignalMap maps the signals handled by sessions to their action.
+type SignalMap map[os.Signal]SignalAction
+
+// DefaultSignalMap returns the control characters sent for ControlSignals.
+func DefaultSignalMap() SignalMap {
+	signalMap := make(SignalMap)
+	for _, sig := range ControlSignals {
+		signalMap[sig] = SignalAction{Type: SignalSendByte, Byte: SignalsByteMap[sig]}
+	}
+	return signalMap
+}
+
+// ParseSignalMap returns the default mapping changed by a comma separated list of signal=action, such as
+// "TSTP=suspend,HUP=terminate,TERM=terminate,USR1=^T". Actions are a control character written as ^C or
+// 0x03, terminate, suspend or ignore.
+func ParseSignalMap(spec string) (SignalMap, error) {
+	signalMap := DefaultSignalMap()
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid signal mapping %q, expected signal=action", entry)
+		}
+		name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(parts[0])), "SIG")
+		sig, ok := signalsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported signal %q, supported signals: %s", parts[0], supportedSignalNames())
+		}
+		action, err := parseSignalAction(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		signalMap[sig] = action
+	}
+	return signalMap, nil
+}
+
+// parseSignalAction parses the action of a signal mapping.
+func parseSignalAction(value string) (SignalAction, error) {
+	switch strings.ToLower(value) {
+	case "terminate":
+		return SignalAction{Type: SignalTerminate}, nil
+	case "ignore":
+		return SignalAction{Type: SignalIgnore}, nil
+	case "suspend":
+		if !suspendSupported {
+			return SignalAction{}, fmt.Errorf("suspend is not supported on this platform")
+		}
+		return SignalAction{Type: SignalSuspend}, nil
+	}
+	if len(value) == 2 && value[0] == '^' {
+		if c := strings.ToUpper(value)[1]; c >= '@' && c <= '_' {
+			return SignalAction{Type: SignalSendByte, Byte: c - '@'}, nil
+		}
+	}
+	if strings.HasPrefix(value, "0x") {
+		if b, err := strconv.ParseUint(value[2:], 16, 8); err == nil {
+			return SignalAction{Type: SignalSendByte, Byte: byte(b)}, nil
+		}
+	}
+	return SignalAction{}, fmt.Errorf("invalid signal action %q, expected a control character such as ^C or 0x03, terminate, suspend or ignore", value)
+}
+
+// supportedSignalNames lists the names accepted by ParseSignalMap.
+func supportedSignalNames() string {
+	names := make([]string, 0, len(signalsByName))
+	for name := range signalsByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Signals returns the signals of the mapping.
+func (m SignalMap) Signals() []os.Signal {
+	signals := make([]os.Signal, 0, len(m))
+	for sig := range m {
+		signals = append(signals, sig)
+	}
+	return signals
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package sessionutil contains utility methods required to start session.
+package sessionutil
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultSignalMap(t *testing.T) {
+	signalMap := DefaultSignalMap()
+	assert.Len(t, signalMap.Signals(), len(ControlSignals))
+	assert.Equal(t, SignalAction{Type: SignalSendByte, Byte: 0x03}, signalMap[syscall.SIGINT])
+	assert.Equal(t, SignalAction{Type: SignalSendByte, Byte: 0x1c}, signalMap[syscall.SIGQUIT])
+}
+
+func TestParseSignalMap(t *testing.T) {
+	signalMap, err := ParseSignalMap("SIGHUP=terminate, term=Terminate,QUIT=ignore,INT=0x07,")
+	assert.Nil(t, err)
+	assert.Equal(t, SignalAction{Type: SignalTerminate}, signalMap[syscall.SIGHUP])
+	assert.Equal(t, SignalAction{Type: SignalTerminate}, signalMap[syscall.SIGTERM])
+	assert.Equal(t, SignalAction{Type: SignalIgnore}, signalMap[syscall.SIGQUIT])
+	assert.Equal(t, SignalAction{Type: SignalSendByte, Byte: 0x07}, signalMap[syscall.SIGINT])
+
+	signalMap, err = ParseSignalMap("INT=^\\")
+	assert.Nil(t, err)
+	assert.Equal(t, SignalAction{Type: SignalSendByte, Byte: 0x1c}, signalMap[syscall.SIGINT])
+
+	for spec, message := range map[string]string{
+		"INT":         "expected signal=action",
+		"KILL=ignore": "unsupported signal",
+		"INT=^1":      "invalid signal action",
+		"INT=0x100":   "invalid signal action",
+	} {
+		_, err = ParseSignalMap(spec)
+		assert.Contains(t, err.Error(), message, spec)
+	}
+
+	_, err = ParseSignalMap("INT=suspend")
+	assert.Equal(t, suspendSupported, err == nil)
+}
@@ -33,3 +33,21 @@ var SignalsByteMap = map[os.Signal]byte{
 }
 
 var ControlSignals = []os.Signal{syscall.SIGINT, syscall.SIGTSTP, syscall.SIGQUIT}
+
+// signalsByName holds the signals a SignalMap can map
+var signalsByName = map[string]os.Signal{
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TSTP": syscall.SIGTSTP,
+	"HUP":  syscall.SIGHUP,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+const suspendSupported = true
+
+// SuspendProcess stops the plugin like a suspended job, it returns once the process is continued.
+func SuspendProcess() error {
+	return syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+}
@@ -18,6 +18,7 @@
 package sessionutil
 
 import (
+	"errors"
 	"os"
 	"syscall"
 )
@@ -31,3 +32,18 @@ var SignalsByteMap = map[os.Signal]byte{
 }
 
 var ControlSignals = []os.Signal{syscall.SIGINT, syscall.SIGQUIT}
+
+// signalsByName holds the signals a SignalMap can map
+var signalsByName = map[string]os.Signal{
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"HUP":  syscall.SIGHUP,
+	"TERM": syscall.SIGTERM,
+}
+
+const suspendSupported = false
+
+// SuspendProcess is not supported on windows.
+func SuspendProcess() error {
+	return errors.New("suspend is not supported on windows")
+}
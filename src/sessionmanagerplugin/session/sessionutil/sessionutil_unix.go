@@ -22,6 +22,7 @@ import (
 	"io"
 	"net"
 	"os"
+	"syscall"
 
 	"github.com/aws/session-manager-plugin/src/log"
 	"github.com/aws/session-manager-plugin/src/message"
@@ -43,3 +44,13 @@ func (d *DisplayMode) DisplayMessage(log log.T, message message.ClientMessage) {
 func NewListener(log log.T, address string) (net.Listener, error) {
 	return net.Listen("unix", address)
 }
+
+// LockFile takes an exclusive advisory lock on the file, blocking until it is available.
+func LockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+// UnlockFile releases a lock taken with LockFile.
+func UnlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
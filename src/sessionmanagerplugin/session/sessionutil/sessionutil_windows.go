@@ -86,3 +86,13 @@ func NewListener(log log.T, address string) (net.Listener, error) {
 		return listener, err
 	}
 }
+
+// LockFile takes an exclusive lock on the file, blocking until it is available.
+func LockFile(file *os.File) error {
+	return windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, &windows.Overlapped{})
+}
+
+// UnlockFile releases a lock taken with LockFile.
+func UnlockFile(file *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, &windows.Overlapped{})
+}
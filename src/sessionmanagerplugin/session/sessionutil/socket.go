@@ -0,0 +1,98 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package sessionutil provides utility for sessions.
+package sessionutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// RemoveStaleSocket removes a socket left behind by a previous session, a socket that still
+// accepts connections or any other file is kept.
+func RemoveStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another session", path)
+	}
+	return os.Remove(path)
+}
+
+// ListenUnixSocket listens on a unix socket at path with permissions mode, owned by uid and gid unless they
+// are -1. A stale socket at path is removed first, the socket is removed when the listener is closed.
+func ListenUnixSocket(path string, mode os.FileMode, uid int, gid int) (net.Listener, error) {
+	if err := RemoveStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	// the socket is created in a private directory and moved in place once its permissions are set,
+	// no one else can connect in between
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".ssm-socket")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "socket")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if unixListener, ok := listener.(*net.UnixListener); ok {
+		// the socket is removed under its final name
+		unixListener.SetUnlinkOnClose(false)
+	}
+	err = os.Chmod(socket, mode)
+	if err == nil && (uid != -1 || gid != -1) {
+		err = os.Chown(socket, uid, gid)
+	}
+	if err == nil {
+		err = os.Rename(socket, path)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &unixSocketListener{Listener: listener, path: path}, nil
+}
+
+// unixSocketListener removes its socket when closed.
+type unixSocketListener struct {
+	net.Listener
+	path string
+}
+
+// Close stops listening and removes the socket.
+func (l *unixSocketListener) Close() error {
+	err := l.Listener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// Addr returns the address of the socket under its final name.
+func (l *unixSocketListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
@@ -0,0 +1,271 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:generate go run charset_gen.go
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Charset converts between a character encoding and unicode.
+type Charset interface {
+	// decode returns the first character of p and its length, a length of 0 means that p only holds
+	// the start of a multi-byte sequence. Invalid bytes decode to utf8.RuneError.
+	decode(p []byte) (r rune, size int)
+	// encode appends the encoded character to dst, characters that cannot be encoded are replaced with '?'.
+	encode(dst []byte, r rune) []byte
+}
+
+// charsets maps normalized names, lower case without punctuation, to the supported encodings
+var charsets = map[string]Charset{
+	"utf8":        utf8Charset{},
+	"ascii":       &singleByteCharset{},
+	"usascii":     &singleByteCharset{},
+	"latin1":      &singleByteCharset{table: latin1Table()},
+	"iso88591":    &singleByteCharset{table: latin1Table()},
+	"latin9":      &singleByteCharset{table: &iso885915},
+	"iso885915":   &singleByteCharset{table: &iso885915},
+	"cp1250":      &singleByteCharset{table: &windows1250},
+	"windows1250": &singleByteCharset{table: &windows1250},
+	"cp1251":      &singleByteCharset{table: &windows1251},
+	"windows1251": &singleByteCharset{table: &windows1251},
+	"cp1252":      &singleByteCharset{table: &windows1252},
+	"windows1252": &singleByteCharset{table: &windows1252},
+	"shiftjis":    &shiftJISCharset{},
+	"sjis":        &shiftJISCharset{},
+	"cp932":       &shiftJISCharset{},
+	"windows31j":  &shiftJISCharset{},
+}
+
+// LookupCharset returns the encoding with the given name, such as UTF-8, ISO-8859-1, windows-1252 or Shift_JIS.
+func LookupCharset(name string) (Charset, error) {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(name))
+	if charset, ok := charsets[normalized]; ok {
+		return charset, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", name)
+}
+
+// localCharset returns the encoding of the local terminal from the locale environment, UTF-8 unless
+// a supported codeset such as in ja_JP.SJIS is given.
+func localCharset() Charset {
+	for _, variable := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		locale := os.Getenv(variable)
+		if locale == "" {
+			continue
+		}
+		if index := strings.IndexByte(locale, '.'); index >= 0 {
+			codeset := strings.SplitN(locale[index+1:], "@", 2)[0]
+			if charset, err := LookupCharset(codeset); err == nil {
+				return charset
+			}
+		}
+		break
+	}
+	return utf8Charset{}
+}
+
+// transcoder converts a byte stream between encodings. Incomplete multi-byte sequences at the end
+// of the input are kept until the next call.
+type transcoder struct {
+	mutex   sync.Mutex
+	from    Charset
+	to      Charset
+	partial []byte
+}
+
+// newTranscoder returns a transcoder from one encoding to another.
+func newTranscoder(from Charset, to Charset) *transcoder {
+	return &transcoder{from: from, to: to}
+}
+
+// transcode returns the input converted to the target encoding.
+func (t *transcoder) transcode(input []byte) []byte {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	data := input
+	if len(t.partial) > 0 {
+		data = append(t.partial, input...)
+		t.partial = nil
+	}
+	output := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		r, size := t.from.decode(data)
+		if size == 0 {
+			t.partial = append([]byte(nil), data...)
+			break
+		}
+		output = t.to.encode(output, r)
+		data = data[size:]
+	}
+	return output
+}
+
+// utf8Charset is the UTF-8 encoding.
+type utf8Charset struct{}
+
+func (utf8Charset) decode(p []byte) (rune, int) {
+	if !utf8.FullRune(p) {
+		return 0, 0
+	}
+	return utf8.DecodeRune(p)
+}
+
+func (utf8Charset) encode(dst []byte, r rune) []byte {
+	return append(dst, string(r)...)
+}
+
+// singleByteCharset is an encoding of ASCII and up to 128 further characters in the bytes 0x80 to 0xff.
+// Without a table only ASCII is supported.
+type singleByteCharset struct {
+	table   *[128]rune
+	once    sync.Once
+	reverse map[rune]byte
+}
+
+// latin1Table returns the table of ISO 8859-1, which maps bytes to the same code points.
+func latin1Table() *[128]rune {
+	var table [128]rune
+	for i := range table {
+		table[i] = rune(0x80 + i)
+	}
+	return &table
+}
+
+func (c *singleByteCharset) decode(p []byte) (rune, int) {
+	if p[0] < utf8.RuneSelf {
+		return rune(p[0]), 1
+	}
+	if c.table == nil {
+		return utf8.RuneError, 1
+	}
+	return c.table[p[0]-0x80], 1
+}
+
+func (c *singleByteCharset) encode(dst []byte, r rune) []byte {
+	if r < utf8.RuneSelf {
+		return append(dst, byte(r))
+	}
+	c.once.Do(func() {
+		c.reverse = make(map[rune]byte)
+		if c.table != nil {
+			for i, tableRune := range c.table {
+				if tableRune != utf8.RuneError {
+					c.reverse[tableRune] = byte(0x80 + i)
+				}
+			}
+		}
+	})
+	if b, ok := c.reverse[r]; ok {
+		return append(dst, b)
+	}
+	return append(dst, '?')
+}
+
+// shiftJISCharset is Shift_JIS as extended by Windows code page 932.
+type shiftJISCharset struct {
+	once    sync.Once
+	reverse map[rune]uint16
+}
+
+const shiftJISTableSize = 60 * 188
+
+// shiftJISIndex returns the index of a double byte code in shiftJISTable, or -1 for invalid codes.
+func shiftJISIndex(lead byte, trail byte) int {
+	var row, column int
+	switch {
+	case lead >= 0x81 && lead <= 0x9f:
+		row = int(lead) - 0x81
+	case lead >= 0xe0 && lead <= 0xfc:
+		row = int(lead) - 0xc1
+	default:
+		return -1
+	}
+	switch {
+	case trail >= 0x40 && trail <= 0x7e:
+		column = int(trail) - 0x40
+	case trail >= 0x80 && trail <= 0xfc:
+		column = int(trail) - 0x41
+	default:
+		return -1
+	}
+	return row*188 + column
+}
+
+func (c *shiftJISCharset) decode(p []byte) (rune, int) {
+	lead := p[0]
+	switch {
+	case lead < utf8.RuneSelf:
+		return rune(lead), 1
+	case lead >= 0xa1 && lead <= 0xdf:
+		// halfwidth katakana
+		return rune(0xff61 + int(lead) - 0xa1), 1
+	case (lead >= 0x81 && lead <= 0x9f) || (lead >= 0xe0 && lead <= 0xfc):
+	default:
+		return utf8.RuneError, 1
+	}
+
+	if len(p) < 2 {
+		return 0, 0
+	}
+	if index := shiftJISIndex(lead, p[1]); index >= 0 && shiftJISTable[index] != 0 {
+		return rune(shiftJISTable[index]), 2
+	}
+	if p[1] < utf8.RuneSelf {
+		// keep ASCII following an invalid lead byte
+		return utf8.RuneError, 1
+	}
+	return utf8.RuneError, 2
+}
+
+func (c *shiftJISCharset) encode(dst []byte, r rune) []byte {
+	switch {
+	case r < utf8.RuneSelf:
+		return append(dst, byte(r))
+	case r >= 0xff61 && r <= 0xff9f:
+		return append(dst, byte(0xa1+r-0xff61))
+	}
+	c.once.Do(func() {
+		c.reverse = make(map[rune]uint16)
+		for lead := 0x81; lead <= 0xfc; lead++ {
+			for trail := 0x40; trail <= 0xfc; trail++ {
+				code := uint16(lead<<8 | trail)
+				index := shiftJISIndex(byte(lead), byte(trail))
+				// the NEC selected IBM extensions duplicate the IBM extensions, which are preferred
+				if index < 0 || shiftJISTable[index] == 0 || (code >= 0xed40 && code <= 0xeefc) {
+					continue
+				}
+				if _, ok := c.reverse[rune(shiftJISTable[index])]; !ok {
+					c.reverse[rune(shiftJISTable[index])] = code
+				}
+			}
+		}
+	})
+	if code, ok := c.reverse[r]; ok {
+		return append(dst, byte(code>>8), byte(code))
+	}
+	return append(dst, '?')
+}
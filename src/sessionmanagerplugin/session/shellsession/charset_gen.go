@@ -0,0 +1,153 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:build ignore
+// +build ignore
+
+// charset_gen generates charset_tables.go from the Unicode consortium mapping files.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const mappingsUrl = "https://www.unicode.org/Public/MAPPINGS/"
+
+var singleByteCharsets = []struct {
+	name        string
+	description string
+	path        string
+}{
+	{"iso885915", "ISO 8859-15", "ISO8859/8859-15.TXT"},
+	{"windows1250", "Windows code page 1250", "VENDORS/MICSFT/WINDOWS/CP1250.TXT"},
+	{"windows1251", "Windows code page 1251", "VENDORS/MICSFT/WINDOWS/CP1251.TXT"},
+	{"windows1252", "Windows code page 1252", "VENDORS/MICSFT/WINDOWS/CP1252.TXT"},
+}
+
+// readMapping returns the code to unicode mapping of a mapping file, lines read "0x8140	0x3000	# comment".
+func readMapping(path string) map[int]rune {
+	response, err := http.Get(mappingsUrl + path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	mapping := make(map[int]rune)
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(strings.SplitN(scanner.Text(), "#", 2)[0])
+		if len(fields) != 2 {
+			continue
+		}
+		code, err := strconv.ParseInt(fields[0], 0, 32)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r, err := strconv.ParseInt(fields[1], 0, 32)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mapping[int(code)] = rune(r)
+	}
+	if err = scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return mapping
+}
+
+func writeTable(buf *bytes.Buffer, values []rune) {
+	for i, value := range values {
+		if i%16 == 0 {
+			buf.WriteString("\n\t")
+		} else {
+			buf.WriteString(" ")
+		}
+		fmt.Fprintf(buf, "0x%04x,", value)
+	}
+	buf.WriteString("\n}\n")
+}
+
+func main() {
+	var buf bytes.Buffer
+	buf.WriteString(`// Code generated by charset_gen.go. DO NOT EDIT.
+
+// Package shellsession starts shell session.
+package shellsession
+`)
+
+	for _, charset := range singleByteCharsets {
+		mapping := readMapping(charset.path)
+		values := make([]rune, 128)
+		for i := range values {
+			values[i] = utf8.RuneError
+			if r, ok := mapping[0x80+i]; ok {
+				values[i] = r
+			}
+		}
+		fmt.Fprintf(&buf, "\n// %s maps the bytes 0x80 to 0xff of %s to unicode\nvar %s = [128]rune{", charset.name, charset.description, charset.name)
+		writeTable(&buf, values)
+	}
+
+	mapping := readMapping("VENDORS/MICSFT/WINDOWS/CP932.TXT")
+	values := make([]rune, shiftJISTableSize)
+	for code, r := range mapping {
+		if code > 0xff {
+			if index := shiftJISIndex(byte(code>>8), byte(code)); index >= 0 {
+				values[index] = r
+			}
+		}
+	}
+	fmt.Fprintf(&buf, "\n// shiftJISTable maps the double byte codes of Windows code page 932 to unicode, indexed by shiftJISIndex\nvar shiftJISTable = [%d]uint16{", shiftJISTableSize)
+	writeTable(&buf, values)
+
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = ioutil.WriteFile("charset_tables.go", source, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// shiftJISTableSize and shiftJISIndex match charset.go
+const shiftJISTableSize = 60 * 188
+
+func shiftJISIndex(lead byte, trail byte) int {
+	var row, column int
+	switch {
+	case lead >= 0x81 && lead <= 0x9f:
+		row = int(lead) - 0x81
+	case lead >= 0xe0 && lead <= 0xfc:
+		row = int(lead) - 0xc1
+	default:
+		return -1
+	}
+	switch {
+	case trail >= 0x40 && trail <= 0x7e:
+		column = int(trail) - 0x40
+	case trail >= 0x80 && trail <= 0xfc:
+		column = int(trail) - 0x41
+	default:
+		return -1
+	}
+	return row*188 + column
+}
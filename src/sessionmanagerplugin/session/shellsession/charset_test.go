@@ -0,0 +1,103 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"os"
+	"testing"
+
+	"github.com/aws/session-manager-plugin/src/message"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func transcodeWith(t *testing.T, from string, to string, input ...[]byte) string {
+	fromCharset, err := LookupCharset(from)
+	assert.Nil(t, err)
+	toCharset, err := LookupCharset(to)
+	assert.Nil(t, err)
+
+	converter := newTranscoder(fromCharset, toCharset)
+	var output []byte
+	for _, part := range input {
+		output = append(output, converter.transcode(part)...)
+	}
+	return string(output)
+}
+
+func TestLookupCharset(t *testing.T) {
+	for _, name := range []string{"UTF-8", "ISO-8859-1", "latin1", "Windows-1252", "cp1251", "Shift_JIS", "SJIS"} {
+		_, err := LookupCharset(name)
+		assert.Nil(t, err, name)
+	}
+	_, err := LookupCharset("EBCDIC")
+	assert.Contains(t, err.Error(), "unsupported encoding")
+}
+
+func TestTranscodeSingleByteCharsets(t *testing.T) {
+	assert.Equal(t, "café €5", transcodeWith(t, "windows-1252", "utf-8", []byte("caf\xe9 \x805")))
+	assert.Equal(t, "caf\xe9 ?5", transcodeWith(t, "utf-8", "latin1", []byte("café €5")))
+	assert.Equal(t, "Привет", transcodeWith(t, "windows-1251", "utf-8", []byte("\xcf\xf0\xe8\xe2\xe5\xf2")))
+	assert.Equal(t, "ok �", transcodeWith(t, "ascii", "utf-8", []byte("ok \xff")))
+}
+
+func TestTranscodeShiftJIS(t *testing.T) {
+	// "日本語" and halfwidth "ｱ", the second character is split across two messages
+	sjis := []byte("\x93\xfa\x96\x7b\x8c\xea \xb1")
+	assert.Equal(t, "日本語 ｱ", transcodeWith(t, "shift_jis", "utf-8", sjis[:3], sjis[3:]))
+	assert.Equal(t, string(sjis), transcodeWith(t, "utf-8", "shift_jis", []byte("日本語 ｱ")))
+
+	// the IBM extension is preferred over its NEC selected duplicate
+	assert.Equal(t, "ⅰ", transcodeWith(t, "cp932", "utf-8", []byte("\xee\xef")))
+	assert.Equal(t, "\xfa\x40", transcodeWith(t, "utf-8", "cp932", []byte("ⅰ")))
+
+	// an invalid trail byte does not swallow ASCII
+	assert.Equal(t, "� A", transcodeWith(t, "sjis", "utf-8", []byte("\x81"), []byte(" A")))
+}
+
+func TestTranscodeKeepsSplitUTF8Sequences(t *testing.T) {
+	converter := newTranscoder(utf8Charset{}, utf8Charset{})
+	assert.Equal(t, "a", string(converter.transcode([]byte("a\xe2\x82"))))
+	assert.Equal(t, "€b", string(converter.transcode([]byte("\xacb"))))
+}
+
+func TestLocalCharset(t *testing.T) {
+	for _, variable := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		original, ok := os.LookupEnv(variable)
+		if ok {
+			defer os.Setenv(variable, original)
+		} else {
+			defer os.Unsetenv(variable)
+		}
+		os.Unsetenv(variable)
+	}
+
+	assert.Equal(t, utf8Charset{}, localCharset())
+	os.Setenv("LANG", "ja_JP.SJIS")
+	_, ok := localCharset().(*shiftJISCharset)
+	assert.True(t, ok)
+	os.Setenv("LC_ALL", "C")
+	assert.Equal(t, utf8Charset{}, localCharset())
+}
+
+func TestProcessStreamMessagePayloadTranscodesOutput(t *testing.T) {
+	remoteCharset, _ := LookupCharset("latin1")
+	shellSession := ShellSession{outputTranscoder: newTranscoder(remoteCharset, utf8Charset{})}
+	shellSession.Session = session.Session{}
+
+	isReady, err := shellSession.ProcessStreamMessagePayload(logger, message.ClientMessage{Payload: []byte("caf\xe9\r\n")})
+	assert.True(t, isReady)
+	assert.Nil(t, err)
+}
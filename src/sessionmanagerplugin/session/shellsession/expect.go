@@ -0,0 +1,164 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+)
+
+const (
+	DefaultExpectTimeout = 30 * time.Second
+)
+
+// ExpectError is returned when the expected output is not received, it carries the transcript of the session.
+type ExpectError struct {
+	Pattern    string
+	Reason     string
+	Transcript string
+}
+
+func (e *ExpectError) Error() string {
+	return fmt.Sprintf("expected output matching %q was not received: %s\n\nTranscript:\n%s", e.Pattern, e.Reason, e.Transcript)
+}
+
+// Expect sends input to a shell session and waits for its output to match regular expressions.
+// It implements session.IShellAutomation, the given script is run once the shell session is ready.
+type Expect struct {
+	// Echo receives a copy of the shell output when set.
+	Echo io.Writer
+
+	script     func(log log.T, expect *Expect) error
+	send       func(input []byte) error
+	mutex      sync.Mutex
+	notify     chan struct{}
+	pending    []byte
+	transcript bytes.Buffer
+	closed     bool
+}
+
+// NewExpect returns an Expect that runs script against the shell session.
+func NewExpect(script func(log log.T, expect *Expect) error) *Expect {
+	return &Expect{
+		script: script,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Run runs the script, it is called by the shell session once it is ready.
+func (e *Expect) Run(log log.T, send func(input []byte) error) error {
+	e.send = send
+	return e.script(log, e)
+}
+
+// ProcessOutput records output of the shell to be matched.
+func (e *Expect) ProcessOutput(payload []byte) {
+	e.mutex.Lock()
+	e.pending = append(e.pending, payload...)
+	e.transcript.Write(payload)
+	if e.Echo != nil {
+		e.Echo.Write(payload)
+	}
+	e.mutex.Unlock()
+	e.wakeUp()
+}
+
+// Close marks the session as closed, pending and later expectations fail.
+func (e *Expect) Close() {
+	e.mutex.Lock()
+	e.closed = true
+	e.mutex.Unlock()
+	e.wakeUp()
+}
+
+// Send writes input to the shell as is.
+func (e *Expect) Send(input string) error {
+	if e.send == nil {
+		return errors.New("shell session is not ready")
+	}
+	e.mutex.Lock()
+	fmt.Fprintf(&e.transcript, "\n>>> send %q\n", input)
+	e.mutex.Unlock()
+	return e.send([]byte(input))
+}
+
+// SendLine writes input followed by a carriage return to the shell.
+func (e *Expect) SendLine(input string) error {
+	return e.Send(input + "\r")
+}
+
+// Expect waits until the output received since the last match contains pattern and returns the match
+// followed by its groups. Output up to the end of the match is consumed.
+func (e *Expect) Expect(pattern *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		e.mutex.Lock()
+		if indexes := pattern.FindSubmatchIndex(e.pending); indexes != nil {
+			groups := make([]string, len(indexes)/2)
+			for i := range groups {
+				if indexes[2*i] >= 0 {
+					groups[i] = string(e.pending[indexes[2*i]:indexes[2*i+1]])
+				}
+			}
+			e.pending = e.pending[indexes[1]:]
+			e.mutex.Unlock()
+			return groups, nil
+		}
+		closed := e.closed
+		e.mutex.Unlock()
+
+		if closed {
+			return nil, e.newExpectError(pattern, "session was closed")
+		}
+
+		select {
+		case <-e.notify:
+		case <-deadline.C:
+			return nil, e.newExpectError(pattern, fmt.Sprintf("timed out after %s", timeout))
+		}
+	}
+}
+
+// Transcript returns the output and input of the session so far.
+func (e *Expect) Transcript() string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.transcript.String()
+}
+
+// wakeUp notifies a waiting Expect call without blocking.
+func (e *Expect) wakeUp() {
+	select {
+	case e.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (e *Expect) newExpectError(pattern *regexp.Regexp, reason string) error {
+	return &ExpectError{
+		Pattern:    pattern.String(),
+		Reason:     reason,
+		Transcript: e.Transcript(),
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/message"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeShell answers each line sent to it with the given responses in order
+func fakeShell(expect *Expect, responses ...string) func(input []byte) error {
+	return func(input []byte) error {
+		if len(responses) > 0 && strings.HasSuffix(string(input), "\r") {
+			response := responses[0]
+			responses = responses[1:]
+			go expect.ProcessOutput([]byte(response))
+		}
+		return nil
+	}
+}
+
+func TestExpectMatchesAcrossOutputMessages(t *testing.T) {
+	expect := NewExpect(func(log log.T, expect *Expect) error {
+		go func() {
+			expect.ProcessOutput([]byte("Last login: today\r\nsh-4.2"))
+			expect.ProcessOutput([]byte("$ "))
+		}()
+		groups, err := expect.Expect(regexp.MustCompile(`(sh-[\d.]+)\$ `), time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"sh-4.2$ ", "sh-4.2"}, groups)
+		return nil
+	})
+	assert.Nil(t, expect.Run(logger, func(input []byte) error { return nil }))
+}
+
+func TestExpectTimeoutIncludesTranscript(t *testing.T) {
+	expect := NewExpect(func(log log.T, expect *Expect) error {
+		expect.ProcessOutput([]byte("Password: "))
+		_, err := expect.Expect(regexp.MustCompile(`\$ $`), 10*time.Millisecond)
+		return err
+	})
+	err := expect.Run(logger, func(input []byte) error { return nil })
+	assert.NotNil(t, err)
+	expectError, ok := err.(*ExpectError)
+	assert.True(t, ok)
+	assert.Contains(t, expectError.Reason, "timed out")
+	assert.Contains(t, expectError.Transcript, "Password: ")
+}
+
+func TestExpectFailsWhenSessionIsClosed(t *testing.T) {
+	expect := NewExpect(func(log log.T, expect *Expect) error {
+		go expect.Close()
+		_, err := expect.Expect(regexp.MustCompile(`done`), time.Second)
+		return err
+	})
+	err := expect.Run(logger, func(input []byte) error { return nil })
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "session was closed")
+}
+
+func TestScriptRun(t *testing.T) {
+	script, err := ParseScript(strings.NewReader(`
+# read the hostname
+timeout 1s
+sendline hostname
+expect /(?P<host>ip-[\d-]+)/
+sendline "echo ${host}"
+expect "ok" 500ms
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(script.Steps))
+
+	var sent []string
+	expect := NewExpect(script.Run)
+	shell := fakeShell(expect, "ip-10-0-0-1\r\n$ ", "ok\r\n$ ")
+	err = expect.Run(logger, func(input []byte) error {
+		sent = append(sent, string(input))
+		return shell(input)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"hostname\r", "echo ip-10-0-0-1\r"}, sent)
+	assert.Equal(t, "ip-10-0-0-1", script.Captures["host"])
+	assert.Equal(t, "ip-10-0-0-1", script.Captures["1"])
+}
+
+func TestScriptRunReportsFailedLine(t *testing.T) {
+	script, err := ParseScript(strings.NewReader("sendline whoami\nexpect /root/ 10ms\n"))
+	assert.Nil(t, err)
+
+	expect := NewExpect(script.Run)
+	err = expect.Run(logger, fakeShell(expect, "ssm-user\r\n"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "script failed at line 2")
+	assert.Contains(t, err.Error(), "ssm-user")
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	_, err := ParseScript(strings.NewReader("expect root\n"))
+	assert.Contains(t, err.Error(), "line 1")
+
+	_, err = ParseScript(strings.NewReader("sleep\n"))
+	assert.Contains(t, err.Error(), "invalid duration")
+
+	_, err = ParseScript(strings.NewReader("\nrun ls\n"))
+	assert.Contains(t, err.Error(), "line 2: unknown command \"run\"")
+}
+
+func TestProcessStreamMessagePayloadWithShellAutomation(t *testing.T) {
+	expect := NewExpect(nil)
+	shellSession := ShellSession{}
+	shellSession.Session = session.Session{ShellAutomation: expect}
+
+	isReady, err := shellSession.ProcessStreamMessagePayload(logger, message.ClientMessage{Payload: []byte("$ ")})
+	assert.True(t, isReady)
+	assert.Nil(t, err)
+	assert.Equal(t, "$ ", expect.Transcript())
+}
@@ -0,0 +1,291 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/session-manager-plugin/src/jsonutil"
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sdkutil"
+)
+
+const (
+	GuardrailActionBlock   = "block"
+	GuardrailActionConfirm = "confirm"
+)
+
+// GuardrailPolicy is the content of a guardrails policy file, for example
+//
+//	{"rules": [
+//	  {"name": "delete-root", "pattern": "rm\\s+-\\w*r\\w*\\s+/(\\s|$)", "action": "block"},
+//	  {"name": "shutdown", "pattern": "\\b(shutdown|reboot|halt)\\b", "action": "confirm",
+//	   "profiles": ["prod"], "targetTags": {"Environment": "production"}}
+//	]}
+type GuardrailPolicy struct {
+	Rules []GuardrailRule `json:"rules"`
+}
+
+// GuardrailRule matches a command line typed by the user.
+// A rule applies to every session unless it is scoped to targets, profiles or target tags, all given scopes must match.
+type GuardrailRule struct {
+	Name       string            `json:"name"`
+	Pattern    string            `json:"pattern"`
+	Action     string            `json:"action"`
+	Targets    []string          `json:"targets,omitempty"`
+	Profiles   []string          `json:"profiles,omitempty"`
+	TargetTags map[string]string `json:"targetTags,omitempty"`
+
+	regexp *regexp.Regexp
+}
+
+// getTargetTags returns the tags of an EC2 instance or managed instance.
+var getTargetTags = func(log log.T, targetId string) (map[string]string, error) {
+	sdkSession, err := sdkutil.GetDefaultSession()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	if strings.HasPrefix(targetId, "mi-") {
+		output, err := ssm.New(sdkSession).ListTagsForResource(&ssm.ListTagsForResourceInput{
+			ResourceId:   aws.String(targetId),
+			ResourceType: aws.String(ssm.ResourceTypeForTaggingManagedInstance),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range output.TagList {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		return tags, nil
+	}
+
+	// EC2 instance tags are read through the tagging api which needs the instance arn
+	identity, err := sts.New(sdkSession).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, err
+	}
+	callerArn, err := arn.Parse(aws.StringValue(identity.Arn))
+	if err != nil {
+		return nil, err
+	}
+	instanceArn := arn.ARN{
+		Partition: callerArn.Partition,
+		Service:   "ec2",
+		Region:    aws.StringValue(sdkSession.Config.Region),
+		AccountID: aws.StringValue(identity.Account),
+		Resource:  "instance/" + targetId,
+	}
+	output, err := resourcegroupstaggingapi.New(sdkSession).GetResources(&resourcegroupstaggingapi.GetResourcesInput{
+		ResourceARNList: []*string{aws.String(instanceArn.String())},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, resource := range output.ResourceTagMappingList {
+		for _, tag := range resource.Tags {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	return tags, nil
+}
+
+// LoadGuardrailPolicy reads and validates a guardrails policy file.
+func LoadGuardrailPolicy(path string) (*GuardrailPolicy, error) {
+	policy := &GuardrailPolicy{}
+	err := jsonutil.UnmarshalFile(path, policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid guardrails policy %s: %v", path, err)
+	}
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.Action != GuardrailActionBlock && rule.Action != GuardrailActionConfirm {
+			return nil, fmt.Errorf("guardrail rule %q has invalid action %q", rule.Name, rule.Action)
+		}
+		if rule.regexp, err = regexp.Compile(rule.Pattern); err != nil {
+			return nil, fmt.Errorf("guardrail rule %q has invalid pattern: %v", rule.Name, err)
+		}
+	}
+	return policy, nil
+}
+
+// RulesFor returns the rules that apply to a session with the target and profile.
+// Target tags are only looked up if a rule is scoped by tags.
+func (p *GuardrailPolicy) RulesFor(log log.T, targetId string, profile string) (rules []GuardrailRule, err error) {
+	var tags map[string]string
+	for _, rule := range p.Rules {
+		if len(rule.Targets) > 0 && !matchesAny(rule.Targets, targetId) {
+			continue
+		}
+		if len(rule.Profiles) > 0 && !matchesAny(rule.Profiles, profile) {
+			continue
+		}
+		if len(rule.TargetTags) > 0 {
+			if tags == nil {
+				if tags, err = getTargetTags(log, targetId); err != nil {
+					return nil, fmt.Errorf("unable to get tags of target %s for guardrails: %v", targetId, err)
+				}
+			}
+			if !matchesTags(rule.TargetTags, tags) {
+				continue
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// matchesAny reports whether value matches one of the glob patterns.
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesTags reports whether all wanted tags are present with the same value.
+func matchesTags(wanted map[string]string, tags map[string]string) bool {
+	for key, value := range wanted {
+		if tagValue, ok := tags[key]; !ok || tagValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// uncertainLineRule is held back for lines that cannot be checked against the rules, as the remote shell
+// changed them for example by history recall or completion.
+var uncertainLineRule = &GuardrailRule{Name: "uncertain-line", Action: GuardrailActionConfirm}
+
+// CommandGuard holds back Enter typed on the keyboard until the line passes the guardrail rules.
+// Lines matching a block rule are cancelled with Ctrl+C, lines matching a confirm rule and lines
+// the remote shell changed in ways the guard cannot follow are only submitted once the user
+// answers y to the confirmation prompt.
+type CommandGuard struct {
+	mutex   sync.Mutex
+	rules   []GuardrailRule
+	line    inputLine
+	pending *GuardrailRule
+	out     io.Writer
+}
+
+// NewCommandGuard returns a guard checking lines against rules, messages are written to out.
+func NewCommandGuard(rules []GuardrailRule, out io.Writer) *CommandGuard {
+	return &CommandGuard{rules: rules, out: out}
+}
+
+// ProcessInput returns the part of the keyboard input that may be sent to the agent.
+// It is safe to call from the keyboard loop and the control signal handler.
+// Input following a held back Enter is dropped.
+func (c *CommandGuard) ProcessInput(log log.T, input []byte) []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.pending != nil {
+		return c.processConfirmation(log, input)
+	}
+
+	forward := make([]byte, 0, len(input))
+	for _, b := range input {
+		if c.line.process(b) != inputEnter {
+			forward = append(forward, b)
+			continue
+		}
+
+		line := c.line.String()
+		rule := c.match(line)
+		if rule == nil && c.line.uncertain {
+			log.Infof("Command requires confirmation as it cannot be checked against the guardrail rules: %s", line)
+			fmt.Fprint(c.out, "\r\n[guardrails] The command was changed by the shell, for example by history recall, "+
+				"and cannot be checked. Run it? [y/N] ")
+			c.pending = uncertainLineRule
+			return forward
+		}
+		if rule == nil {
+			c.line.reset()
+			forward = append(forward, b)
+			continue
+		}
+
+		if rule.Action == GuardrailActionBlock {
+			log.Warnf("Command blocked by guardrail rule %s: %s", rule.Name, line)
+			fmt.Fprintf(c.out, "\r\n[guardrails] Command blocked by rule %q.\r\n", rule.Name)
+			c.line.reset()
+			return append(forward, keyCtrlC)
+		}
+		log.Infof("Command requires confirmation by guardrail rule %s: %s", rule.Name, line)
+		fmt.Fprintf(c.out, "\r\n[guardrails] Command matches rule %q. Run it? [y/N] ", rule.Name)
+		c.pending = rule
+		return forward
+	}
+	return forward
+}
+
+// awaitingConfirmation reports whether a line is held back until the user answers the confirmation prompt.
+func (c *CommandGuard) awaitingConfirmation() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.pending != nil
+}
+
+// processConfirmation submits or cancels the held back line depending on the answer.
+func (c *CommandGuard) processConfirmation(log log.T, input []byte) []byte {
+	if len(input) == 0 {
+		return input
+	}
+	rule := c.pending
+	line := c.line.String()
+	c.pending = nil
+	c.line.reset()
+
+	if input[0] == 'y' || input[0] == 'Y' {
+		log.Infof("Command confirmed for guardrail rule %s: %s", rule.Name, line)
+		fmt.Fprint(c.out, "y\r\n")
+		return []byte{keyEnter}
+	}
+	log.Infof("Command cancelled for guardrail rule %s: %s", rule.Name, line)
+	fmt.Fprint(c.out, "\r\n[guardrails] Command cancelled.\r\n")
+	return []byte{keyCtrlC}
+}
+
+// match returns the first rule matching the line, block rules take precedence over confirm rules.
+func (c *CommandGuard) match(line string) *GuardrailRule {
+	var confirm *GuardrailRule
+	for i := range c.rules {
+		rule := &c.rules[i]
+		if !rule.regexp.MatchString(line) {
+			continue
+		}
+		if rule.Action == GuardrailActionBlock {
+			return rule
+		}
+		if confirm == nil {
+			confirm = rule
+		}
+	}
+	return confirm
+}
@@ -0,0 +1,149 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/stretchr/testify/assert"
+)
+
+const testGuardrailPolicy = `{"rules": [
+	{"name": "delete-root", "pattern": "rm\\s+-rf\\s+/$", "action": "block"},
+	{"name": "shutdown", "pattern": "^shutdown", "action": "confirm", "targets": ["i-prod*"]},
+	{"name": "reboot", "pattern": "^reboot", "action": "confirm", "profiles": ["prod"], "targetTags": {"Environment": "production"}}
+]}`
+
+func loadTestGuardrailPolicy(t *testing.T) *GuardrailPolicy {
+	path := filepath.Join(t.TempDir(), "guardrails.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(testGuardrailPolicy), 0600))
+	policy, err := LoadGuardrailPolicy(path)
+	assert.Nil(t, err)
+	return policy
+}
+
+func TestInputLineEditing(t *testing.T) {
+	var line inputLine
+	for _, b := range []byte("ls -la /tpm\x7f\x7fmp\x1b[D\x1b[D\x01sudo \x05") {
+		assert.Equal(t, inputEdit, line.process(b))
+	}
+	assert.Equal(t, "sudo ls -la /tmp", line.String())
+	assert.False(t, line.uncertain)
+
+	for _, b := range []byte("\x17\x17") {
+		line.process(b)
+	}
+	assert.Equal(t, "sudo ls ", line.String())
+	assert.Equal(t, inputEnter, line.process(keyEnter))
+
+	line.reset()
+	for _, b := range []byte("git ch\t") {
+		line.process(b)
+	}
+	assert.True(t, line.uncertain)
+}
+
+func TestInputLineBracketedPaste(t *testing.T) {
+	var line inputLine
+	var kinds []int
+	for _, b := range []byte("\x1b[200~echo a\recho b\x1b[201~") {
+		if kind := line.process(b); kind != inputEdit {
+			kinds = append(kinds, kind)
+		}
+	}
+	assert.Equal(t, []int{inputPasteStart, inputPasteEnd}, kinds)
+	assert.Equal(t, "echo a\necho b", line.String())
+	assert.Equal(t, inputEnter, line.process(keyEnter))
+}
+
+func TestRulesForScopesRules(t *testing.T) {
+	policy := loadTestGuardrailPolicy(t)
+	tagLookups := 0
+	originalGetTargetTags := getTargetTags
+	defer func() { getTargetTags = originalGetTargetTags }()
+	getTargetTags = func(log log.T, targetId string) (map[string]string, error) {
+		tagLookups++
+		return map[string]string{"Environment": "production"}, nil
+	}
+
+	rules, err := policy.RulesFor(logger, "i-dev123", "default")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(rules))
+	assert.Equal(t, 0, tagLookups)
+
+	rules, err = policy.RulesFor(logger, "i-prod123", "prod")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(rules))
+	assert.Equal(t, 1, tagLookups)
+}
+
+func TestLoadGuardrailPolicyRejectsInvalidRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guardrails.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"rules": [{"name": "any", "pattern": ".", "action": "warn"}]}`), 0600))
+	_, err := LoadGuardrailPolicy(path)
+	assert.Contains(t, err.Error(), "invalid action")
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"rules": [{"name": "any", "pattern": "(", "action": "block"}]}`), 0600))
+	_, err = LoadGuardrailPolicy(path)
+	assert.Contains(t, err.Error(), "invalid pattern")
+
+	_, err = LoadGuardrailPolicy(filepath.Join(t.TempDir(), "missing.json"))
+	assert.NotNil(t, err)
+}
+
+func TestCommandGuardBlocksCommand(t *testing.T) {
+	var out bytes.Buffer
+	guard := NewCommandGuard(loadTestGuardrailPolicy(t).Rules[:1], &out)
+
+	assert.Equal(t, []byte("ls\r"), guard.ProcessInput(logger, []byte("ls\r")))
+	assert.Equal(t, []byte("rm -rf /\x03"), guard.ProcessInput(logger, []byte("rm -rf /\r")))
+	assert.Contains(t, out.String(), `blocked by rule "delete-root"`)
+	assert.Equal(t, "", guard.line.String())
+}
+
+func TestCommandGuardConfirmsCommand(t *testing.T) {
+	var out bytes.Buffer
+	guard := NewCommandGuard(loadTestGuardrailPolicy(t).Rules[1:2], &out)
+
+	assert.Equal(t, []byte("shutdown now"), guard.ProcessInput(logger, []byte("shutdown now\rls\r")))
+	assert.Contains(t, out.String(), "[y/N]")
+	assert.Equal(t, []byte{keyEnter}, guard.ProcessInput(logger, []byte("y")))
+
+	assert.Equal(t, []byte("shutdown -r"), guard.ProcessInput(logger, []byte("shutdown -r\r")))
+	assert.Equal(t, []byte{keyCtrlC}, guard.ProcessInput(logger, []byte("\r")))
+	assert.Contains(t, out.String(), "Command cancelled")
+	assert.Equal(t, []byte("ls\r"), guard.ProcessInput(logger, []byte("ls\r")))
+}
+
+func TestCommandGuardConfirmsRecalledCommand(t *testing.T) {
+	var out bytes.Buffer
+	guard := NewCommandGuard(loadTestGuardrailPolicy(t).Rules[:1], &out)
+
+	assert.Equal(t, []byte("rm -rf /\x03"), guard.ProcessInput(logger, []byte("rm -rf /\r")))
+
+	// the blocked command recalled from the shell history is not known to the guard
+	assert.Equal(t, []byte("\x1b[A"), guard.ProcessInput(logger, []byte("\x1b[A\r")))
+	assert.Contains(t, out.String(), "cannot be checked")
+	assert.Equal(t, []byte{keyCtrlC}, guard.ProcessInput(logger, []byte("n")))
+	assert.Contains(t, out.String(), "Command cancelled")
+
+	assert.Equal(t, []byte("\x1b[A"), guard.ProcessInput(logger, []byte("\x1b[A\r")))
+	assert.Equal(t, []byte{keyEnter}, guard.ProcessInput(logger, []byte("y")))
+	assert.Equal(t, []byte("ls\r"), guard.ProcessInput(logger, []byte("ls\r")))
+}
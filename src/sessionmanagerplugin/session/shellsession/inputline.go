@@ -0,0 +1,205 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Control characters and escape sequences interpreted by the input line model
+const (
+	keyCtrlA     = 0x01
+	keyCtrlB     = 0x02
+	keyCtrlC     = 0x03
+	keyCtrlD     = 0x04
+	keyCtrlE     = 0x05
+	keyCtrlF     = 0x06
+	keyBackspace = 0x08
+	keyTab       = 0x09
+	keyLineFeed  = 0x0a
+	keyCtrlK     = 0x0b
+	keyEnter     = 0x0d
+	keyCtrlU     = 0x15
+	keyCtrlW     = 0x17
+	keyEscape    = 0x1b
+	keyDelete    = 0x7f
+
+	bracketedPasteStart = "[200~"
+	bracketedPasteEnd   = "[201~"
+)
+
+// inputLine models the line being edited in the remote shell from the keys typed locally.
+// The model follows readline style editing; changes made by the remote shell itself such as
+// history recall or completion cannot be followed, the line is then marked uncertain.
+type inputLine struct {
+	runes     []rune
+	cursor    int
+	uncertain bool
+	pasting   bool
+	escape    []byte
+	partial   []byte
+}
+
+// Input kinds returned by process
+const (
+	inputEdit = iota
+	inputEnter
+	inputPasteStart
+	inputPasteEnd
+)
+
+// String returns the modeled content of the line.
+func (l *inputLine) String() string {
+	return string(l.runes)
+}
+
+// reset clears the line after it was submitted or cancelled.
+func (l *inputLine) reset() {
+	l.runes = l.runes[:0]
+	l.cursor = 0
+	l.uncertain = false
+	l.partial = nil
+}
+
+// process updates the line with one input byte and reports Enter and bracketed paste boundaries.
+func (l *inputLine) process(b byte) int {
+	if l.escape != nil {
+		return l.processEscape(b)
+	}
+	if len(l.partial) > 0 || b >= utf8.RuneSelf {
+		l.partial = append(l.partial, b)
+		if utf8.FullRune(l.partial) {
+			r, _ := utf8.DecodeRune(l.partial)
+			l.partial = nil
+			l.insert(r)
+		}
+		return inputEdit
+	}
+
+	switch b {
+	case keyEscape:
+		l.escape = []byte{}
+	case keyEnter, keyLineFeed:
+		if l.pasting {
+			// pasted line breaks are inserted into the line, the shell does not run them
+			l.insert('\n')
+			return inputEdit
+		}
+		return inputEnter
+	case keyBackspace, keyDelete:
+		if l.cursor > 0 {
+			l.runes = append(l.runes[:l.cursor-1], l.runes[l.cursor:]...)
+			l.cursor--
+		}
+	case keyCtrlA:
+		l.cursor = 0
+	case keyCtrlE:
+		l.cursor = len(l.runes)
+	case keyCtrlB:
+		l.moveCursor(-1)
+	case keyCtrlF:
+		l.moveCursor(1)
+	case keyCtrlU:
+		l.runes = append(l.runes[:0], l.runes[l.cursor:]...)
+		l.cursor = 0
+	case keyCtrlK:
+		l.runes = l.runes[:l.cursor]
+	case keyCtrlW:
+		start := l.cursor
+		for start > 0 && unicode.IsSpace(l.runes[start-1]) {
+			start--
+		}
+		for start > 0 && !unicode.IsSpace(l.runes[start-1]) {
+			start--
+		}
+		l.runes = append(l.runes[:start], l.runes[l.cursor:]...)
+		l.cursor = start
+	case keyCtrlC, keyCtrlD:
+		l.reset()
+	case keyTab:
+		if l.pasting {
+			l.insert('\t')
+		} else {
+			// completion is done by the remote shell
+			l.uncertain = true
+		}
+	default:
+		if b >= 0x20 {
+			l.insert(rune(b))
+		}
+	}
+	return inputEdit
+}
+
+// processEscape handles the bytes of an escape sequence once ESC was received.
+func (l *inputLine) processEscape(b byte) int {
+	l.escape = append(l.escape, b)
+	if len(l.escape) == 1 && b != '[' && b != 'O' {
+		// Alt+key, readline may edit the line in ways that are not modeled
+		l.escape = nil
+		l.uncertain = true
+		return inputEdit
+	}
+	// CSI and SS3 sequences end with a byte in the range 0x40-0x7e
+	if len(l.escape) == 1 || b < 0x40 || b > 0x7e {
+		return inputEdit
+	}
+
+	sequence := string(l.escape)
+	l.escape = nil
+	switch sequence {
+	case bracketedPasteStart:
+		l.pasting = true
+		return inputPasteStart
+	case bracketedPasteEnd:
+		l.pasting = false
+		return inputPasteEnd
+	case "[D", "OD":
+		l.moveCursor(-1)
+	case "[C", "OC":
+		l.moveCursor(1)
+	case "[H", "OH", "[1~", "[7~":
+		l.cursor = 0
+	case "[F", "OF", "[4~", "[8~":
+		l.cursor = len(l.runes)
+	case "[3~":
+		if l.cursor < len(l.runes) {
+			l.runes = append(l.runes[:l.cursor], l.runes[l.cursor+1:]...)
+		}
+	default:
+		// history recall and other keys change the line on the remote side only
+		l.uncertain = true
+	}
+	return inputEdit
+}
+
+// insert adds a character at the cursor.
+func (l *inputLine) insert(r rune) {
+	l.runes = append(l.runes, 0)
+	copy(l.runes[l.cursor+1:], l.runes[l.cursor:])
+	l.runes[l.cursor] = r
+	l.cursor++
+}
+
+// moveCursor moves the cursor by offset within the line.
+func (l *inputLine) moveCursor(offset int) {
+	l.cursor += offset
+	if l.cursor < 0 {
+		l.cursor = 0
+	} else if l.cursor > len(l.runes) {
+		l.cursor = len(l.runes)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+)
+
+const (
+	// LocalEchoMinRoundTripTime is the round trip time from which typed characters are predicted
+	LocalEchoMinRoundTripTime = 50 * time.Millisecond
+	// LocalEchoMinTimeout and LocalEchoMaxTimeout bound the wait for the echo of a prediction
+	LocalEchoMinTimeout = 500 * time.Millisecond
+	LocalEchoMaxTimeout = 5 * time.Second
+
+	// localEchoMaxKeystroke is the longest input treated as typed keys rather than pasted text
+	localEchoMaxKeystroke = 8
+
+	underlineOn  = "\x1b[4m"
+	underlineOff = "\x1b[24m"
+)
+
+// localEcho predicts the echo of printable characters typed into the shell and shows them underlined
+// until the shell output confirms them. Every line starts without predictions until the shell echoed
+// a typed character, so that nothing typed at a password prompt is shown. Predictions are suspended
+// until the next line when the output differs or does not arrive, and while a full screen application runs.
+type localEcho struct {
+	mutex     sync.Mutex
+	out       io.Writer
+	roundTrip func() time.Duration
+	scanner   outputScanner
+	// pending holds the predicted characters not yet echoed by the shell
+	pending []byte
+	// epoch changes whenever pending predictions are confirmed or dropped
+	epoch     int
+	blocked   bool
+	suspended bool
+	// echoed is set once the shell echoed a character typed on the current line, unconfirmed holds the
+	// characters typed before that
+	echoed      bool
+	unconfirmed []byte
+}
+
+// newLocalEcho returns a predictor writing to out, roundTrip returns the current round trip time.
+func newLocalEcho(out io.Writer, roundTrip func() time.Duration) *localEcho {
+	return &localEcho{out: out, roundTrip: roundTrip}
+}
+
+// isKeystroke returns whether the input consists of a few printable ASCII characters.
+func isKeystroke(input []byte) bool {
+	if len(input) == 0 || len(input) > localEchoMaxKeystroke {
+		return false
+	}
+	for _, b := range input {
+		if b < 0x20 || b > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// processInput shows the prediction of input sent to the shell.
+func (e *localEcho) processInput(log log.T, input []byte) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !isKeystroke(input) {
+		// the cursor position is unknown until the shell answered keys such as enter, tab or arrows
+		e.blocked = true
+		e.unconfirmed = nil
+		for _, b := range input {
+			if b == keyEnter {
+				e.suspended = false
+				e.echoed = false
+			}
+		}
+		return
+	}
+	if e.blocked || e.suspended || e.scanner.alternateScreen || !e.scanner.betweenSequences() {
+		return
+	}
+	if !e.echoed {
+		e.unconfirmed = append(e.unconfirmed, input...)
+		return
+	}
+	roundTrip := e.roundTrip()
+	if roundTrip < LocalEchoMinRoundTripTime {
+		return
+	}
+
+	if len(e.pending) == 0 {
+		timeout := 3 * roundTrip
+		if timeout < LocalEchoMinTimeout {
+			timeout = LocalEchoMinTimeout
+		} else if timeout > LocalEchoMaxTimeout {
+			timeout = LocalEchoMaxTimeout
+		}
+		epoch := e.epoch
+		time.AfterFunc(timeout, func() { e.expire(log, epoch) })
+	}
+	e.pending = append(e.pending, input...)
+	fmt.Fprintf(e.out, "%s%s%s", underlineOn, input, underlineOff)
+}
+
+// expire drops predictions the shell did not echo in time.
+func (e *localEcho) expire(log log.T, epoch int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.epoch != epoch || len(e.pending) == 0 {
+		return
+	}
+	log.Debugf("Local echo suspended, %d predicted characters were not echoed", len(e.pending))
+	io.WriteString(e.out, e.erase())
+	e.drop()
+	e.suspended = true
+}
+
+// erase returns the sequence moving the cursor back over the predictions and clearing them.
+func (e *localEcho) erase() string {
+	return fmt.Sprintf("\x1b[%dD\x1b[K", len(e.pending))
+}
+
+// drop forgets the pending predictions.
+func (e *localEcho) drop() {
+	e.pending = nil
+	e.epoch++
+}
+
+// display reconciles the predictions with the shell output and shows the output.
+func (e *localEcho) display(log log.T, output []byte, display func(output []byte)) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.blocked = false
+	if len(e.pending) == 0 {
+		e.confirmEcho(output)
+		e.scanner.scan(output)
+		display(output)
+		return
+	}
+
+	matched := 0
+	for matched < len(output) && matched < len(e.pending) && output[matched] == e.pending[matched] {
+		matched++
+	}
+	// the output is written over the predictions from their start
+	moveBack := fmt.Sprintf("\x1b[%dD", len(e.pending))
+	var reconciled []byte
+	switch {
+	case matched == len(e.pending):
+		reconciled = append([]byte(moveBack), output...)
+		e.drop()
+	case matched == len(output):
+		reconciled = append([]byte(moveBack), output...)
+		reconciled = append(reconciled, underlineOn...)
+		reconciled = append(reconciled, e.pending[matched:]...)
+		reconciled = append(reconciled, underlineOff...)
+		e.pending = e.pending[matched:]
+	default:
+		log.Debugf("Local echo suspended, the output does not match %d predicted characters", len(e.pending))
+		reconciled = append([]byte(e.erase()), output...)
+		e.drop()
+		e.suspended = true
+	}
+	e.scanner.scan(output)
+	display(reconciled)
+}
+
+// confirmEcho turns predictions on for the current line once output starts with the echo of the characters
+// typed before, any other output requires new characters to be echoed.
+func (e *localEcho) confirmEcho(output []byte) {
+	if e.echoed || len(e.unconfirmed) == 0 || len(output) == 0 {
+		return
+	}
+	n := len(output)
+	if n > len(e.unconfirmed) {
+		n = len(e.unconfirmed)
+	}
+	if bytes.Equal(output[:n], e.unconfirmed[:n]) {
+		e.echoed = true
+	}
+	e.unconfirmed = nil
+}
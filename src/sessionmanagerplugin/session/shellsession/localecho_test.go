@@ -0,0 +1,158 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is written by the keyboard, the output and the expiry of predictions.
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) take() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	defer b.buf.Reset()
+	return b.buf.String()
+}
+
+func newTestLocalEcho(roundTrip time.Duration) (*localEcho, *syncBuffer) {
+	out := &syncBuffer{}
+	return newLocalEcho(out, func() time.Duration { return roundTrip }), out
+}
+
+// confirmTestEcho has the shell echo a typed character so that the following ones are predicted.
+func confirmTestEcho(t *testing.T, echo *localEcho, out *syncBuffer) {
+	echo.processInput(logger, []byte("c"))
+	assert.Empty(t, out.take())
+	echo.display(logger, []byte("c"), func(output []byte) { out.Write(output) })
+	assert.Equal(t, "c", out.take())
+}
+
+func TestLocalEchoConfirmsPredictions(t *testing.T) {
+	echo, out := newTestLocalEcho(300 * time.Millisecond)
+	display := func(output []byte) { out.Write(output) }
+	confirmTestEcho(t, echo, out)
+
+	echo.processInput(logger, []byte("l"))
+	echo.processInput(logger, []byte("s"))
+	assert.Equal(t, "\x1b[4ml\x1b[24m\x1b[4ms\x1b[24m", out.take())
+
+	// the echo of the first character arrives, the second stays underlined
+	echo.display(logger, []byte("l"), display)
+	assert.Equal(t, "\x1b[2Dl\x1b[4ms\x1b[24m", out.take())
+
+	echo.display(logger, []byte("s"), display)
+	assert.Equal(t, "\x1b[1Ds", out.take())
+	assert.Empty(t, echo.pending)
+
+	// enter is not predicted and blocks predictions until the shell answered
+	echo.processInput(logger, []byte("\r"))
+	echo.processInput(logger, []byte("x"))
+	assert.Empty(t, out.take())
+	echo.display(logger, []byte("\r\nfile\r\n$ "), display)
+	assert.Equal(t, "\r\nfile\r\n$ ", out.take())
+	confirmTestEcho(t, echo, out)
+	echo.processInput(logger, []byte("x"))
+	assert.Equal(t, "\x1b[4mx\x1b[24m", out.take())
+}
+
+func TestLocalEchoHidesUnechoedLine(t *testing.T) {
+	echo, out := newTestLocalEcho(300 * time.Millisecond)
+	display := func(output []byte) { out.Write(output) }
+	confirmTestEcho(t, echo, out)
+
+	// the password typed at the prompt of the next line is not shown
+	echo.processInput(logger, []byte("\r"))
+	echo.display(logger, []byte("\r\nPassword: "), display)
+	for _, key := range []string{"s", "e", "c", "r", "e", "t"} {
+		echo.processInput(logger, []byte(key))
+	}
+	assert.Equal(t, "\r\nPassword: ", out.take())
+
+	// output that is not the echo of the typed characters does not turn predictions on
+	echo.processInput(logger, []byte("x"))
+	echo.display(logger, []byte("\a"), display)
+	echo.processInput(logger, []byte("y"))
+	assert.Equal(t, "\a", out.take())
+	assert.Empty(t, echo.pending)
+}
+
+func TestLocalEchoSuspendsOnMismatch(t *testing.T) {
+	echo, out := newTestLocalEcho(300 * time.Millisecond)
+	display := func(output []byte) { out.Write(output) }
+	confirmTestEcho(t, echo, out)
+
+	echo.processInput(logger, []byte("v"))
+	echo.display(logger, []byte("\x1b[?1049h\x1b[H"), display)
+	assert.Equal(t, "\x1b[4mv\x1b[24m\x1b[1D\x1b[K\x1b[?1049h\x1b[H", out.take())
+
+	// a full screen application keeps predictions off after enter
+	echo.processInput(logger, []byte("\r"))
+	echo.display(logger, []byte("~"), display)
+	echo.processInput(logger, []byte("i"))
+	assert.Equal(t, "~", out.take())
+
+	echo.display(logger, []byte("\x1b[?1049l$ "), display)
+	assert.Equal(t, "\x1b[?1049l$ ", out.take())
+	confirmTestEcho(t, echo, out)
+	echo.processInput(logger, []byte("i"))
+	assert.Equal(t, "\x1b[4mi\x1b[24m", out.take())
+}
+
+func TestLocalEchoSuspendsWithoutEcho(t *testing.T) {
+	echo, out := newTestLocalEcho(300 * time.Millisecond)
+	confirmTestEcho(t, echo, out)
+
+	// a password prompt does not echo, the prediction is removed after the timeout
+	echo.processInput(logger, []byte("s"))
+	time.Sleep(3*300*time.Millisecond + 200*time.Millisecond)
+	assert.Equal(t, "\x1b[4ms\x1b[24m\x1b[1D\x1b[K", out.take())
+
+	echo.processInput(logger, []byte("e"))
+	assert.Empty(t, out.take())
+	echo.processInput(logger, []byte("\r"))
+	echo.display(logger, []byte("\r\n$ "), func(output []byte) { out.Write(output) })
+	assert.Equal(t, "\r\n$ ", out.take())
+	confirmTestEcho(t, echo, out)
+	echo.processInput(logger, []byte("e"))
+	assert.Equal(t, "\x1b[4me\x1b[24m", out.take())
+}
+
+func TestLocalEchoOnlyForHighLatency(t *testing.T) {
+	echo, out := newTestLocalEcho(10 * time.Millisecond)
+	echo.processInput(logger, []byte("l"))
+	assert.Empty(t, out.take())
+
+	echo, out = newTestLocalEcho(300 * time.Millisecond)
+	confirmTestEcho(t, echo, out)
+	echo.processInput(logger, []byte("pasted text longer than keys"))
+	echo.processInput(logger, []byte("é"))
+	assert.Empty(t, out.take())
+}
@@ -0,0 +1,229 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/message"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/sessionutil"
+)
+
+// Frames written to mirror viewers start with the frame type and the big endian payload length.
+const (
+	// MirrorFrameSize carries the terminal size of the session as JSON message.SizeData
+	MirrorFrameSize byte = 's'
+	// MirrorFrameOutput carries shell output as displayed on the session terminal
+	MirrorFrameOutput byte = 'o'
+
+	// MirrorViewerBuffer is the number of frames queued for a viewer before it is disconnected as too slow
+	MirrorViewerBuffer = 1024
+	// DefaultWatchSocketMode only lets the session owner attach viewers
+	DefaultWatchSocketMode os.FileMode = 0600
+
+	mirrorFrameHeaderSize = 5
+	mirrorMaxFrameSize    = 1 << 20
+)
+
+// ParseWatchSocketMode parses the octal permissions of the mirror socket such as 0660.
+func ParseWatchSocketMode(value string) (os.FileMode, error) {
+	return sessionutil.ParseSocketMode(value)
+}
+
+// ReadMirrorFrame reads the next frame a session mirror writes to its viewers.
+func ReadMirrorFrame(reader io.Reader) (frameType byte, payload []byte, err error) {
+	header := make([]byte, mirrorFrameHeaderSize)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		return
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > mirrorMaxFrameSize {
+		return 0, nil, fmt.Errorf("mirror frame of %d bytes exceeds the limit", length)
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(reader, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	return header[0], payload, nil
+}
+
+// newMirrorFrame returns a frame of the given type.
+func newMirrorFrame(frameType byte, payload []byte) []byte {
+	frame := make([]byte, mirrorFrameHeaderSize, mirrorFrameHeaderSize+len(payload))
+	frame[0] = frameType
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+// sessionMirror copies the terminal size and output of a shell session to read-only viewers attached
+// to a unix socket. Anything viewers write is discarded.
+type sessionMirror struct {
+	path     string
+	mode     os.FileMode
+	mutex    sync.Mutex
+	listener net.Listener
+	viewers  map[*mirrorViewer]struct{}
+	size     []byte
+	closed   bool
+}
+
+// mirrorViewer is an attached viewer, frames are written from its queue so that a slow viewer
+// never holds up the session.
+type mirrorViewer struct {
+	conn   net.Conn
+	frames chan []byte
+}
+
+// newSessionMirror returns a mirror for the socket path, it accepts viewers once listening.
+func newSessionMirror(path string, mode os.FileMode) *sessionMirror {
+	return &sessionMirror{
+		path:    path,
+		mode:    mode,
+		viewers: make(map[*mirrorViewer]struct{}),
+	}
+}
+
+// listen creates the socket and accepts viewers until the mirror is closed.
+func (m *sessionMirror) listen(log log.T) (err error) {
+	var listener net.Listener
+	if listener, err = sessionutil.ListenUnixSocket(m.path, m.mode, -1, -1); err != nil {
+		return
+	}
+
+	m.mutex.Lock()
+	m.listener = listener
+	m.mutex.Unlock()
+	log.Infof("Shell session mirror is listening on %s", m.path)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			m.attach(log, conn)
+		}
+	}()
+	return
+}
+
+// attach adds a viewer and sends it the current terminal size.
+func (m *sessionMirror) attach(log log.T, conn net.Conn) {
+	viewer := &mirrorViewer{conn: conn, frames: make(chan []byte, MirrorViewerBuffer)}
+
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	if m.size != nil {
+		viewer.frames <- m.size
+	}
+	m.viewers[viewer] = struct{}{}
+	count := len(m.viewers)
+	m.mutex.Unlock()
+	log.Infof("Viewer attached to the shell session mirror, %d watching", count)
+
+	go func() {
+		for frame := range viewer.frames {
+			if _, err := conn.Write(frame); err != nil {
+				break
+			}
+		}
+		m.detach(viewer)
+	}()
+	go func() {
+		// viewers cannot send input, reading only notices when they leave
+		io.Copy(ioutil.Discard, conn)
+		m.detach(viewer)
+	}()
+}
+
+// detach removes the viewer and closes its connection.
+func (m *sessionMirror) detach(viewer *mirrorViewer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.detachLocked(viewer)
+}
+
+func (m *sessionMirror) detachLocked(viewer *mirrorViewer) {
+	if _, ok := m.viewers[viewer]; !ok {
+		return
+	}
+	delete(m.viewers, viewer)
+	close(viewer.frames)
+	viewer.conn.Close()
+}
+
+// broadcast queues the frame for every viewer, viewers that fall too far behind are disconnected.
+func (m *sessionMirror) broadcast(frame []byte) {
+	for viewer := range m.viewers {
+		select {
+		case viewer.frames <- frame:
+		default:
+			m.detachLocked(viewer)
+		}
+	}
+}
+
+// setSize sends the terminal size to the viewers and keeps it for viewers attaching later.
+func (m *sessionMirror) setSize(log log.T, sizeData message.SizeData) {
+	payload, err := json.Marshal(sizeData)
+	if err != nil {
+		log.Errorf("Cannot marshall size data: %v", err)
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.size = newMirrorFrame(MirrorFrameSize, payload)
+	m.broadcast(m.size)
+}
+
+// write sends shell output to the viewers.
+func (m *sessionMirror) write(output []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.viewers) > 0 {
+		m.broadcast(newMirrorFrame(MirrorFrameOutput, output))
+	}
+}
+
+// close disconnects the viewers and removes the socket.
+func (m *sessionMirror) close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.closed {
+		return
+	}
+	m.closed = true
+	for viewer := range m.viewers {
+		m.detachLocked(viewer)
+	}
+	if m.listener != nil {
+		m.listener.Close()
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/message"
+	"github.com/stretchr/testify/assert"
+)
+
+// attachViewer connects to the mirror and waits until the mirror has registered the viewer.
+func attachViewer(t *testing.T, mirror *sessionMirror) (net.Conn, *bufio.Reader) {
+	mirror.mutex.Lock()
+	count := len(mirror.viewers)
+	mirror.mutex.Unlock()
+	conn, err := net.Dial("unix", mirror.path)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		mirror.mutex.Lock()
+		attached := len(mirror.viewers) > count
+		mirror.mutex.Unlock()
+		if attached {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return conn, bufio.NewReader(conn)
+}
+
+func TestSessionMirrorSendsSizeAndOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watch.sock")
+	mirror := newSessionMirror(path, 0660)
+	assert.Nil(t, mirror.listen(logger))
+	defer mirror.close()
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0660), info.Mode().Perm())
+
+	mirror.write([]byte("not seen by anyone"))
+	mirror.setSize(logger, message.SizeData{Cols: 120, Rows: 40})
+	conn, reader := attachViewer(t, mirror)
+	defer conn.Close()
+
+	// viewers cannot send input, anything written is ignored
+	conn.Write([]byte("rm -rf /\r"))
+	mirror.write([]byte("$ ls\r\n"))
+
+	frameType, payload, err := ReadMirrorFrame(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, MirrorFrameSize, frameType)
+	assert.Equal(t, `{"cols":120,"rows":40}`, string(payload))
+
+	frameType, payload, err = ReadMirrorFrame(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, MirrorFrameOutput, frameType)
+	assert.Equal(t, "$ ls\r\n", string(payload))
+
+	mirror.close()
+	_, _, err = ReadMirrorFrame(reader)
+	assert.NotNil(t, err)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSessionMirrorDisconnectsSlowViewers(t *testing.T) {
+	mirror := newSessionMirror(filepath.Join(t.TempDir(), "watch.sock"), DefaultWatchSocketMode)
+	assert.Nil(t, mirror.listen(logger))
+	defer mirror.close()
+	conn, _ := attachViewer(t, mirror)
+	defer conn.Close()
+
+	// the viewer never reads, once the socket buffers and the queue are full it is dropped
+	output := make([]byte, 64*1024)
+	for i := 0; i < 4*MirrorViewerBuffer; i++ {
+		mirror.write(output)
+	}
+	mirror.mutex.Lock()
+	assert.Empty(t, mirror.viewers)
+	mirror.mutex.Unlock()
+}
+
+func TestSessionMirrorSocketPath(t *testing.T) {
+	dir := t.TempDir()
+
+	// a regular file is never replaced
+	path := filepath.Join(dir, "file")
+	ioutil.WriteFile(path, []byte("data"), 0600)
+	assert.Contains(t, newSessionMirror(path, DefaultWatchSocketMode).listen(logger).Error(), "not a socket")
+
+	// a socket in use belongs to another session, a stale one is replaced
+	path = filepath.Join(dir, "watch.sock")
+	first := newSessionMirror(path, DefaultWatchSocketMode)
+	assert.Nil(t, first.listen(logger))
+	assert.Contains(t, newSessionMirror(path, DefaultWatchSocketMode).listen(logger).Error(), "in use")
+	first.listener.Close()
+
+	second := newSessionMirror(path, DefaultWatchSocketMode)
+	assert.Nil(t, second.listen(logger))
+	second.close()
+}
+
+func TestParseWatchSocketMode(t *testing.T) {
+	mode, err := ParseWatchSocketMode("0660")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0660), mode)
+
+	for _, value := range []string{"rw", "0999", "4755"} {
+		_, err = ParseWatchSocketMode(value)
+		assert.NotNil(t, err, value)
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+)
+
+const (
+	// PasteChunkSize is the largest part of a paste sent in one message
+	PasteChunkSize = 512
+	// Pasted chunks are sent one round trip time apart, bounded by these intervals
+	PasteMinInterval = 5 * time.Millisecond
+	PasteMaxInterval = 200 * time.Millisecond
+	// Pastes of at least PasteProgressThreshold bytes show their progress
+	PasteProgressThreshold = 16 * 1024
+
+	bracketedPasteModeEnable  = "\x1b[?2004h"
+	bracketedPasteModeDisable = "\x1b[?2004l"
+)
+
+// inputSegment is a part of the keyboard input, either typed or pasted.
+type inputSegment struct {
+	data  []byte
+	paste bool
+}
+
+// pasteDetector separates bracketed pastes from typed keyboard input.
+type pasteDetector struct {
+	pasting bool
+	pending []byte
+	paste   []byte
+}
+
+// process splits the input into typed and pasted segments. Pasted content is only returned once
+// the end of the paste was read, markers split across reads are kept until the next read.
+func (p *pasteDetector) process(input []byte) (segments []inputSegment) {
+	data := append(p.pending, input...)
+	p.pending = nil
+	for len(data) > 0 {
+		marker := []byte("\x1b" + bracketedPasteStart)
+		if p.pasting {
+			marker = []byte("\x1b" + bracketedPasteEnd)
+		}
+
+		index := bytes.Index(data, marker)
+		if index < 0 {
+			if !p.pasting {
+				// terminals write the start marker together with the paste, a lone escape is a key press
+				return append(segments, inputSegment{data: data})
+			}
+			keep := partialMarkerLength(data, marker)
+			p.paste = append(p.paste, data[:len(data)-keep]...)
+			p.pending = append([]byte(nil), data[len(data)-keep:]...)
+			return
+		}
+
+		if p.pasting {
+			segments = append(segments, inputSegment{data: append(p.paste, data[:index]...), paste: true})
+			p.paste = nil
+		} else if index > 0 {
+			segments = append(segments, inputSegment{data: data[:index]})
+		}
+		p.pasting = !p.pasting
+		data = data[index+len(marker):]
+	}
+	return
+}
+
+// partialMarkerLength returns the length of the longest suffix of data that starts the marker.
+func partialMarkerLength(data []byte, marker []byte) int {
+	for length := len(marker) - 1; length > 0; length-- {
+		if len(data) >= length && bytes.Equal(data[len(data)-length:], marker[:length]) {
+			return length
+		}
+	}
+	return 0
+}
+
+// pasteHandler tracks the bracketed paste mode of the terminal. If confirmation of pastes is
+// configured the local terminal is kept in bracketed paste mode so that pastes are always detected,
+// the mode requested by the remote shell decides whether pastes are forwarded with markers.
+// Otherwise the requests of the remote shell reach the local terminal unchanged.
+type pasteHandler struct {
+	detector   pasteDetector
+	confirm    bool
+	localMode  int32
+	remoteMode int32
+	// tail is the end of the last output that may start a mode request, its last withheld bytes
+	// were not written to the terminal yet
+	tail     []byte
+	withheld int
+}
+
+// enableLocalMode switches the local terminal to bracketed paste mode if confirmation of pastes is configured.
+func (p *pasteHandler) enableLocalMode() {
+	if !p.confirm {
+		return
+	}
+	atomic.StoreInt32(&p.localMode, 1)
+	fmt.Fprint(os.Stdout, bracketedPasteModeEnable)
+}
+
+// disableLocalMode restores the local terminal if it was switched to bracketed paste mode.
+func (p *pasteHandler) disableLocalMode() {
+	if atomic.CompareAndSwapInt32(&p.localMode, 1, 0) {
+		fmt.Fprint(os.Stdout, bracketedPasteModeDisable)
+	}
+}
+
+// processOutput records the bracketed paste mode requested by the remote shell and, while the
+// local terminal is managed by the handler, removes the requests from the output. Requests split
+// across payloads are found with the tail of the previous payload.
+func (p *pasteHandler) processOutput(payload []byte) []byte {
+	data := payload
+	if len(p.tail) > 0 {
+		data = append(p.tail, payload...)
+	}
+	shown := len(p.tail) - p.withheld
+	enable := bytes.LastIndex(data, []byte(bracketedPasteModeEnable))
+	disable := bytes.LastIndex(data, []byte(bracketedPasteModeDisable))
+	if enable > disable {
+		atomic.StoreInt32(&p.remoteMode, 1)
+	} else if disable > enable {
+		atomic.StoreInt32(&p.remoteMode, 0)
+	}
+
+	keep := partialMarkerLength(data, []byte(bracketedPasteModeEnable))
+	if length := partialMarkerLength(data, []byte(bracketedPasteModeDisable)); length > keep {
+		keep = length
+	}
+	p.tail = append([]byte(nil), data[len(data)-keep:]...)
+	if p.withheld = 0; atomic.LoadInt32(&p.localMode) == 0 {
+		return data[shown:]
+	}
+	// a request may be completed by the next payload, so the tail is withheld until then
+	if p.withheld = keep; p.withheld > len(data)-shown {
+		p.withheld = len(data) - shown
+	}
+	data = bytes.Replace(data[shown:len(data)-p.withheld], []byte(bracketedPasteModeEnable), nil, -1)
+	return bytes.Replace(data, []byte(bracketedPasteModeDisable), nil, -1)
+}
+
+// remoteBracketedPaste reports whether the remote shell expects pastes with markers.
+func (p *pasteHandler) remoteBracketedPaste() bool {
+	return atomic.LoadInt32(&p.remoteMode) == 1
+}
+
+// handlePaste asks for confirmation of multi-line pastes if configured and sends the paste paced by
+// the round trip time, so that the remote terminal input buffer is not overrun.
+func (s *ShellSession) handlePaste(log log.T, reader *bufio.Reader, content []byte) error {
+	if lines := countLines(content); s.paste.confirm && lines > 1 {
+		fmt.Fprintf(os.Stdout, "\r\n[paste] Send %d lines (%d bytes)? [y/N] ", lines, len(content))
+		answer, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if answer != 'y' && answer != 'Y' {
+			log.Infof("Paste of %d bytes cancelled", len(content))
+			fmt.Fprint(os.Stdout, "\r\n[paste] Cancelled.\r\n")
+			return nil
+		}
+		fmt.Fprint(os.Stdout, "y\r\n")
+	}
+
+	if s.paste.remoteBracketedPaste() {
+		content = append(append([]byte("\x1b"+bracketedPasteStart), content...), "\x1b"+bracketedPasteEnd...)
+	}
+	log.Debugf("Sending paste of %d bytes", len(content))
+
+	showProgress := len(content) >= PasteProgressThreshold
+	lastPercent := -1
+	for sent := 0; sent < len(content); {
+		chunk := nextPasteChunk(content[sent:])
+		if err := s.sendKeyboardInput(log, chunk); err != nil {
+			return err
+		}
+		sent += len(chunk)
+
+		if s.commandGuard != nil && s.commandGuard.awaitingConfirmation() {
+			// input after a held back line is dropped by the guard, stop sending
+			log.Infof("Paste stopped by guardrails after %d of %d bytes", sent, len(content))
+			break
+		}
+		if showProgress {
+			if percent := sent * 100 / len(content); percent/5 != lastPercent/5 {
+				lastPercent = percent
+				s.showPasteProgress(fmt.Sprintf("[paste] %d%% (%d of %d bytes)", percent, sent, len(content)))
+			}
+		}
+		if sent < len(content) {
+			time.Sleep(s.pasteInterval())
+		}
+	}
+	if showProgress {
+		s.showPasteProgress("")
+	}
+	return nil
+}
+
+// countLines returns the number of lines in the content, which may end with CR, LF or CRLF.
+func countLines(content []byte) int {
+	breaks := bytes.Count(content, []byte{'\n'}) + bytes.Count(content, []byte{'\r'}) - bytes.Count(content, []byte("\r\n"))
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte{'\n'}) && !bytes.HasSuffix(content, []byte{'\r'}) {
+		return breaks + 1
+	}
+	return breaks
+}
+
+// nextPasteChunk returns the next part of the paste to send, ending at a line break where possible.
+func nextPasteChunk(content []byte) []byte {
+	if len(content) <= PasteChunkSize {
+		return content
+	}
+	if index := bytes.LastIndexAny(content[:PasteChunkSize], "\r\n"); index > 0 {
+		return content[:index+1]
+	}
+	return content[:PasteChunkSize]
+}
+
+// pasteInterval returns the pause between pasted chunks based on the observed round trip time.
+func (s *ShellSession) pasteInterval() time.Duration {
+	interval := s.DataChannel.GetRoundTripTime()
+	if interval < PasteMinInterval {
+		return PasteMinInterval
+	}
+	if interval > PasteMaxInterval {
+		return PasteMaxInterval
+	}
+	return interval
+}
+
+// showPasteProgress writes the progress on the last row of the shell without moving the cursor, an empty text clears it.
+func (s *ShellSession) showPasteProgress(text string) {
+	row := int(s.SizeData.Rows)
+	if s.statusLine != nil {
+		row = s.statusLine.shellRows()
+	}
+	if row == 0 {
+		row = 1
+	}
+	fmt.Fprintf(os.Stdout, "\x1b7\x1b[%d;1H\x1b[2K%s\x1b8", row, text)
+}
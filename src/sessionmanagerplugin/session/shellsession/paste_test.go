@@ -0,0 +1,122 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	dataChannelMock "github.com/aws/session-manager-plugin/src/datachannel/mocks"
+	"github.com/aws/session-manager-plugin/src/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPasteDetectorSplitsPastes(t *testing.T) {
+	var detector pasteDetector
+
+	segments := detector.process([]byte("ls\x1b[200~cat <<EOF\rline 1\r\x1b[20"))
+	assert.Equal(t, []inputSegment{{data: []byte("ls")}}, segments)
+
+	segments = detector.process([]byte("1~\x1b[A"))
+	assert.Equal(t, []inputSegment{
+		{data: []byte("cat <<EOF\rline 1\r"), paste: true},
+		{data: []byte("\x1b[A")},
+	}, segments)
+
+	// a lone escape key press is not held back
+	assert.Equal(t, []inputSegment{{data: []byte{keyEscape}}}, detector.process([]byte{keyEscape}))
+}
+
+func TestPasteHandlerTracksRemoteMode(t *testing.T) {
+	handler := &pasteHandler{}
+	output := []byte("\x1b[?2004h$ ")
+
+	assert.Equal(t, output, handler.processOutput(output))
+	assert.True(t, handler.remoteBracketedPaste())
+
+	handler.localMode = 1
+	assert.Equal(t, []byte("\r\n$ "), handler.processOutput([]byte("\x1b[?2004h\r\n\x1b[?2004l$ ")))
+	assert.False(t, handler.remoteBracketedPaste())
+}
+
+func TestPasteHandlerFindsSplitModeRequests(t *testing.T) {
+	handler := &pasteHandler{}
+
+	// the output is passed through while the local terminal is not managed
+	assert.Equal(t, "$ \x1b[?20", string(handler.processOutput([]byte("$ \x1b[?20"))))
+	assert.Equal(t, "04h", string(handler.processOutput([]byte("04h"))))
+	assert.True(t, handler.remoteBracketedPaste())
+
+	// the start of a request is withheld until the next payload while the local terminal is managed
+	handler.localMode = 1
+	assert.Equal(t, "\r\n", string(handler.processOutput([]byte("\r\n\x1b[?2004"))))
+	assert.Equal(t, "$ ", string(handler.processOutput([]byte("l$ "))))
+	assert.False(t, handler.remoteBracketedPaste())
+	assert.Equal(t, "", string(handler.processOutput([]byte("\x1b["))))
+	assert.Equal(t, "\x1b[1m", string(handler.processOutput([]byte("1m"))))
+}
+
+func TestPasteHandlerLocalModeOnlyIfConfigured(t *testing.T) {
+	handler := &pasteHandler{}
+	handler.enableLocalMode()
+	assert.Equal(t, int32(0), handler.localMode)
+	handler.disableLocalMode()
+}
+
+func TestCountLines(t *testing.T) {
+	assert.Equal(t, 0, countLines(nil))
+	assert.Equal(t, 1, countLines([]byte("ls")))
+	assert.Equal(t, 2, countLines([]byte("ls\r\npwd\r\n")))
+	assert.Equal(t, 3, countLines([]byte("a\rb\nc")))
+}
+
+func TestHandlePastePacesChunks(t *testing.T) {
+	mockChannel := &dataChannelMock.IDataChannel{}
+	mockChannel.On("GetRoundTripTime").Return(time.Duration(0))
+	var sent [][]byte
+	mockChannel.On("SendInputDataMessage", mock.Anything, message.Output, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		sent = append(sent, args.Get(2).([]byte))
+	})
+
+	shellSession := ShellSession{paste: &pasteHandler{remoteMode: 1}}
+	shellSession.DataChannel = mockChannel
+
+	content := []byte(strings.Repeat("echo 0123456789\r", 100))
+	assert.Nil(t, shellSession.handlePaste(logger, bufio.NewReader(strings.NewReader("")), content))
+
+	assert.True(t, len(sent) > 1)
+	joined := bytes.Join(sent, nil)
+	assert.Equal(t, "\x1b[200~"+string(content)+"\x1b[201~", string(joined))
+	for _, chunk := range sent {
+		assert.True(t, len(chunk) <= PasteChunkSize)
+	}
+}
+
+func TestHandlePasteConfirmation(t *testing.T) {
+	mockChannel := &dataChannelMock.IDataChannel{}
+	shellSession := ShellSession{paste: &pasteHandler{confirm: true}}
+	shellSession.DataChannel = mockChannel
+
+	assert.Nil(t, shellSession.handlePaste(logger, bufio.NewReader(strings.NewReader("n")), []byte("rm a\rrm b\r")))
+	mockChannel.AssertNotCalled(t, "SendInputDataMessage", mock.Anything, mock.Anything, mock.Anything)
+
+	mockChannel.On("SendInputDataMessage", mock.Anything, message.Output, mock.Anything).Return(nil)
+	assert.Nil(t, shellSession.handlePaste(logger, bufio.NewReader(strings.NewReader("y")), []byte("rm a\rrm b\r")))
+	mockChannel.AssertCalled(t, "SendInputDataMessage", mock.Anything, message.Output, []byte("rm a\rrm b\r"))
+}
@@ -0,0 +1,210 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/log"
+)
+
+// Script commands, one per line. Lines starting with # are comments.
+//
+//	timeout <duration>             sets the default timeout of following expect commands
+//	send <text>                    sends text, a double quoted text may contain Go escape sequences
+//	sendline <text>                sends text followed by a carriage return
+//	expect /<regexp>/ [duration]   waits for output matching the regular expression
+//	expect "<text>" [duration]     waits for output containing the text
+//	sleep <duration>               pauses the script
+//
+// Groups of the last match are available to send and sendline as ${1}, ${2}, ... and named groups as ${name}.
+const (
+	ScriptTimeout  = "timeout"
+	ScriptSend     = "send"
+	ScriptSendLine = "sendline"
+	ScriptExpect   = "expect"
+	ScriptSleep    = "sleep"
+)
+
+var scriptVariable = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// ScriptStep is a single parsed line of a script.
+type ScriptStep struct {
+	Line     int
+	Command  string
+	Text     string
+	Pattern  *regexp.Regexp
+	Duration time.Duration
+}
+
+// Script is a parsed script that can be run with an Expect.
+type Script struct {
+	Steps []ScriptStep
+
+	// Captures holds the groups of expect matches, by group number and name.
+	Captures map[string]string
+}
+
+// ParseScript reads a script, returning an error for the first invalid line.
+func ParseScript(reader io.Reader) (*Script, error) {
+	script := &Script{Captures: make(map[string]string)}
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		step, err := parseScriptLine(lineNumber, line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		script.Steps = append(script.Steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+// parseScriptLine parses a line into a step.
+func parseScriptLine(lineNumber int, line string) (step ScriptStep, err error) {
+	step.Line = lineNumber
+	fields := strings.SplitN(line, " ", 2)
+	step.Command = fields[0]
+	argument := ""
+	if len(fields) > 1 {
+		argument = strings.TrimSpace(fields[1])
+	}
+
+	switch step.Command {
+	case ScriptTimeout, ScriptSleep:
+		if step.Duration, err = time.ParseDuration(argument); err != nil {
+			return step, fmt.Errorf("invalid duration %q", argument)
+		}
+	case ScriptSend, ScriptSendLine:
+		if step.Text, err = parseScriptText(argument); err != nil {
+			return step, err
+		}
+	case ScriptExpect:
+		var rest string
+		if step.Pattern, rest, err = parseScriptPattern(argument); err != nil {
+			return step, err
+		}
+		if rest != "" {
+			if step.Duration, err = time.ParseDuration(rest); err != nil {
+				return step, fmt.Errorf("invalid duration %q", rest)
+			}
+		}
+	default:
+		return step, fmt.Errorf("unknown command %q", step.Command)
+	}
+	return step, nil
+}
+
+// parseScriptText returns the text of a send command, unquoting it if needed.
+func parseScriptText(argument string) (string, error) {
+	if strings.HasPrefix(argument, `"`) {
+		text, err := strconv.Unquote(argument)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted text %s", argument)
+		}
+		return text, nil
+	}
+	return argument, nil
+}
+
+// parseScriptPattern returns the pattern of an expect command and what follows it on the line.
+func parseScriptPattern(argument string) (pattern *regexp.Regexp, rest string, err error) {
+	switch {
+	case strings.HasPrefix(argument, "/"):
+		end := strings.LastIndex(argument, "/")
+		if end == 0 {
+			return nil, "", fmt.Errorf("regular expression %s is not terminated", argument)
+		}
+		if pattern, err = regexp.Compile(argument[1:end]); err != nil {
+			return nil, "", fmt.Errorf("invalid regular expression: %v", err)
+		}
+		return pattern, strings.TrimSpace(argument[end+1:]), nil
+	case strings.HasPrefix(argument, `"`):
+		text, err := strconv.QuotedPrefix(argument)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid quoted text %s", argument)
+		}
+		unquoted, _ := strconv.Unquote(text)
+		return regexp.MustCompile(regexp.QuoteMeta(unquoted)), strings.TrimSpace(argument[len(text):]), nil
+	default:
+		return nil, "", fmt.Errorf("expect requires a /regular expression/ or a \"quoted text\"")
+	}
+}
+
+// Run runs the script steps with expect and returns the first failure.
+func (s *Script) Run(log log.T, expect *Expect) (err error) {
+	timeout := DefaultExpectTimeout
+	for _, step := range s.Steps {
+		log.Debugf("Running script line %d: %s", step.Line, step.Command)
+		switch step.Command {
+		case ScriptTimeout:
+			timeout = step.Duration
+		case ScriptSleep:
+			time.Sleep(step.Duration)
+		case ScriptSend:
+			err = expect.Send(s.expand(step.Text))
+		case ScriptSendLine:
+			err = expect.SendLine(s.expand(step.Text))
+		case ScriptExpect:
+			stepTimeout := timeout
+			if step.Duration > 0 {
+				stepTimeout = step.Duration
+			}
+			var groups []string
+			if groups, err = expect.Expect(step.Pattern, stepTimeout); err == nil {
+				s.capture(step.Pattern, groups)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("script failed at line %d: %v", step.Line, err)
+		}
+	}
+	return nil
+}
+
+// capture stores the groups of a match by number and name.
+func (s *Script) capture(pattern *regexp.Regexp, groups []string) {
+	names := pattern.SubexpNames()
+	for i := 1; i < len(groups); i++ {
+		s.Captures[strconv.Itoa(i)] = groups[i]
+		if names[i] != "" {
+			s.Captures[names[i]] = groups[i]
+		}
+	}
+}
+
+// expand replaces ${name} with captured groups, unknown names are left as is.
+func (s *Script) expand(text string) string {
+	return scriptVariable.ReplaceAllStringFunc(text, func(variable string) string {
+		if value, ok := s.Captures[variable[2:len(variable)-1]]; ok {
+			return value
+		}
+		return variable
+	})
+}
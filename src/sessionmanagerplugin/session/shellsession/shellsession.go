@@ -17,6 +17,7 @@ package shellsession
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"os"
 	"os/signal"
 	"time"
@@ -24,6 +25,7 @@ import (
 	"github.com/aws/session-manager-plugin/src/config"
 	"github.com/aws/session-manager-plugin/src/log"
 	"github.com/aws/session-manager-plugin/src/message"
+	"github.com/aws/session-manager-plugin/src/sdkutil"
 	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
 	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/sessionutil"
 	"golang.org/x/crypto/ssh/terminal"
@@ -32,6 +34,10 @@ import (
 const (
 	ResizeSleepInterval = time.Millisecond * 500
 	StdinBufferLimit    = 1024
+
+	// Terminal size reported to the agent when the session is driven by ShellAutomation
+	AutomationTerminalCols = 200
+	AutomationTerminalRows = 50
 )
 
 type ShellSession struct {
@@ -40,6 +46,14 @@ type ShellSession struct {
 	// SizeData is used to store size data at session level to compare with new size.
 	SizeData          message.SizeData
 	originalSttyState bytes.Buffer
+	commandGuard      *CommandGuard
+	paste             *pasteHandler
+	zmodem            *zmodemHandler
+	outputTranscoder  *transcoder
+	inputTranscoder   *transcoder
+	mirror            *sessionMirror
+	statusLine        *statusLine
+	localEcho         *localEcho
 }
 
 var GetTerminalSizeCall = func(fd int) (width int, height int, err error) {
@@ -57,6 +71,48 @@ func (ShellSession) Name() string {
 
 func (s *ShellSession) Initialize(log log.T, sessionVar *session.Session) {
 	s.Session = *sessionVar
+	s.paste = &pasteHandler{confirm: s.ConfirmPaste}
+	if s.ZmodemDir != "" {
+		if err := CheckZmodemDir(s.ZmodemDir); err != nil {
+			log.Errorf("ZMODEM transfers are turned off: %v", err)
+		} else {
+			s.zmodem = &zmodemHandler{
+				send: func(input []byte) error {
+					return s.DataChannel.SendInputDataMessage(log, message.Output, input)
+				},
+				display: func(output []byte) {
+					s.displayShellOutput(log, output)
+				},
+				out: os.Stdout,
+				dir: s.ZmodemDir,
+			}
+		}
+	}
+	if s.RemoteEncoding != "" {
+		if remoteCharset, err := LookupCharset(s.RemoteEncoding); err != nil {
+			log.Errorf("Remote encoding is not used: %v", err)
+		} else {
+			s.outputTranscoder = newTranscoder(remoteCharset, localCharset())
+			s.inputTranscoder = newTranscoder(localCharset(), remoteCharset)
+		}
+	}
+	if s.WatchSocket != "" {
+		mode := DefaultWatchSocketMode
+		if s.WatchSocketMode != "" {
+			if parsed, err := ParseWatchSocketMode(s.WatchSocketMode); err != nil {
+				log.Errorf("Using socket mode %o: %v", DefaultWatchSocketMode, err)
+			} else {
+				mode = parsed
+			}
+		}
+		s.mirror = newSessionMirror(s.WatchSocket, mode)
+	}
+	if s.PredictiveEcho {
+		s.localEcho = newLocalEcho(os.Stdout, s.DataChannel.GetRoundTripTime)
+	}
+	if s.StatusLine {
+		s.statusLine = newStatusLine(os.Stdout, s.DataChannel, s.SessionId, s.TargetId)
+	}
 	s.DataChannel.RegisterOutputStreamHandler(s.ProcessStreamMessagePayload, true)
 	s.DataChannel.GetWsChannel().SetOnMessage(
 		func(input []byte) {
@@ -66,10 +122,28 @@ func (s *ShellSession) Initialize(log log.T, sessionVar *session.Session) {
 
 // StartSession takes input and write it to data channel
 func (s *ShellSession) SetSessionHandlers(log log.T) (err error) {
+	if s.ShellAutomation != nil {
+		return s.runShellAutomation(log)
+	}
+
+	if err = s.loadGuardrails(log); err != nil {
+		return
+	}
+
+	if s.mirror != nil {
+		if err = s.mirror.listen(log); err != nil {
+			log.Errorf("Failed to open the shell session mirror: %v", err)
+			return
+		}
+	}
 
 	// handle re-size
 	s.handleTerminalResize(log)
 
+	if s.statusLine != nil {
+		s.refreshStatusLine()
+	}
+
 	// handle control signals
 	s.handleControlSignals(log)
 
@@ -79,17 +153,72 @@ func (s *ShellSession) SetSessionHandlers(log log.T) (err error) {
 	return
 }
 
-// handleControlSignals handles control signals when given by user
+// loadGuardrails sets up the command guard when a guardrails policy file is configured
+func (s *ShellSession) loadGuardrails(log log.T) (err error) {
+	if s.GuardrailsFile == "" {
+		return
+	}
+	var (
+		policy *GuardrailPolicy
+		rules  []GuardrailRule
+	)
+	if policy, err = LoadGuardrailPolicy(s.GuardrailsFile); err != nil {
+		log.Errorf("Failed to load guardrails: %v", err)
+		return
+	}
+	if rules, err = policy.RulesFor(log, s.TargetId, sdkutil.GetProfile()); err != nil {
+		log.Errorf("Failed to load guardrails: %v", err)
+		return
+	}
+	log.Infof("Loaded %d guardrail rules for target %s", len(rules), s.TargetId)
+	if len(rules) > 0 {
+		s.commandGuard = NewCommandGuard(rules, os.Stdout)
+	}
+	return
+}
+
+// sendKeyboardInput sends keyboard input to the agent once it passes the command guard, transcoded
+// to the remote encoding if one is set. During ZMODEM transfers the input goes to the transfer instead
+func (s *ShellSession) sendKeyboardInput(log log.T, input []byte) error {
+	if s.zmodem != nil && s.zmodem.processInput(input) {
+		return nil
+	}
+	if s.commandGuard != nil {
+		if input = s.commandGuard.ProcessInput(log, input); len(input) == 0 {
+			return nil
+		}
+	}
+	if s.localEcho != nil {
+		s.localEcho.processInput(log, input)
+	}
+	if s.inputTranscoder != nil {
+		if input = s.inputTranscoder.transcode(input); len(input) == 0 {
+			return nil
+		}
+	}
+	return s.Session.DataChannel.SendInputDataMessage(log, message.Output, input)
+}
+
+// handleControlSignals handles control signals when given by user, by default they are sent to the shell as control characters
 func (s *ShellSession) handleControlSignals(log log.T) {
+	signalMap := s.ControlSignalMap()
 	go func() {
 		signals := make(chan os.Signal, 1)
-		signal.Notify(signals, sessionutil.ControlSignals...)
+		signal.Notify(signals, signalMap.Signals()...)
 		for {
 			sig := <-signals
-			if b, ok := sessionutil.SignalsByteMap[sig]; ok {
-				if err := s.DataChannel.SendInputDataMessage(log, message.Output, []byte{b}); err != nil {
+			switch action := signalMap[sig]; action.Type {
+			case sessionutil.SignalSendByte:
+				if err := s.sendKeyboardInput(log, []byte{action.Byte}); err != nil {
 					log.Errorf("Failed to send control signals: %v", err)
 				}
+			case sessionutil.SignalTerminate:
+				fmt.Fprintf(os.Stdout, "\r\nSignal %v received, terminating session %s.\r\n", sig, s.SessionId)
+				s.AuditSessionEnd(log, "terminate signal received")
+				s.EndSession(log)
+				s.Stop()
+			case sessionutil.SignalSuspend:
+				s.suspend(log)
 			}
 		}
 	}()
@@ -113,11 +242,18 @@ func (s *ShellSession) handleTerminalResize(log log.T) {
 			}
 
 			if s.SizeData.Rows != uint32(height) || s.SizeData.Cols != uint32(width) {
-				sizeData := message.SizeData{
+				s.SizeData = message.SizeData{
 					Cols: uint32(width),
 					Rows: uint32(height),
 				}
-				s.SizeData = sizeData
+				// the shell does not get the row of the status line
+				sizeData := s.SizeData
+				if s.statusLine != nil {
+					sizeData.Rows = uint32(s.statusLine.resize(width, height))
+				}
+				if s.mirror != nil {
+					s.mirror.setSize(log, sizeData)
+				}
 
 				if inputSizeData, err = json.Marshal(sizeData); err != nil {
 					log.Errorf("Cannot marshall size data: %v", err)
@@ -133,8 +269,101 @@ func (s *ShellSession) handleTerminalResize(log log.T) {
 	}()
 }
 
+// refreshStatusLine redraws the status line every StatusLineInterval.
+func (s *ShellSession) refreshStatusLine() {
+	go func() {
+		for range time.Tick(StatusLineInterval) {
+			s.statusLine.refresh()
+		}
+	}()
+}
+
+// runShellAutomation runs the session automation in place of the terminal and ends the session once it returns
+func (s *ShellSession) runShellAutomation(log log.T) (err error) {
+	if err = s.sendAutomationSize(log); err != nil {
+		return
+	}
+
+	err = s.ShellAutomation.Run(log, func(input []byte) error {
+		return s.DataChannel.SendInputDataMessage(log, message.Output, input)
+	})
+
+	s.EndSession(log)
+	return
+}
+
+// sendAutomationSize sends the fixed terminal size of automated sessions
+func (s *ShellSession) sendAutomationSize(log log.T) (err error) {
+	var inputSizeData []byte
+	if inputSizeData, err = json.Marshal(message.SizeData{Cols: AutomationTerminalCols, Rows: AutomationTerminalRows}); err != nil {
+		log.Errorf("Cannot marshall size data: %v", err)
+		return
+	}
+	if err = s.DataChannel.SendInputDataMessage(log, message.Size, inputSizeData); err != nil {
+		log.Errorf("Failed to Send size data: %v", err)
+	}
+	return
+}
+
+// SessionRestarted continues the shell session in the new session started in place of the timed out one
+func (s *ShellSession) SessionRestarted(log log.T, sessionId string) {
+	s.SessionId = sessionId
+	if s.statusLine != nil {
+		s.statusLine.setSessionId(sessionId)
+	}
+	if s.ShellAutomation != nil {
+		s.sendAutomationSize(log)
+		return
+	}
+	// the resize loop sends the terminal size once it differs from the size known to the old session
+	s.SizeData = message.SizeData{}
+}
+
 // ProcessStreamMessagePayload prints payload received on datachannel to console
 func (s ShellSession) ProcessStreamMessagePayload(log log.T, outputMessage message.ClientMessage) (isHandlerReady bool, err error) {
-	s.DisplayMode.DisplayMessage(log, outputMessage)
+	if s.ShellAutomation != nil {
+		s.ShellAutomation.ProcessOutput(outputMessage.Payload)
+		return true, nil
+	}
+	if s.zmodem != nil {
+		// ZMODEM transfers bypass the terminal display
+		if outputMessage.Payload = s.zmodem.processOutput(log, outputMessage.Payload); len(outputMessage.Payload) == 0 {
+			return true, nil
+		}
+	}
+	s.displayShellOutput(log, outputMessage.Payload)
 	return true, nil
 }
+
+// displayShellOutput shows output of the remote shell, transcoded, mirrored and below the status line as configured
+func (s ShellSession) displayShellOutput(log log.T, payload []byte) {
+	if s.paste != nil {
+		payload = s.paste.processOutput(payload)
+	}
+	if s.outputTranscoder != nil {
+		if payload = s.outputTranscoder.transcode(payload); len(payload) == 0 {
+			return
+		}
+	}
+	if s.mirror != nil {
+		s.mirror.write(payload)
+	}
+	if s.localEcho != nil {
+		s.localEcho.display(log, payload, func(output []byte) {
+			s.displayOutput(log, output)
+		})
+		return
+	}
+	s.displayOutput(log, payload)
+}
+
+// displayOutput writes shell output to the terminal, below the status line if there is one
+func (s ShellSession) displayOutput(log log.T, output []byte) {
+	if s.statusLine != nil {
+		s.statusLine.display(output, func(output []byte) {
+			s.DisplayMode.DisplayMessage(log, message.ClientMessage{Payload: output})
+		})
+		return
+	}
+	s.DisplayMode.DisplayMessage(log, message.ClientMessage{Payload: output})
+}
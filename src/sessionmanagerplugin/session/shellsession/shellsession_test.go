@@ -60,6 +60,20 @@ func TestInitialize(t *testing.T) {
 	mockWsChannel.On("SetOnMessage", mock.Anything)
 	shellSession.Initialize(logger, session)
 	assert.Equal(t, shellSession.Session, *session)
+	assert.Nil(t, shellSession.zmodem)
+}
+
+func TestInitializeWithZmodemDir(t *testing.T) {
+	dataChannel := &dataChannelMock.IDataChannel{}
+	wsChannel := &mocks.IWebSocketChannel{}
+	session := &session.Session{DataChannel: dataChannel, ZmodemDir: t.TempDir()}
+	shellSession := ShellSession{}
+	dataChannel.On("RegisterOutputStreamHandler", mock.Anything, true)
+	dataChannel.On("GetWsChannel").Return(wsChannel)
+	wsChannel.On("SetOnMessage", mock.Anything)
+	shellSession.Initialize(logger, session)
+	assert.NotNil(t, shellSession.zmodem)
+	assert.Equal(t, session.ZmodemDir, shellSession.zmodem.dir)
 }
 
 func TestHandleControlSignals(t *testing.T) {
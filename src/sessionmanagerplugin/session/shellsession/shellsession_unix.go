@@ -25,7 +25,8 @@ import (
 	"time"
 
 	"github.com/aws/session-manager-plugin/src/log"
-	"github.com/aws/session-manager-plugin/src/message"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/sessionutil"
 )
 
 // disableEchoAndInputBuffering disables echo to avoid double echo and disable input buffering
@@ -53,9 +54,45 @@ func setState(state *bytes.Buffer) error {
 
 // stop restores the terminal settings and exits
 func (s *ShellSession) Stop() {
+	s.AuditSessionEnd(log.Logger(false, "session-manager-plugin"), "session closed")
+	if s.ShellAutomation != nil {
+		// terminal settings were not changed, the automation ends the session once it returns
+		s.ShellAutomation.Close()
+		return
+	}
+	if s.mirror != nil {
+		s.mirror.close()
+	}
+	if s.statusLine != nil {
+		s.statusLine.close()
+	}
+	if s.paste != nil {
+		s.paste.disableLocalMode()
+	}
 	setState(&s.originalSttyState)
 	setState(bytes.NewBufferString("echo")) // for linux and ubuntu
-	os.Exit(0)
+	os.Exit(session.ExitCode())
+}
+
+// suspend restores the terminal settings and stops the plugin, the terminal is set up again once it is continued
+func (s *ShellSession) suspend(log log.T) {
+	if s.statusLine != nil {
+		s.statusLine.close()
+	}
+	s.paste.disableLocalMode()
+	setState(&s.originalSttyState)
+	setState(bytes.NewBufferString("echo"))
+
+	if err := sessionutil.SuspendProcess(); err != nil {
+		log.Errorf("Failed to suspend: %v", err)
+	}
+
+	setState(bytes.NewBufferString("cbreak"))
+	setState(bytes.NewBufferString("-echo"))
+	s.paste.enableLocalMode()
+	if s.statusLine != nil {
+		s.statusLine.resize(int(s.SizeData.Cols), int(s.SizeData.Rows))
+	}
 }
 
 // handleKeyboardInput handles input entered by customer on terminal
@@ -67,6 +104,9 @@ func (s *ShellSession) handleKeyboardInput(log log.T) (err error) {
 	//handle double echo and disable input buffering
 	s.disableEchoAndInputBuffering()
 
+	// pastes are detected with bracketed paste mode and sent paced
+	s.paste.enableLocalMode()
+
 	stdinBytes := make([]byte, StdinBufferLimit)
 	reader := bufio.NewReader(os.Stdin)
 	for {
@@ -75,7 +115,17 @@ func (s *ShellSession) handleKeyboardInput(log log.T) (err error) {
 			break
 		}
 
-		if err = s.Session.DataChannel.SendInputDataMessage(log, message.Output, stdinBytes[:stdinBytesLen]); err != nil {
+		for _, segment := range s.paste.detector.process(stdinBytes[:stdinBytesLen]) {
+			if segment.paste {
+				err = s.handlePaste(log, reader, segment.data)
+			} else {
+				err = s.sendKeyboardInput(log, segment.data)
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
 			log.Errorf("Failed to send UTF8 char: %v", err)
 			break
 		}
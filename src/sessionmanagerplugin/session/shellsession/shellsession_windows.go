@@ -22,7 +22,7 @@ import (
 	"time"
 
 	"github.com/aws/session-manager-plugin/src/log"
-	"github.com/aws/session-manager-plugin/src/message"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
 	"github.com/eiannone/keyboard"
 )
 
@@ -55,7 +55,24 @@ var specialKeysInputMap = map[keyboard.Key][]byte{
 
 // stop restores the terminal settings and exits
 func (s *ShellSession) Stop() {
-	os.Exit(0)
+	s.AuditSessionEnd(log.Logger(false, "session-manager-plugin"), "session closed")
+	if s.ShellAutomation != nil {
+		// terminal settings were not changed, the automation ends the session once it returns
+		s.ShellAutomation.Close()
+		return
+	}
+	if s.mirror != nil {
+		s.mirror.close()
+	}
+	if s.statusLine != nil {
+		s.statusLine.close()
+	}
+	os.Exit(session.ExitCode())
+}
+
+// suspend is not supported on windows, signal mappings cannot ask for it
+func (s *ShellSession) suspend(log log.T) {
+	log.Warnf("Suspend is not supported on windows")
 }
 
 // handleKeyboardInput handles input entered by customer on terminal
@@ -77,7 +94,7 @@ func (s *ShellSession) handleKeyboardInput(log log.T) (err error) {
 		}
 		if character != 0 {
 			charBytes := []byte(string(character))
-			if err = s.Session.DataChannel.SendInputDataMessage(log, message.Output, charBytes); err != nil {
+			if err = s.sendKeyboardInput(log, charBytes); err != nil {
 				log.Errorf("Failed to send UTF8 char: %v", err)
 				break
 			}
@@ -86,7 +103,7 @@ func (s *ShellSession) handleKeyboardInput(log log.T) (err error) {
 			if byteValue, ok := specialKeysInputMap[key]; ok {
 				keyBytes = byteValue
 			}
-			if err = s.Session.DataChannel.SendInputDataMessage(log, message.Output, keyBytes); err != nil {
+			if err = s.sendKeyboardInput(log, keyBytes); err != nil {
 				log.Errorf("Failed to send UTF8 char: %v", err)
 				break
 			}
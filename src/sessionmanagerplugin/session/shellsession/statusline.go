@@ -0,0 +1,266 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/datachannel"
+)
+
+const (
+	// StatusLineInterval is how often the status line is refreshed
+	StatusLineInterval = time.Second
+	// StatusLineMinRows is the smallest terminal that keeps a row for the status line
+	StatusLineMinRows = 3
+)
+
+// outputScanner follows the escape sequences of the shell output, so that the status line is only
+// drawn between sequences and the scroll region is restored when the shell resets it.
+type outputScanner struct {
+	state  int
+	params []byte
+	utf8   int
+	// alternateScreen is set while a full screen application uses the alternate screen
+	alternateScreen bool
+}
+
+const (
+	scanGround = iota
+	scanEscape
+	scanEscapeIntermediate
+	scanCSI
+	scanString
+	scanStringEscape
+)
+
+// scan consumes the output and returns whether it reset the scroll region or the terminal.
+func (o *outputScanner) scan(output []byte) (reset bool) {
+	for _, b := range output {
+		switch o.state {
+		case scanGround:
+			switch {
+			case b == 0x1b:
+				o.state = scanEscape
+				o.utf8 = 0
+			case b&0xc0 == 0x80 && o.utf8 > 0:
+				o.utf8--
+			case b&0xe0 == 0xc0:
+				o.utf8 = 1
+			case b&0xf0 == 0xe0:
+				o.utf8 = 2
+			case b&0xf8 == 0xf0:
+				o.utf8 = 3
+			default:
+				o.utf8 = 0
+			}
+		case scanEscape:
+			switch {
+			case b == '[':
+				o.state = scanCSI
+				o.params = o.params[:0]
+			case b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_':
+				o.state = scanString
+			case b >= 0x20 && b <= 0x2f:
+				o.state = scanEscapeIntermediate
+			case b == 'c':
+				o.state = scanGround
+				reset = true
+			default:
+				o.state = scanGround
+			}
+		case scanEscapeIntermediate:
+			if b < 0x20 || b > 0x2f {
+				o.state = scanGround
+			}
+		case scanCSI:
+			if b >= 0x40 && b <= 0x7e {
+				o.state = scanGround
+				if b == 'r' && (len(o.params) == 0 || string(o.params) == ";") {
+					reset = true
+				} else if (b == 'h' || b == 'l') && bytes.HasPrefix(o.params, []byte("?")) {
+					for _, mode := range strings.Split(string(o.params[1:]), ";") {
+						if mode == "1049" || mode == "1047" || mode == "47" {
+							o.alternateScreen = b == 'h'
+						}
+					}
+				}
+			} else if len(o.params) < 16 {
+				o.params = append(o.params, b)
+			}
+		case scanString:
+			if b == 0x07 {
+				o.state = scanGround
+			} else if b == 0x1b {
+				o.state = scanStringEscape
+			}
+		case scanStringEscape:
+			if b == '\\' {
+				o.state = scanGround
+			} else {
+				o.state = scanString
+			}
+		}
+	}
+	return
+}
+
+// betweenSequences returns whether the output ended outside of escape sequences and characters.
+func (o *outputScanner) betweenSequences() bool {
+	return o.state == scanGround && o.utf8 == 0
+}
+
+// statusLine keeps the last terminal row for session health, the shell gets a scroll region without it.
+type statusLine struct {
+	mutex       sync.Mutex
+	out         io.Writer
+	dataChannel datachannel.IDataChannel
+	sessionId   string
+	targetId    string
+	cols        int
+	rows        int
+	scanner     outputScanner
+	reset       bool
+}
+
+// newStatusLine returns a status line for the session, it is drawn once the terminal size is known.
+func newStatusLine(out io.Writer, dataChannel datachannel.IDataChannel, sessionId string, targetId string) *statusLine {
+	return &statusLine{out: out, dataChannel: dataChannel, sessionId: sessionId, targetId: targetId}
+}
+
+// resize reserves the last row of the resized terminal and returns the rows left for the shell.
+func (l *statusLine) resize(cols int, rows int) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var buf strings.Builder
+	if l.rows == 0 {
+		// make room below the cursor so that it stays inside the scroll region
+		buf.WriteString("\x1bD\x1b[A")
+	} else if l.active() {
+		// the old status line would be left behind in the shell rows
+		fmt.Fprintf(&buf, "\x1b7\x1b[%d;1H\x1b[2K\x1b8", l.rows)
+	}
+	l.cols, l.rows = cols, rows
+	if !l.active() {
+		buf.WriteString("\x1b7\x1b[r\x1b8")
+		io.WriteString(l.out, buf.String())
+		return rows
+	}
+	io.WriteString(l.out, buf.String())
+	l.setScrollRegion()
+	l.draw()
+	return rows - 1
+}
+
+// shellRows returns the rows of the terminal left for the shell.
+func (l *statusLine) shellRows() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.active() {
+		return l.rows - 1
+	}
+	return l.rows
+}
+
+// active returns whether the terminal is large enough for the status line.
+func (l *statusLine) active() bool {
+	return l.rows >= StatusLineMinRows
+}
+
+// setScrollRegion limits scrolling to the shell rows, setting it moves the cursor so it is saved around.
+func (l *statusLine) setScrollRegion() {
+	fmt.Fprintf(l.out, "\x1b7\x1b[1;%dr\x1b8", l.rows-1)
+}
+
+// draw writes the status line in reverse video on the last row.
+func (l *statusLine) draw() {
+	status := []rune(l.text())
+	if len(status) > l.cols {
+		status = status[:l.cols]
+	}
+	fmt.Fprintf(l.out, "\x1b7\x1b[%d;1H\x1b[2K\x1b[7m%-*s\x1b[0m\x1b8", l.rows, l.cols, string(status))
+}
+
+// text returns the session health shown on the status line.
+func (l *statusLine) text() string {
+	var buf strings.Builder
+	if l.dataChannel.IsReconnecting() {
+		buf.WriteString(" RECONNECTING |")
+	}
+	fmt.Fprintf(&buf, " %s | %s | agent %s | rtt %v | rto %v | unacked %d ",
+		l.sessionId,
+		l.targetId,
+		l.dataChannel.GetAgentVersion(),
+		l.dataChannel.GetRoundTripTime().Round(time.Millisecond),
+		l.dataChannel.GetRetransmissionTimeout().Round(time.Millisecond),
+		l.dataChannel.GetUnacknowledgedMessageCount())
+	return buf.String()
+}
+
+// setSessionId shows the session that replaced the timed out session.
+func (l *statusLine) setSessionId(sessionId string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sessionId = sessionId
+	if l.active() && l.scanner.betweenSequences() {
+		l.draw()
+	}
+}
+
+// display shows shell output, restoring the scroll region when the output reset it.
+func (l *statusLine) display(output []byte, display func(output []byte)) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	display(output)
+	if l.scanner.scan(output) {
+		l.reset = true
+	}
+	if l.reset && l.scanner.betweenSequences() {
+		l.reset = false
+		if l.active() {
+			l.setScrollRegion()
+			l.draw()
+		}
+	}
+}
+
+// refresh redraws the status line unless the shell output stopped in the middle of a sequence.
+func (l *statusLine) refresh() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.active() && l.scanner.betweenSequences() {
+		l.draw()
+	}
+}
+
+// close gives the whole terminal back to the shell.
+func (l *statusLine) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.active() {
+		fmt.Fprintf(l.out, "\x1b7\x1b[r\x1b[%d;1H\x1b[2K\x1b8", l.rows)
+	}
+	l.rows = 0
+}
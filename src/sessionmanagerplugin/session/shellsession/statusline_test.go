@@ -0,0 +1,111 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	dataChannelMock "github.com/aws/session-manager-plugin/src/datachannel/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStatusLine(reconnecting bool) (*statusLine, *bytes.Buffer) {
+	mockChannel := &dataChannelMock.IDataChannel{}
+	mockChannel.On("IsReconnecting").Return(reconnecting)
+	mockChannel.On("GetAgentVersion").Return("3.2.582.0")
+	mockChannel.On("GetRoundTripTime").Return(time.Duration(123456789))
+	mockChannel.On("GetRetransmissionTimeout").Return(400 * time.Millisecond)
+	mockChannel.On("GetUnacknowledgedMessageCount").Return(2)
+
+	var out bytes.Buffer
+	return newStatusLine(&out, mockChannel, "user-0123456789", "i-123456"), &out
+}
+
+func TestOutputScanner(t *testing.T) {
+	scanner := outputScanner{}
+	assert.False(t, scanner.scan([]byte("ls\r\n\x1b[1;32mok\x1b[0m \x1b]0;title\x07")))
+	assert.True(t, scanner.betweenSequences())
+
+	// sequences and characters split across messages
+	assert.False(t, scanner.scan([]byte("\x1b[1;3")))
+	assert.False(t, scanner.betweenSequences())
+	scanner.scan([]byte("1m\xe2\x82"))
+	assert.False(t, scanner.betweenSequences())
+	scanner.scan([]byte("\xac"))
+	assert.True(t, scanner.betweenSequences())
+
+	// resets of the scroll region or the terminal, a region set by the shell is kept
+	assert.True(t, scanner.scan([]byte("\x1b[r")))
+	assert.True(t, scanner.scan([]byte("\x1bc")))
+	assert.False(t, scanner.scan([]byte("\x1b[2;10r")))
+	assert.False(t, scanner.scan([]byte("\x1b(B\x1b[?1049h")))
+	assert.True(t, scanner.betweenSequences())
+}
+
+func TestStatusLineReservesLastRow(t *testing.T) {
+	line, out := newTestStatusLine(false)
+
+	assert.Equal(t, 39, line.resize(100, 40))
+	assert.Equal(t, 39, line.shellRows())
+	assert.Contains(t, out.String(), "\x1b[1;39r")
+	assert.Contains(t, out.String(), "\x1b[40;1H\x1b[2K\x1b[7m user-0123456789 | i-123456 | agent 3.2.582.0 | rtt 123ms | rto 400ms | unacked 2 ")
+
+	// too small for a status line
+	out.Reset()
+	assert.Equal(t, 2, line.resize(100, 2))
+	assert.Contains(t, out.String(), "\x1b[40;1H\x1b[2K")
+	assert.Contains(t, out.String(), "\x1b[r")
+
+	out.Reset()
+	line.refresh()
+	assert.Empty(t, out.String())
+}
+
+func TestStatusLineShowsReconnect(t *testing.T) {
+	line, out := newTestStatusLine(true)
+	line.resize(30, 10)
+	assert.Contains(t, out.String(), "\x1b[7m RECONNECTING | user-012345678\x1b[0m")
+}
+
+func TestStatusLineRestoresScrollRegion(t *testing.T) {
+	line, out := newTestStatusLine(false)
+	line.resize(100, 40)
+
+	var displayed bytes.Buffer
+	display := func(output []byte) {
+		displayed.Write(output)
+		out.Write(output)
+	}
+
+	out.Reset()
+	line.display([]byte("$ ls\r\n"), display)
+	assert.Equal(t, "$ ls\r\n", out.String())
+
+	// the reset is only undone once the shell output is between sequences
+	out.Reset()
+	line.display([]byte("\x1b[r\x1b[1;3"), display)
+	assert.Equal(t, "\x1b[r\x1b[1;3", out.String())
+	line.refresh()
+	assert.Equal(t, "\x1b[r\x1b[1;3", out.String())
+	line.display([]byte("1m"), display)
+	assert.Contains(t, out.String(), "1m\x1b7\x1b[1;39r\x1b8")
+
+	out.Reset()
+	line.close()
+	assert.Equal(t, "\x1b7\x1b[r\x1b[40;1H\x1b[2K\x1b8", out.String())
+	assert.Equal(t, "$ ls\r\n\x1b[r\x1b[1;31m", displayed.String())
+}
@@ -0,0 +1,408 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// ZMODEM framing characters
+const (
+	zPAD   = '*'
+	zDLE   = 0x18
+	zBIN   = 'A'
+	zHEX   = 'B'
+	zBIN32 = 'C'
+
+	xON  = 0x11
+	xOFF = 0x13
+)
+
+// ZMODEM frame types
+const (
+	zRQINIT = 0
+	zRINIT  = 1
+	zSINIT  = 2
+	zACK    = 3
+	zFILE   = 4
+	zSKIP   = 5
+	zNAK    = 6
+	zABORT  = 7
+	zFIN    = 8
+	zRPOS   = 9
+	zDATA   = 10
+	zEOF    = 11
+	zFERR   = 12
+	zCAN    = 16
+)
+
+// ZMODEM data subpacket terminators and escaped rubouts
+const (
+	zCRCE = 'h'
+	zCRCG = 'i'
+	zCRCQ = 'j'
+	zCRCW = 'k'
+	zRUB0 = 'l'
+	zRUB1 = 'm'
+)
+
+// ZRINIT capability flags, carried in ZF0
+const (
+	zCANFDX  = 0x01
+	zCANOVIO = 0x02
+	zCANFC32 = 0x20
+	zESCCTL  = 0x40
+)
+
+const (
+	// ZmodemSubpacketSize is the size of the data subpackets sent
+	ZmodemSubpacketSize = 1024
+	// zmodemMaxSubpacketSize bounds the data subpackets accepted
+	zmodemMaxSubpacketSize = 8192
+)
+
+// zmodemCancelSequence aborts a transfer on the remote side
+var zmodemCancelSequence = []byte("\x18\x18\x18\x18\x18\x18\x18\x18\x08\x08\x08\x08\x08\x08\x08\x08\x08\x08")
+
+var errZmodemCancelled = errors.New("transfer cancelled by the remote side")
+
+// zmodemDataError is a corrupted header or subpacket, the transfer recovers by resending from a position.
+type zmodemDataError struct {
+	reason string
+}
+
+func (e *zmodemDataError) Error() string {
+	return "corrupted data: " + e.reason
+}
+
+// zmodemHeader is a ZMODEM frame header. The four data bytes hold a little endian file position
+// or, in reverse order ZF3 to ZF0, flags.
+type zmodemHeader struct {
+	frameType byte
+	data      [4]byte
+	crc32     bool
+}
+
+// newPositionHeader returns a header carrying a file position.
+func newPositionHeader(frameType byte, position int64) zmodemHeader {
+	header := zmodemHeader{frameType: frameType}
+	binary.LittleEndian.PutUint32(header.data[:], uint32(position))
+	return header
+}
+
+// position returns the file position carried by the header.
+func (h zmodemHeader) position() int64 {
+	return int64(binary.LittleEndian.Uint32(h.data[:]))
+}
+
+// flags returns ZF0, the first flags byte of the header.
+func (h zmodemHeader) flags() byte {
+	return h.data[3]
+}
+
+// crc16 updates a CRC-16/XMODEM checksum as used by ZMODEM.
+func crc16(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// zmodemEncoder writes ZMODEM headers and data subpackets with CRC-16 checksums.
+type zmodemEncoder struct {
+	// escapeControl escapes all control characters, requested by receivers with ESCCTL
+	escapeControl bool
+	last          byte
+}
+
+// escape appends data with ZDLE escaping of the characters that do not pass through terminals.
+func (e *zmodemEncoder) escape(buf *bytes.Buffer, data []byte) {
+	for _, c := range data {
+		escape := false
+		switch c {
+		case zDLE, 0x10, 0x90, xON, xON | 0x80, xOFF, xOFF | 0x80:
+			escape = true
+		case '\r', '\r' | 0x80:
+			// telnet interprets CR after @
+			escape = e.escapeControl || e.last&0x7f == '@'
+		default:
+			escape = e.escapeControl && c&0x60 == 0
+		}
+		if escape {
+			buf.WriteByte(zDLE)
+			c ^= 0x40
+		}
+		buf.WriteByte(c)
+		e.last = c
+	}
+}
+
+// hexHeader returns the header in hex format, used for headers that are not followed by data.
+func (e *zmodemEncoder) hexHeader(header zmodemHeader) []byte {
+	raw := append([]byte{header.frameType}, header.data[:]...)
+	crc := crc16(0, raw)
+	raw = append(raw, byte(crc>>8), byte(crc))
+
+	buf := bytes.NewBufferString("**\x18B")
+	buf.WriteString(hex.EncodeToString(raw))
+	buf.WriteString("\r\x8a")
+	if header.frameType != zFIN && header.frameType != zACK {
+		buf.WriteByte(xON)
+	}
+	return buf.Bytes()
+}
+
+// binaryHeader returns the header in binary format with a CRC-16 checksum.
+func (e *zmodemEncoder) binaryHeader(header zmodemHeader) []byte {
+	raw := append([]byte{header.frameType}, header.data[:]...)
+	crc := crc16(0, raw)
+	raw = append(raw, byte(crc>>8), byte(crc))
+
+	var buf bytes.Buffer
+	buf.Write([]byte{zPAD, zDLE, zBIN})
+	e.escape(&buf, raw)
+	return buf.Bytes()
+}
+
+// subpacket returns a data subpacket ended by frameEnd with a CRC-16 checksum.
+func (e *zmodemEncoder) subpacket(data []byte, frameEnd byte) []byte {
+	crc := crc16(crc16(0, data), []byte{frameEnd})
+
+	var buf bytes.Buffer
+	e.escape(&buf, data)
+	buf.Write([]byte{zDLE, frameEnd})
+	e.escape(&buf, []byte{byte(crc >> 8), byte(crc)})
+	if frameEnd == zCRCW {
+		buf.WriteByte(xON)
+	}
+	return buf.Bytes()
+}
+
+// zmodemDecoder reads ZMODEM headers and data subpackets.
+type zmodemDecoder struct {
+	reader *bufio.Reader
+}
+
+// readEscaped returns the next byte with ZDLE escaping removed. Frame ends of data subpackets are
+// reported with frameEnd set.
+func (d *zmodemDecoder) readEscaped() (c byte, frameEnd bool, err error) {
+	for {
+		if c, err = d.reader.ReadByte(); err != nil {
+			return
+		}
+		switch c {
+		case xON, xON | 0x80, xOFF, xOFF | 0x80:
+			// flow control characters inserted by the terminal
+			continue
+		case zDLE:
+		default:
+			return c, false, nil
+		}
+
+		cancels := 1
+		for {
+			if c, err = d.reader.ReadByte(); err != nil {
+				return
+			}
+			if c != zDLE {
+				break
+			}
+			if cancels++; cancels >= 5 {
+				return 0, false, errZmodemCancelled
+			}
+		}
+		switch c {
+		case zCRCE, zCRCG, zCRCQ, zCRCW:
+			return c, true, nil
+		case zRUB0:
+			return 0x7f, false, nil
+		case zRUB1:
+			return 0xff, false, nil
+		case xON, xON | 0x80, xOFF, xOFF | 0x80:
+			continue
+		}
+		if c&0x60 != 0x40 {
+			return 0, false, &zmodemDataError{fmt.Sprintf("invalid escape 0x%02x", c)}
+		}
+		return c ^ 0x40, false, nil
+	}
+}
+
+// readEscapedBytes reads count escaped bytes which must not contain a frame end.
+func (d *zmodemDecoder) readEscapedBytes(count int) ([]byte, error) {
+	data := make([]byte, count)
+	for i := range data {
+		c, frameEnd, err := d.readEscaped()
+		if err != nil {
+			return nil, err
+		}
+		if frameEnd {
+			return nil, &zmodemDataError{"unexpected frame end"}
+		}
+		data[i] = c
+	}
+	return data, nil
+}
+
+// readHeader skips to the next header and returns it once the checksum is verified.
+func (d *zmodemDecoder) readHeader() (header zmodemHeader, err error) {
+	var c byte
+	for {
+		if c, err = d.reader.ReadByte(); err != nil {
+			return
+		}
+		if c != zPAD {
+			continue
+		}
+		for c == zPAD {
+			if c, err = d.reader.ReadByte(); err != nil {
+				return
+			}
+		}
+		if c != zDLE {
+			continue
+		}
+		if c, err = d.reader.ReadByte(); err != nil {
+			return
+		}
+		switch c {
+		case zHEX:
+			return d.readHexHeader()
+		case zBIN:
+			return d.readBinaryHeader(false)
+		case zBIN32:
+			return d.readBinaryHeader(true)
+		case zDLE:
+			// the start of a cancel sequence
+			if err = d.reader.UnreadByte(); err != nil {
+				return
+			}
+			if _, _, err = d.readEscaped(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readHexHeader reads the rest of a hex header.
+func (d *zmodemDecoder) readHexHeader() (header zmodemHeader, err error) {
+	digits := make([]byte, 14)
+	for i := range digits {
+		var c byte
+		if c, err = d.reader.ReadByte(); err != nil {
+			return
+		}
+		digits[i] = c & 0x7f
+	}
+	raw, decodeErr := hex.DecodeString(string(digits))
+	if decodeErr != nil {
+		return header, &zmodemDataError{"invalid hex header"}
+	}
+	if crc16(0, raw[:5]) != binary.BigEndian.Uint16(raw[5:]) {
+		return header, &zmodemDataError{"hex header checksum mismatch"}
+	}
+	header.frameType = raw[0]
+	copy(header.data[:], raw[1:5])
+	return
+}
+
+// readBinaryHeader reads the rest of a binary header with a CRC-16 or CRC-32 checksum.
+func (d *zmodemDecoder) readBinaryHeader(withCrc32 bool) (header zmodemHeader, err error) {
+	length := 7
+	if withCrc32 {
+		length = 9
+	}
+	var raw []byte
+	if raw, err = d.readEscapedBytes(length); err != nil {
+		return
+	}
+	if withCrc32 {
+		if crc32.ChecksumIEEE(raw[:5]) != binary.LittleEndian.Uint32(raw[5:]) {
+			return header, &zmodemDataError{"binary header checksum mismatch"}
+		}
+	} else if crc16(0, raw[:5]) != binary.BigEndian.Uint16(raw[5:]) {
+		return header, &zmodemDataError{"binary header checksum mismatch"}
+	}
+	header.frameType = raw[0]
+	copy(header.data[:], raw[1:5])
+	header.crc32 = withCrc32
+	return
+}
+
+// readSubpacket reads a data subpacket following a binary header and returns its data and frame end.
+func (d *zmodemDecoder) readSubpacket(withCrc32 bool) (data []byte, frameEnd byte, err error) {
+	for {
+		c, isFrameEnd, readErr := d.readEscaped()
+		if readErr != nil {
+			return nil, 0, readErr
+		}
+		if isFrameEnd {
+			frameEnd = c
+			break
+		}
+		if len(data) >= zmodemMaxSubpacketSize {
+			return nil, 0, &zmodemDataError{"subpacket too long"}
+		}
+		data = append(data, c)
+	}
+
+	if withCrc32 {
+		var crc []byte
+		if crc, err = d.readEscapedBytes(4); err != nil {
+			return
+		}
+		checksum := crc32.Update(crc32.ChecksumIEEE(data), crc32.IEEETable, []byte{frameEnd})
+		if checksum != binary.LittleEndian.Uint32(crc) {
+			return nil, 0, &zmodemDataError{"subpacket checksum mismatch"}
+		}
+		return
+	}
+	var crc []byte
+	if crc, err = d.readEscapedBytes(2); err != nil {
+		return
+	}
+	if crc16(crc16(0, data), []byte{frameEnd}) != binary.BigEndian.Uint16(crc) {
+		return nil, 0, &zmodemDataError{"subpacket checksum mismatch"}
+	}
+	return
+}
+
+// zmodemStartLength is the length of the start sequences found by zmodemStart
+const zmodemStartLength = len("**\x18B00")
+
+// zmodemStart returns the index of a ZMODEM start sequence in the output of the remote shell.
+// A ZRQINIT header is written by sz offering files, a ZRINIT header by rz waiting for files.
+func zmodemStart(payload []byte) (index int, receive bool) {
+	if index = bytes.Index(payload, []byte("**\x18B00")); index >= 0 {
+		return index, true
+	}
+	if index = bytes.Index(payload, []byte("**\x18B01")); index >= 0 {
+		return index, false
+	}
+	return -1, false
+}
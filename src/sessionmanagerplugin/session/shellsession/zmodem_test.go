@@ -0,0 +1,273 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newZmodemPeer returns a transfer whose frames are delivered to the other peer.
+func newZmodemPeer(dir string, initial []byte) *zmodemTransfer {
+	transfer := &zmodemTransfer{
+		input: newZmodemInput(initial, 5*time.Second),
+		out:   ioutil.Discard,
+		dir:   dir,
+		done:  make(chan struct{}),
+	}
+	transfer.decoder = zmodemDecoder{reader: bufio.NewReader(transfer.input)}
+	return transfer
+}
+
+func deliverTo(peer *zmodemTransfer) func(input []byte) error {
+	return func(input []byte) error {
+		peer.input.queue(append([]byte(nil), input...))
+		return nil
+	}
+}
+
+func TestZmodemHexHeaderMatchesLrzsz(t *testing.T) {
+	// header written by rz when it waits for files
+	rinit := zmodemHeader{frameType: zRINIT}
+	rinit.data[3] = zCANFDX | zCANOVIO | zCANFC32
+	encoder := zmodemEncoder{}
+	assert.Equal(t, "**\x18B0100000023be50\r\x8a\x11", string(encoder.hexHeader(rinit)))
+
+	index, receive := zmodemStart([]byte("rz waiting to receive.**\x18B0100000023be50\r\x8a\x11"))
+	assert.Equal(t, 22, index)
+	assert.False(t, receive)
+
+	index, receive = zmodemStart([]byte("rz\r**\x18B00000000000000\r\x8a\x11"))
+	assert.Equal(t, 3, index)
+	assert.True(t, receive)
+
+	index, _ = zmodemStart([]byte("ls -la\r\n"))
+	assert.Equal(t, -1, index)
+}
+
+func TestZmodemSubpacketRoundTrip(t *testing.T) {
+	data := make([]byte, 512)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	encoder := zmodemEncoder{escapeControl: true}
+	frame := append(encoder.binaryHeader(newPositionHeader(zDATA, 70000)), encoder.subpacket(data, zCRCW)...)
+
+	decoder := zmodemDecoder{reader: bufio.NewReader(bytes.NewReader(append([]byte("garbage"), frame...)))}
+	header, err := decoder.readHeader()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(zDATA), header.frameType)
+	assert.Equal(t, int64(70000), header.position())
+
+	received, frameEnd, err := decoder.readSubpacket(header.crc32)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(zCRCW), frameEnd)
+	assert.Equal(t, data, received)
+
+	// corrupt one data byte
+	frame[20] ^= 0x01
+	decoder = zmodemDecoder{reader: bufio.NewReader(bytes.NewReader(frame))}
+	header, _ = decoder.readHeader()
+	_, _, err = decoder.readSubpacket(header.crc32)
+	_, ok := err.(*zmodemDataError)
+	assert.True(t, ok)
+
+	decoder = zmodemDecoder{reader: bufio.NewReader(bytes.NewReader(zmodemCancelSequence))}
+	_, _, err = decoder.readSubpacket(false)
+	assert.Equal(t, errZmodemCancelled, err)
+}
+
+func TestZmodemTransferRoundTrip(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	content := make([]byte, 3*ZmodemAckInterval*ZmodemSubpacketSize+123)
+	rand.New(rand.NewSource(1)).Read(content)
+	path := filepath.Join(sourceDir, "data.bin")
+	assert.Nil(t, ioutil.WriteFile(path, content, 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(targetDir, "data.bin"), []byte("existing"), 0600))
+
+	// the receiving side answers the ZRQINIT sz writes, the sending side answers the ZRINIT rz writes
+	receiver := newZmodemPeer(targetDir, []byte("rz\r**\x18B00000000000000\r\x8a\x11"))
+	sender := newZmodemPeer(sourceDir, nil)
+	receiver.send = deliverTo(sender)
+	sender.send = deliverTo(receiver)
+	sender.input.keys <- []byte(path + "\r")
+	receiver.input.keys <- []byte("y\r")
+
+	go receiver.run(logger, true)
+	go sender.run(logger, false)
+	for _, transfer := range []*zmodemTransfer{receiver, sender} {
+		select {
+		case <-transfer.done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("transfer did not finish")
+		}
+	}
+
+	received, err := ioutil.ReadFile(filepath.Join(targetDir, "data.bin.1"))
+	assert.Nil(t, err)
+	assert.Equal(t, content, received)
+	existing, _ := ioutil.ReadFile(filepath.Join(targetDir, "data.bin"))
+	assert.Equal(t, "existing", string(existing))
+}
+
+func TestZmodemHandlerRoutesOutputAndInput(t *testing.T) {
+	var sent [][]byte
+	var out bytes.Buffer
+	handler := &zmodemHandler{
+		send: func(input []byte) error {
+			sent = append(sent, input)
+			return nil
+		},
+		display: func(output []byte) {},
+		out:     &out,
+		dir:     t.TempDir(),
+	}
+
+	display := handler.processOutput(logger, []byte("$ rz\r\n**\x18B0100000023be50\r\x8a\x11"))
+	assert.Equal(t, "$ rz\r\n", string(display))
+	assert.True(t, handler.processInput([]byte{keyCtrlC}))
+
+	transfer := handler.runningTransfer()
+	<-transfer.done
+	assert.Contains(t, out.String(), "aborted by user")
+	assert.Equal(t, zmodemCancelSequence, sent[len(sent)-1])
+
+	assert.Equal(t, "$ ", string(handler.processOutput(logger, []byte("$ "))))
+	assert.False(t, handler.processInput([]byte("ls")))
+	assert.Nil(t, handler.runningTransfer())
+}
+
+func TestZmodemTransferDeclinedFileIsSkipped(t *testing.T) {
+	sourceDir := t.TempDir()
+	targetDir := t.TempDir()
+	path := filepath.Join(sourceDir, "data.bin")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("content"), 0600))
+
+	receiver := newZmodemPeer(targetDir, []byte("rz\r**\x18B00000000000000\r\x8a\x11"))
+	sender := newZmodemPeer(sourceDir, nil)
+	receiver.send = deliverTo(sender)
+	sender.send = deliverTo(receiver)
+	sender.input.keys <- []byte(path + "\r")
+	receiver.input.keys <- []byte("n\r")
+
+	go receiver.run(logger, true)
+	go sender.run(logger, false)
+	for _, transfer := range []*zmodemTransfer{receiver, sender} {
+		select {
+		case <-transfer.done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("transfer did not finish")
+		}
+	}
+
+	files, err := ioutil.ReadDir(targetDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(files))
+}
+
+func TestZmodemHandlerDoesNotWaitForPrompt(t *testing.T) {
+	var out bytes.Buffer
+	handler := &zmodemHandler{
+		send:    func(input []byte) error { return nil },
+		display: func(output []byte) {},
+		out:     &out,
+		dir:     t.TempDir(),
+	}
+
+	// rz waits for files and the transfer prompts for them while more output arrives
+	handler.processOutput(logger, []byte("**\x18B0100000023be50\r\x8a\x11"))
+	returned := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			assert.Equal(t, 0, len(handler.processOutput(logger, []byte("**\x18B0100000023be50\r\x8a\x11"))))
+		}
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("output handler waited for the transfer")
+	}
+
+	handler.processInput([]byte{keyCtrlC})
+	<-handler.runningTransfer().done
+}
+
+func TestZmodemHandlerFindsSplitStartSequence(t *testing.T) {
+	handler := &zmodemHandler{
+		send:    func(input []byte) error { return nil },
+		display: func(output []byte) {},
+		out:     ioutil.Discard,
+		dir:     t.TempDir(),
+	}
+
+	assert.Equal(t, "$ rz\r\n**", string(handler.processOutput(logger, []byte("$ rz\r\n**"))))
+	assert.Equal(t, 0, len(handler.processOutput(logger, []byte("\x18B0100000023be50\r\x8a\x11"))))
+	transfer := handler.runningTransfer()
+	assert.NotNil(t, transfer)
+
+	handler.processInput([]byte{keyCtrlC})
+	<-transfer.done
+}
+
+func TestZmodemHandlerDisplaysOutputFollowingTransfer(t *testing.T) {
+	var displayed bytes.Buffer
+	handler := &zmodemHandler{
+		send:    func(input []byte) error { return nil },
+		display: func(output []byte) { displayed.Write(output) },
+		out:     ioutil.Discard,
+		dir:     t.TempDir(),
+	}
+
+	// the transfer is aborted before it reads the output queued after the start sequence
+	handler.processOutput(logger, []byte("**\x18B0100000023be50\r\x8a\x11"))
+	transfer := handler.runningTransfer()
+	handler.processOutput(logger, []byte("$ "))
+	handler.processInput([]byte{keyCtrlC})
+	<-transfer.done
+
+	assert.Contains(t, displayed.String(), "$ ")
+	assert.Nil(t, handler.runningTransfer())
+	assert.Equal(t, "ls", string(handler.processOutput(logger, []byte("ls"))))
+}
+
+func TestCheckZmodemDir(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, CheckZmodemDir(dir))
+	assert.NotNil(t, CheckZmodemDir(filepath.Join(dir, "missing")))
+
+	file := filepath.Join(dir, "file")
+	assert.Nil(t, ioutil.WriteFile(file, nil, 0600))
+	assert.NotNil(t, CheckZmodemDir(file))
+}
+
+func TestCreateUniqueFileIgnoresRemotePaths(t *testing.T) {
+	dir := t.TempDir()
+	file, path, err := createUniqueFile(dir, "../../etc/passwd")
+	assert.Nil(t, err)
+	file.Close()
+	assert.Equal(t, filepath.Join(dir, "passwd"), path)
+
+	_, _, err = createUniqueFile(dir, "..")
+	assert.NotNil(t, err)
+}
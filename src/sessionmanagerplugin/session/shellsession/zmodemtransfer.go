@@ -0,0 +1,706 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package shellsession starts shell session.
+package shellsession
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/config"
+	"github.com/aws/session-manager-plugin/src/log"
+)
+
+const (
+	// ZmodemTimeout is the longest wait for the remote side during a transfer
+	ZmodemTimeout = 30 * time.Second
+	// ZmodemAckInterval is the number of subpackets sent before waiting for an acknowledgement
+	ZmodemAckInterval = 16
+	// zmodemMaxRetries bounds the number of times a position is resent after corrupted data
+	zmodemMaxRetries = 10
+	// zmodemMaxQueued bounds the output of the remote side queued while a transfer waits for the user
+	zmodemMaxQueued = 4 << 20
+)
+
+var (
+	errZmodemAborted = errors.New("transfer aborted by user")
+	errZmodemSkipped = errors.New("file skipped")
+)
+
+// CheckZmodemDir returns an error unless dir is a directory files received by ZMODEM transfers can be written to.
+func CheckZmodemDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("invalid ZMODEM download directory: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("invalid ZMODEM download directory: %s is not a directory", dir)
+	}
+	return nil
+}
+
+// zmodemHandler detects ZMODEM transfers started by sz or rz in the remote shell and runs them
+// in place of the terminal display. Keyboard input is routed to the running transfer.
+type zmodemHandler struct {
+	mutex    sync.Mutex
+	transfer *zmodemTransfer
+	// tail is the end of the output displayed last, which may hold the beginning of a start sequence
+	tail []byte
+	send func(input []byte) error
+	// display shows the output that followed a transfer as any other output of the remote shell
+	display func(output []byte)
+	out     io.Writer
+	dir     string
+}
+
+// processOutput queues output for the running transfer or starts a transfer when the output contains
+// a ZMODEM start sequence. The output that is to be displayed is returned. It never waits for the
+// transfer, which may be waiting for the user.
+func (h *zmodemHandler) processOutput(log log.T, payload []byte) []byte {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.transfer != nil {
+		if h.transfer.input.queue(payload) {
+			return nil
+		}
+		h.transfer = nil
+	}
+
+	// the start sequence may be split between payloads
+	output := append(append([]byte(nil), h.tail...), payload...)
+	index, receive := zmodemStart(output)
+	if index < 0 {
+		if len(output) >= zmodemStartLength {
+			output = output[len(output)-zmodemStartLength+1:]
+		}
+		h.tail = output
+		return payload
+	}
+	h.tail = nil
+
+	transfer := &zmodemTransfer{
+		input: newZmodemInput(output[index:], ZmodemTimeout),
+		send:  h.send,
+		out:   h.out,
+		dir:   h.dir,
+		ended: h.endTransfer,
+		done:  make(chan struct{}),
+	}
+	transfer.decoder = zmodemDecoder{reader: bufio.NewReader(transfer.input)}
+	h.transfer = transfer
+	go transfer.run(log, receive)
+	// the beginning of the start sequence in the previous output was displayed with it
+	if index -= len(output) - len(payload); index < 0 {
+		index = 0
+	}
+	return payload[:index]
+}
+
+// processInput routes keyboard input to the running transfer and reports whether it was consumed.
+func (h *zmodemHandler) processInput(input []byte) bool {
+	transfer := h.runningTransfer()
+	if transfer == nil {
+		return false
+	}
+	select {
+	case <-transfer.done:
+		return false
+	default:
+	}
+	select {
+	case transfer.input.keys <- append([]byte(nil), input...):
+	default:
+		// keys are only read for prompts and to abort, drop typed ahead input
+	}
+	return true
+}
+
+// runningTransfer returns the transfer started last, if any.
+func (h *zmodemHandler) runningTransfer() *zmodemTransfer {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.transfer
+}
+
+// endTransfer forgets a finished transfer and displays the output that followed it, before any output
+// processed after it.
+func (h *zmodemHandler) endTransfer(transfer *zmodemTransfer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.transfer == transfer {
+		h.transfer = nil
+	}
+	if rest := transfer.unread(); len(rest) > 0 {
+		h.display(rest)
+	}
+}
+
+// zmodemInput reads the output of the remote shell during a transfer. Reads fail when the user
+// presses Ctrl+C or the remote side does not send anything within the timeout.
+type zmodemInput struct {
+	mutex sync.Mutex
+	// queued is the output not read yet, closed is set once the transfer ended
+	queued  []byte
+	closed  bool
+	ready   chan struct{}
+	keys    chan []byte
+	typed   []byte
+	buffer  []byte
+	timeout time.Duration
+}
+
+// newZmodemInput returns the input of a transfer starting with initial output.
+func newZmodemInput(initial []byte, timeout time.Duration) *zmodemInput {
+	return &zmodemInput{
+		ready:   make(chan struct{}, 1),
+		keys:    make(chan []byte, 64),
+		buffer:  initial,
+		timeout: timeout,
+	}
+}
+
+// queue adds output for the transfer and reports whether it was taken, false once the transfer ended.
+// Output beyond zmodemMaxQueued is dropped, the transfer recovers or fails as with lost data.
+func (z *zmodemInput) queue(output []byte) bool {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	if z.closed {
+		return false
+	}
+	if len(z.queued)+len(output) <= zmodemMaxQueued {
+		z.queued = append(z.queued, output...)
+	}
+	select {
+	case z.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// close ends the input and returns the output that was not read.
+func (z *zmodemInput) close() []byte {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	z.closed = true
+	rest := append(z.buffer, z.queued...)
+	z.buffer, z.queued = nil, nil
+	return rest
+}
+
+// take moves the queued output to the read buffer.
+func (z *zmodemInput) take() {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	z.buffer, z.queued = z.queued, nil
+}
+
+// Read implements io.Reader.
+func (z *zmodemInput) Read(p []byte) (int, error) {
+	for len(z.buffer) == 0 {
+		if z.take(); len(z.buffer) > 0 {
+			break
+		}
+		select {
+		case <-z.ready:
+		case key := <-z.keys:
+			if bytes.IndexByte(key, keyCtrlC) >= 0 {
+				return 0, errZmodemAborted
+			}
+			// typed ahead of a prompt
+			z.typed = append(z.typed, key...)
+		case <-time.After(z.timeout):
+			return 0, fmt.Errorf("no response from the remote side within %v", z.timeout)
+		}
+	}
+	n := copy(p, z.buffer)
+	z.buffer = z.buffer[n:]
+	return n, nil
+}
+
+// zmodemTransfer is one ZMODEM session with sz or rz in the remote shell.
+type zmodemTransfer struct {
+	input   *zmodemInput
+	decoder zmodemDecoder
+	encoder zmodemEncoder
+	send    func(input []byte) error
+	out     io.Writer
+	dir     string
+	// ended, if set, is called once the transfer ended and takes the output that followed it
+	ended func(transfer *zmodemTransfer)
+	done  chan struct{}
+}
+
+// run receives the files offered by sz or sends files to rz, then hands the output that followed to ended.
+func (t *zmodemTransfer) run(log log.T, receive bool) {
+	defer close(t.done)
+
+	var err error
+	if receive {
+		err = t.receive(log)
+	} else {
+		err = t.sendFiles(log)
+	}
+	if err != nil {
+		log.Errorf("ZMODEM transfer failed: %v", err)
+		fmt.Fprintf(t.out, "\r\n[zmodem] Transfer failed: %v\r\n", err)
+		if sendErr := t.send(zmodemCancelSequence); sendErr != nil {
+			log.Errorf("Failed to cancel ZMODEM transfer: %v", sendErr)
+		}
+	}
+
+	if t.ended != nil {
+		t.ended(t)
+	} else {
+		t.unread()
+	}
+}
+
+// unread ends the input of the transfer and returns the output it did not read, which belongs to the
+// terminal again.
+func (t *zmodemTransfer) unread() (rest []byte) {
+	if buffered := t.decoder.reader.Buffered(); buffered > 0 {
+		peeked, _ := t.decoder.reader.Peek(buffered)
+		rest = append(rest, peeked...)
+	}
+	return append(rest, t.input.close()...)
+}
+
+// sendFrame sends a frame to the remote side in messages of at most StreamDataPayloadSize bytes.
+func (t *zmodemTransfer) sendFrame(frame []byte) error {
+	for len(frame) > 0 {
+		size := len(frame)
+		if size > config.StreamDataPayloadSize {
+			size = config.StreamDataPayloadSize
+		}
+		if err := t.send(frame[:size]); err != nil {
+			return err
+		}
+		frame = frame[size:]
+	}
+	return nil
+}
+
+// readHeader returns the next valid header, skipping corrupted ones, and fails when the remote side aborts.
+func (t *zmodemTransfer) readHeader() (header zmodemHeader, err error) {
+	for {
+		if header, err = t.decoder.readHeader(); err != nil {
+			if _, ok := err.(*zmodemDataError); ok {
+				continue
+			}
+			return
+		}
+		switch header.frameType {
+		case zCAN, zABORT:
+			return header, errZmodemCancelled
+		case zFERR:
+			return header, errors.New("file error on the remote side")
+		}
+		return
+	}
+}
+
+// receive answers sz and receives the offered files into the download directory.
+func (t *zmodemTransfer) receive(log log.T) (err error) {
+	rinit := zmodemHeader{frameType: zRINIT}
+	rinit.data[3] = zCANFDX | zCANOVIO | zCANFC32
+
+	// the ZRQINIT that started the transfer is answered first
+	for {
+		var header zmodemHeader
+		if header, err = t.readHeader(); err != nil {
+			return
+		}
+		switch header.frameType {
+		case zRQINIT:
+			err = t.sendFrame(t.encoder.hexHeader(rinit))
+		case zSINIT:
+			if _, _, err = t.decoder.readSubpacket(header.crc32); err == nil {
+				err = t.sendFrame(t.encoder.hexHeader(newPositionHeader(zACK, 0)))
+			}
+		case zFILE:
+			var info []byte
+			if info, _, err = t.decoder.readSubpacket(header.crc32); err != nil {
+				if _, ok := err.(*zmodemDataError); !ok {
+					return
+				}
+				// corrupted file header, ask for it again
+				err = t.sendFrame(t.encoder.hexHeader(newPositionHeader(zNAK, 0)))
+			} else if err = t.confirmFile(log, info); err == nil {
+				if err = t.receiveFile(log, info); err == nil {
+					err = t.sendFrame(t.encoder.hexHeader(rinit))
+				}
+			}
+			if err == errZmodemSkipped {
+				// the sender moves on to the next file without waiting for ZRINIT
+				err = nil
+			}
+		case zFIN:
+			if err = t.sendFrame(t.encoder.hexHeader(zmodemHeader{frameType: zFIN})); err != nil {
+				return
+			}
+			t.readOverAndOut()
+			return nil
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// confirmFile asks the user whether to receive the file described by the ZFILE subpacket, a file that is
+// declined is skipped.
+func (t *zmodemTransfer) confirmFile(log log.T, info []byte) (err error) {
+	name, size := parseZmodemFileInfo(info)
+	// the name is quoted as it comes from the remote side and may hold control sequences
+	answer, err := t.readLine(fmt.Sprintf("\r\n[zmodem] Receive %q (%d bytes) into %s? [y/N] ", name, size, t.dir))
+	if err != nil {
+		return
+	}
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer == "y" || answer == "yes" {
+		return nil
+	}
+	log.Infof("Declined to receive %q", name)
+	fmt.Fprintf(t.out, "[zmodem] Skipping %q\r\n", name)
+	if err = t.sendFrame(t.encoder.hexHeader(zmodemHeader{frameType: zSKIP})); err != nil {
+		return
+	}
+	return errZmodemSkipped
+}
+
+// receiveFile receives the file described by the ZFILE subpacket, files that cannot be created are skipped.
+func (t *zmodemTransfer) receiveFile(log log.T, info []byte) (err error) {
+	name, size := parseZmodemFileInfo(info)
+	file, path, err := createUniqueFile(t.dir, name)
+	if err != nil {
+		log.Errorf("Cannot create file for %q: %v", name, err)
+		fmt.Fprintf(t.out, "\r\n[zmodem] Skipping %q: %v\r\n", name, err)
+		if err = t.sendFrame(t.encoder.hexHeader(zmodemHeader{frameType: zSKIP})); err != nil {
+			return
+		}
+		return errZmodemSkipped
+	}
+	defer file.Close()
+	log.Infof("Receiving %q to %s", name, path)
+	fmt.Fprintf(t.out, "\r\n[zmodem] Receiving %q (%d bytes) to %q\r\n", name, size, path)
+
+	var position int64
+	retries := 0
+	if err = t.sendFrame(t.encoder.hexHeader(newPositionHeader(zRPOS, position))); err != nil {
+		return
+	}
+	for {
+		var header zmodemHeader
+		if header, err = t.readHeader(); err != nil {
+			return
+		}
+		switch header.frameType {
+		case zDATA:
+			if header.position() != position {
+				err = t.sendFrame(t.encoder.hexHeader(newPositionHeader(zRPOS, position)))
+				break
+			}
+			if err = t.receiveData(file, header, &position, size); err != nil {
+				if _, ok := err.(*zmodemDataError); !ok {
+					return
+				}
+				if retries >= zmodemMaxRetries {
+					return fmt.Errorf("too many retries: %v", err)
+				}
+				retries++
+				log.Debugf("Requesting data from position %d after %v", position, err)
+				err = t.sendFrame(t.encoder.hexHeader(newPositionHeader(zRPOS, position)))
+			}
+		case zEOF:
+			if header.position() != position {
+				// data is still outstanding
+				break
+			}
+			fmt.Fprintf(t.out, "\r[zmodem] Received %q, %d bytes\r\n", path, position)
+			return file.Close()
+		case zFILE:
+			// the ZRPOS was lost, the file header is sent again
+			if _, _, err = t.decoder.readSubpacket(header.crc32); err == nil {
+				err = t.sendFrame(t.encoder.hexHeader(newPositionHeader(zRPOS, position)))
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// receiveData writes the subpackets following a ZDATA header until the frame ends.
+func (t *zmodemTransfer) receiveData(file *os.File, header zmodemHeader, position *int64, size int64) error {
+	for {
+		data, frameEnd, err := t.decoder.readSubpacket(header.crc32)
+		if err != nil {
+			return err
+		}
+		if _, err = file.Write(data); err != nil {
+			return err
+		}
+		*position += int64(len(data))
+		t.showProgress(*position, size)
+
+		switch frameEnd {
+		case zCRCW:
+			return t.sendFrame(t.encoder.hexHeader(newPositionHeader(zACK, *position)))
+		case zCRCQ:
+			if err = t.sendFrame(t.encoder.hexHeader(newPositionHeader(zACK, *position))); err != nil {
+				return err
+			}
+		case zCRCE:
+			return nil
+		}
+	}
+}
+
+// readOverAndOut consumes the "OO" sz writes after the session ended.
+func (t *zmodemTransfer) readOverAndOut() {
+	t.input.timeout = time.Second
+	for i := 0; i < 2; i++ {
+		if c, err := t.decoder.reader.ReadByte(); err != nil || c != 'O' {
+			if err == nil {
+				t.decoder.reader.UnreadByte()
+			}
+			return
+		}
+	}
+}
+
+// sendFiles asks the user for files and sends them to rz.
+func (t *zmodemTransfer) sendFiles(log log.T) (err error) {
+	var header zmodemHeader
+	if header, err = t.readHeader(); err != nil {
+		return
+	}
+	t.encoder.escapeControl = header.flags()&zESCCTL != 0
+
+	line, err := t.readLine("\r\n[zmodem] Remote is waiting for files. Files to send (empty to cancel): ")
+	if err != nil {
+		return
+	}
+	paths := strings.Fields(line)
+	if len(paths) == 0 {
+		return errZmodemAborted
+	}
+
+	for _, path := range paths {
+		if err = t.sendFile(log, path); err != nil {
+			return
+		}
+	}
+
+	if err = t.sendFrame(t.encoder.hexHeader(zmodemHeader{frameType: zFIN})); err != nil {
+		return
+	}
+	for {
+		if header, err = t.readHeader(); err != nil {
+			return
+		}
+		if header.frameType == zFIN {
+			return t.sendFrame([]byte("OO"))
+		}
+	}
+}
+
+// sendFile offers a local file to rz and sends it from the position it asks for.
+func (t *zmodemTransfer) sendFile(log log.T, path string) (err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return
+	}
+	size := fileInfo.Size()
+	name := filepath.Base(path)
+	log.Infof("Sending %s as %q", path, name)
+	fmt.Fprintf(t.out, "\r\n[zmodem] Sending %s (%d bytes)\r\n", path, size)
+
+	info := fmt.Sprintf("%s\x00%d %o %o 0 1 %d\x00", name, size, fileInfo.ModTime().Unix(), 0100000|fileInfo.Mode().Perm(), size)
+	offer := append(t.encoder.binaryHeader(zmodemHeader{frameType: zFILE}), t.encoder.subpacket([]byte(info), zCRCW)...)
+	if err = t.sendFrame(offer); err != nil {
+		return
+	}
+
+	var header zmodemHeader
+	for {
+		if header, err = t.readHeader(); err != nil {
+			return
+		}
+		switch header.frameType {
+		case zRINIT:
+			// the offer was lost
+			err = t.sendFrame(offer)
+		case zSKIP:
+			fmt.Fprintf(t.out, "[zmodem] Remote skipped %s\r\n", name)
+			return nil
+		case zRPOS:
+			return t.sendData(file, header.position(), size)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// sendData sends the file from position, waiting for an acknowledgement every ZmodemAckInterval
+// subpackets, and repositions when the receiver asks for it.
+func (t *zmodemTransfer) sendData(file *os.File, position int64, size int64) (err error) {
+	buffer := make([]byte, ZmodemSubpacketSize)
+	retries := 0
+	for {
+		if _, err = file.Seek(position, io.SeekStart); err != nil {
+			return
+		}
+		if err = t.sendFrame(t.encoder.binaryHeader(newPositionHeader(zDATA, position))); err != nil {
+			return
+		}
+
+		frameEnd := byte(zCRCG)
+		for count := 1; frameEnd == zCRCG; count++ {
+			n, readErr := io.ReadFull(file, buffer)
+			if readErr != nil && readErr != io.ErrUnexpectedEOF && readErr != io.EOF {
+				return readErr
+			}
+			if position+int64(n) >= size {
+				frameEnd = zCRCE
+			} else if count%ZmodemAckInterval == 0 {
+				frameEnd = zCRCW
+			}
+			if err = t.sendFrame(t.encoder.subpacket(buffer[:n], frameEnd)); err != nil {
+				return
+			}
+			position += int64(n)
+			t.showProgress(position, size)
+		}
+		if frameEnd == zCRCE {
+			if err = t.sendFrame(t.encoder.hexHeader(newPositionHeader(zEOF, position))); err != nil {
+				return
+			}
+		}
+
+		// wait for the acknowledgement of ZCRCW or the end of file
+		var header zmodemHeader
+		for waiting := true; waiting; {
+			if header, err = t.readHeader(); err != nil {
+				return
+			}
+			switch header.frameType {
+			case zACK:
+				waiting = frameEnd != zCRCW
+			case zRINIT:
+				if frameEnd == zCRCE {
+					fmt.Fprintf(t.out, "\r[zmodem] Sent %s, %d bytes\r\n", file.Name(), size)
+					return nil
+				}
+			case zRPOS:
+				if retries++; retries > zmodemMaxRetries {
+					return errors.New("too many retries")
+				}
+				position = header.position()
+				waiting = false
+			}
+		}
+	}
+}
+
+// readLine reads a line typed by the user, echoing it as the terminal is not echoing during the session.
+func (t *zmodemTransfer) readLine(prompt string) (string, error) {
+	fmt.Fprint(t.out, prompt)
+	var line []byte
+	for {
+		key := t.input.typed
+		t.input.typed = nil
+		if len(key) == 0 {
+			key = <-t.input.keys
+		}
+		for _, c := range key {
+			switch c {
+			case keyEnter, keyLineFeed:
+				fmt.Fprint(t.out, "\r\n")
+				return string(line), nil
+			case keyCtrlC:
+				return "", errZmodemAborted
+			case keyBackspace, keyDelete:
+				if len(line) > 0 {
+					line = line[:len(line)-1]
+					fmt.Fprint(t.out, "\b \b")
+				}
+			default:
+				if c >= 0x20 {
+					line = append(line, c)
+					t.out.Write([]byte{c})
+				}
+			}
+		}
+	}
+}
+
+// showProgress updates the progress of the current file in place.
+func (t *zmodemTransfer) showProgress(position int64, size int64) {
+	if size > 0 {
+		fmt.Fprintf(t.out, "\r[zmodem] %d of %d bytes (%d%%)", position, size, position*100/size)
+	} else {
+		fmt.Fprintf(t.out, "\r[zmodem] %d bytes", position)
+	}
+}
+
+// parseZmodemFileInfo returns the name and size from a ZFILE subpacket, "name\0size mtime mode ...".
+func parseZmodemFileInfo(info []byte) (name string, size int64) {
+	parts := bytes.SplitN(info, []byte{0}, 2)
+	name = string(parts[0])
+	if len(parts) > 1 {
+		if fields := strings.Fields(string(bytes.TrimRight(parts[1], "\x00"))); len(fields) > 0 {
+			size, _ = strconv.ParseInt(fields[0], 10, 64)
+		}
+	}
+	return
+}
+
+// createUniqueFile creates a new file for a received file name in dir. Paths sent by the remote side
+// are reduced to their base name and existing files are never overwritten, name.1, name.2 and so
+// on are used instead.
+func createUniqueFile(dir string, name string) (*os.File, string, error) {
+	name = filepath.Base(filepath.Clean("/" + strings.Replace(name, "\\", "/", -1)))
+	if name == "/" || name == "." {
+		return nil, "", fmt.Errorf("invalid file name")
+	}
+	for i := 0; i < 100; i++ {
+		path := filepath.Join(dir, name)
+		if i > 0 {
+			path = fmt.Sprintf("%s.%d", path, i)
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			return file, path, nil
+		}
+		if !os.IsExist(err) {
+			return nil, "", err
+		}
+	}
+	return nil, "", fmt.Errorf("too many files named %q", name)
+}
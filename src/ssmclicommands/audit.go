@@ -0,0 +1,143 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package ssmclicommands contains all the commands with its implementation.
+package ssmclicommands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/aws/session-manager-plugin/src/ssmclicommands/utils"
+)
+
+const (
+	AUDIT        = "audit"
+	AUDIT_VERIFY = "verify"
+	FILE         = "file"
+	KEY_FILE     = "key-file"
+)
+
+var AuditParameterKeys = []string{utils.PositionalArguments, FILE, KEY_FILE}
+
+const AUDIT_HELP = `NAME : {{.AuditName}}
+
+SYNOPSIS:
+	{{.SsmCliName}}
+	{{.AuditName}} {{.VerifyName}}
+	{{.File}}
+	[{{.KeyFile}}]
+
+PARAMETERS:
+	{{.File}} (string) Audit log file
+	Path of the audit log written with start-session --audit-log
+
+	{{.KeyFile}} (string) Key file
+	File containing the key the audit log was written with, required if the log uses HMAC-SHA256
+
+Command:
+      {{.SsmCliName}} {{.AuditName}} {{.VerifyName}} --{{.File}} ~/.ssm/audit.log --{{.KeyFile}} ~/.ssm/audit.key
+`
+
+type AuditHelpParams struct {
+	SsmCliName string
+	AuditName  string
+	VerifyName string
+	File       string
+	KeyFile    string
+}
+
+type AuditCommand struct {
+	helpText string
+}
+
+// verifyAuditLog checks the hash chain of the audit log.
+var verifyAuditLog = func(path string, key []byte) (int64, error) {
+	return session.VerifyAuditLog(path, key)
+}
+
+func init() {
+	utils.Register(&AuditCommand{})
+}
+
+// Name is the command name used in the cli
+func (AuditCommand) Name() string {
+	return AUDIT
+}
+
+// Help prints help for the audit cli command
+func (c *AuditCommand) Help() string {
+	if len(c.helpText) == 0 {
+		t, _ := template.New("AuditHelp").Parse(AUDIT_HELP)
+		params := AuditHelpParams{
+			utils.SsmCliName,
+			AUDIT,
+			AUDIT_VERIFY,
+			FILE,
+			KEY_FILE,
+		}
+		buf := new(bytes.Buffer)
+		t.Execute(buf, params)
+		c.helpText = buf.String()
+	}
+	return c.helpText
+}
+
+// validates and execute audit command
+func (c *AuditCommand) Execute(parameters map[string][]string) (error, string) {
+	validation := c.validateAuditInput(parameters)
+	if len(validation) > 0 {
+		return errors.New(strings.Join(validation, "\n")), ""
+	}
+
+	var (
+		key []byte
+		err error
+	)
+	if parameters[KEY_FILE] != nil {
+		if key, err = session.ReadAuditKeyFile(parameters[KEY_FILE][0]); err != nil {
+			return err, "Audit log verification failed"
+		}
+	}
+
+	count, err := verifyAuditLog(parameters[FILE][0], key)
+	if err != nil {
+		return fmt.Errorf("audit log verification failed after %d valid records: %v", count, err), ""
+	}
+	return nil, fmt.Sprintf("Audit log verified, %d records intact.\n", count)
+}
+
+// func to validate audit input
+func (AuditCommand) validateAuditInput(parameters map[string][]string) []string {
+	validation := make([]string, 0)
+
+	if subcommand := utils.GetSubcommand(parameters); subcommand != AUDIT_VERIFY {
+		validation = append(validation, fmt.Sprintf("unknown subcommand %q, supported subcommands: %v", subcommand, AUDIT_VERIFY))
+	}
+
+	if len(parameters[FILE]) == 0 {
+		validation = append(validation, fmt.Sprintf("%v is required", utils.FormatFlag(FILE)))
+	}
+
+	for key := range parameters {
+		if !contains(AuditParameterKeys, key) {
+			validation = append(validation, fmt.Sprintf("%v not a valid command parameter flag", key))
+		}
+	}
+
+	return validation
+}
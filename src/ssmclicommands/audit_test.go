@@ -0,0 +1,62 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package ssmclicommands contains all the commands with its implementation.
+package ssmclicommands
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditCommand_Help(t *testing.T) {
+	command := &AuditCommand{}
+	assert.Contains(t, command.Help(), "SYNOPSIS:")
+}
+
+func TestAuditCommand_ExecuteVerify(t *testing.T) {
+	args := []string{1: "audit", 2: "verify", 3: "--file", 4: "audit.log"}
+	err, _, _, _, parameters := ParseCliCommand(args)
+	assert.Nil(t, err)
+
+	verifyAuditLog = func(path string, key []byte) (int64, error) {
+		assert.Equal(t, "audit.log", path)
+		assert.Nil(t, key)
+		return 3, nil
+	}
+	command := &AuditCommand{}
+	err, msg := command.Execute(parameters)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, "3 records intact")
+
+	verifyAuditLog = func(path string, key []byte) (int64, error) {
+		return 1, fmt.Errorf("hash mismatch for record 2")
+	}
+	err, _ = command.Execute(parameters)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "after 1 valid records: hash mismatch for record 2")
+}
+
+func TestAuditCommand_validateAuditInput(t *testing.T) {
+	args := []string{1: "audit", 2: "check"}
+	err, _, _, _, parameters := ParseCliCommand(args)
+	assert.Nil(t, err)
+
+	command := &AuditCommand{}
+	validation := command.validateAuditInput(parameters)
+	assert.Equal(t, 2, len(validation))
+	assert.Contains(t, validation[0], "unknown subcommand \"check\"")
+	assert.Equal(t, "--file is required", validation[1])
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package ssmclicommands contains all the commands with its implementation.
+package ssmclicommands
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/portsession"
+	"github.com/aws/session-manager-plugin/src/ssmclicommands/utils"
+)
+
+// daemonEventsDescriptor is the file descriptor the background process writes its ready events to
+const daemonEventsDescriptor = 3
+
+// executeDaemon starts the command again in the background and returns once all its local listeners are ready
+func (s *StartSessionCommand) executeDaemon(log log.T, parameters map[string][]string) (error, string) {
+	listeners := len(parameters[PORT_MAPPING])
+	if listeners == 0 {
+		listeners = 1
+	}
+	pid, err := startDaemon(log, daemonArguments(parameters), listeners, os.Stdout)
+	if err != nil {
+		log.Errorf("Cannot start session in the background: %v", err)
+		return err, "StartSession failed"
+	}
+	return nil, fmt.Sprintf("Session running in the background as process %d", pid)
+}
+
+// daemonArguments returns the arguments of the command without the daemon flag, writing its ready events to the
+// parent too
+func daemonArguments(parameters map[string][]string) []string {
+	keys := make([]string, 0, len(parameters))
+	for key := range parameters {
+		if key != DAEMON && key != READY_EVENTS && key != utils.PositionalArguments {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	args := []string{START_SESSION}
+	for _, key := range keys {
+		args = append(args, utils.FormatFlag(key))
+		args = append(args, parameters[key]...)
+	}
+	events := fmt.Sprintf("fd:%d", daemonEventsDescriptor)
+	if parameters[READY_EVENTS] != nil {
+		events = parameters[READY_EVENTS][0] + "," + events
+	}
+	return append(args, utils.FormatFlag(READY_EVENTS), events)
+}
+
+// startDaemon runs ssmcli with args in the background, copies the ready events of the given number of listeners
+// to out and returns the process id once they are ready
+var startDaemon = func(log log.T, args []string, listeners int, out io.Writer) (pid int, err error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	events, writer, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer events.Close()
+
+	cmd := exec.Command(executable, args...)
+	if err = daemonProcess(cmd, writer); err != nil {
+		writer.Close()
+		return 0, err
+	}
+	err = cmd.Start()
+	// the pipe closes once the background process exits
+	writer.Close()
+	if err != nil {
+		return 0, err
+	}
+	log.Infof("Started %s %v in the background as process %d.", executable, args, cmd.Process.Pid)
+
+	if err = waitForReadyEvents(events, listeners, out); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+	pid = cmd.Process.Pid
+	cmd.Process.Release()
+	return pid, nil
+}
+
+// waitForReadyEvents copies the ready events read from events to out until the given number of listeners are ready
+func waitForReadyEvents(events io.Reader, listeners int, out io.Writer) error {
+	scanner := bufio.NewScanner(events)
+	for ready := 0; ready < listeners; {
+		if !scanner.Scan() {
+			return errors.New("the session ended before its local ports were open, see the log of ssmcli")
+		}
+		var event portsession.ReadyEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.Event != portsession.ReadyEventReady {
+			continue
+		}
+		fmt.Fprintln(out, scanner.Text())
+		ready++
+	}
+	return nil
+}
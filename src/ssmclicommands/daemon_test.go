@@ -0,0 +1,74 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ssmclicommands
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDaemonArguments(t *testing.T) {
+	args := daemonArguments(map[string][]string{
+		INSTANCE_ID:  {"i-123456"},
+		PORT_MAPPING: {"0=80", "0=db.internal:5432"},
+		DAEMON:       {},
+	})
+	assert.Equal(t, []string{START_SESSION, "--instance-id", "i-123456", "--port-mapping", "0=80", "0=db.internal:5432",
+		"--ready-events", "fd:3"}, args)
+
+	args = daemonArguments(map[string][]string{INSTANCE_ID: {"i-123456"}, READY_EVENTS: {"/tmp/events"}, DAEMON: {}})
+	assert.Equal(t, []string{START_SESSION, "--instance-id", "i-123456", "--ready-events", "/tmp/events,fd:3"}, args)
+}
+
+func TestWaitForReadyEvents(t *testing.T) {
+	events := `{"event":"ready","sessionId":"s-1","target":"i-123456","localPortNumber":"54321"}
+not an event
+{"event":"connected","sessionId":"s-1","target":"i-123456","localPortNumber":"54321"}
+{"event":"ready","sessionId":"s-2","target":"i-123456","localPortNumber":"54322"}
+`
+	out := &strings.Builder{}
+	assert.Nil(t, waitForReadyEvents(strings.NewReader(events), 2, out))
+	assert.Equal(t, `{"event":"ready","sessionId":"s-1","target":"i-123456","localPortNumber":"54321"}
+{"event":"ready","sessionId":"s-2","target":"i-123456","localPortNumber":"54322"}
+`, out.String())
+
+	assert.NotNil(t, waitForReadyEvents(strings.NewReader(events), 3, io.Discard))
+}
+
+func TestStartSessionCommand_ExecuteDaemon(t *testing.T) {
+	defer func(original func(log.T, []string, int, io.Writer) (int, error)) {
+		startDaemon = original
+	}(startDaemon)
+	startDaemon = func(log log.T, args []string, listeners int, out io.Writer) (int, error) {
+		assert.Equal(t, "fd:3", args[len(args)-1])
+		assert.Equal(t, 2, listeners)
+		return 1234, nil
+	}
+
+	command := &StartSessionCommand{}
+	err, result := command.Execute(map[string][]string{
+		INSTANCE_ID:  {"i-123456"},
+		PORT_MAPPING: {"0=80", "0=443"},
+		DAEMON:       {},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "Session running in the background as process 1234", result)
+
+	err, _ = command.Execute(map[string][]string{INSTANCE_ID: {"i-123456"}, DAEMON: {}})
+	assert.EqualError(t, err, "--daemon requires --port-mapping or a port forwarding --document-name")
+}
@@ -0,0 +1,31 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:build darwin || freebsd || linux || netbsd || openbsd
+// +build darwin freebsd linux netbsd openbsd
+
+// Package ssmclicommands contains all the commands with its implementation.
+package ssmclicommands
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// daemonProcess detaches cmd from the terminal in a session of its own, events is its file descriptor 3
+func daemonProcess(cmd *exec.Cmd, events *os.File) error {
+	cmd.ExtraFiles = []*os.File{events}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	return nil
+}
@@ -0,0 +1,31 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:build windows
+// +build windows
+
+// Package ssmclicommands contains all the commands with its implementation.
+package ssmclicommands
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/aws/session-manager-plugin/src/ssmclicommands/utils"
+)
+
+// daemonProcess is not supported, processes do not inherit file descriptors beyond the standard ones on Windows
+func daemonProcess(cmd *exec.Cmd, events *os.File) error {
+	return fmt.Errorf("%v is not supported on Windows, use %v with a file", utils.FormatFlag(DAEMON), utils.FormatFlag(READY_EVENTS))
+}
@@ -58,8 +58,8 @@ func ParseCliCommand(args []string) (err error, options []string, command string
 	subcommand = strings.ToLower(args[pos])
 	pos++
 
-	// Parameters
-	if pos >= argCount {
+	// Parameters, a subcommand given without any parameter is kept as a positional argument
+	if pos >= argCount && utils.IsFlag(subcommand) {
 		return
 	}
 	parameters = make(map[string][]string)
@@ -0,0 +1,189 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package ssmclicommands contains all the commands with its implementation.
+package ssmclicommands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/shellsession"
+	"github.com/aws/session-manager-plugin/src/ssmclicommands/utils"
+)
+
+const (
+	SCRIPT = "script"
+	QUIET  = "quiet"
+)
+
+var ScriptParameterKeys = append([]string{FILE, QUIET}, ParameterKeys...)
+
+const SCRIPT_HELP = `NAME : {{.ScriptName}}
+
+SYNOPSIS:
+	{{.SsmCliName}}
+	{{.ScriptName}}
+	{{.InstanceId}}
+	{{.File}}
+	[{{.Quiet}}]
+
+PARAMETERS:
+	{{.InstanceId}} (string) InstanceId
+	InstanceId is required to start a session
+
+	{{.File}} (string) Script file
+	Script run against the shell session, one command per line:
+	    timeout <duration>             default timeout of following expect commands (30s)
+	    send <text>                    sends text, a "quoted text" may contain escape sequences
+	    sendline <text>                sends text followed by a carriage return
+	    expect /<regexp>/ [duration]   waits for output matching the regular expression
+	    expect "<text>" [duration]     waits for output containing the text
+	    sleep <duration>               pauses the script
+	Groups of the last match are available to send and sendline as ${1} or ${name}.
+
+	{{.Quiet}}
+	Do not copy the shell output to the console
+
+	All {{.StartSessionName}} parameters are supported.
+
+Command:
+      {{.SsmCliName}} {{.ScriptName}} --{{.InstanceId}} i-123456 --{{.File}} runbook.script
+`
+
+type ScriptHelpParams struct {
+	SsmCliName       string
+	ScriptName       string
+	StartSessionName string
+	InstanceId       string
+	File             string
+	Quiet            string
+}
+
+type ScriptCommand struct {
+	helpText string
+}
+
+func init() {
+	utils.Register(&ScriptCommand{})
+}
+
+// Name is the command name used in the cli
+func (ScriptCommand) Name() string {
+	return SCRIPT
+}
+
+// Help prints help for the script cli command
+func (c *ScriptCommand) Help() string {
+	if len(c.helpText) == 0 {
+		t, _ := template.New("ScriptHelp").Parse(SCRIPT_HELP)
+		params := ScriptHelpParams{
+			utils.SsmCliName,
+			SCRIPT,
+			START_SESSION,
+			INSTANCE_ID,
+			FILE,
+			QUIET,
+		}
+		buf := new(bytes.Buffer)
+		t.Execute(buf, params)
+		c.helpText = buf.String()
+	}
+	return c.helpText
+}
+
+// validates and execute script command
+func (c *ScriptCommand) Execute(parameters map[string][]string) (error, string) {
+	validation := c.validateScriptInput(parameters)
+	if len(validation) > 0 {
+		return errors.New(strings.Join(validation, "\n")), ""
+	}
+
+	// parse the script before starting the session so that mistakes do not cost a session
+	file, err := os.Open(parameters[FILE][0])
+	if err != nil {
+		return fmt.Errorf("unable to open script: %v", err), ""
+	}
+	script, err := shellsession.ParseScript(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("invalid script %s: %v", parameters[FILE][0], err), ""
+	}
+
+	expect := shellsession.NewExpect(script.Run)
+	if parameters[QUIET] == nil {
+		expect.Echo = os.Stdout
+	}
+
+	startSessionParameters := make(map[string][]string)
+	for key, value := range parameters {
+		if key != FILE && key != QUIET {
+			startSessionParameters[key] = value
+		}
+	}
+
+	log := log.Logger(true, "ssmcli")
+	startSessionCommand := &StartSessionCommand{}
+	session, err := startSessionCommand.newSession(log, startSessionParameters)
+	if err != nil {
+		return err, "Script failed"
+	}
+	session.ShellAutomation = expect
+
+	if err = executeSession(log, session); err != nil {
+		log.Errorf("Script failed: %v", err)
+		return err, "Script failed"
+	}
+	return nil, fmt.Sprintf("\nScript completed successfully.%s\n", formatCaptures(script.Captures))
+}
+
+// func to validate script input
+func (ScriptCommand) validateScriptInput(parameters map[string][]string) []string {
+	validation := make([]string, 0)
+
+	if parameters[INSTANCE_ID] == nil {
+		validation = append(validation, fmt.Sprintf("%v is required", utils.FormatFlag(INSTANCE_ID)))
+	}
+	if len(parameters[FILE]) == 0 {
+		validation = append(validation, fmt.Sprintf("%v is required", utils.FormatFlag(FILE)))
+	}
+
+	for key := range parameters {
+		if !contains(ScriptParameterKeys, key) {
+			validation = append(validation, fmt.Sprintf("%v not a valid command parameter flag", key))
+		}
+	}
+
+	return validation
+}
+
+// formatCaptures lists the captured groups sorted by name
+func formatCaptures(captures map[string]string) string {
+	names := make([]string, 0, len(captures))
+	for name := range captures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "\n%s=%q", name, captures[name])
+	}
+	return buf.String()
+}
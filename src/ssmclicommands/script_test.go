@@ -0,0 +1,73 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package ssmclicommands contains all the commands with its implementation.
+package ssmclicommands
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScriptCommand_Execute(t *testing.T) {
+	file, _ := ioutil.TempFile("", "script")
+	defer os.Remove(file.Name())
+	file.WriteString("sendline hostname\n")
+	file.Close()
+
+	args := []string{1: "script", 2: "--instance-id", 3: "i-123456", 4: "--file", 5: file.Name(), 6: "--quiet"}
+	err, _, _, _, parameters := ParseCliCommand(args)
+	assert.Nil(t, err)
+
+	getSSMClient = func(log log.T, region string, profile string, endpoint string) (*ssm.SSM, error) {
+		return &ssm.SSM{}, nil
+	}
+	startSession = func(s *StartSessionCommand, input *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
+		assert.Equal(t, "i-123456", *input.Target)
+		return startSessionOutput, nil
+	}
+	executeSession = func(log log.T, session *session.Session) (err error) {
+		assert.NotNil(t, session.ShellAutomation)
+		return nil
+	}
+
+	command := &ScriptCommand{}
+	err, msg := command.Execute(parameters)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, "Script completed successfully")
+}
+
+func TestScriptCommand_ExecuteInvalidScript(t *testing.T) {
+	file, _ := ioutil.TempFile("", "script")
+	defer os.Remove(file.Name())
+	file.WriteString("expect nothing\n")
+	file.Close()
+
+	parameters := map[string][]string{INSTANCE_ID: {"i-123456"}, FILE: {file.Name()}}
+	command := &ScriptCommand{}
+	err, _ := command.Execute(parameters)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "line 1")
+}
+
+func TestScriptCommand_validateScriptInput(t *testing.T) {
+	command := &ScriptCommand{}
+	validation := command.validateScriptInput(map[string][]string{REGION: {"us-east-1"}})
+	assert.Equal(t, []string{"--instance-id is required", "--file is required"}, validation)
+}
@@ -0,0 +1,232 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package ssmclicommands contains all the commands with its implementation.
+package ssmclicommands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/datachannel"
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/portsession"
+	"github.com/aws/session-manager-plugin/src/ssmclicommands/utils"
+)
+
+const (
+	SOCKS        = "socks"
+	LOCAL_PORT   = "local-port"
+	MAX_SESSIONS = "max-sessions"
+	ALLOW        = "allow"
+)
+
+var SocksParameterKeys = []string{INSTANCE_ID, REGION, PROFILE, ENDPOINT, KEEP_ALIVE, LOCAL_HOST, ALLOW_NON_LOOPBACK, LOCAL_PORT, MAX_SESSIONS, ALLOW, IDLE_TIMEOUT}
+
+const SOCKS_HELP = `NAME : {{.SocksName}}
+
+SYNOPSIS:
+	{{.SsmCliName}}
+	{{.SocksName}}
+	{{.InstanceId}}
+	[{{.LocalPort}}]
+	[{{.Allow}}]
+	[{{.MaxSessions}}]
+	[{{.IdleTimeout}}]
+	[{{.Region}}]
+	[{{.Profile}}]
+
+PARAMETERS:
+	{{.InstanceId}} (string) Bastion target
+	Target the destinations of the proxy are reached from, each one in an
+	AWS-StartPortForwardingSessionToRemoteHost session started by its first connection
+
+	{{.LocalPort}} (string) Local port
+	Port the SOCKS5 proxy listens on, 1080 by default
+
+	{{.Allow}} (list) Allowed destinations
+	Destinations HOST[:PORT] the proxy may connect to. HOST is a host name pattern such as *.internal,
+	an IP address or a CIDR network, IPv6 in brackets, PORT a port number, a range such as 8000-8100 or *.
+	All destinations are allowed if not set
+
+	{{.MaxSessions}} (int) Session limit
+	Most sessions open at the same time, connections to further destinations are refused, no limit by default
+
+	{{.IdleTimeout}} (duration) Session idle timeout
+	Ends the session of a destination after it had no connection for the given duration, 5m by default
+
+	{{.Region}} (string) Region
+	Region of the bastion target
+
+	{{.Profile}} (string) Profile
+	AWS credentials profile
+
+	{{.KeepAlive}} (duration) Keep-alive interval
+	Sends an empty message at the given interval while a connection of a session is open
+
+	{{.LocalHost}} (string) Local bind addresses
+	Comma separated addresses the proxy listens on in place of localhost
+
+	{{.AllowNonLoopback}}
+	Listens on non-loopback local addresses without asking for confirmation
+
+Command:
+      {{.SsmCliName}} {{.SocksName}} --{{.InstanceId}} i-123456 --{{.Allow}} *.internal:5432 10.0.0.0/16:443 --{{.MaxSessions}} 5
+`
+
+type SocksHelpParams struct {
+	SsmCliName       string
+	SocksName        string
+	InstanceId       string
+	LocalPort        string
+	Allow            string
+	MaxSessions      string
+	IdleTimeout      string
+	Region           string
+	Profile          string
+	KeepAlive        string
+	LocalHost        string
+	AllowNonLoopback string
+}
+
+type SocksCommand struct {
+	helpText string
+}
+
+// runSocksProxy runs the SOCKS proxy with the settings of base
+var runSocksProxy = func(log log.T, base *session.Session, settings portsession.SocksSettings) error {
+	return portsession.RunSocksProxy(log, base, settings)
+}
+
+func init() {
+	utils.Register(&SocksCommand{})
+}
+
+// Name is the command name used in the cli
+func (SocksCommand) Name() string {
+	return SOCKS
+}
+
+// Help prints help for the socks cli command
+func (c *SocksCommand) Help() string {
+	if len(c.helpText) == 0 {
+		t, _ := template.New("SocksHelp").Parse(SOCKS_HELP)
+		params := SocksHelpParams{
+			utils.SsmCliName,
+			SOCKS,
+			INSTANCE_ID,
+			LOCAL_PORT,
+			ALLOW,
+			MAX_SESSIONS,
+			IDLE_TIMEOUT,
+			REGION,
+			PROFILE,
+			KEEP_ALIVE,
+			LOCAL_HOST,
+			ALLOW_NON_LOOPBACK,
+		}
+		buf := new(bytes.Buffer)
+		t.Execute(buf, params)
+		c.helpText = buf.String()
+	}
+	return c.helpText
+}
+
+// validates and execute socks command
+func (c *SocksCommand) Execute(parameters map[string][]string) (error, string) {
+	validation := c.validateSocksInput(parameters)
+	if len(validation) > 0 {
+		return errors.New(strings.Join(validation, "\n")), ""
+	}
+
+	var (
+		err      error
+		settings portsession.SocksSettings
+	)
+	if settings.Allowlist, err = portsession.ParseAllowlist(parameters[ALLOW]); err != nil {
+		return err, ""
+	}
+	if parameters[LOCAL_PORT] != nil {
+		settings.LocalPortNumber = parameters[LOCAL_PORT][0]
+	}
+	if parameters[MAX_SESSIONS] != nil {
+		if settings.MaxSessions, err = strconv.Atoi(parameters[MAX_SESSIONS][0]); err != nil || settings.MaxSessions <= 0 {
+			return fmt.Errorf("--%s must be a positive number", MAX_SESSIONS), ""
+		}
+	}
+
+	log := log.Logger(true, "ssmcli")
+	base := &session.Session{
+		DataChannel:      &datachannel.DataChannel{},
+		TargetId:         parameters[INSTANCE_ID][0],
+		AllowNonLoopback: parameters[ALLOW_NON_LOOPBACK] != nil,
+	}
+	var region, profile string
+	if parameters[REGION] != nil {
+		region = parameters[REGION][0]
+	}
+	if parameters[PROFILE] != nil {
+		profile = parameters[PROFILE][0]
+	}
+	if parameters[ENDPOINT] != nil {
+		base.Endpoint = parameters[ENDPOINT][0]
+	}
+	if parameters[LOCAL_HOST] != nil {
+		base.LocalHost = parameters[LOCAL_HOST][0]
+	}
+	if parameters[KEEP_ALIVE] != nil {
+		if base.KeepAliveInterval, err = time.ParseDuration(parameters[KEEP_ALIVE][0]); err != nil || base.KeepAliveInterval <= 0 {
+			return fmt.Errorf("--%s must be a positive duration such as 30m", KEEP_ALIVE), ""
+		}
+	}
+	if parameters[IDLE_TIMEOUT] != nil {
+		if base.OnDemand, err = time.ParseDuration(parameters[IDLE_TIMEOUT][0]); err != nil || base.OnDemand <= 0 {
+			return fmt.Errorf("--%s must be a positive duration such as 5m", IDLE_TIMEOUT), ""
+		}
+	}
+	if _, err = getSSMClient(log, region, profile, base.Endpoint); err != nil {
+		return err, "Socks failed"
+	}
+
+	if err = runSocksProxy(log, base, settings); err != nil {
+		log.Errorf("Cannot run SOCKS proxy: %v", err)
+		return err, "Socks failed"
+	}
+	return nil, "SOCKS proxy closed"
+}
+
+// func to validate socks input
+func (SocksCommand) validateSocksInput(parameters map[string][]string) []string {
+	validation := make([]string, 0)
+
+	if parameters[INSTANCE_ID] == nil || len(parameters[INSTANCE_ID]) != 1 {
+		validation = append(validation, fmt.Sprintf("%v requires the bastion target %v", SOCKS, utils.FormatFlag(INSTANCE_ID)))
+	}
+	if len(parameters[utils.PositionalArguments]) > 0 {
+		validation = append(validation, fmt.Sprintf("%v takes no arguments", SOCKS))
+	}
+
+	for key := range parameters {
+		if !contains(SocksParameterKeys, key) && key != utils.PositionalArguments {
+			validation = append(validation, fmt.Sprintf("%v not a valid command parameter flag", key))
+		}
+	}
+
+	return validation
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ssmclicommands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/portsession"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocksCommand_Help(t *testing.T) {
+	command := &SocksCommand{}
+	assert.Contains(t, command.Help(), "SYNOPSIS:")
+}
+
+func TestSocksCommand_Execute(t *testing.T) {
+	args := []string{1: "socks", 2: "--instance-id", 3: "i-123456", 4: "--allow", 5: "*.internal:5432", 6: "10.0.0.0/16",
+		7: "--max-sessions", 8: "3", 9: "--idle-timeout", 10: "10m", 11: "--local-port", 12: "1081"}
+	err, _, _, _, parameters := ParseCliCommand(args)
+	assert.Nil(t, err)
+
+	getSSMClient = func(log log.T, region string, profile string, endpoint string) (*ssm.SSM, error) {
+		return &ssm.SSM{}, nil
+	}
+	defer func(original func(log.T, *session.Session, portsession.SocksSettings) error) {
+		runSocksProxy = original
+	}(runSocksProxy)
+	runSocksProxy = func(log log.T, base *session.Session, settings portsession.SocksSettings) error {
+		assert.Equal(t, "i-123456", base.TargetId)
+		assert.Equal(t, 10*time.Minute, base.OnDemand)
+		assert.Equal(t, "1081", settings.LocalPortNumber)
+		assert.Equal(t, 3, settings.MaxSessions)
+		assert.True(t, settings.Allowlist.Allows("orders.internal", 5432))
+		assert.True(t, settings.Allowlist.Allows("10.0.1.1", 22))
+		assert.False(t, settings.Allowlist.Allows("10.1.1.1", 22))
+		return nil
+	}
+
+	command := &SocksCommand{}
+	err, msg := command.Execute(parameters)
+	assert.Nil(t, err)
+	assert.Equal(t, "SOCKS proxy closed", msg)
+}
+
+func TestSocksCommand_ExecuteInvalidSettings(t *testing.T) {
+	command := &SocksCommand{}
+
+	err, _, _, _, parameters := ParseCliCommand([]string{1: "socks", 2: "--instance-id", 3: "i-123456", 4: "--max-sessions", 5: "none"})
+	assert.Nil(t, err)
+	err, _ = command.Execute(parameters)
+	assert.EqualError(t, err, "--max-sessions must be a positive number")
+
+	err, _, _, _, parameters = ParseCliCommand([]string{1: "socks", 2: "--instance-id", 3: "i-123456", 4: "--allow", 5: "host:http"})
+	assert.Nil(t, err)
+	err, _ = command.Execute(parameters)
+	assert.NotNil(t, err)
+}
+
+func TestSocksCommand_validateSocksInput(t *testing.T) {
+	command := &SocksCommand{}
+
+	err, _, _, _, parameters := ParseCliCommand([]string{1: "socks", 2: "--allow", 3: "*.internal", 4: "--document-name", 5: "doc"})
+	assert.Nil(t, err)
+	validation := command.validateSocksInput(parameters)
+	assert.Equal(t, []string{"socks requires the bastion target --instance-id", "document-name not a valid command parameter flag"}, validation)
+}
@@ -19,7 +19,11 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"net"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	sdkSession "github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
@@ -28,23 +32,57 @@ import (
 	"github.com/aws/session-manager-plugin/src/log"
 	"github.com/aws/session-manager-plugin/src/sdkutil"
 	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
-	_ "github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/portsession"
-	_ "github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/shellsession"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/portsession"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/sessionutil"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/shellsession"
 	"github.com/aws/session-manager-plugin/src/ssmclicommands/utils"
 	"github.com/twinj/uuid"
 )
 
 const (
-	START_SESSION = "start-session"
-	INSTANCE_ID   = "instance-id"
-	REGION        = "region"
-	PROFILE       = "profile"
-	ENDPOINT      = "endpoint"
-	DOCUMENT_NAME = "document-name"
-	PARAMETERS    = "parameters"
+	START_SESSION      = "start-session"
+	INSTANCE_ID        = "instance-id"
+	REGION             = "region"
+	PROFILE            = "profile"
+	ENDPOINT           = "endpoint"
+	DOCUMENT_NAME      = "document-name"
+	PARAMETERS         = "parameters"
+	AUDIT_LOG          = "audit-log"
+	AUDIT_KEY          = "audit-key-file"
+	GUARDRAILS         = "guardrails"
+	CONFIRM_PASTE      = "confirm-paste"
+	REMOTE_ENCODING    = "remote-encoding"
+	WATCH_SOCKET       = "watch-socket"
+	WATCH_SOCKET_MODE  = "watch-socket-mode"
+	STATUS_LINE        = "status-line"
+	PREDICTIVE_ECHO    = "predictive-echo"
+	ZMODEM_DIR         = "zmodem-dir"
+	SIGNAL_MAP         = "signal-map"
+	MAX_RESTARTS       = "max-restarts"
+	IDLE_TIMEOUT       = "idle-timeout"
+	MAX_DURATION       = "max-duration"
+	LIMIT_WARNING      = "limit-warning"
+	KEEP_ALIVE         = "keep-alive"
+	SESSION_PER_CONN   = "session-per-connection"
+	LOCAL_HOST         = "local-host"
+	ALLOW_NON_LOOPBACK = "allow-non-loopback"
+	PORT_MAPPING       = "port-mapping"
+	ON_DEMAND          = "on-demand"
+	HTTP_PROXY         = "http-proxy"
+	HTTP_HOST          = "http-host"
+	HTTP_TLS           = "http-tls"
+	READY_EVENTS       = "ready-events"
+	DAEMON             = "daemon"
+	ALLOW_CLIENTS      = "allow-clients"
+	ALLOW_UIDS         = "allow-uids"
+	ALLOW_GIDS         = "allow-gids"
+	SOCKET_MODE        = "socket-mode"
+	SOCKET_OWNER       = "socket-owner"
+	MAX_CONNECTIONS    = "max-connections"
+	CONNECTION_LOG     = "connection-log"
 )
 
-var ParameterKeys = []string{INSTANCE_ID, REGION, PROFILE, ENDPOINT, DOCUMENT_NAME, PARAMETERS}
+var ParameterKeys = []string{INSTANCE_ID, REGION, PROFILE, ENDPOINT, DOCUMENT_NAME, PARAMETERS, AUDIT_LOG, AUDIT_KEY, GUARDRAILS, CONFIRM_PASTE, REMOTE_ENCODING, WATCH_SOCKET, WATCH_SOCKET_MODE, STATUS_LINE, PREDICTIVE_ECHO, ZMODEM_DIR, SIGNAL_MAP, MAX_RESTARTS, IDLE_TIMEOUT, MAX_DURATION, LIMIT_WARNING, KEEP_ALIVE, SESSION_PER_CONN, LOCAL_HOST, ALLOW_NON_LOOPBACK, PORT_MAPPING, ON_DEMAND, HTTP_PROXY, HTTP_HOST, HTTP_TLS, READY_EVENTS, DAEMON, ALLOW_CLIENTS, ALLOW_UIDS, ALLOW_GIDS, SOCKET_MODE, SOCKET_OWNER, MAX_CONNECTIONS, CONNECTION_LOG}
 
 const START_SESSION_HELP = `NAME : {{.StartSessionName}}
 
@@ -61,6 +99,130 @@ PARAMETERS:
 	{{.Region}} (string) Region
 	Region is required if not configured in aws config file (https://docs.aws.amazon.com/credref/latest/refdocs/creds-config-files.html)
 
+	{{.AuditLog}} (string) Audit log file
+	Appends hash chained records of the session to the given file, verify them with {{.SsmCliName}} audit verify
+
+	{{.AuditKey}} (string) Audit key file
+	File containing a key used to chain audit records with HMAC-SHA256
+
+	{{.Guardrails}} (string) Guardrails policy file
+	JSON rules blocking or asking confirmation for matching commands typed in a shell session
+
+	{{.ConfirmPaste}}
+	Asks for confirmation before sending a multi-line paste to a shell session, keeps the local terminal in bracketed paste mode to detect every paste
+
+	{{.RemoteEncoding}} (string) Remote encoding
+	Character set of the remote shell such as ISO-8859-1, windows-1252 or Shift_JIS, converted from and to the local terminal encoding
+
+	{{.WatchSocket}} (string) Watch socket
+	Unix socket on which read-only viewers attach to a shell session with {{.SsmCliName}} watch
+
+	{{.WatchSocketMode}} (string) Watch socket permissions
+	Octal permissions of the watch socket, 0600 by default, 0660 lets the group of the socket watch
+
+	{{.StatusLine}}
+	Keeps the last row of the terminal for the session id, target, agent version, round trip time,
+	retransmission timeout, unacknowledged messages and reconnect state of a shell session
+
+	{{.PredictiveEcho}}
+	Shows characters typed into a shell session underlined before the shell echoes them when the
+	round trip time is high, turned off at password prompts and in full screen applications
+
+	{{.ZmodemDir}} (string) ZMODEM download directory
+	Turns on ZMODEM transfers started by sz and rz in a shell session, files offered by sz are received into
+	the given directory once confirmed and rz asks for the local files to send
+
+	{{.SignalMap}} (string) Signal mapping
+	Comma separated signal=action list changing what signals received by the plugin do, actions are a
+	control character sent to the shell (^C or 0x03), terminate, suspend the plugin or ignore.
+	By default INT, QUIT and TSTP send ^C, ^\ and ^Z, port sessions are terminated by mapped characters
+
+	{{.MaxRestarts}} (int) Maximum restarts
+	Starts a new shell session to the same target with the same document and parameters when the
+	session times out, at most the given number of times
+
+	{{.IdleTimeout}} (duration) Idle timeout
+	Ends the session after no input or output for the given duration, such as 15m, the plugin exits with status 3
+
+	{{.MaxDuration}} (duration) Maximum duration
+	Ends the session once it was open for the given duration, such as 8h, the plugin exits with status 4
+
+	{{.LimitWarning}} (duration) Limit warning
+	How long before the idle timeout or maximum duration the user is warned, 1m by default
+
+	{{.KeepAlive}} (duration) Keep-alive interval
+	Sends an empty message at the given interval, such as 5m, while a local connection to a port forwarding
+	session is open, so that quiet connections are not ended by the idle timeout
+
+	{{.SessionPerConnection}}
+	Forwards local connections made while another one is forwarded in sessions of their own when the agent
+	forwards one connection at a time, by default they are queued
+
+	{{.LocalHost}} (string) Local bind addresses
+	Comma separated IPv4 or IPv6 addresses or host names the local port of a port forwarding session listens
+	on in place of localhost, listening on non-loopback addresses has to be confirmed
+
+	{{.AllowNonLoopback}}
+	Listens on non-loopback local addresses without asking for confirmation
+
+	{{.AllowClients}} (string) Allowed clients
+	Comma separated IP addresses or CIDR networks of the clients local ports accept, other connections are
+	refused and logged
+
+	{{.AllowUIDs}} (string) Allowed user ids
+	Comma separated user ids of the processes local unix sockets accept, checked with the peer credentials
+	of the connection on Linux and macOS
+
+	{{.AllowGIDs}} (string) Allowed group ids
+	Comma separated primary group ids of the processes local unix sockets accept, a process of an allowed
+	user or group is accepted
+
+	{{.SocketMode}} (string) Socket permissions
+	Octal permissions of local unix sockets, 0600 by default. A socket left behind by a plugin that did not
+	exit cleanly is replaced
+
+	{{.SocketOwner}} (string) Socket owner
+	[USER][:GROUP] owning local unix sockets, names or numeric ids
+
+	{{.MaxConnections}} (int) Maximum connections
+	Maximum number of connections a local port forwards at once, further connections are refused and logged
+
+	{{.ConnectionLog}} (string) Connection log
+	Comma separated file descriptors, fd:N, or files a JSON line is appended to for every connection a local
+	port forwarded once it closed, with its client, stream id, start and end time, bytes in and out and why it
+	closed. The connections are summed up when the session ends
+
+	{{.PortMapping}} (list) Port mappings
+	Forwards each LOCAL=[TARGET/][HOST:]PORT mapping in a session of its own, LOCAL is a local port or a unix
+	socket path, TARGET defaults to the instance id and HOST is reached through the target. Their state is
+	shown together and Ctrl-C terminates all sessions. LOCAL udp:PORT forwards the datagrams of a local UDP
+	port to a relay listening on PORT, such as {{.SsmCliName}} udp-relay
+
+	{{.OnDemand}} (duration) On-demand idle period
+	Listens on the local ports of the port mappings, or of a port forwarding document, right away but starts
+	their sessions when the first client connects, and terminates them once no connection was open for the
+	given duration, such as 10m. The next connection starts a new session
+
+	{{.HTTPProxy}}
+	Serves the local ports of the port mappings, or of a port forwarding document, as HTTP reverse proxies
+	that rewrite the Host header to the forwarded address, and the redirects and cookie domains of the
+	forwarded address to the local one. Requests are written to the plugin log
+
+	{{.HTTPHost}} (string) HTTP Host header
+	Host header the HTTP reverse proxy sends in place of the forwarded address, such as grafana.internal
+
+	{{.HTTPTLS}}
+	Serves the HTTP reverse proxy over TLS with a self-signed certificate generated at start
+
+	{{.ReadyEvents}} (string) Ready events
+	Comma separated file descriptors, fd:N, or files JSON lines are appended to once a local port of a port
+	forwarding session is open, with its port or unix socket, session id and target, and once it accepted its
+	first connection
+
+	{{.Daemon}}
+	Starts the port forwarding session in the background, waits until its local ports are open, prints their
+	ready events and exits
+
 Command:
       For any region,
       {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.Region}} us-east-1
@@ -70,22 +232,102 @@ Command:
 
       For any document with parameters,
       {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.DocumentName}} AWS-StartPortForwardingSession --{{.Parameters}}  '{"localPortNumber":["6789"]}'
+
+      For an audited session,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.AuditLog}} ~/.ssm/audit.log --{{.AuditKey}} ~/.ssm/audit.key
+
+      For a shell session that Ctrl-Z suspends locally and a hangup terminates,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.SignalMap}} TSTP=suspend,HUP=terminate
+
+      For a shell session that continues in a new session up to 3 times when it times out,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.MaxRestarts}} 3
+
+      For a session that ends after 15 minutes without activity or after 8 hours,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.IdleTimeout}} 15m --{{.MaxDuration}} 8h
+
+      For a port forwarding session kept open while a database connection is open,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.DocumentName}} AWS-StartPortForwardingSession --{{.Parameters}}  '{"portNumber":["5432"]}' --{{.KeepAlive}} 5m
+
+      For a port forwarding session reachable from containers on the Docker bridge,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.DocumentName}} AWS-StartPortForwardingSession --{{.Parameters}}  '{"portNumber":["80"]}' --{{.LocalHost}} 127.0.0.1,172.17.0.1
+
+      For a database socket only the postgres group can connect to, with at most 20 connections,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.PortMapping}} /run/db/pg.sock=db.internal:5432 --{{.SocketMode}} 0660 --{{.SocketOwner}} :postgres --{{.MaxConnections}} 20
+
+      For a port forwarding session recording every connection and its traffic,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.DocumentName}} AWS-StartPortForwardingSession --{{.Parameters}}  '{"portNumber":["5432"]}' --{{.ConnectionLog}} ~/.ssm/connections.log
+
+      For a web server and a database reachable through the instance forwarded at once,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.PortMapping}} 8080=80 5432=db.internal:5432 /tmp/redis.sock=i-789012/6379
+
+      For DNS queries relayed by {{.SsmCliName}} udp-relay --listen 127.0.0.1:9053 --destination 10.0.0.2:53 on the instance,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.PortMapping}} udp:5353=9053
+
+      For a database port whose session is only open while clients are connected,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.PortMapping}} 5432=db.internal:5432 --{{.OnDemand}} 10m
+
+      For a web UI expecting its own host name, served locally over TLS,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.PortMapping}} 8443=grafana.internal:3000 --{{.HTTPProxy}} --{{.HTTPHost}} grafana.internal --{{.HTTPTLS}}
+
+      For a script forwarding a port to any free local port in the background,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.DocumentName}} AWS-StartPortForwardingSession --{{.Parameters}}  '{"portNumber":["80"]}' --{{.Daemon}}
+
+      For a shell session others can watch,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.WatchSocket}} /tmp/incident.sock --{{.WatchSocketMode}} 0660
+
+      For a shell session that receives files sent with sz into the downloads directory,
+      {{.SsmCliName}} {{.StartSessionName}} --{{.InstanceId}} i-123456 --{{.ZmodemDir}} ~/Downloads
 `
 
 type StartSessionHelpParams struct {
-	SsmCliName       string
-	StartSessionName string
-	InstanceId       string
-	Region           string
-	Profile          string
-	Endpoint         string
-	DocumentName     string
-	Parameters       string
+	SsmCliName           string
+	StartSessionName     string
+	InstanceId           string
+	Region               string
+	Profile              string
+	Endpoint             string
+	DocumentName         string
+	Parameters           string
+	AuditLog             string
+	AuditKey             string
+	Guardrails           string
+	ConfirmPaste         string
+	RemoteEncoding       string
+	WatchSocket          string
+	WatchSocketMode      string
+	StatusLine           string
+	PredictiveEcho       string
+	ZmodemDir            string
+	SignalMap            string
+	MaxRestarts          string
+	IdleTimeout          string
+	MaxDuration          string
+	LimitWarning         string
+	KeepAlive            string
+	SessionPerConnection string
+	LocalHost            string
+	AllowNonLoopback     string
+	PortMapping          string
+	OnDemand             string
+	HTTPProxy            string
+	HTTPHost             string
+	HTTPTLS              string
+	ReadyEvents          string
+	Daemon               string
+	AllowClients         string
+	AllowUIDs            string
+	AllowGIDs            string
+	SocketMode           string
+	SocketOwner          string
+	MaxConnections       string
+	ConnectionLog        string
 }
 
 type StartSessionCommand struct {
-	helpText string
-	sdk      *ssm.SSM
+	helpText           string
+	sdk                *ssm.SSM
+	documentName       string
+	documentParameters map[string][]string
 }
 
 // getSSMClient generate ssm client by configuration
@@ -133,6 +375,39 @@ func (c *StartSessionCommand) Help() string {
 			ENDPOINT,
 			DOCUMENT_NAME,
 			PARAMETERS,
+			AUDIT_LOG,
+			AUDIT_KEY,
+			GUARDRAILS,
+			CONFIRM_PASTE,
+			REMOTE_ENCODING,
+			WATCH_SOCKET,
+			WATCH_SOCKET_MODE,
+			STATUS_LINE,
+			PREDICTIVE_ECHO,
+			ZMODEM_DIR,
+			SIGNAL_MAP,
+			MAX_RESTARTS,
+			IDLE_TIMEOUT,
+			MAX_DURATION,
+			LIMIT_WARNING,
+			KEEP_ALIVE,
+			SESSION_PER_CONN,
+			LOCAL_HOST,
+			ALLOW_NON_LOOPBACK,
+			PORT_MAPPING,
+			ON_DEMAND,
+			HTTP_PROXY,
+			HTTP_HOST,
+			HTTP_TLS,
+			READY_EVENTS,
+			DAEMON,
+			ALLOW_CLIENTS,
+			ALLOW_UIDS,
+			ALLOW_GIDS,
+			SOCKET_MODE,
+			SOCKET_OWNER,
+			MAX_CONNECTIONS,
+			CONNECTION_LOG,
 		}
 		buf := new(bytes.Buffer)
 		t.Execute(buf, params)
@@ -143,13 +418,6 @@ func (c *StartSessionCommand) Help() string {
 
 // validates and execute start-session command
 func (s *StartSessionCommand) Execute(parameters map[string][]string) (error, string) {
-	var (
-		err        error
-		region     string
-		profile    string
-		endpoint   string
-		instanceId string
-	)
 	validation := s.validateStartSessionInput(parameters)
 	if len(validation) > 0 {
 		return errors.New(strings.Join(validation, "\n")), ""
@@ -157,6 +425,161 @@ func (s *StartSessionCommand) Execute(parameters map[string][]string) (error, st
 
 	log := log.Logger(true, "ssmcli")
 
+	if parameters[DAEMON] != nil {
+		return s.executeDaemon(log, parameters)
+	}
+
+	if parameters[PORT_MAPPING] != nil || parameters[ON_DEMAND] != nil || parameters[HTTP_PROXY] != nil {
+		return s.executePortMappings(log, parameters)
+	}
+
+	session, err := s.newSession(log, parameters)
+	if err != nil {
+		return err, "StartSession failed"
+	}
+
+	if err = executeSession(log, session); err != nil {
+		log.Errorf("Cannot perform start session: %v", err)
+		return err, "StartSession failed"
+	}
+
+	return err, "StartSession executed successfully"
+}
+
+// executePortMappings forwards the port mappings, or the port of the document, in sessions of their own until
+// all of them closed, or until terminated if sessions are started on demand
+func (s *StartSessionCommand) executePortMappings(log log.T, parameters map[string][]string) (error, string) {
+	mode := ON_DEMAND
+	if parameters[ON_DEMAND] == nil {
+		mode = HTTP_PROXY
+	}
+	mappings := make([]portsession.PortMapping, 0, len(parameters[PORT_MAPPING]))
+	for _, spec := range parameters[PORT_MAPPING] {
+		mapping, err := portsession.ParsePortMapping(spec)
+		if err != nil {
+			return err, ""
+		}
+		mappings = append(mappings, mapping)
+	}
+	if len(mappings) == 0 {
+		mapping, err := documentPortMapping(parameters, mode)
+		if err != nil {
+			return err, ""
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	for _, mapping := range mappings {
+		if mapping.UDP && parameters[HTTP_PROXY] != nil {
+			return fmt.Errorf("%v cannot serve udp port mappings", utils.FormatFlag(HTTP_PROXY)), ""
+		}
+	}
+
+	base, err := s.sessionSettings(log, parameters)
+	if err != nil {
+		return err, "StartSession failed"
+	}
+
+	if err = runPortMappings(log, base, mappings); err != nil {
+		log.Errorf("Cannot forward port mappings: %v", err)
+		return err, "StartSession failed"
+	}
+	return nil, "Port mappings closed"
+}
+
+// documentPortMapping returns the mapping of the port forwarding document and parameters of the command, for
+// the mode flag given without port mappings
+func documentPortMapping(parameters map[string][]string, mode string) (mapping portsession.PortMapping, err error) {
+	if parameters[DOCUMENT_NAME] == nil || len(parameters[PARAMETERS]) != 1 {
+		return mapping, fmt.Errorf("%v requires %v or a port forwarding %v with %v", utils.FormatFlag(mode),
+			utils.FormatFlag(PORT_MAPPING), utils.FormatFlag(DOCUMENT_NAME), utils.FormatFlag(PARAMETERS))
+	}
+	documentParameters := make(map[string][]string)
+	if err = jsonutil.Unmarshal(parameters[PARAMETERS][0], &documentParameters); err != nil {
+		return mapping, fmt.Errorf("%v not valid input, get error: %v", PARAMETERS, err)
+	}
+	first := func(key string) string {
+		if values := documentParameters[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	mapping = portsession.PortMapping{
+		LocalPortNumber:    first("localPortNumber"),
+		LocalUnixSocket:    first("localUnixSocket"),
+		Host:               first("host"),
+		PortNumber:         first("portNumber"),
+		Document:           parameters[DOCUMENT_NAME][0],
+		DocumentParameters: documentParameters,
+	}
+	if mapping.PortNumber == "" {
+		return mapping, fmt.Errorf("%v requires the portNumber parameter of a port forwarding document", utils.FormatFlag(mode))
+	}
+	if mapping.LocalPortNumber == "" && mapping.LocalUnixSocket == "" {
+		mapping.LocalPortNumber = "0"
+	}
+	return mapping, nil
+}
+
+// runPortMappings forwards the port mappings with the settings of base
+var runPortMappings = func(log log.T, base *session.Session, mappings []portsession.PortMapping) error {
+	return portsession.RunPortMappings(log, base, mappings)
+}
+
+// newSession calls StartSession API with the command parameters and returns the session ready to be executed
+func (s *StartSessionCommand) newSession(log log.T, parameters map[string][]string) (*session.Session, error) {
+	session, err := s.sessionSettings(log, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Calling StartSession API with parameters: %v", parameters)
+	sessionId, tokenValue, streamUrl, err := s.getStartSessionParams(log, parameters)
+	if err != nil {
+		log.Errorf("Error in getting start awsSession params: %v", err)
+		return nil, err
+	}
+	log.Infof("For SessionId: %s, StartSession returned streamUrl: %s", sessionId, streamUrl)
+
+	session.SessionId = sessionId
+	session.StreamUrl = streamUrl
+	session.TokenValue = tokenValue
+	session.ClientId = uuid.NewV4().String()
+	session.DocumentName = s.documentName
+	session.Parameters = s.documentParameters
+	return session, nil
+}
+
+// sessionSettings returns a session with the settings of the command parameters and creates the ssm client
+func (s *StartSessionCommand) sessionSettings(log log.T, parameters map[string][]string) (*session.Session, error) {
+	var (
+		err            error
+		region         string
+		profile        string
+		endpoint       string
+		instanceId     string
+		guardrails     string
+		remoteEncoding string
+		watchSocket    string
+		zmodemDir      string
+		watchMode      string
+		signalMap      sessionutil.SignalMap
+		maxRestarts    int
+		localHost      string
+		httpHost       string
+		readyEvents    string
+		connectionLog  string
+		allowClients   []*net.IPNet
+		allowUIDs      []uint32
+		allowGIDs      []uint32
+		socketMode     os.FileMode
+		socketOwner    string
+		maxConnections int
+		durations      = make(map[string]time.Duration)
+		auditLog       *session.AuditLog
+	)
+
 	if parameters[REGION] != nil {
 		region = parameters[REGION][0]
 	}
@@ -171,35 +594,147 @@ func (s *StartSessionCommand) Execute(parameters map[string][]string) (error, st
 		instanceId = parameters[INSTANCE_ID][0]
 	}
 
-	if s.sdk, err = getSSMClient(log, region, profile, endpoint); err != nil {
-		return err, "StartSession failed"
+	if parameters[GUARDRAILS] != nil {
+		guardrails = parameters[GUARDRAILS][0]
 	}
 
-	log.Infof("Calling StartSession API with parameters: %v", parameters)
-	sessionId, tokenValue, streamUrl, err := s.getStartSessionParams(log, parameters)
-	if err != nil {
-		log.Errorf("Error in getting start awsSession params: %v", err)
-		return err, "StartSession failed"
+	if parameters[REMOTE_ENCODING] != nil {
+		remoteEncoding = parameters[REMOTE_ENCODING][0]
+		if _, err = shellsession.LookupCharset(remoteEncoding); err != nil {
+			return nil, err
+		}
 	}
-	log.Infof("For SessionId: %s, StartSession returned streamUrl: %s", sessionId, streamUrl)
-	clientId := uuid.NewV4().String()
 
-	session := session.Session{
-		SessionId:   sessionId,
-		StreamUrl:   streamUrl,
-		TokenValue:  tokenValue,
-		Endpoint:    endpoint,
-		ClientId:    clientId,
-		TargetId:    instanceId,
-		DataChannel: &datachannel.DataChannel{},
+	if parameters[LOCAL_HOST] != nil {
+		localHost = parameters[LOCAL_HOST][0]
+	}
+	if parameters[HTTP_HOST] != nil {
+		httpHost = parameters[HTTP_HOST][0]
+	}
+	if parameters[READY_EVENTS] != nil {
+		readyEvents = parameters[READY_EVENTS][0]
+	}
+	if parameters[CONNECTION_LOG] != nil {
+		connectionLog = parameters[CONNECTION_LOG][0]
 	}
 
-	if err = executeSession(log, &session); err != nil {
-		log.Errorf("Cannot perform start session: %v", err)
-		return err, "StartSession failed"
+	if parameters[WATCH_SOCKET] != nil {
+		watchSocket = parameters[WATCH_SOCKET][0]
+	}
+	if parameters[WATCH_SOCKET_MODE] != nil {
+		watchMode = parameters[WATCH_SOCKET_MODE][0]
+		if _, err = shellsession.ParseWatchSocketMode(watchMode); err != nil {
+			return nil, err
+		}
+	}
+	if parameters[ZMODEM_DIR] != nil {
+		zmodemDir = parameters[ZMODEM_DIR][0]
+		if err = shellsession.CheckZmodemDir(zmodemDir); err != nil {
+			return nil, err
+		}
 	}
 
-	return err, "StartSession executed successfully"
+	if parameters[SIGNAL_MAP] != nil {
+		if signalMap, err = sessionutil.ParseSignalMap(parameters[SIGNAL_MAP][0]); err != nil {
+			return nil, err
+		}
+	}
+
+	if parameters[ALLOW_CLIENTS] != nil {
+		if allowClients, err = sessionutil.ParseClientNetworks(parameters[ALLOW_CLIENTS][0]); err != nil {
+			return nil, err
+		}
+	}
+	if parameters[ALLOW_UIDS] != nil {
+		if allowUIDs, err = sessionutil.ParseIds(parameters[ALLOW_UIDS][0]); err != nil {
+			return nil, err
+		}
+	}
+	if parameters[ALLOW_GIDS] != nil {
+		if allowGIDs, err = sessionutil.ParseIds(parameters[ALLOW_GIDS][0]); err != nil {
+			return nil, err
+		}
+	}
+	if parameters[SOCKET_MODE] != nil {
+		if socketMode, err = sessionutil.ParseSocketMode(parameters[SOCKET_MODE][0]); err != nil {
+			return nil, err
+		}
+	}
+	if parameters[SOCKET_OWNER] != nil {
+		socketOwner = parameters[SOCKET_OWNER][0]
+		if _, _, err = sessionutil.ParseSocketOwner(socketOwner); err != nil {
+			return nil, err
+		}
+	}
+	if parameters[MAX_CONNECTIONS] != nil {
+		if maxConnections, err = strconv.Atoi(parameters[MAX_CONNECTIONS][0]); err != nil || maxConnections <= 0 {
+			return nil, fmt.Errorf("--%s must be a positive number of connections", MAX_CONNECTIONS)
+		}
+	}
+
+	if parameters[MAX_RESTARTS] != nil {
+		if maxRestarts, err = strconv.Atoi(parameters[MAX_RESTARTS][0]); err != nil || maxRestarts < 0 {
+			return nil, fmt.Errorf("--%s must be a non-negative number of restarts", MAX_RESTARTS)
+		}
+	}
+
+	for _, key := range []string{IDLE_TIMEOUT, MAX_DURATION, LIMIT_WARNING, KEEP_ALIVE, ON_DEMAND} {
+		if parameters[key] != nil {
+			if durations[key], err = time.ParseDuration(parameters[key][0]); err != nil || durations[key] <= 0 {
+				return nil, fmt.Errorf("--%s must be a positive duration such as 30m", key)
+			}
+		}
+	}
+
+	if parameters[AUDIT_LOG] != nil {
+		var key []byte
+		if parameters[AUDIT_KEY] != nil {
+			if key, err = session.ReadAuditKeyFile(parameters[AUDIT_KEY][0]); err != nil {
+				return nil, err
+			}
+		}
+		auditLog = session.NewAuditLog(parameters[AUDIT_LOG][0], key)
+	}
+
+	if s.sdk, err = getSSMClient(log, region, profile, endpoint); err != nil {
+		return nil, err
+	}
+
+	return &session.Session{
+		Endpoint:             endpoint,
+		TargetId:             instanceId,
+		DataChannel:          &datachannel.DataChannel{},
+		AuditLog:             auditLog,
+		GuardrailsFile:       guardrails,
+		ConfirmPaste:         parameters[CONFIRM_PASTE] != nil,
+		RemoteEncoding:       remoteEncoding,
+		WatchSocket:          watchSocket,
+		WatchSocketMode:      watchMode,
+		StatusLine:           parameters[STATUS_LINE] != nil,
+		PredictiveEcho:       parameters[PREDICTIVE_ECHO] != nil,
+		ZmodemDir:            zmodemDir,
+		SignalMap:            signalMap,
+		MaxRestarts:          maxRestarts,
+		IdleTimeout:          durations[IDLE_TIMEOUT],
+		MaxDuration:          durations[MAX_DURATION],
+		LimitWarning:         durations[LIMIT_WARNING],
+		KeepAliveInterval:    durations[KEEP_ALIVE],
+		SessionPerConnection: parameters[SESSION_PER_CONN] != nil,
+		LocalHost:            localHost,
+		AllowNonLoopback:     parameters[ALLOW_NON_LOOPBACK] != nil,
+		OnDemand:             durations[ON_DEMAND],
+		HTTPProxy:            parameters[HTTP_PROXY] != nil,
+		HTTPProxyHost:        httpHost,
+		HTTPProxyTLS:         parameters[HTTP_TLS] != nil,
+		ReadyEvents:          readyEvents,
+		AllowClients:         allowClients,
+		AllowPeerUIDs:        allowUIDs,
+		AllowPeerGIDs:        allowGIDs,
+		SocketMode:           socketMode,
+		SocketOwner:          socketOwner,
+		MaxConnections:       maxConnections,
+		ConnectionLog:        connectionLog,
+	}, nil
 }
 
 // func to validate start-session input
@@ -214,6 +749,21 @@ func (StartSessionCommand) validateStartSessionInput(parameters map[string][]str
 			utils.FormatFlag(INSTANCE_ID)))
 	}
 
+	if parameters[PORT_MAPPING] != nil && (parameters[DOCUMENT_NAME] != nil || parameters[PARAMETERS] != nil) {
+		validation = append(validation, fmt.Sprintf("%v cannot be combined with %v or %v",
+			utils.FormatFlag(PORT_MAPPING), utils.FormatFlag(DOCUMENT_NAME), utils.FormatFlag(PARAMETERS)))
+	}
+
+	if parameters[HTTP_PROXY] == nil && (parameters[HTTP_HOST] != nil || parameters[HTTP_TLS] != nil) {
+		validation = append(validation, fmt.Sprintf("%v and %v require %v",
+			utils.FormatFlag(HTTP_HOST), utils.FormatFlag(HTTP_TLS), utils.FormatFlag(HTTP_PROXY)))
+	}
+
+	if parameters[DAEMON] != nil && parameters[PORT_MAPPING] == nil && parameters[DOCUMENT_NAME] == nil {
+		validation = append(validation, fmt.Sprintf("%v requires %v or a port forwarding %v",
+			utils.FormatFlag(DAEMON), utils.FormatFlag(PORT_MAPPING), utils.FormatFlag(DOCUMENT_NAME)))
+	}
+
 	for key := range parameters {
 		if !contains(ParameterKeys, key) {
 			validation = append(validation, fmt.Sprintf("%v not a valid command parameter flag", key))
@@ -241,8 +791,11 @@ func (s *StartSessionCommand) getStartSessionParams(log log.T, parameters map[st
 		Target: &parameters[INSTANCE_ID][0],
 	}
 
+	s.documentName = ""
+	s.documentParameters = nil
 	if parameters[DOCUMENT_NAME] != nil {
 		startSessionInput.DocumentName = &parameters[DOCUMENT_NAME][0]
+		s.documentName = parameters[DOCUMENT_NAME][0]
 	}
 
 	delete(parameters, INSTANCE_ID)
@@ -268,6 +821,7 @@ func (s *StartSessionCommand) getStartSessionParams(log log.T, parameters map[st
 		}
 
 		startSessionInput.Parameters = userParameters
+		s.documentParameters = params
 	}
 
 	log.Infof("StartSession input parameters: %v", startSessionInput)
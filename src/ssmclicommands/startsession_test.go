@@ -16,11 +16,17 @@ package ssmclicommands
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/session-manager-plugin/src/log"
 	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/portsession"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/sessionutil"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -97,6 +103,272 @@ func TestStartSessionCommand_ExecuteGetSSMClientFailure(t *testing.T) {
 	assert.Equal(t, msg, "StartSession failed")
 }
 
+func TestStartSessionCommand_ExecuteWithSignalMap(t *testing.T) {
+	parameter, _ := getCommandParameter()
+	parameter[SIGNAL_MAP] = []string{"HUP=terminate,INT=^G"}
+	command := &StartSessionCommand{}
+	getSSMClient = func(log log.T, region string, profile string, endpoint string) (*ssm.SSM, error) {
+		return &ssm.SSM{}, nil
+	}
+	startSession = func(s *StartSessionCommand, input *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
+		return startSessionOutput, nil
+	}
+	executeSession = func(log log.T, session *session.Session) (err error) {
+		assert.Equal(t, sessionutil.SignalAction{Type: sessionutil.SignalTerminate}, session.SignalMap[syscall.SIGHUP])
+		assert.Equal(t, sessionutil.SignalAction{Type: sessionutil.SignalSendByte, Byte: 0x07}, session.SignalMap[syscall.SIGINT])
+		return nil
+	}
+
+	err, _ := command.Execute(parameter)
+	assert.Nil(t, err)
+
+	parameter, _ = getCommandParameter()
+	parameter[SIGNAL_MAP] = []string{"HUP=hangup"}
+	err, msg := command.Execute(parameter)
+	assert.Contains(t, err.Error(), "invalid signal action")
+	assert.Equal(t, msg, "StartSession failed")
+}
+
+func TestStartSessionCommand_ExecuteWithZmodemDir(t *testing.T) {
+	dir := t.TempDir()
+	parameter, _ := getCommandParameter()
+	parameter[ZMODEM_DIR] = []string{dir}
+	command := &StartSessionCommand{}
+	getSSMClient = func(log log.T, region string, profile string, endpoint string) (*ssm.SSM, error) {
+		return &ssm.SSM{}, nil
+	}
+	startSession = func(s *StartSessionCommand, input *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
+		return startSessionOutput, nil
+	}
+	executeSession = func(log log.T, session *session.Session) (err error) {
+		assert.Equal(t, dir, session.ZmodemDir)
+		return nil
+	}
+
+	err, _ := command.Execute(parameter)
+	assert.Nil(t, err)
+
+	parameter, _ = getCommandParameter()
+	parameter[ZMODEM_DIR] = []string{filepath.Join(dir, "missing")}
+	err, msg := command.Execute(parameter)
+	assert.Contains(t, err.Error(), "invalid ZMODEM download directory")
+	assert.Equal(t, msg, "StartSession failed")
+}
+
+func TestStartSessionCommand_ExecuteWithMaxRestarts(t *testing.T) {
+	parameter, _ := getCommandParameter()
+	parameter[MAX_RESTARTS] = []string{"3"}
+	command := &StartSessionCommand{}
+	getSSMClient = func(log log.T, region string, profile string, endpoint string) (*ssm.SSM, error) {
+		return &ssm.SSM{}, nil
+	}
+	startSession = func(s *StartSessionCommand, input *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
+		return startSessionOutput, nil
+	}
+	executeSession = func(log log.T, session *session.Session) (err error) {
+		assert.Equal(t, 3, session.MaxRestarts)
+		return nil
+	}
+
+	err, _ := command.Execute(parameter)
+	assert.Nil(t, err)
+
+	parameter, _ = getCommandParameter()
+	parameter[MAX_RESTARTS] = []string{"-1"}
+	err, msg := command.Execute(parameter)
+	assert.Contains(t, err.Error(), "--max-restarts must be a non-negative number")
+	assert.Equal(t, msg, "StartSession failed")
+}
+
+func TestStartSessionCommand_ExecuteWithSessionLimits(t *testing.T) {
+	parameter, _ := getCommandParameter()
+	parameter[IDLE_TIMEOUT] = []string{"15m"}
+	parameter[MAX_DURATION] = []string{"8h"}
+	parameter[KEEP_ALIVE] = []string{"5m"}
+	command := &StartSessionCommand{}
+	getSSMClient = func(log log.T, region string, profile string, endpoint string) (*ssm.SSM, error) {
+		return &ssm.SSM{}, nil
+	}
+	startSession = func(s *StartSessionCommand, input *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
+		return startSessionOutput, nil
+	}
+	executeSession = func(log log.T, session *session.Session) (err error) {
+		assert.Equal(t, 15*time.Minute, session.IdleTimeout)
+		assert.Equal(t, 8*time.Hour, session.MaxDuration)
+		assert.Equal(t, time.Duration(0), session.LimitWarning)
+		assert.Equal(t, 5*time.Minute, session.KeepAliveInterval)
+		return nil
+	}
+
+	err, _ := command.Execute(parameter)
+	assert.Nil(t, err)
+
+	parameter, _ = getCommandParameter()
+	parameter[LIMIT_WARNING] = []string{"soon"}
+	err, msg := command.Execute(parameter)
+	assert.Contains(t, err.Error(), "--limit-warning must be a positive duration")
+	assert.Equal(t, msg, "StartSession failed")
+}
+
+func TestStartSessionCommand_ExecuteWithPortMappings(t *testing.T) {
+	parameter := map[string][]string{
+		INSTANCE_ID:  {"i-123456"},
+		PORT_MAPPING: {"8080=80", "/tmp/redis.sock=i-789012/cache.internal:6379"},
+		KEEP_ALIVE:   {"5m"},
+	}
+	command := &StartSessionCommand{}
+	getSSMClient = func(log log.T, region string, profile string, endpoint string) (*ssm.SSM, error) {
+		return &ssm.SSM{}, nil
+	}
+	startSession = func(s *StartSessionCommand, input *ssm.StartSessionInput) (*ssm.StartSessionOutput, error) {
+		assert.Fail(t, "port mappings start their sessions themselves")
+		return startSessionOutput, nil
+	}
+	defer func(original func(log.T, *session.Session, []portsession.PortMapping) error) {
+		runPortMappings = original
+	}(runPortMappings)
+	runPortMappings = func(log log.T, base *session.Session, mappings []portsession.PortMapping) error {
+		assert.Equal(t, "i-123456", base.TargetId)
+		assert.Equal(t, 5*time.Minute, base.KeepAliveInterval)
+		assert.Equal(t, []portsession.PortMapping{
+			{LocalPortNumber: "8080", PortNumber: "80"},
+			{LocalUnixSocket: "/tmp/redis.sock", Target: "i-789012", Host: "cache.internal", PortNumber: "6379"},
+		}, mappings)
+		return nil
+	}
+
+	err, _ := command.Execute(parameter)
+	assert.Nil(t, err)
+
+	parameter[PORT_MAPPING] = []string{"8080"}
+	err, _ = command.Execute(parameter)
+	assert.Contains(t, err.Error(), "invalid port mapping")
+
+	parameter[PORT_MAPPING] = []string{"8080=80"}
+	parameter[DOCUMENT_NAME] = []string{"AWS-StartPortForwardingSession"}
+	err, _ = command.Execute(parameter)
+	assert.Contains(t, err.Error(), "--port-mapping cannot be combined with --document-name or --parameters")
+}
+
+func TestStartSessionCommand_ExecuteWithAccessControl(t *testing.T) {
+	parameter := map[string][]string{
+		INSTANCE_ID:     {"i-123456"},
+		PORT_MAPPING:    {"/tmp/pg.sock=db.internal:5432"},
+		ALLOW_CLIENTS:   {"127.0.0.1,10.0.0.0/8"},
+		ALLOW_UIDS:      {"1000"},
+		ALLOW_GIDS:      {"50,51"},
+		SOCKET_MODE:     {"0660"},
+		SOCKET_OWNER:    {":50"},
+		MAX_CONNECTIONS: {"20"},
+		CONNECTION_LOG:  {"/tmp/connections.log"},
+	}
+	command := &StartSessionCommand{}
+	getSSMClient = func(log log.T, region string, profile string, endpoint string) (*ssm.SSM, error) {
+		return &ssm.SSM{}, nil
+	}
+	defer func(original func(log.T, *session.Session, []portsession.PortMapping) error) {
+		runPortMappings = original
+	}(runPortMappings)
+	runPortMappings = func(log log.T, base *session.Session, mappings []portsession.PortMapping) error {
+		assert.Equal(t, 2, len(base.AllowClients))
+		assert.Equal(t, "10.0.0.0/8", base.AllowClients[1].String())
+		assert.Equal(t, []uint32{1000}, base.AllowPeerUIDs)
+		assert.Equal(t, []uint32{50, 51}, base.AllowPeerGIDs)
+		assert.Equal(t, os.FileMode(0660), base.SocketMode)
+		assert.Equal(t, ":50", base.SocketOwner)
+		assert.Equal(t, 20, base.MaxConnections)
+		assert.Equal(t, "/tmp/connections.log", base.ConnectionLog)
+		return nil
+	}
+
+	err, _ := command.Execute(parameter)
+	assert.Nil(t, err)
+
+	parameter[MAX_CONNECTIONS] = []string{"0"}
+	err, _ = command.Execute(parameter)
+	assert.EqualError(t, err, "--max-connections must be a positive number of connections")
+
+	parameter[MAX_CONNECTIONS] = []string{"20"}
+	parameter[ALLOW_CLIENTS] = []string{"localhost"}
+	err, _ = command.Execute(parameter)
+	assert.Contains(t, err.Error(), "invalid client address")
+}
+
+func TestStartSessionCommand_ExecuteOnDemand(t *testing.T) {
+	parameter := map[string][]string{
+		INSTANCE_ID:   {"i-123456"},
+		DOCUMENT_NAME: {"AWS-StartPortForwardingSession"},
+		PARAMETERS:    {`{"portNumber":["5432"],"localPortNumber":["15432"]}`},
+		ON_DEMAND:     {"10m"},
+	}
+	command := &StartSessionCommand{}
+	getSSMClient = func(log log.T, region string, profile string, endpoint string) (*ssm.SSM, error) {
+		return &ssm.SSM{}, nil
+	}
+	defer func(original func(log.T, *session.Session, []portsession.PortMapping) error) {
+		runPortMappings = original
+	}(runPortMappings)
+	runPortMappings = func(log log.T, base *session.Session, mappings []portsession.PortMapping) error {
+		assert.Equal(t, 10*time.Minute, base.OnDemand)
+		assert.Equal(t, []portsession.PortMapping{{
+			LocalPortNumber:    "15432",
+			PortNumber:         "5432",
+			Document:           "AWS-StartPortForwardingSession",
+			DocumentParameters: map[string][]string{"portNumber": {"5432"}, "localPortNumber": {"15432"}},
+		}}, mappings)
+		return nil
+	}
+
+	err, _ := command.Execute(parameter)
+	assert.Nil(t, err)
+
+	delete(parameter, PARAMETERS)
+	err, _ = command.Execute(parameter)
+	assert.Contains(t, err.Error(), "--on-demand requires --port-mapping or a port forwarding --document-name with --parameters")
+}
+
+func TestStartSessionCommand_ExecuteHTTPProxy(t *testing.T) {
+	parameter := map[string][]string{
+		INSTANCE_ID:  {"i-123456"},
+		PORT_MAPPING: {"8443=grafana.internal:3000"},
+		HTTP_PROXY:   {},
+		HTTP_HOST:    {"grafana.internal"},
+		HTTP_TLS:     {},
+	}
+	command := &StartSessionCommand{}
+	getSSMClient = func(log log.T, region string, profile string, endpoint string) (*ssm.SSM, error) {
+		return &ssm.SSM{}, nil
+	}
+	defer func(original func(log.T, *session.Session, []portsession.PortMapping) error) {
+		runPortMappings = original
+	}(runPortMappings)
+	runPortMappings = func(log log.T, base *session.Session, mappings []portsession.PortMapping) error {
+		assert.True(t, base.HTTPProxy)
+		assert.Equal(t, "grafana.internal", base.HTTPProxyHost)
+		assert.True(t, base.HTTPProxyTLS)
+		assert.Equal(t, 1, len(mappings))
+		return nil
+	}
+
+	err, _ := command.Execute(parameter)
+	assert.Nil(t, err)
+
+	delete(parameter, HTTP_PROXY)
+	err, _ = command.Execute(parameter)
+	assert.Contains(t, err.Error(), "--http-host and --http-tls require --http-proxy")
+}
+
+func TestStartSessionCommand_ExecuteHTTPProxyRefusesUDP(t *testing.T) {
+	parameter := map[string][]string{
+		INSTANCE_ID:  {"i-123456"},
+		PORT_MAPPING: {"udp:5353=9053"},
+		HTTP_PROXY:   {},
+	}
+	command := &StartSessionCommand{}
+	err, _ := command.Execute(parameter)
+	assert.EqualError(t, err, "--http-proxy cannot serve udp port mappings")
+}
+
 func TestStartSessionCommand_ExecuteSessionFailure(t *testing.T) {
 	parameter, _ := getCommandParameter()
 	command := &StartSessionCommand{
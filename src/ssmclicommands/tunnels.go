@@ -0,0 +1,195 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package ssmclicommands contains all the commands with its implementation.
+package ssmclicommands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/aws/session-manager-plugin/src/datachannel"
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/portsession"
+	"github.com/aws/session-manager-plugin/src/ssmclicommands/utils"
+)
+
+const (
+	TUNNELS    = "tunnels"
+	TUNNELS_UP = "up"
+)
+
+var TunnelsParameterKeys = []string{utils.PositionalArguments, REGION, PROFILE, ENDPOINT, KEEP_ALIVE, LOCAL_HOST, ALLOW_NON_LOOPBACK}
+
+const TUNNELS_HELP = `NAME : {{.TunnelsName}}
+
+SYNOPSIS:
+	{{.SsmCliName}}
+	{{.TunnelsName}} {{.UpName}} <file>
+	[{{.Region}}]
+	[{{.Profile}}]
+
+PARAMETERS:
+	<file> (string) Tunnels file
+	YAML or JSON file listing named tunnels, each forwarded in a session of its own and restarted with
+	backoff when it closes or fails to start, until Ctrl-C closes all of them:
+
+	tunnels:
+	  - name: orders-db
+	    target: i-123456
+	    document: AWS-StartPortForwardingSessionToRemoteHost   # optional, chosen by remoteHost
+	    remoteHost: orders.cluster.internal                    # optional, the target itself by default
+	    remotePort: 5432
+	    localPort: 15432                                       # or localUnixSocket, any free port by default
+	    profile: prod                                          # optional, --{{.Profile}} by default
+	    region: eu-west-1                                      # optional, --{{.Region}} by default
+
+	{{.Region}} (string) Region
+	Region of the tunnels that do not set one
+
+	{{.Profile}} (string) Profile
+	AWS credentials profile of the tunnels that do not set one
+
+	{{.KeepAlive}} (duration) Keep-alive interval
+	Sends an empty message at the given interval while a connection of a tunnel is open
+
+	{{.LocalHost}} (string) Local bind addresses
+	Comma separated addresses the local ports listen on in place of localhost
+
+	{{.AllowNonLoopback}}
+	Listens on non-loopback local addresses without asking for confirmation
+
+Command:
+      {{.SsmCliName}} {{.TunnelsName}} {{.UpName}} ~/team/tunnels.yaml --{{.Region}} us-east-1
+`
+
+type TunnelsHelpParams struct {
+	SsmCliName       string
+	TunnelsName      string
+	UpName           string
+	Region           string
+	Profile          string
+	KeepAlive        string
+	LocalHost        string
+	AllowNonLoopback string
+}
+
+type TunnelsCommand struct {
+	helpText string
+}
+
+// runTunnelDefinitions keeps the tunnels open with the settings of base
+var runTunnelDefinitions = func(log log.T, base *session.Session, definitions []portsession.TunnelDefinition) error {
+	return portsession.RunTunnelDefinitions(log, base, definitions)
+}
+
+func init() {
+	utils.Register(&TunnelsCommand{})
+}
+
+// Name is the command name used in the cli
+func (TunnelsCommand) Name() string {
+	return TUNNELS
+}
+
+// Help prints help for the tunnels cli command
+func (c *TunnelsCommand) Help() string {
+	if len(c.helpText) == 0 {
+		t, _ := template.New("TunnelsHelp").Parse(TUNNELS_HELP)
+		params := TunnelsHelpParams{
+			utils.SsmCliName,
+			TUNNELS,
+			TUNNELS_UP,
+			REGION,
+			PROFILE,
+			KEEP_ALIVE,
+			LOCAL_HOST,
+			ALLOW_NON_LOOPBACK,
+		}
+		buf := new(bytes.Buffer)
+		t.Execute(buf, params)
+		c.helpText = buf.String()
+	}
+	return c.helpText
+}
+
+// validates and execute tunnels command
+func (c *TunnelsCommand) Execute(parameters map[string][]string) (error, string) {
+	validation := c.validateTunnelsInput(parameters)
+	if len(validation) > 0 {
+		return errors.New(strings.Join(validation, "\n")), ""
+	}
+
+	definitions, err := portsession.LoadTunnelDefinitions(parameters[utils.PositionalArguments][1])
+	if err != nil {
+		return err, ""
+	}
+
+	log := log.Logger(true, "ssmcli")
+	base := &session.Session{
+		DataChannel:      &datachannel.DataChannel{},
+		AllowNonLoopback: parameters[ALLOW_NON_LOOPBACK] != nil,
+	}
+	var region, profile string
+	if parameters[REGION] != nil {
+		region = parameters[REGION][0]
+	}
+	if parameters[PROFILE] != nil {
+		profile = parameters[PROFILE][0]
+	}
+	if parameters[ENDPOINT] != nil {
+		base.Endpoint = parameters[ENDPOINT][0]
+	}
+	if parameters[LOCAL_HOST] != nil {
+		base.LocalHost = parameters[LOCAL_HOST][0]
+	}
+	if parameters[KEEP_ALIVE] != nil {
+		if base.KeepAliveInterval, err = time.ParseDuration(parameters[KEEP_ALIVE][0]); err != nil || base.KeepAliveInterval <= 0 {
+			return fmt.Errorf("--%s must be a positive duration such as 30m", KEEP_ALIVE), ""
+		}
+	}
+	if _, err = getSSMClient(log, region, profile, base.Endpoint); err != nil {
+		return err, "Tunnels failed"
+	}
+
+	if err = runTunnelDefinitions(log, base, definitions); err != nil {
+		log.Errorf("Cannot keep tunnels open: %v", err)
+		return err, "Tunnels failed"
+	}
+	return nil, "Tunnels closed"
+}
+
+// func to validate tunnels input
+func (TunnelsCommand) validateTunnelsInput(parameters map[string][]string) []string {
+	validation := make([]string, 0)
+
+	arguments := parameters[utils.PositionalArguments]
+	if subcommand := utils.GetSubcommand(parameters); subcommand != TUNNELS_UP {
+		validation = append(validation, fmt.Sprintf("unknown subcommand %q, supported subcommands: %v", subcommand, TUNNELS_UP))
+	} else if len(arguments) != 2 {
+		validation = append(validation, fmt.Sprintf("%v %v requires the tunnels file", TUNNELS, TUNNELS_UP))
+	}
+
+	for key := range parameters {
+		if !contains(TunnelsParameterKeys, key) {
+			validation = append(validation, fmt.Sprintf("%v not a valid command parameter flag", key))
+		}
+	}
+
+	return validation
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package ssmclicommands contains all the commands with its implementation.
+package ssmclicommands
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/portsession"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTunnelsCommand_Help(t *testing.T) {
+	command := &TunnelsCommand{}
+	assert.Contains(t, command.Help(), "SYNOPSIS:")
+}
+
+func TestTunnelsCommand_ExecuteUp(t *testing.T) {
+	file, err := ioutil.TempFile("", "tunnels*.yaml")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString("tunnels:\n  - name: web\n    target: i-123456\n    remotePort: 80\n    localPort: 8080\n")
+	file.Close()
+
+	args := []string{1: "tunnels", 2: "up", 3: file.Name(), 4: "--region", 5: "us-east-1", 6: "--keep-alive", 7: "5m"}
+	err, _, _, _, parameters := ParseCliCommand(args)
+	assert.Nil(t, err)
+
+	getSSMClient = func(log log.T, region string, profile string, endpoint string) (*ssm.SSM, error) {
+		assert.Equal(t, "us-east-1", region)
+		return &ssm.SSM{}, nil
+	}
+	defer func(original func(log.T, *session.Session, []portsession.TunnelDefinition) error) {
+		runTunnelDefinitions = original
+	}(runTunnelDefinitions)
+	runTunnelDefinitions = func(log log.T, base *session.Session, definitions []portsession.TunnelDefinition) error {
+		assert.Equal(t, 5*time.Minute, base.KeepAliveInterval)
+		assert.Equal(t, []portsession.TunnelDefinition{{Name: "web", Target: "i-123456", RemotePort: "80", LocalPort: "8080"}}, definitions)
+		return nil
+	}
+
+	command := &TunnelsCommand{}
+	err, msg := command.Execute(parameters)
+	assert.Nil(t, err)
+	assert.Equal(t, "Tunnels closed", msg)
+}
+
+func TestTunnelsCommand_validateTunnelsInput(t *testing.T) {
+	command := &TunnelsCommand{}
+
+	err, _, _, _, parameters := ParseCliCommand([]string{1: "tunnels", 2: "down"})
+	assert.Nil(t, err)
+	validation := command.validateTunnelsInput(parameters)
+	assert.Equal(t, 1, len(validation))
+	assert.Contains(t, validation[0], "unknown subcommand \"down\"")
+
+	err, _, _, _, parameters = ParseCliCommand([]string{1: "tunnels", 2: "up", 3: "--instance-id", 4: "i-123456"})
+	assert.Nil(t, err)
+	validation = command.validateTunnelsInput(parameters)
+	assert.Equal(t, []string{"tunnels up requires the tunnels file", "instance-id not a valid command parameter flag"}, validation)
+}
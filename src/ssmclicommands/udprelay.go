@@ -0,0 +1,146 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package ssmclicommands contains all the commands with its implementation.
+package ssmclicommands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"strings"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/portsession"
+	"github.com/aws/session-manager-plugin/src/ssmclicommands/utils"
+)
+
+const (
+	UDP_RELAY   = "udp-relay"
+	LISTEN      = "listen"
+	DESTINATION = "destination"
+)
+
+var UDPRelayParameterKeys = []string{LISTEN, DESTINATION}
+
+const UDP_RELAY_HELP = `NAME : {{.UDPRelayName}}
+
+SYNOPSIS:
+	{{.SsmCliName}}
+	{{.UDPRelayName}}
+	{{.Listen}}
+	{{.Destination}}
+
+PARAMETERS:
+	{{.Listen}} (string) Listen address
+	TCP address the relay accepts the flows of udp: port mappings on, such as 127.0.0.1:9053
+
+	{{.Destination}} (string) Destination
+	UDP address the datagrams of every flow are sent to, replies are sent back on the flow
+
+	Run it on the target, for instance in an interactive command session, and forward a local UDP port to it
+	with {{.SsmCliName}} start-session --port-mapping udp:LOCAL=PORT. It can be run locally to test UDP mappings.
+
+Command:
+      {{.SsmCliName}} {{.UDPRelayName}} --{{.Listen}} 127.0.0.1:9053 --{{.Destination}} 10.0.0.2:53
+`
+
+type UDPRelayHelpParams struct {
+	SsmCliName   string
+	UDPRelayName string
+	Listen       string
+	Destination  string
+}
+
+type UDPRelayCommand struct {
+	helpText string
+}
+
+// serveUDPRelay relays the flows accepted on listener to destination
+var serveUDPRelay = func(log log.T, listener net.Listener, destination string) error {
+	return portsession.ServeUDPRelay(log, listener, destination)
+}
+
+func init() {
+	utils.Register(&UDPRelayCommand{})
+}
+
+// Name is the command name used in the cli
+func (UDPRelayCommand) Name() string {
+	return UDP_RELAY
+}
+
+// Help prints help for the udp-relay cli command
+func (c *UDPRelayCommand) Help() string {
+	if len(c.helpText) == 0 {
+		t, _ := template.New("UDPRelayHelp").Parse(UDP_RELAY_HELP)
+		params := UDPRelayHelpParams{
+			utils.SsmCliName,
+			UDP_RELAY,
+			LISTEN,
+			DESTINATION,
+		}
+		buf := new(bytes.Buffer)
+		t.Execute(buf, params)
+		c.helpText = buf.String()
+	}
+	return c.helpText
+}
+
+// validates and execute udp-relay command
+func (c *UDPRelayCommand) Execute(parameters map[string][]string) (error, string) {
+	validation := c.validateUDPRelayInput(parameters)
+	if len(validation) > 0 {
+		return errors.New(strings.Join(validation, "\n")), ""
+	}
+
+	log := log.Logger(true, "ssmcli")
+	listener, err := net.Listen("tcp", parameters[LISTEN][0])
+	if err != nil {
+		return err, "UDP relay failed"
+	}
+	defer listener.Close()
+	fmt.Printf("Relaying flows accepted on %s to udp %s.\n", listener.Addr(), parameters[DESTINATION][0])
+
+	if err = serveUDPRelay(log, listener, parameters[DESTINATION][0]); err != nil {
+		log.Errorf("UDP relay stopped: %v", err)
+		return err, "UDP relay failed"
+	}
+	return nil, "UDP relay closed"
+}
+
+// func to validate udp-relay input
+func (UDPRelayCommand) validateUDPRelayInput(parameters map[string][]string) []string {
+	validation := make([]string, 0)
+
+	for _, key := range UDPRelayParameterKeys {
+		if len(parameters[key]) != 1 {
+			validation = append(validation, fmt.Sprintf("%v requires %v", UDP_RELAY, utils.FormatFlag(key)))
+		}
+	}
+	if len(parameters[DESTINATION]) == 1 {
+		if _, _, err := net.SplitHostPort(parameters[DESTINATION][0]); err != nil {
+			validation = append(validation, fmt.Sprintf("invalid %v %q, expected HOST:PORT", utils.FormatFlag(DESTINATION), parameters[DESTINATION][0]))
+		}
+	}
+
+	for key := range parameters {
+		if !contains(UDPRelayParameterKeys, key) {
+			validation = append(validation, fmt.Sprintf("%v not a valid command parameter flag", key))
+		}
+	}
+
+	return validation
+}
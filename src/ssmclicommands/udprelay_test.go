@@ -0,0 +1,55 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package ssmclicommands
+
+import (
+	"net"
+	"testing"
+
+	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUDPRelayCommand_Help(t *testing.T) {
+	command := &UDPRelayCommand{}
+	assert.Contains(t, command.Help(), "SYNOPSIS:")
+}
+
+func TestUDPRelayCommand_Execute(t *testing.T) {
+	err, _, _, _, parameters := ParseCliCommand([]string{1: "udp-relay", 2: "--listen", 3: "127.0.0.1:0", 4: "--destination", 5: "10.0.0.2:53"})
+	assert.Nil(t, err)
+
+	defer func(original func(log.T, net.Listener, string) error) {
+		serveUDPRelay = original
+	}(serveUDPRelay)
+	serveUDPRelay = func(log log.T, listener net.Listener, destination string) error {
+		assert.Equal(t, "127.0.0.1", listener.Addr().(*net.TCPAddr).IP.String())
+		assert.Equal(t, "10.0.0.2:53", destination)
+		return nil
+	}
+
+	command := &UDPRelayCommand{}
+	err, msg := command.Execute(parameters)
+	assert.Nil(t, err)
+	assert.Equal(t, "UDP relay closed", msg)
+}
+
+func TestUDPRelayCommand_validateUDPRelayInput(t *testing.T) {
+	command := &UDPRelayCommand{}
+
+	err, _, _, _, parameters := ParseCliCommand([]string{1: "udp-relay", 2: "--destination", 3: "10.0.0.2", 4: "--region", 5: "us-east-1"})
+	assert.Nil(t, err)
+	validation := command.validateUDPRelayInput(parameters)
+	assert.Equal(t, []string{"udp-relay requires --listen", "invalid --destination \"10.0.0.2\", expected HOST:PORT", "region not a valid command parameter flag"}, validation)
+}
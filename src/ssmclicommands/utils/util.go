@@ -23,6 +23,9 @@ const (
 	HelpFlag   = "help"
 	SsmCliName = "ssmcli"
 	FlagPrefix = "--"
+
+	// PositionalArguments is the parameter name under which arguments given before any flag are stored
+	PositionalArguments = ""
 )
 
 // CliCommands is the set of support commands
@@ -75,3 +78,11 @@ func IsHelp(subcommand string, parameters map[string][]string) bool {
 func FormatFlag(flagName string) string {
 	return fmt.Sprintf("%v%v", FlagPrefix, flagName)
 }
+
+// GetSubcommand returns the first positional argument of the parameters, or empty if there is none
+func GetSubcommand(parameters map[string][]string) string {
+	if arguments := parameters[PositionalArguments]; len(arguments) > 0 {
+		return strings.ToLower(arguments[0])
+	}
+	return ""
+}
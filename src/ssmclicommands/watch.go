@@ -0,0 +1,156 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package ssmclicommands contains all the commands with its implementation.
+package ssmclicommands
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/aws/session-manager-plugin/src/message"
+	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/shellsession"
+	"github.com/aws/session-manager-plugin/src/ssmclicommands/utils"
+)
+
+const (
+	WATCH  = "watch"
+	SOCKET = "socket"
+)
+
+var WatchParameterKeys = []string{SOCKET}
+
+const WATCH_HELP = `NAME : {{.WatchName}}
+
+SYNOPSIS:
+	{{.SsmCliName}}
+	{{.WatchName}}
+	{{.Socket}}
+
+PARAMETERS:
+	{{.Socket}} (string) Watch socket
+	Socket of a shell session started with {{.StartSessionName}} --{{.WatchSocket}}, the session output is
+	shown read-only until the session ends
+
+Command:
+      {{.SsmCliName}} {{.WatchName}} --{{.Socket}} /tmp/incident.sock
+`
+
+type WatchHelpParams struct {
+	SsmCliName       string
+	WatchName        string
+	StartSessionName string
+	WatchSocket      string
+	Socket           string
+}
+
+type WatchCommand struct {
+	helpText string
+}
+
+// dialWatchSocket connects to the mirror of a shell session.
+var dialWatchSocket = func(path string) (net.Conn, error) {
+	return net.Dial("unix", path)
+}
+
+// watchOutput and watchNotices receive the mirrored output and the terminal size changes.
+var (
+	watchOutput  io.Writer = os.Stdout
+	watchNotices io.Writer = os.Stderr
+)
+
+func init() {
+	utils.Register(&WatchCommand{})
+}
+
+// Name is the command name used in the cli
+func (WatchCommand) Name() string {
+	return WATCH
+}
+
+// Help prints help for the watch cli command
+func (c *WatchCommand) Help() string {
+	if len(c.helpText) == 0 {
+		t, _ := template.New("WatchHelp").Parse(WATCH_HELP)
+		params := WatchHelpParams{
+			utils.SsmCliName,
+			WATCH,
+			START_SESSION,
+			WATCH_SOCKET,
+			SOCKET,
+		}
+		buf := new(bytes.Buffer)
+		t.Execute(buf, params)
+		c.helpText = buf.String()
+	}
+	return c.helpText
+}
+
+// validates and execute watch command
+func (c *WatchCommand) Execute(parameters map[string][]string) (error, string) {
+	validation := c.validateWatchInput(parameters)
+	if len(validation) > 0 {
+		return errors.New(strings.Join(validation, "\n")), ""
+	}
+
+	conn, err := dialWatchSocket(parameters[SOCKET][0])
+	if err != nil {
+		return fmt.Errorf("unable to attach to the shell session: %v", err), ""
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		frameType, payload, err := shellsession.ReadMirrorFrame(reader)
+		if err == io.EOF {
+			return nil, "\nThe watched session has ended.\n"
+		} else if err != nil {
+			return fmt.Errorf("watching the shell session failed: %v", err), ""
+		}
+
+		switch frameType {
+		case shellsession.MirrorFrameOutput:
+			watchOutput.Write(payload)
+		case shellsession.MirrorFrameSize:
+			var sizeData message.SizeData
+			if err = json.Unmarshal(payload, &sizeData); err == nil {
+				fmt.Fprintf(watchNotices, "\r\n[watched terminal is %d columns by %d rows]\r\n", sizeData.Cols, sizeData.Rows)
+			}
+		}
+	}
+}
+
+// func to validate watch input
+func (WatchCommand) validateWatchInput(parameters map[string][]string) []string {
+	validation := make([]string, 0)
+
+	if len(parameters[SOCKET]) == 0 {
+		validation = append(validation, fmt.Sprintf("%v is required", utils.FormatFlag(SOCKET)))
+	}
+
+	for key := range parameters {
+		if !contains(WatchParameterKeys, key) {
+			validation = append(validation, fmt.Sprintf("%v not a valid command parameter flag", key))
+		}
+	}
+
+	return validation
+}
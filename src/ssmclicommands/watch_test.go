@@ -0,0 +1,70 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package ssmclicommands contains all the commands with its implementation.
+package ssmclicommands
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchCommand_Execute(t *testing.T) {
+	defer func() {
+		watchOutput = os.Stdout
+		watchNotices = os.Stderr
+	}()
+	var output, notices bytes.Buffer
+	watchOutput = &output
+	watchNotices = &notices
+
+	session, viewer := net.Pipe()
+	dialWatchSocket = func(path string) (net.Conn, error) {
+		assert.Equal(t, "/tmp/incident.sock", path)
+		return viewer, nil
+	}
+	go func() {
+		session.Write([]byte("s\x00\x00\x00\x16{\"cols\":120,\"rows\":40}"))
+		session.Write([]byte("o\x00\x00\x00\x06$ ls\r\n"))
+		session.Close()
+	}()
+
+	args := []string{1: "watch", 2: "--socket", 3: "/tmp/incident.sock"}
+	err, _, _, _, parameters := ParseCliCommand(args)
+	assert.Nil(t, err)
+
+	command := &WatchCommand{}
+	err, msg := command.Execute(parameters)
+	assert.Nil(t, err)
+	assert.Contains(t, msg, "session has ended")
+	assert.Equal(t, "$ ls\r\n", output.String())
+	assert.Contains(t, notices.String(), "120 columns by 40 rows")
+}
+
+func TestWatchCommand_ExecuteWithoutSession(t *testing.T) {
+	dialWatchSocket = func(path string) (net.Conn, error) {
+		return nil, errors.New("connect: no such file or directory")
+	}
+
+	command := &WatchCommand{}
+	err, _ := command.Execute(map[string][]string{SOCKET: {"/tmp/missing.sock"}})
+	assert.Contains(t, err.Error(), "unable to attach")
+
+	err, _ = command.Execute(map[string][]string{})
+	assert.Contains(t, err.Error(), "--socket is required")
+}
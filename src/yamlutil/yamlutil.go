@@ -0,0 +1,288 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package yamlutil reads the block style subset of YAML used by configuration files.
+package yamlutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/aws/session-manager-plugin/src/jsonutil"
+)
+
+// line is a line of content without its indentation, comment and trailing spaces
+type line struct {
+	number int
+	indent int
+	text   string
+}
+
+// parser parses block mappings and sequences of lines
+type parser struct {
+	lines []line
+	pos   int
+}
+
+// UnmarshalFile reads the content of a file then Unmarshals the content to an object.
+func UnmarshalFile(filePath string, dest interface{}) (err error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return
+	}
+	return Unmarshal(string(content), dest)
+}
+
+// Unmarshal parses YAML content and stores it in dest the way encoding/json would store the same document.
+func Unmarshal(content string, dest interface{}) (err error) {
+	value, err := Parse(content)
+	if err != nil {
+		return
+	}
+	return jsonutil.Remarshal(value, dest)
+}
+
+// Parse parses block mappings, block sequences and scalars into maps, slices, strings, numbers, booleans and nil.
+// Flow collections have to be valid JSON, anchors, tags, block scalars and multiple documents are not supported.
+func Parse(content string) (value interface{}, err error) {
+	p := &parser{}
+	for i, text := range strings.Split(content, "\n") {
+		text = strings.TrimRight(stripComment(text), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || (i == 0 && trimmed == "---") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", i+1)
+		}
+		p.lines = append(p.lines, line{number: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	if value, err = p.parseNode(p.lines[0].indent); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].number)
+	}
+	return value, nil
+}
+
+// parseNode parses the node starting at the current line.
+func (p *parser) parseNode(indent int) (interface{}, error) {
+	current := p.lines[p.pos]
+	if isSequenceItem(current.text) {
+		return p.parseSequence(indent)
+	}
+	if _, _, isKey, err := splitKey(current); err != nil {
+		return nil, err
+	} else if isKey {
+		return p.parseMapping(indent)
+	}
+	p.pos++
+	return parseScalar(current)
+}
+
+// parseMapping parses the keys of a mapping at indent.
+func (p *parser) parseMapping(indent int) (interface{}, error) {
+	mapping := make(map[string]interface{})
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && !isSequenceItem(p.lines[p.pos].text) {
+		current := p.lines[p.pos]
+		key, rest, isKey, err := splitKey(current)
+		if err != nil {
+			return nil, err
+		}
+		if !isKey {
+			return nil, fmt.Errorf("line %d: expected key: value", current.number)
+		}
+		if _, exists := mapping[key]; exists {
+			return nil, fmt.Errorf("line %d: duplicate key %q", current.number, key)
+		}
+		p.pos++
+
+		if rest != "" {
+			mapping[key], err = parseScalar(line{number: current.number, text: rest})
+		} else if p.pos < len(p.lines) && (p.lines[p.pos].indent > indent ||
+			(p.lines[p.pos].indent == indent && isSequenceItem(p.lines[p.pos].text))) {
+			mapping[key], err = p.parseNode(p.lines[p.pos].indent)
+		} else {
+			mapping[key] = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].number)
+	}
+	return mapping, nil
+}
+
+// parseSequence parses the items of a sequence at indent, an item may start a mapping on its line.
+func (p *parser) parseSequence(indent int) (interface{}, error) {
+	sequence := make([]interface{}, 0)
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isSequenceItem(p.lines[p.pos].text) {
+		current := p.lines[p.pos]
+		rest := strings.TrimLeft(current.text[1:], " ")
+
+		var (
+			item interface{}
+			err  error
+		)
+		if rest == "" {
+			p.pos++
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				item, err = p.parseNode(p.lines[p.pos].indent)
+			}
+		} else {
+			// the rest of the line is a node indented to its column
+			column := indent + len(current.text) - len(rest)
+			p.lines[p.pos] = line{number: current.number, indent: column, text: rest}
+			item, err = p.parseNode(column)
+		}
+		if err != nil {
+			return nil, err
+		}
+		sequence = append(sequence, item)
+	}
+	return sequence, nil
+}
+
+// isSequenceItem returns whether text is an item of a block sequence.
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitKey splits key: value, the key may be quoted.
+func splitKey(l line) (key string, rest string, isKey bool, err error) {
+	text := l.text
+	end := 0
+	if text == "" {
+		return "", "", false, nil
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		if end = closingQuote(text); end < 0 {
+			return "", "", false, nil
+		}
+		end++
+		if !strings.HasPrefix(text[end:], ":") {
+			return "", "", false, nil
+		}
+	} else if end = strings.Index(text, ": "); end < 0 {
+		if !strings.HasSuffix(text, ":") {
+			return "", "", false, nil
+		}
+		end = len(text) - 1
+	}
+	if strings.HasPrefix(text[end+1:], ":") || (end+1 < len(text) && text[end+1] != ' ') {
+		return "", "", false, nil
+	}
+	if strings.TrimSpace(text[:end]) == "" {
+		return "", "", false, fmt.Errorf("line %d: empty key", l.number)
+	}
+	scalar, scalarErr := parseScalar(line{text: strings.TrimSpace(text[:end])})
+	if scalarErr != nil {
+		return "", "", false, nil
+	}
+	return fmt.Sprint(scalar), strings.TrimSpace(text[end+1:]), true, nil
+}
+
+// parseScalar parses a quoted or plain scalar, or a JSON flow collection.
+func parseScalar(l line) (interface{}, error) {
+	text := l.text
+	if text == "" {
+		return nil, fmt.Errorf("line %d: empty value", l.number)
+	}
+	switch text[0] {
+	case '"':
+		if closingQuote(text) != len(text)-1 {
+			return nil, fmt.Errorf("line %d: invalid double quoted string", l.number)
+		}
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid double quoted string: %v", l.number, err)
+		}
+		return value, nil
+	case '\'':
+		if closingQuote(text) != len(text)-1 {
+			return nil, fmt.Errorf("line %d: invalid single quoted string", l.number)
+		}
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	case '[', '{':
+		var value interface{}
+		if err := json.Unmarshal([]byte(text), &value); err != nil {
+			return nil, fmt.Errorf("line %d: flow collections have to be valid JSON: %v", l.number, err)
+		}
+		return value, nil
+	case '|', '>', '&', '*', '!':
+		return nil, fmt.Errorf("line %d: %q is not supported", l.number, text[0])
+	}
+
+	switch text {
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	}
+	if number, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return number, nil
+	}
+	if number, err := strconv.ParseFloat(text, 64); err == nil && !strings.ContainsAny(text, "xXnN") {
+		return number, nil
+	}
+	return text, nil
+}
+
+// closingQuote returns the index of the quote closing the string text starts with, or -1.
+func closingQuote(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote && quote == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// stripComment removes a comment starting with # at the beginning of text or after a space, outside of quotes.
+func stripComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote == 0 && (c == '"' || c == '\'') && (i == 0 || strings.ContainsRune(" :-[{,", rune(text[i-1]))):
+			quote = c
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			if quote == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+				i++
+			} else {
+				quote = 0
+			}
+		case quote == 0 && c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package yamlutil reads the block style subset of YAML used by configuration files.
+package yamlutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	content := `---
+# tunnels of the team
+tunnels:
+  - name: "db # primary"   # comment
+    remotePort: 5432
+    enabled: true
+    tags: ["a", "b"]
+  -
+    name: 'it''s'
+    hosts:
+    - one
+    - two: 2
+      three:
+empty:
+"quoted key": -1.5
+`
+	value, err := Parse(content)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"tunnels": []interface{}{
+			map[string]interface{}{"name": "db # primary", "remotePort": int64(5432), "enabled": true, "tags": []interface{}{"a", "b"}},
+			map[string]interface{}{"name": "it's", "hosts": []interface{}{"one", map[string]interface{}{"two": int64(2), "three": nil}}},
+		},
+		"empty":      nil,
+		"quoted key": -1.5,
+	}, value)
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, content := range []string{
+		"a: 1\n  b: 2",
+		"a: 1\na: 2",
+		"a: |\n  text",
+		"a: \"open",
+		"a:\n\t- b",
+		"a: [1, 2",
+		": x",
+		"tunnels:\n  - name: a\n    : x\n",
+	} {
+		_, err := Parse(content)
+		assert.NotNil(t, err, content)
+	}
+}
+
+func TestParseEmptyKey(t *testing.T) {
+	_, err := Parse(": x")
+	assert.EqualError(t, err, "line 1: empty key")
+
+	_, err = Parse("tunnels:\n  - name: a\n    : x\n")
+	assert.EqualError(t, err, "line 3: empty key")
+}
+
+func TestUnmarshal(t *testing.T) {
+	var dest struct {
+		Name  string   `json:"name"`
+		Port  int      `json:"port"`
+		Hosts []string `json:"hosts"`
+	}
+	err := Unmarshal("name: web\nport: 8080\nhosts:\n  - a\n  - b\n", &dest)
+	assert.Nil(t, err)
+	assert.Equal(t, "web", dest.Name)
+	assert.Equal(t, 8080, dest.Port)
+	assert.Equal(t, []string{"a", "b"}, dest.Hosts)
+}